@@ -2,6 +2,8 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/shafik23/ys/token"
@@ -10,6 +12,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Pos // position of the node's first character
+	End() token.Pos // position immediately after the node's last character
 }
 
 type Statement interface {
@@ -26,6 +30,7 @@ type Expression interface {
 // Every valid YS program is a series of statements.
 type Program struct {
 	Statements []Statement
+	Comments   []*Comment // the program's comments, in order; they are not part of the tree
 }
 
 ////////////////////////////////////////////////////////////////
@@ -38,6 +43,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Pos {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Pos{}
+}
+
+func (p *Program) End() token.Pos {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Pos{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -63,6 +82,15 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+func (ls *LetStatement) Pos() token.Pos { return ls.Token.Pos }
+
+func (ls *LetStatement) End() token.Pos {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
+
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -93,6 +121,10 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Pos { return i.Token.Pos }
+
+func (i *Identifier) End() token.Pos { return i.Token.End }
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -110,6 +142,15 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Pos { return rs.Token.Pos }
+
+func (rs *ReturnStatement) End() token.Pos {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
+
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -137,6 +178,20 @@ func (es *ExpressionStatement) TokenLiteral() string {
 	return es.Token.Literal
 }
 
+func (es *ExpressionStatement) Pos() token.Pos {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+
+func (es *ExpressionStatement) End() token.Pos {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil { // if the expression is not nil
 		return es.Expression.String() // return its string representation
@@ -150,6 +205,7 @@ func (es *ExpressionStatement) String() string {
 type IntegerLiteral struct {
 	Token token.Token // the token.INT token
 	Value int64       // the integer literal's value
+	Big   *big.Int    // the value instead, if it does not fit in an int64
 }
 
 func (il *IntegerLiteral) expressionNode() {}
@@ -157,12 +213,38 @@ func (il *IntegerLiteral) expressionNode() {}
 func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
+
+func (il *IntegerLiteral) Pos() token.Pos { return il.Token.Pos }
+
+func (il *IntegerLiteral) End() token.Pos { return il.Token.End }
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
 ////////////////////////////////////////////////////////////////
 
+type FloatLiteral struct {
+	Token token.Token // the token.FLOAT token
+	Value float64     // the float literal's value
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) Pos() token.Pos { return fl.Token.Pos }
+
+func (fl *FloatLiteral) End() token.Pos { return fl.Token.End }
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
+////////////////////////////////////////////////////////////////
+
 type PrefixExpression struct {
 	Token    token.Token // the prefix operator, e.g. !
 	Operator string      // the prefix operator, e.g. !
@@ -175,6 +257,15 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 
+func (pe *PrefixExpression) Pos() token.Pos { return pe.Token.Pos }
+
+func (pe *PrefixExpression) End() token.Pos {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -201,6 +292,20 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *InfixExpression) Pos() token.Pos {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+
+func (ie *InfixExpression) End() token.Pos {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -226,6 +331,10 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Pos { return b.Token.Pos }
+
+func (b *Boolean) End() token.Pos { return b.Token.End }
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -245,6 +354,18 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Pos() token.Pos { return ie.Token.Pos }
+
+func (ie *IfExpression) End() token.Pos {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -266,6 +387,7 @@ func (ie *IfExpression) String() string {
 type BlockStatement struct {
 	Token      token.Token // the token.LBRACE token
 	Statements []Statement
+	Rbrace     token.Token // the closing token.RBRACE token
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -274,6 +396,10 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+func (bs *BlockStatement) Pos() token.Pos { return bs.Token.Pos }
+
+func (bs *BlockStatement) End() token.Pos { return bs.Rbrace.End }
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -289,7 +415,9 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
 	Token      token.Token // the token.FUNCTION token
 	Parameters []*Identifier
+	Rparen     token.Token // the token.RPAREN token closing the parameters
 	Body       *BlockStatement
+	Name       string // the name the function is bound to by a let statement, if any
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -298,6 +426,15 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Pos() token.Pos { return fl.Token.Pos }
+
+func (fl *FunctionLiteral) End() token.Pos {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -322,6 +459,7 @@ type CallExpression struct {
 	Token     token.Token // the token.LPAREN token
 	Function  Expression  // the function expression
 	Arguments []Expression
+	Rparen    token.Token // the closing token.RPAREN token
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -330,6 +468,10 @@ func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+func (ce *CallExpression) Pos() token.Pos { return ce.Function.Pos() }
+
+func (ce *CallExpression) End() token.Pos { return ce.Rparen.End }
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -360,6 +502,10 @@ func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
 
+func (sl *StringLiteral) Pos() token.Pos { return sl.Token.Pos }
+
+func (sl *StringLiteral) End() token.Pos { return sl.Token.End }
+
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
@@ -369,6 +515,7 @@ func (sl *StringLiteral) String() string {
 type ArrayLiteral struct {
 	Token    token.Token // the token.LBRACKET token
 	Elements []Expression
+	Rbracket token.Token // the closing token.RBRACKET token
 }
 
 func (al *ArrayLiteral) expressionNode() {}
@@ -377,6 +524,10 @@ func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+func (al *ArrayLiteral) Pos() token.Pos { return al.Token.Pos }
+
+func (al *ArrayLiteral) End() token.Pos { return al.Rbracket.End }
+
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -396,9 +547,10 @@ func (al *ArrayLiteral) String() string {
 ////////////////////////////////////////////////////////////////
 
 type IndexExpression struct {
-	Token token.Token // the token.LBRACKET token
-	Left  Expression  // the left-hand side expression
-	Index Expression  // the index expression
+	Token    token.Token // the token.LBRACKET token
+	Left     Expression  // the left-hand side expression
+	Index    Expression  // the index expression
+	Rbracket token.Token // the closing token.RBRACKET token
 }
 
 func (ie *IndexExpression) expressionNode() {}
@@ -407,6 +559,10 @@ func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IndexExpression) Pos() token.Pos { return ie.Left.Pos() }
+
+func (ie *IndexExpression) End() token.Pos { return ie.Rbracket.End }
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -421,9 +577,71 @@ func (ie *IndexExpression) String() string {
 
 ////////////////////////////////////////////////////////////////
 
+// MemberExpression reads a member of a module, or a string key of a hash: lib.name.
+type MemberExpression struct {
+	Token  token.Token // the token.DOT token
+	Object Expression  // the module or hash
+	Member *Identifier // the name of the member
+}
+
+func (me *MemberExpression) expressionNode() {}
+
+func (me *MemberExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MemberExpression) Pos() token.Pos { return me.Object.Pos() }
+
+func (me *MemberExpression) End() token.Pos { return me.Member.End() }
+
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + "." + me.Member.String() + ")"
+}
+
+////////////////////////////////////////////////////////////////
+
+// AssignExpression updates an existing variable, array element or hash entry,
+// e.g. x = 5, count += 1 or xs[0] = 5.
+type AssignExpression struct {
+	Token    token.Token // the assignment operator token, e.g. = or +=
+	Target   Expression  // an *Identifier or *IndexExpression
+	Operator string      // the assignment operator, e.g. = or +=
+	Value    Expression  // the right-hand side expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) Pos() token.Pos { return ae.Target.Pos() }
+
+func (ae *AssignExpression) End() token.Pos {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(") // append the opening parenthesis
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")") // append the closing parenthesis
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////
+
 type HashLiteral struct {
-	Token token.Token // the token.LBRACE token
-	Pairs map[Expression]Expression
+	Token  token.Token // the token.LBRACE token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token // the closing token.RBRACE token
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -432,13 +650,17 @@ func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
 
+func (hl *HashLiteral) Pos() token.Pos { return hl.Token.Pos }
+
+func (hl *HashLiteral) End() token.Pos { return hl.Rbrace.End }
+
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{} // create a slice of strings
 
-	for key, value := range hl.Pairs { // iterate over the pairs
-		pairs = append(pairs, key.String()+":"+value.String()) // append the string representation of each pair to the slice
+	for _, key := range SortedKeys(hl) { // iterate over the pairs in the order they were written in
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String()) // append the string representation of each pair to the slice
 	}
 
 	out.WriteString("{")
@@ -447,3 +669,312 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+////////////////////////////////////////////////////////////////
+
+type WhileStatement struct {
+	Token     token.Token // the token.WHILE token
+	Condition Expression  // the loop condition, checked before every iteration
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+
+func (ws *WhileStatement) Pos() token.Pos { return ws.Token.Pos }
+
+func (ws *WhileStatement) End() token.Pos {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while") // append the while keyword
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////
+
+// ForStatement is a C-style loop: for (init; condition; post) { body }.
+// Any of Init, Condition and Post may be nil.
+type ForStatement struct {
+	Token     token.Token // the token.FOR token
+	Init      Statement   // run once before the loop
+	Condition Expression  // checked before every iteration; a nil condition loops forever
+	Post      Statement   // run after every iteration, including ones ended by continue
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+func (fs *ForStatement) Pos() token.Pos { return fs.Token.Pos }
+
+func (fs *ForStatement) End() token.Pos {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Post != nil {
+		out.WriteString(strings.TrimSuffix(fs.Post.String(), ";"))
+	}
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////
+
+// ForInStatement loops over the elements of an array, the characters of a string or
+// the keys of a hash: for (x in iterable) { body }. With two variables,
+// for (k, v in iterable), Key is bound to the index (or hash key) and Value to the element.
+type ForInStatement struct {
+	Token    token.Token // the token.FOR token
+	Key      *Identifier // the index or hash key variable; nil with a single variable
+	Value    *Identifier // the element variable (the key, for a hash with a single variable)
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fi *ForInStatement) statementNode() {}
+
+func (fi *ForInStatement) TokenLiteral() string {
+	return fi.Token.Literal
+}
+
+func (fi *ForInStatement) Pos() token.Pos { return fi.Token.Pos }
+
+func (fi *ForInStatement) End() token.Pos {
+	if fi.Body != nil {
+		return fi.Body.End()
+	}
+	return fi.Token.End
+}
+
+func (fi *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fi.Key != nil {
+		out.WriteString(fi.Key.String() + ", ")
+	}
+	out.WriteString(fi.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fi.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fi.Body.String())
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////
+
+type BreakStatement struct {
+	Token token.Token // the token.BREAK token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BreakStatement) Pos() token.Pos { return bs.Token.Pos }
+
+func (bs *BreakStatement) End() token.Pos { return bs.Token.End }
+
+func (bs *BreakStatement) String() string {
+	return bs.TokenLiteral() + ";"
+}
+
+////////////////////////////////////////////////////////////////
+
+type ContinueStatement struct {
+	Token token.Token // the token.CONTINUE token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+func (cs *ContinueStatement) Pos() token.Pos { return cs.Token.Pos }
+
+func (cs *ContinueStatement) End() token.Pos { return cs.Token.End }
+
+func (cs *ContinueStatement) String() string {
+	return cs.TokenLiteral() + ";"
+}
+
+////////////////////////////////////////////////////////////////
+
+// ThrowStatement raises its value as an error, which a surrounding try can catch.
+type ThrowStatement struct {
+	Token token.Token // the token.THROW token
+	Value Expression  // the value thrown
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+func (ts *ThrowStatement) Pos() token.Pos { return ts.Token.Pos }
+
+func (ts *ThrowStatement) End() token.Pos {
+	if ts.Value != nil {
+		return ts.Value.End()
+	}
+	return ts.Token.End
+}
+
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+////////////////////////////////////////////////////////////////
+
+// TryExpression runs Block and, if it raises an error, Catch with the error bound to
+// Param: try { ... } catch (e) { ... } finally { ... }. Finally runs however the others end.
+// At least one of Catch and Finally is present.
+type TryExpression struct {
+	Token   token.Token     // the token.TRY token
+	Block   *BlockStatement // the block whose errors are caught
+	Param   *Identifier     // the variable bound to the caught error; nil without a catch
+	Catch   *BlockStatement // nil without a catch
+	Finally *BlockStatement // nil without a finally
+}
+
+func (te *TryExpression) expressionNode() {}
+
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) Pos() token.Pos { return te.Token.Pos }
+
+func (te *TryExpression) End() token.Pos {
+	switch {
+	case te.Finally != nil:
+		return te.Finally.End()
+	case te.Catch != nil:
+		return te.Catch.End()
+	case te.Block != nil:
+		return te.Block.End()
+	}
+	return te.Token.End
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.Param.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////
+
+// ImportStatement loads another file as a module. `import "lib.ys" as lib` binds the
+// module to Alias, while `from "lib.ys" import a, b` binds the members it Names.
+type ImportStatement struct {
+	Token token.Token    // the token.IMPORT or token.FROM token
+	Path  *StringLiteral // the file to import
+	Alias *Identifier    // the name the module is bound to; nil when importing Names
+	Names []*Identifier  // the members to import; empty when importing the module
+}
+
+func (is *ImportStatement) statementNode() {}
+
+func (is *ImportStatement) TokenLiteral() string {
+	return is.Token.Literal
+}
+
+func (is *ImportStatement) Pos() token.Pos { return is.Token.Pos }
+
+func (is *ImportStatement) End() token.Pos {
+	if len(is.Names) > 0 {
+		return is.Names[len(is.Names)-1].End()
+	}
+	if is.Alias != nil {
+		return is.Alias.End()
+	}
+	return is.Path.End()
+}
+
+func (is *ImportStatement) String() string {
+	if len(is.Names) == 0 {
+		return "import " + strconv.Quote(is.Path.Value) + " as " + is.Alias.String() + ";"
+	}
+
+	names := []string{}
+	for _, name := range is.Names {
+		names = append(names, name.String())
+	}
+
+	return "from " + strconv.Quote(is.Path.Value) + " import " + strings.Join(names, ", ") + ";"
+}
+
+////////////////////////////////////////////////////////////////
+
+// Comment is a // comment, which runs to the end of its line. Comments can come between
+// any two tokens, so the parser keeps them aside in Program.Comments for tools such as
+// the formatter rather than in the tree.
+type Comment struct {
+	Token token.Token // the token.COMMENT token
+	Text  string      // the comment, including the leading //
+}
+
+func (c *Comment) TokenLiteral() string {
+	return c.Token.Literal
+}
+
+func (c *Comment) Pos() token.Pos { return c.Token.Pos }
+
+func (c *Comment) End() token.Pos { return c.Token.End }
+
+func (c *Comment) String() string {
+	return c.Text
+}
@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/shafik23/ys/token"
@@ -27,3 +29,146 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestFprint(t *testing.T) {
+	pos := func(col int) token.Pos { return token.Pos{Line: 1, Column: col, Offset: col - 1} }
+
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Token: token.Token{Type: token.IDENT, Literal: "f", Pos: pos(1)},
+				Expression: &CallExpression{
+					Token:    token.Token{Type: token.LPAREN, Literal: "(", Pos: pos(2)},
+					Function: &Identifier{Token: token.Token{Type: token.IDENT, Literal: "f", Pos: pos(1)}, Value: "f"},
+					Arguments: []Expression{
+						&HashLiteral{
+							Token: token.Token{Type: token.LBRACE, Literal: "{", Pos: pos(3)},
+							Pairs: map[Expression]Expression{
+								&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "b", Pos: pos(12)}, Value: "b"}: &Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Pos: pos(17)}, Value: true},
+								&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a", Pos: pos(4)}, Value: "a"}:  &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1", Pos: pos(9)}, Value: 1},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	expected := `Program 1:1
+  Statements:
+    [0]: ExpressionStatement 1:1
+      Expression: CallExpression 1:1
+        Function: Identifier 1:1 Value="f"
+        Arguments:
+          [0]: HashLiteral 1:3
+            Pairs:
+              [0] Key: StringLiteral 1:4 Value="a"
+              [0] Value: IntegerLiteral 1:9 Value=1
+              [1] Key: StringLiteral 1:12 Value="b"
+              [1] Value: Boolean 1:17 Value=true
+`
+
+	var out bytes.Buffer
+	if err := Fprint(&out, program); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("Fprint wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestInspect(t *testing.T) {
+	pos := func(col int) token.Pos { return token.Pos{Line: 1, Column: col, Offset: col - 1} }
+	ident := func(name string, col int) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Pos: pos(col)}, Value: name}
+	}
+
+	// let f = fn(a) { if (a) { {"b": a, "c": g} } }
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: pos(1)},
+				Name:  ident("f", 5),
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn", Pos: pos(9)},
+					Parameters: []*Identifier{ident("a", 12)},
+					Body: &BlockStatement{
+						Token: token.Token{Type: token.LBRACE, Literal: "{", Pos: pos(15)},
+						Statements: []Statement{
+							&ExpressionStatement{
+								Token: token.Token{Type: token.IF, Literal: "if", Pos: pos(17)},
+								Expression: &IfExpression{
+									Token:     token.Token{Type: token.IF, Literal: "if", Pos: pos(17)},
+									Condition: ident("a", 21),
+									Consequence: &BlockStatement{
+										Token: token.Token{Type: token.LBRACE, Literal: "{", Pos: pos(24)},
+										Statements: []Statement{
+											&ExpressionStatement{
+												Token: token.Token{Type: token.LBRACE, Literal: "{", Pos: pos(26)},
+												Expression: &HashLiteral{
+													Token: token.Token{Type: token.LBRACE, Literal: "{", Pos: pos(26)},
+													Pairs: map[Expression]Expression{
+														&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "c", Pos: pos(35)}, Value: "c"}: ident("g", 40),
+														&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "b", Pos: pos(27)}, Value: "b"}: ident("a", 32),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var visited []string
+	depth, maxDepth := 0, 0
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		depth++
+		maxDepth = max(maxDepth, depth)
+
+		switch node := node.(type) {
+		case *Identifier:
+			visited = append(visited, node.Value)
+		case *StringLiteral:
+			visited = append(visited, `"`+node.Value+`"`)
+		}
+
+		// The branches of ifs are skipped.
+		if _, ok := node.(*IfExpression); ok {
+			depth--
+			return false
+		}
+		return true
+	})
+
+	if got := strings.Join(visited, " "); got != "f a" {
+		t.Errorf("Inspect skipping ifs visited the wrong nodes. got=%q", got)
+	}
+	if depth != 0 || maxDepth != 6 {
+		t.Errorf("Inspect did not end each node it went into. depth=%d, maxDepth=%d", depth, maxDepth)
+	}
+
+	visited = nil
+	Inspect(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *Identifier:
+			visited = append(visited, node.Value)
+		case *StringLiteral:
+			visited = append(visited, `"`+node.Value+`"`)
+		}
+		return true
+	})
+
+	if got := strings.Join(visited, " "); got != `f a a "b" a "c" g` {
+		t.Errorf("Inspect visited the wrong nodes. got=%q", got)
+	}
+}
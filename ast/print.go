@@ -0,0 +1,132 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/shafik23/ys/token"
+)
+
+var (
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+	tokenType = reflect.TypeOf(token.Token{})
+	bigType   = reflect.TypeOf((*big.Int)(nil))
+)
+
+// Fprint writes node to w as an indented tree, one node per line with its position and
+// the values it holds, and its children below it labelled with their fields:
+//
+//	LetStatement 1:1
+//	  Name: Identifier 1:5 Value="x"
+//	  Value: InfixExpression 1:9 Operator="+"
+//	    Left: IntegerLiteral 1:9 Value=1
+//	    Right: IntegerLiteral 1:13 Value=2
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.node("", node, 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(indent int, format string, a ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, strings.Repeat("  ", indent)+format+"\n", a...)
+	}
+}
+
+// node prints a node on a line of its own, labelled with the field that holds it, and
+// then its children.
+func (p *printer) node(label string, node Node, indent int) {
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return
+	}
+	s := reflect.Indirect(v)
+
+	line := label + s.Type().Name()
+	if pos := node.Pos(); pos.IsValid() {
+		line += " " + pos.String()
+	}
+
+	// Values go on the node's line, and nodes below it.
+	type child struct {
+		name  string
+		value reflect.Value
+	}
+	var children []child
+
+	for i := 0; i < s.NumField(); i++ {
+		field, value := s.Type().Field(i), s.Field(i)
+		if !field.IsExported() || field.Type == tokenType {
+			continue
+		}
+
+		switch {
+		case field.Type == bigType:
+			if !value.IsNil() {
+				line += fmt.Sprintf(" %s=%s", field.Name, value.Interface())
+			}
+		case field.Type.Kind() == reflect.String:
+			if value.String() != "" {
+				line += fmt.Sprintf(" %s=%q", field.Name, value.String())
+			}
+		case field.Type.Kind() == reflect.Bool, field.Type.Kind() == reflect.Int64, field.Type.Kind() == reflect.Float64:
+			line += fmt.Sprintf(" %s=%v", field.Name, value.Interface())
+		default:
+			children = append(children, child{field.Name, value})
+		}
+	}
+
+	p.printf(indent, "%s", line)
+
+	for _, c := range children {
+		p.value(c.name, c.value, indent+1)
+	}
+}
+
+// value prints a child of a node: a node, or a slice or map of them.
+func (p *printer) value(name string, v reflect.Value, indent int) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if node, ok := v.Interface().(Node); ok {
+			p.node(name+": ", node, indent)
+		}
+
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return
+		}
+		p.printf(indent, "%s:", name)
+		for i := 0; i < v.Len(); i++ {
+			p.value(fmt.Sprintf("[%d]", i), v.Index(i), indent+1)
+		}
+
+	case reflect.Map:
+		if v.Len() == 0 || !v.Type().Key().Implements(nodeType) {
+			return
+		}
+
+		// Map pairs are printed in the order they appear in the source.
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Interface().(Node).Pos().Offset < keys[j].Interface().(Node).Pos().Offset
+		})
+
+		p.printf(indent, "%s:", name)
+		for i, key := range keys {
+			p.value(fmt.Sprintf("[%d] Key", i), key, indent+1)
+			p.value(fmt.Sprintf("[%d] Value", i), v.MapIndex(key), indent+1)
+		}
+	}
+}
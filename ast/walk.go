@@ -0,0 +1,173 @@
+package ast
+
+import "sort"
+
+// A Visitor's Visit method is called by Walk for each node it reaches. If the visitor w
+// it returns is not nil, Walk visits each of the node's children with w, followed by a
+// call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth-first, in the order the nodes appear in
+// the source: it calls v.Visit(node) and, unless that returns nil, walks each of the
+// node's children with the visitor it returned, then calls its Visit with nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	// Identifiers, literals, comments and loop control statements have no children.
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+
+	case *LetStatement:
+		Walk(v, n.Name)
+		walkExpression(v, n.Value)
+
+	case *ReturnStatement:
+		walkExpression(v, n.ReturnValue)
+
+	case *ThrowStatement:
+		walkExpression(v, n.Value)
+
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+
+	case *ImportStatement:
+		Walk(v, n.Path)
+		if n.Alias != nil {
+			Walk(v, n.Alias)
+		}
+		for _, name := range n.Names {
+			Walk(v, name)
+		}
+
+	case *WhileStatement:
+		walkExpression(v, n.Condition)
+		Walk(v, n.Body)
+
+	case *ForStatement:
+		if n.Init != nil {
+			Walk(v, n.Init)
+		}
+		walkExpression(v, n.Condition)
+		if n.Post != nil {
+			Walk(v, n.Post)
+		}
+		Walk(v, n.Body)
+
+	case *ForInStatement:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		Walk(v, n.Value)
+		walkExpression(v, n.Iterable)
+		Walk(v, n.Body)
+
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+
+	case *AssignExpression:
+		walkExpression(v, n.Target)
+		walkExpression(v, n.Value)
+
+	case *IfExpression:
+		walkExpression(v, n.Condition)
+		Walk(v, n.Consequence)
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+
+	case *TryExpression:
+		Walk(v, n.Block)
+		if n.Catch != nil {
+			Walk(v, n.Param)
+			Walk(v, n.Catch)
+		}
+		if n.Finally != nil {
+			Walk(v, n.Finally)
+		}
+
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+
+	case *CallExpression:
+		walkExpression(v, n.Function)
+		for _, arg := range n.Arguments {
+			walkExpression(v, arg)
+		}
+
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+
+	case *MemberExpression:
+		walkExpression(v, n.Object)
+		Walk(v, n.Member)
+
+	case *ArrayLiteral:
+		for _, element := range n.Elements {
+			walkExpression(v, element)
+		}
+
+	case *HashLiteral:
+		for _, key := range SortedKeys(n) {
+			walkExpression(v, key)
+			walkExpression(v, n.Pairs[key])
+		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+// walkExpression walks exp, if there is one: optional parts of a node, and those the
+// parser could not make sense of, are nil.
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+// SortedKeys returns the keys of a hash literal in the order they were written in.
+func SortedKeys(hash *HashLiteral) []Expression {
+	keys := make([]Expression, 0, len(hash.Pairs))
+	for key := range hash.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Pos().Offset < keys[j].Pos().Offset })
+	return keys
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in the order of Walk, calling f for each
+// node. If f returns true, Inspect goes on to the node's children, followed by a call
+// of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
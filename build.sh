@@ -2,5 +2,5 @@
 set -e
 
 go mod tidy
-go test -v ./... && go build
+go test -v ./... && go build -o ys ./cmd/ys
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shafik23/ys/debug"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+// debugCommand runs a script under the debugger, controlled from the terminal or, with
+// -dap, serves the Debug Adapter Protocol to an editor on standard input and output.
+func debugCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ys debug", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	dap := flags.Bool("dap", false, "serve the Debug Adapter Protocol on standard input and output")
+	path := flags.String("path", os.Getenv("YSPATH"), "directories to search for imports, separated by '"+string(os.PathListSeparator)+"'")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *dap {
+		if flags.NArg() > 0 {
+			fmt.Fprintln(stderr, "ys debug -dap takes no arguments: the editor says what to debug")
+			return 2
+		}
+		if err := debug.NewDAPServer(stdin, stdout, searchPath(*path)).Run(); err != nil {
+			fmt.Fprintln(stderr, "ys debug:", err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Fprintln(stderr, "usage: ys debug [-dap] [-path=dirs] file.ys [args...]")
+		return 2
+	}
+
+	file := filepath.Clean(flags.Arg(0))
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	p := parser.New(lexer.NewFile(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		fmt.Fprintln(stderr, strings.Join(p.Errors(), "\n"))
+		return 1
+	}
+
+	scriptArgs := &object.Array{}
+	for _, arg := range flags.Args()[1:] {
+		scriptArgs.Elements = append(scriptArgs.Elements, &object.String{Value: arg})
+	}
+
+	env := object.NewEnvironment()
+	env.Set("args", scriptArgs)
+
+	// The program and the debugger take turns reading standard input.
+	in := bufio.NewReader(stdin)
+
+	d := debug.New(object.NewIO(in, stdout, stderr), evaluator.NewModules(searchPath(*path)))
+	d.Start(program, env, true)
+
+	fmt.Fprintln(stdout, `Debugging `+file+`; type "help" for a list of commands.`)
+	result := debug.RunTerminal(d, in, stdout, map[string]string{file: string(src)})
+
+	if errObj, isErr := result.(*object.Error); isErr {
+		printError(stderr, errObj)
+		return 1
+	}
+
+	return 0
+}
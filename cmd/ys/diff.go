@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines a diff shows around each change.
+const diffContext = 3
+
+// edit is one line of a diff: kept (' '), removed ('-') or added ('+').
+type edit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns the changes that turn before into after, the contents of file
+// before and after formatting, in the unified format of `diff -u`. It returns "" if
+// nothing changed.
+func unifiedDiff(file, before, after string) string {
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+
+	// The line numbers, counting from 0, that each edit starts at on either side.
+	aLine := make([]int, len(edits)+1)
+	bLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.op != '+' {
+			aLine[i+1]++
+		}
+		if e.op != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk takes in every change that is close enough to the one before it for
+		// their context to meet.
+		last := i
+		for j := i + 1; j < len(edits) && j-last <= 2*diffContext; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+
+		start := max(i-diffContext, 0)
+		end := min(last+diffContext+1, len(edits))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", file, file)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[end]), hunkRange(bLine[start], bLine[end]))
+
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the lines from start up to end, counting from 0, as a hunk header
+// does: the first line counting from 1, or the line before an empty range, and the length.
+func hunkRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// splitLines splits s into lines, each keeping its newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest list of edits that turns a into b, found with Myers'
+// algorithm: for each number of lines changed d in turn, it follows every way of making
+// d changes as far along both lists as matching lines allow, until one reaches the end.
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m
+
+	// v[offset+k] is how far along a the furthest path on diagonal k = x-y reaches.
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // a line added
+			} else {
+				x = v[offset+k-1] + 1 // a line removed
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end along the path found, through the furthest points each
+	// round reached.
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{' ', a[x]})
+		}
+
+		if x == prevX {
+			y--
+			edits = append(edits, edit{'+', b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{' ', a[x]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
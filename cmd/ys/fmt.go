@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shafik23/ys/format"
+)
+
+// fmtCommand formats Ys source files, or standard input when given none. Directories
+// are searched for .ys files.
+func fmtCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ys fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	write := flags.Bool("w", false, "write the formatted source back to each file instead of printing it")
+	diff := flags.Bool("d", false, "print the changes formatting would make instead of the formatted source")
+	check := flags.Bool("check", false, "list the files that are not formatted, and exit with status 1 if there are any")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	f := &formatter{write: *write, diff: *diff, check: *check, stdout: stdout, stderr: stderr}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(stderr, "ys fmt: cannot use -w with standard input")
+			return 2
+		}
+
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		f.format("", "<standard input>", src)
+
+		return f.status()
+	}
+
+	for _, arg := range flags.Args() {
+		err := walkSources(arg, func(path string, src []byte) {
+			f.format(path, path, src)
+		})
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			f.failed = true
+		}
+	}
+
+	return f.status()
+}
+
+// formatter formats files as the flags of `ys fmt` ask.
+type formatter struct {
+	write, diff, check bool
+	stdout, stderr     io.Writer
+
+	failed      bool // a file could not be read, parsed or written
+	unformatted bool // a file was not formatted, in check mode
+}
+
+// format formats the source of file, which is called name in the output.
+func (f *formatter) format(file, name string, src []byte) {
+	formatted, err := format.Source(file, src)
+	if err != nil {
+		fmt.Fprintln(f.stderr, err)
+		f.failed = true
+		return
+	}
+
+	changed := !bytes.Equal(src, formatted)
+
+	if !f.write && !f.diff && !f.check {
+		f.stdout.Write(formatted)
+		return
+	}
+
+	if changed && f.check {
+		fmt.Fprintln(f.stdout, name)
+		f.unformatted = true
+	}
+
+	if changed && f.diff {
+		fmt.Fprint(f.stdout, unifiedDiff(name, string(src), string(formatted)))
+	}
+
+	if changed && f.write {
+		info, err := os.Stat(file)
+		if err == nil {
+			err = os.WriteFile(file, formatted, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintln(f.stderr, err)
+			f.failed = true
+		}
+	}
+}
+
+// status returns the exit code for the files formatted so far.
+func (f *formatter) status() int {
+	if f.failed || f.unformatted {
+		return 1
+	}
+	return 0
+}
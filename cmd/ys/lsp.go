@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/shafik23/ys/lsp"
+)
+
+// lspCommand serves the Language Server Protocol on standard input and output, for an
+// editor that started it.
+func lspCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ys lsp", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(stderr, "ys lsp takes no arguments")
+		return 2
+	}
+
+	if err := lsp.NewServer(stdin, stdout).Run(); err != nil {
+		fmt.Fprintln(stderr, "ys lsp:", err)
+		return 1
+	}
+
+	return 0
+}
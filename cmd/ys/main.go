@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/shafik23/ys/repl"
+)
+
+const usage = `Ys (pronounced "Wise") is a simple programming language.
+
+Usage:
+
+	ys <command> [arguments]
+
+The commands are:
+
+	run     run a script file:           ys run [-engine=eval|vm] [-path=dirs] file.ys [args...]
+	eval    run a program given inline:  ys eval [-engine=eval|vm] [-path=dirs] -e 'code' [args...]
+	repl    start the interactive REPL:  ys repl [-engine=eval|vm] [-path=dirs]
+	fmt     format source files:         ys fmt [-w] [-d] [-check] [files or dirs...]
+	vet     report likely mistakes:      ys vet [-enable=rules] [-disable=rules] [-json] [-rules] [files or dirs...]
+	lsp     serve editors over LSP:      ys lsp
+	debug   debug a script:              ys debug [-dap] [-path=dirs] file.ys [args...]
+	test    run tests:                   ys test [-run=regexp] [-v] [-json] [-junit=file] [-path=dirs] [files or dirs...]
+
+Running "ys file.ys [args...]" is shorthand for "ys run", so scripts can start
+with a "#!/usr/bin/env ys" line. Running "ys" on its own starts the REPL.
+
+ys run -profile=file profiles the script, and writes how long each function
+and line took, how often it ran and what it allocated to the file, for pprof
+or, with -profile-format=text or folded, as tables or flame graph stacks.
+
+Imports are looked up relative to the importing file, then in the -path
+directories, which default to $YSPATH.
+
+ys fmt prints the formatted source of the files, or of standard input, unless
+-w writes it back to them, -d prints the changes it would make or -check lists
+the files it would change and exits with status 1 if there are any.
+
+ys vet reports likely mistakes, such as unused variables and calls with the
+wrong number of arguments, and exits with status 1 if it finds any. -rules
+lists the rules it applies.
+
+ys lsp is a language server, which editors start to get diagnostics,
+go-to-definition, hover, document symbols, completion and formatting for Ys
+files. It speaks the Language Server Protocol on standard input and output.
+
+ys debug runs a script under a debugger, which stops before its first line and
+takes commands such as "break 12", "next" and "print x"; "help" lists them.
+With -dap, it instead serves the Debug Adapter Protocol on standard input and
+output, for editors to launch and control scripts with.
+
+ys test runs the functions named test_* in files named *_test.ys, each in a
+fresh environment in which assert, assert_eq and assert_error are defined, and
+exits with status 1 if any fail. -run selects tests by name, -json prints the
+results as JSON and -junit also writes them, as JUnit XML, to a file.
+`
+
+func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runCommand dispatches to a subcommand and returns the process exit code.
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return replCommand(args, stdin, stdout, stderr)
+	}
+
+	switch args[0] {
+	case "run":
+		return runFileCommand(args[1:], stdin, stdout, stderr)
+	case "eval":
+		return evalCommand(args[1:], stdin, stdout, stderr)
+	case "repl":
+		return replCommand(args[1:], stdin, stdout, stderr)
+	case "fmt":
+		return fmtCommand(args[1:], stdin, stdout, stderr)
+	case "vet":
+		return vetCommand(args[1:], stdin, stdout, stderr)
+	case "lsp":
+		return lspCommand(args[1:], stdin, stdout, stderr)
+	case "debug":
+		return debugCommand(args[1:], stdin, stdout, stderr)
+	case "test":
+		return testCommand(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+
+	if strings.HasPrefix(args[0], "-") {
+		return replCommand(args, stdin, stdout, stderr)
+	}
+
+	return runFileCommand(args, stdin, stdout, stderr)
+}
+
+// newFlagSet returns a flag set for a subcommand that reports errors to stderr, with the
+// flags every subcommand shares: the engine and the search path for imports.
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet("ys "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	engine := fs.String("engine", repl.EngineEval, "use 'eval' (tree-walking evaluator) or 'vm' (bytecode compiler and VM)")
+	path := fs.String("path", os.Getenv("YSPATH"), "directories to search for imports, separated by '"+string(os.PathListSeparator)+"'")
+
+	return fs, engine, path
+}
+
+// searchPath splits the value of the -path flag into directories.
+func searchPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return filepath.SplitList(path)
+}
+
+// walkSources calls fn with the source of the file named by arg or, if it is a
+// directory, of each .ys file in it. Files named directly are read whatever they are
+// called.
+func walkSources(arg string, fn func(path string, src []byte)) error {
+	return filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || (path != arg && filepath.Ext(path) != ".ys") {
+			return nil
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fn(path, src)
+
+		return nil
+	})
+}
+
+// checkEngine reports whether the engine name is valid, complaining to stderr if not.
+func checkEngine(engine string, stderr io.Writer) bool {
+	if engine != repl.EngineEval && engine != repl.EngineVM {
+		fmt.Fprintf(stderr, "unknown engine %q: want %q or %q\n", engine, repl.EngineEval, repl.EngineVM)
+		return false
+	}
+	return true
+}
+
+func replCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs, engine, path := newFlagSet("repl", stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !checkEngine(*engine, stderr) {
+		return 2
+	}
+
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintf(stdout, "You are Wise %s ... \n", user.Username)
+
+	opts := repl.Options{Engine: *engine, SearchPath: searchPath(*path)}
+	if home, err := os.UserHomeDir(); err == nil {
+		opts.HistoryFile = filepath.Join(home, ".ys_history")
+	}
+
+	repl.Start(stdin, stdout, opts)
+
+	return 0
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "script.ys")
+	src := "#!/usr/bin/env ys\nlet greet = fn(name) { \"hello \" + name }\ngreet(args[0])\n"
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := filepath.Join(dir, "broken.ys")
+	if err := os.WriteFile(broken, []byte("let x = 1;\nx + true;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	nested := filepath.Join(dir, "nested.ys")
+	if err := os.WriteFile(nested, []byte("let f = fn(x) {\n  x + true\n};\nf(1);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	echo := filepath.Join(dir, "echo.ys")
+	if err := os.WriteFile(echo, []byte("let first = readline();\nprint(input(\"LINE ONE? \"));\nputs(\"!\");\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cycle := filepath.Join(dir, "ca.ys")
+	if err := os.WriteFile(cycle, []byte("import \"cb.ys\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cb.ys"), []byte("import \"ca.ys\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := filepath.Join(dir, "lib")
+	if err := os.Mkdir(lib, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lib, "util.ys"), []byte("let twice = fn(x) { x * 2 };\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args       []string
+		stdin      string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"run", script, "world"}, "", 0, "", ""},
+		{[]string{script, "world"}, "", 0, "", ""},
+		{[]string{"run", "-engine=vm", script, "world"}, "", 0, "", ""},
+		{[]string{"run", broken}, "", 1, "", "broken.ys:2:1: type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", "-engine=vm", broken}, "", 1, "", "broken.ys:2:1: type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", nested}, "", 1, "", "nested.ys:2:3: type mismatch: INTEGER + BOOLEAN\n    at f (" + nested + ":2:3)\n    at <main> (" + nested + ":4:1)\n"},
+		{[]string{"run", "-engine=vm", nested}, "", 1, "", "    at f (" + nested + ":2:3)\n"},
+		{[]string{"run", cycle}, "", 1, "", "import cycle: ca.ys -> cb.ys -> ca.ys"},
+		{[]string{"run", "-engine=vm", cycle}, "", 1, "", "import cycle: ca.ys -> cb.ys -> ca.ys"},
+		{[]string{"eval", "-e", "len(args) * 10", "a", "b"}, "", 0, "20\n", ""},
+		{[]string{"eval", "-engine=vm", "-e", "len(args) * 10", "a", "b"}, "", 0, "20\n", ""},
+		{[]string{"eval", "-path=" + lib, "-e", "import \"util.ys\"; util.twice(21)"}, "", 0, "42\n", ""},
+		{[]string{"eval", "-engine=vm", "-path=" + lib, "-e", "from \"util.ys\" import twice; twice(21)"}, "", 0, "42\n", ""},
+		{[]string{"eval", "-e", "import \"util.ys\""}, "", 1, "", "cannot find module \"util.ys\""},
+		{[]string{"eval", "-e", "puts(args[0]); print(readline(), 1); warn(\"done\")", "hi"}, "", 0, "hi\nnull1", "done\n"},
+		{[]string{"run", "-engine=vm", echo}, "line one\nline two\n", 0, "LINE ONE? line two!\n", ""},
+		{[]string{"eval"}, "let x = 2\nx * 21", 0, "42\n", ""},
+		{[]string{"eval", "-e", "let = 1"}, "", 1, "", "1:5: expected next token to be IDENT"},
+		{[]string{"eval", "-engine=nope", "-e", "1"}, "", 2, "", "unknown engine"},
+		{[]string{"run"}, "", 2, "", "usage: ys run"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if stdout.String() != tt.wantStdout {
+			t.Errorf("ys %v: stdout wrong. want=%q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+}
+
+func TestRunProfile(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "script.ys")
+	if err := os.WriteFile(script, []byte("let sq = fn(x) { x * x };\nputs(sq(3));\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format   string
+		exitCode int
+		want     string // what the profile starts with, or the error
+	}{
+		{"", 0, "\x1f\x8b"}, // gzipped
+		{"text", 0, "Total time: "},
+		{"folded", 0, "main "},
+		{"json", 2, "unknown profile format \"json\""},
+	}
+
+	for _, tt := range tests {
+		out := filepath.Join(dir, "profile."+tt.format)
+		args := []string{"run", "--profile=" + out, script}
+		if tt.format != "" {
+			args = append([]string{"run", "-profile-format=" + tt.format}, args[1:]...)
+		}
+
+		var stdout, stderr bytes.Buffer
+		code := runCommand(args, strings.NewReader(""), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", args, tt.exitCode, code, stderr.String())
+		}
+		if code != 0 {
+			if !strings.Contains(stderr.String(), tt.want) {
+				t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", args, tt.want, stderr.String())
+			}
+			continue
+		}
+
+		if stdout.String() != "9\n" {
+			t.Errorf("ys %v: stdout wrong. got=%q", args, stdout.String())
+		}
+		profile, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(profile), tt.want) {
+			t.Errorf("ys %v: profile wrong. want it to start with %q, got=%q", args, tt.want, profile)
+		}
+	}
+
+	var stderr bytes.Buffer
+	if code := runCommand([]string{"run", "-engine=vm", "-profile=" + filepath.Join(dir, "p"), script}, strings.NewReader(""), io.Discard, &stderr); code != 2 {
+		t.Errorf("profiling on the VM: exit code wrong. want=2, got=%d", code)
+	}
+}
+
+func TestFmtCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	formatted := "let x = 1;\n"
+	messy := "let x=1\n"
+
+	// Tests can use the assertion builtins without defining them.
+	test := filepath.Join(dir, "script_test.ys")
+	if err := os.WriteFile(test, []byte("let test_it = fn() { assert_eq(1, 1) };\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clean := filepath.Join(dir, "clean.ys")
+	if err := os.WriteFile(clean, []byte(formatted), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dirty := filepath.Join(sub, "dirty.ys")
+	if err := os.WriteFile(dirty, []byte(messy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "notes.txt"), []byte("not ys"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := filepath.Join(dir, "broken.ys")
+	if err := os.WriteFile(broken, []byte("let = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := "--- " + dirty + ".orig\n+++ " + dirty + "\n@@ -1,1 +1,1 @@\n-let x=1\n+let x = 1;\n"
+
+	tests := []struct {
+		args       []string
+		stdin      string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"fmt"}, "puts( 1 )", 0, "puts(1);\n", ""},
+		{[]string{"fmt", clean}, "", 0, formatted, ""},
+		{[]string{"fmt", "-check", dir + "/clean.ys"}, "", 0, "", ""},
+		{[]string{"fmt", "-check", sub}, "", 1, dirty + "\n", ""},
+		{[]string{"fmt", "-d", sub}, "", 0, diff, ""},
+		{[]string{"fmt", "-check"}, "x", 1, "<standard input>\n", ""},
+		{[]string{"fmt", broken}, "", 1, "", "broken.ys:1:5: expected next token to be IDENT"},
+		{[]string{"fmt", "-w"}, "x", 2, "", "cannot use -w with standard input"},
+		{[]string{"fmt", filepath.Join(dir, "missing.ys")}, "", 1, "", "no such file or directory"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if stdout.String() != tt.wantStdout {
+			t.Errorf("ys %v: stdout wrong. want=%q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"fmt", "-w", dir}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("ys fmt -w: exit code wrong. want=1 for broken.ys, got=%d", code)
+	}
+
+	got, err := os.ReadFile(dirty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != formatted {
+		t.Errorf("ys fmt -w did not rewrite the file. got=%q", got)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		before, after string
+		expected      string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nx\nc\n", "--- f.orig\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"", "a\n", "--- f.orig\n+++ f\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"a", "a\n", "--- f.orig\n+++ f\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			"--- f.orig\n+++ f\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"x\n2\n3\n4\n5\n6\ny\n",
+			"--- f.orig\n+++ f\n@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := unifiedDiff("f", tt.before, tt.after); got != tt.expected {
+			t.Errorf("unifiedDiff(%q, %q) wrong.\nwant=%q\ngot= %q", tt.before, tt.after, tt.expected, got)
+		}
+	}
+}
+
+func TestVetCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "script.ys")
+	src := "let f = fn(a) {\n  let unused = 1;\n  return a;\n  puts(a)\n};\nf(1, 2);\nputs(args, nope);\n"
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tests can use the assertion builtins without defining them.
+	test := filepath.Join(dir, "script_test.ys")
+	if err := os.WriteFile(test, []byte("let test_it = fn() { assert_eq(1, 1) };\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clean := filepath.Join(dir, "clean.ys")
+	if err := os.WriteFile(clean, []byte("let f = fn(a) { a };\nf(1);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	all := script + ":2:7: unused is defined but never used (unused)\n" +
+		script + ":4:3: unreachable code (unreachable)\n" +
+		script + ":6:1: f takes 1 argument, but is called with 2 (arity)\n" +
+		script + ":7:12: undefined: nope (undefined)\n"
+
+	tests := []struct {
+		args       []string
+		stdin      string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"vet", script}, "", 1, all, ""},
+		{[]string{"vet", clean}, "", 0, "", ""},
+		{[]string{"vet", test}, "", 0, "", ""},
+		{[]string{"vet", "-enable=arity, undefined", script}, "", 1, script + ":6:1: f takes 1 argument, but is called with 2 (arity)\n" + script + ":7:12: undefined: nope (undefined)\n", ""},
+		{[]string{"vet", "-disable=unused,unreachable,undefined", script}, "", 1, script + ":6:1: f takes 1 argument, but is called with 2 (arity)\n", ""},
+		{[]string{"vet", "-enable=arity", "-disable=arity", script}, "", 2, "", "no rules to run"},
+		{[]string{"vet", "-enable=nope", script}, "", 2, "", `unknown rule "nope"`},
+		{[]string{"vet", "-json", "-enable=undefined"}, "x", 1, "[\n  {\n    \"file\": \"\",\n    \"line\": 1,\n    \"column\": 1,\n    \"rule\": \"undefined\",\n    \"message\": \"undefined: x\"\n  }\n]\n", ""},
+		{[]string{"vet", "-json", clean}, "", 0, "[]\n", ""},
+		{[]string{"vet"}, "let = 1", 1, "", "1:5: expected next token to be IDENT"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if stdout.String() != tt.wantStdout {
+			t.Errorf("ys %v: stdout wrong. want=%q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+}
+
+func TestLspCommand(t *testing.T) {
+	frame := func(body string) string {
+		return "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	}
+	shutdown := frame(`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`)
+	exit := frame(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	tests := []struct {
+		args       []string
+		stdin      string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"lsp"}, shutdown + exit, 0, `{"jsonrpc":"2.0","id":1,"result":null}`, ""},
+		{[]string{"lsp"}, exit, 1, "", "exit without shutdown"},
+		{[]string{"lsp"}, "", 0, "", ""},
+		{[]string{"lsp", "file.ys"}, "", 2, "", "takes no arguments"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), tt.wantStdout) {
+			t.Errorf("ys %v: stdout wrong. want it to contain %q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+}
+
+func TestDebugCommand(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.ys")
+	if err := os.WriteFile(script, []byte("let name = readline();\nputs(\"hi \" + name + \" \" + args[0]);\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args       []string
+		stdin      string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		// The program reads its input from between the debugger's commands.
+		{[]string{"debug", script, "there"}, "n\nbob\nc\n", 0, "(ysdb) stopped at " + script + ":2:1 (step)\n>    2  puts(\"hi \" + name + \" \" + args[0]);\n(ysdb) hi bob there\n", ""},
+		{[]string{"debug", script}, "q\n", 0, "stopped at " + script + ":1:1 (entry)", ""},
+		{[]string{"debug", script}, "c\n", 1, "", "type mismatch: STRING + NULL"},
+		{[]string{"debug"}, "", 2, "", "usage: ys debug"},
+		{[]string{"debug", "-dap", script}, "", 2, "", "takes no arguments"},
+		{[]string{"debug", "-dap"}, "", 0, "", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), tt.wantStdout) {
+			t.Errorf("ys %v: stdout wrong. want it to contain %q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+}
+
+func TestTestCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	src := "let test_pass = fn() { assert_eq(1 + 1, 2) };\nlet test_fail = fn() {\n  puts(\"here\");\n  assert(false, \"no\")\n};\n"
+	file := filepath.Join(dir, "a_test.ys")
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "not_a_test.ys"), []byte("nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad_test.ys")
+	if err := os.WriteFile(bad, []byte("let = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	junit := filepath.Join(dir, "report.xml")
+
+	tests := []struct {
+		args       []string
+		exitCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"test", dir}, 1, "--- FAIL: test_fail (", ""},
+		{[]string{"test", dir}, 1, "    " + file + ":4:3: assertion failed: no\n    output:\n      here\nFAIL  " + file, ""},
+		{[]string{"test", "-run=pass", dir}, 0, "--- PASS: test_pass (", ""},
+		{[]string{"test", "-run=pass", dir}, 0, "ok    " + file, ""},
+		{[]string{"test", "-run=nothing", dir}, 0, "[no tests to run]", ""},
+		{[]string{"test", "-json", "-run=pass", file}, 0, "\"status\": \"pass\"", ""},
+		{[]string{"test", "-junit=" + junit, dir}, 1, "--- FAIL: test_fail (", ""},
+		{[]string{"test", "-run=(", dir}, 2, "", "bad -run"},
+		{[]string{"test", bad}, 1, bad + ":1:5: expected next token to be IDENT", ""},
+		{[]string{"test", t.TempDir()}, 1, "", "no test files"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := runCommand(tt.args, strings.NewReader(""), &stdout, &stderr)
+
+		if code != tt.exitCode {
+			t.Errorf("ys %v: exit code wrong. want=%d, got=%d (stderr=%q)", tt.args, tt.exitCode, code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), tt.wantStdout) {
+			t.Errorf("ys %v: stdout wrong. want it to contain %q, got=%q", tt.args, tt.wantStdout, stdout.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("ys %v: stderr wrong. want it to contain %q, got=%q", tt.args, tt.wantStderr, stderr.String())
+		}
+	}
+
+	report, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), `<testsuite name="`+file+`" tests="2" failures="1" errors="0"`) {
+		t.Errorf("JUnit report wrong. got=%s", report)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/compiler"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/profile"
+	"github.com/shafik23/ys/repl"
+	"github.com/shafik23/ys/vm"
+)
+
+func runFileCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs, engine, path := newFlagSet("run", stderr)
+	profileFile := fs.String("profile", "", "profile the script, and write the profile to this file")
+	profileFormat := fs.String("profile-format", "pprof", "write the profile for 'pprof', as a 'text' table, or as 'folded' stacks for flame graphs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !checkEngine(*engine, stderr) {
+		return 2
+	}
+
+	if fs.NArg() < 1 {
+		fmt.Fprintln(stderr, "usage: ys run [-engine=eval|vm] [-path=dirs] [-profile=file] [-profile-format=pprof|text|folded] file.ys [args...]")
+		return 2
+	}
+
+	var prof *profile.Profile
+	if *profileFile != "" {
+		if *engine != repl.EngineEval {
+			fmt.Fprintln(stderr, "ys run: -profile needs -engine=eval")
+			return 2
+		}
+		if _, ok := profileWriters[*profileFormat]; !ok {
+			fmt.Fprintf(stderr, "unknown profile format %q: want \"pprof\", \"text\" or \"folded\"\n", *profileFormat)
+			return 2
+		}
+		prof = profile.New()
+	}
+
+	file := fs.Arg(0)
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	result, ok := execute(file, string(src), *engine, searchPath(*path), fs.Args()[1:], object.NewIO(stdin, stdout, stderr), prof)
+	if !ok {
+		return 1
+	}
+
+	if prof != nil {
+		if err := writeProfile(prof, *profileFile, *profileFormat); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	if errObj, isErr := result.(*object.Error); isErr {
+		printError(stderr, errObj)
+		return 1
+	}
+
+	return 0
+}
+
+func evalCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs, engine, path := newFlagSet("eval", stderr)
+	expr := fs.String("e", "", "the program to evaluate; read from standard input if not given")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !checkEngine(*engine, stderr) {
+		return 2
+	}
+
+	src := *expr
+	if src == "" {
+		input, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		src = string(input)
+	}
+
+	result, ok := execute("", src, *engine, searchPath(*path), fs.Args(), object.NewIO(stdin, stdout, stderr), nil)
+	if !ok {
+		return 1
+	}
+
+	if errObj, isErr := result.(*object.Error); isErr {
+		printError(stderr, errObj)
+		return 1
+	}
+
+	if result != nil && result != evaluator.NULL {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+
+	return 0
+}
+
+// execute parses and runs a whole program on the given engine, with the script arguments
+// bound to the global `args`, imports searched for in searchPath and streams for its
+// input and output, profiling it with prof if it is not nil. It reports false, on the
+// error stream, if the program could not be parsed or compiled.
+func execute(file, src, engine string, searchPath, args []string, streams *object.IO, prof *profile.Profile) (object.Object, bool) {
+	l := lexer.NewFile(file, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(streams.Err, msg)
+		}
+		return nil, false
+	}
+
+	scriptArgs := &object.Array{Elements: make([]object.Object, len(args))}
+	for i, arg := range args {
+		scriptArgs.Elements[i] = &object.String{Value: arg}
+	}
+
+	modules := evaluator.NewModules(searchPath)
+	defer modules.Running(file)()
+
+	if engine == repl.EngineVM {
+		return executeVM(program, scriptArgs, modules, streams)
+	}
+
+	env := object.NewEnvironment()
+	env.Set("args", scriptArgs)
+
+	e := evaluator.New()
+	e.SetModules(modules)
+	e.SetIO(streams)
+	if prof != nil {
+		e.SetProfiler(prof)
+	}
+
+	return e.Eval(program, env), true
+}
+
+func executeVM(program *ast.Program, scriptArgs *object.Array, modules *evaluator.Modules, streams *object.IO) (object.Object, bool) {
+	globals := make([]object.Object, vm.GlobalsSize)
+
+	comp := compiler.New()
+	globals[comp.SymbolTable().Define("args").Index] = scriptArgs
+
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintln(streams.Err, err)
+		return nil, false
+	}
+
+	machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
+	machine.SetModules(modules)
+	machine.SetIO(streams)
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj, true
+		}
+		fmt.Fprintln(streams.Err, err)
+		return nil, false
+	}
+
+	return machine.LastPoppedStackElem(), true
+}
+
+// profileWriters write a profile in each of the formats -profile-format names.
+var profileWriters = map[string]func(*profile.Profile, io.Writer) error{
+	"pprof":  (*profile.Profile).WritePprof,
+	"text":   (*profile.Profile).WriteText,
+	"folded": (*profile.Profile).WriteFolded,
+}
+
+// writeProfile writes prof to the named file in format.
+func writeProfile(prof *profile.Profile, file, format string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := profileWriters[format](prof, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printError reports a runtime error, with its stack trace if it happened inside a function.
+func printError(w io.Writer, err *object.Error) {
+	fmt.Fprintln(w, err.Inspect())
+	if len(err.Stack) > 1 {
+		fmt.Fprint(w, err.StackTrace())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shafik23/ys/ystest"
+)
+
+// testCommand runs the tests in the _test.ys files named, or found in the directories
+// named, which default to the current one.
+func testCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ys test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	run := flags.String("run", "", "run only the tests whose names match this regular expression")
+	verbose := flags.Bool("v", false, "print what every test printed, not only those that did not pass")
+	asJSON := flags.Bool("json", false, "print the results as JSON instead of text")
+	junit := flags.String("junit", "", "also write the results, in JUnit XML, to this file")
+	path := flags.String("path", os.Getenv("YSPATH"), "directories to search for imports, separated by '"+string(os.PathListSeparator)+"'")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	runner := &ystest.Runner{SearchPath: searchPath(*path)}
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintln(stderr, "ys test: bad -run:", err)
+			return 2
+		}
+		runner.Run = re
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := ystest.Find(paths...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(stderr, "ys test: no test files")
+		return 1
+	}
+
+	passed := true
+	var suites []*ystest.Suite
+	for _, file := range files {
+		suite := &ystest.Suite{File: file}
+		if src, err := os.ReadFile(file); err != nil {
+			suite.Err = err
+		} else {
+			suite = runner.RunFile(file, string(src))
+		}
+		suites = append(suites, suite)
+
+		if !*asJSON {
+			printSuite(stdout, suite, *verbose)
+		}
+		passed = passed && suite.Passed()
+	}
+
+	if *asJSON {
+		if err := ystest.WriteJSON(stdout, suites); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	if *junit != "" {
+		f, err := os.Create(*junit)
+		if err == nil {
+			err = ystest.WriteJUnit(f, suites)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+// printSuite reports how each test in a file did, with why it failed and what it printed
+// if it did not pass, and then how the file did.
+func printSuite(w io.Writer, suite *ystest.Suite, verbose bool) {
+	if suite.Err != nil {
+		fmt.Fprintln(w, suite.Err)
+	}
+
+	for _, result := range suite.Results {
+		fmt.Fprintf(w, "--- %s: %s (%s)\n", strings.ToUpper(string(result.Status)), result.Name, duration(result.Duration))
+
+		if message := result.Message(); message != "" {
+			fmt.Fprintln(w, indent(message, "    "))
+		}
+		if result.Output != "" && (verbose || result.Status != ystest.Pass) {
+			fmt.Fprintln(w, "    output:")
+			fmt.Fprintln(w, indent(strings.TrimSuffix(result.Output, "\n"), "      "))
+		}
+	}
+
+	status, note := "ok  ", ""
+	if !suite.Passed() {
+		status = "FAIL"
+	} else if len(suite.Results) == 0 {
+		note = " [no tests to run]"
+	}
+	fmt.Fprintf(w, "%s  %s  %s%s\n", status, suite.File, duration(suite.Duration), note)
+}
+
+// duration formats a duration in seconds, as test results give them.
+func duration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+// indent puts prefix before each line of s.
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
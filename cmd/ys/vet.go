@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/vet"
+	"github.com/shafik23/ys/ystest"
+)
+
+// vetCommand reports suspicious constructs in Ys source files, or in standard input when
+// given none. Directories are searched for .ys files.
+func vetCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ys vet", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	var names []string
+	for _, rule := range vet.Rules() {
+		names = append(names, rule.Name)
+	}
+
+	enable := flags.String("enable", "", "run only these rules, separated by commas, of: "+strings.Join(names, ", "))
+	disable := flags.String("disable", "", "do not run these rules, separated by commas")
+	asJSON := flags.Bool("json", false, "print the problems found as a JSON array")
+	list := flags.Bool("rules", false, "list the rules and what they report")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		for _, rule := range vet.Rules() {
+			fmt.Fprintf(stdout, "%-12s %s\n", rule.Name, rule.Doc)
+		}
+		return 0
+	}
+
+	rules, err := selectRules(*enable, *disable)
+	if err != nil {
+		fmt.Fprintln(stderr, "ys vet:", err)
+		return 2
+	}
+
+	v := &vetter{rules: rules, stderr: stderr}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		v.vet("", src)
+	}
+
+	for _, arg := range flags.Args() {
+		if err := walkSources(arg, v.vet); err != nil {
+			fmt.Fprintln(stderr, err)
+			v.failed = true
+		}
+	}
+
+	if *asJSON {
+		if v.diagnostics == nil {
+			v.diagnostics = []vet.Diagnostic{}
+		}
+		out, _ := json.MarshalIndent(v.diagnostics, "", "  ")
+		fmt.Fprintln(stdout, string(out))
+	} else {
+		for _, d := range v.diagnostics {
+			fmt.Fprintln(stdout, d)
+		}
+	}
+
+	if v.failed || len(v.diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// selectRules returns the rules named in enable, or all of them if it is empty, less
+// those named in disable.
+func selectRules(enable, disable string) ([]*vet.Rule, error) {
+	rules := vet.Rules()
+
+	if enable != "" {
+		rules = nil
+		for _, name := range strings.Split(enable, ",") {
+			rule, ok := vet.Lookup(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	if disable != "" {
+		disabled := map[*vet.Rule]bool{}
+		for _, name := range strings.Split(disable, ",") {
+			rule, ok := vet.Lookup(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			disabled[rule] = true
+		}
+
+		kept := rules[:0:0]
+		for _, rule := range rules {
+			if !disabled[rule] {
+				kept = append(kept, rule)
+			}
+		}
+		rules = kept
+	}
+
+	if len(rules) == 0 {
+		return nil, errors.New("no rules to run")
+	}
+
+	return rules, nil
+}
+
+// vetter collects what the rules report in each file.
+type vetter struct {
+	rules  []*vet.Rule
+	stderr io.Writer
+
+	diagnostics []vet.Diagnostic
+	failed      bool // a file could not be read or parsed
+}
+
+func (v *vetter) vet(file string, src []byte) {
+	p := parser.New(lexer.NewFile(file, string(src)))
+
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(v.stderr, err)
+		}
+		v.failed = true
+		return
+	}
+
+	// Scripts run by `ys run` and `ys eval` are given their arguments as args, and tests
+	// run by `ys test` the assertion builtins too.
+	predeclared := []string{"args"}
+	if ystest.IsTestFile(file) {
+		predeclared = append(predeclared, ystest.BuiltinNames()...)
+	}
+
+	v.diagnostics = append(v.diagnostics, vet.Check(program, v.rules, predeclared...)...)
+}
@@ -0,0 +1,316 @@
+// File: code/code.go
+
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a flat sequence of encoded opcodes and their operands.
+type Instructions []byte
+
+// String disassembles the instructions, one per line, prefixed by their offset.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+// Opcode identifies a single VM instruction.
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpGreaterEqual
+	OpLessEqual
+
+	OpMinus
+	OpBang
+
+	OpJumpNotTruthy
+	OpJump
+	OpJumpNotTruthyOrPop
+	OpJumpTruthyOrPop
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+
+	OpArray
+	OpHash
+	OpIndex
+
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	OpIter
+	OpIterNext
+
+	OpDup
+	OpAssignGlobal
+	OpSetFree
+	OpCaptureLocal
+	OpCaptureFree
+	OpSetIndex
+
+	OpTry
+	OpEndTry
+	OpThrow
+
+	OpImport
+	OpMember
+
+	OpGetLocalIfSet
+	OpSetLocalIfSet
+	OpGetFreeIfSet
+	OpSetFreeIfSet
+)
+
+// Definition describes an opcode: its readable name and the width in bytes of each operand.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+
+	OpPop: {"OpPop", []int{}},
+
+	OpAdd: {"OpAdd", []int{}},
+	OpSub: {"OpSub", []int{}},
+	OpMul: {"OpMul", []int{}},
+	OpDiv: {"OpDiv", []int{}},
+	OpMod: {"OpMod", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+	// OpJumpNotTruthyOrPop and OpJumpTruthyOrPop implement && and ||: they jump, leaving the
+	// condition on the stack as the result, or pop it and go on to evaluate the right side.
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpClosure:     {"OpClosure", []int{2, 1}},
+
+	// OpIter turns the iterable on top of the stack into an iterator for a for-in loop.
+	OpIter: {"OpIter", []int{}},
+	// OpIterNext pops an iterator and either jumps to its first operand when it is exhausted,
+	// or pushes the next value (and, when its second operand is 2, the key before it).
+	OpIterNext: {"OpIterNext", []int{2, 1}},
+
+	// OpDup pushes copies of the given number of elements on top of the stack.
+	OpDup: {"OpDup", []int{1}},
+	// OpAssignGlobal is OpSetGlobal for an assignment, which fails if the global was never defined.
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpSetFree:      {"OpSetFree", []int{1}},
+	// OpCaptureLocal and OpCaptureFree push the cell holding a variable, rather than its value,
+	// so that a closure shares the variable with the scope it was captured from.
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+	// OpSetIndex pops a value, an index and an array or hash, stores the value and pushes it back.
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	// OpTry installs an exception handler at its first operand. When an error is raised
+	// before the matching OpEndTry removes it, the VM unwinds to the handler and pushes
+	// the error's value when the second operand is 1 (a catch), or the error itself to be
+	// thrown again once a finally block has run.
+	OpTry:    {"OpTry", []int{2, 1}},
+	OpEndTry: {"OpEndTry", []int{}},
+	// OpThrow pops a value and raises it as an error.
+	OpThrow: {"OpThrow", []int{}},
+
+	// OpImport pushes the module loaded from the file named by the constant at its operand.
+	OpImport: {"OpImport", []int{2}},
+	// OpMember replaces the module or hash on top of the stack with its member named by
+	// the constant at its operand.
+	OpMember: {"OpMember", []int{2}},
+
+	// OpGetLocalIfSet and the others read or write the variable at their first operand and
+	// jump to their second if a let has set it; otherwise they go on to the next binding of
+	// the name, which the variable shadows once its let has run.
+	OpGetLocalIfSet: {"OpGetLocalIfSet", []int{1, 2}},
+	OpSetLocalIfSet: {"OpSetLocalIfSet", []int{1, 2}},
+	OpGetFreeIfSet:  {"OpGetFreeIfSet", []int{1, 2}},
+	OpSetFreeIfSet:  {"OpSetFreeIfSet", []int{1, 2}},
+}
+
+// Lookup returns the definition of the given opcode.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// CheckOperands reports the first operand of op that does not fit in the width its
+// definition gives it, which Make would otherwise silently truncate.
+func CheckOperands(op Opcode, operands ...int) error {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Errorf("opcode %d undefined", op)
+	}
+
+	for i, o := range operands {
+		if i >= len(def.OperandWidths) {
+			break
+		}
+
+		max := 1<<(8*uint(def.OperandWidths[i])) - 1
+		if o < 0 || o > max {
+			return fmt.Errorf("%s operand %d is out of range 0-%d", def.Name, o, max)
+		}
+	}
+
+	return nil
+}
+
+// Make encodes an opcode and its operands into a single instruction. Operands that do
+// not fit their width are truncated; CheckOperands reports them.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction and reports how many bytes were read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// ReadUint16 decodes a big-endian two byte operand.
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one byte operand.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,1035 @@
+// File: compiler/compiler.go
+
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/code"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+// EmittedInstruction remembers an opcode and where it was written, so the compiler can
+// look back at (and patch or drop) the instructions it just produced.
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function currently being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	positions           map[int]token.Pos
+	loops               []*loop
+	tries               []*try
+}
+
+// loop collects the jumps emitted by break and continue statements in a loop body,
+// to be patched once the loop's end and continue target are known.
+type loop struct {
+	breaks    []int
+	continues []int
+	tries     int // how many tries were open where the loop starts
+}
+
+// try is a try expression being compiled: how many exception handlers it has installed
+// at the point being compiled, and the finally block that leaving it early must run.
+type try struct {
+	handlers int
+	finally  *ast.BlockStatement
+}
+
+// Compiler lowers an AST into bytecode for the VM.
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// operandErr is the first operand emitted that did not fit its instruction,
+	// reported with a position by the innermost Compile call that was running.
+	operandErr error
+}
+
+// Bytecode is the result of a compilation: the instructions of the main program,
+// the constant pool they refer to, and the names of the global slots.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Globals      []string
+	Positions    map[int]token.Pos
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+var prefixOpcodes = map[string]code.Opcode{
+	"!": code.OpBang,
+	"-": code.OpMinus,
+}
+
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+		positions:           map[int]token.Pos{},
+	}
+
+	symbolTable := NewSymbolTable()
+
+	for i, name := range evaluator.BuiltinNames() {
+		symbolTable.DefineBuiltin(i, name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a compiler that continues from an existing symbol table and
+// constant pool, as the REPL does between lines.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// SymbolTable returns the compiler's global symbol table.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+// Compile lowers node into the current scope's instructions. It fails if the program
+// needs more locals, arguments, constants or bytecode than the instructions can address.
+func (c *Compiler) Compile(node ast.Node) error {
+	if err := c.compile(node); err != nil {
+		c.operandErr = nil
+		return err
+	}
+
+	if c.operandErr != nil {
+		err := fmt.Errorf("%s: program too large to compile: %s", node.Pos(), c.operandErr)
+		c.operandErr = nil
+		return err
+	}
+
+	return nil
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		// The lets in a block are only known to have run until the block ends.
+		bound := len(c.symbolTable.bound)
+
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+		c.symbolTable.bound = c.symbolTable.bound[:bound]
+
+	case *ast.LetStatement:
+		// The name is bound after its value is compiled, so `let x = x + 1` sees the outer x.
+		// Recursive functions refer to themselves through their FunctionScope name instead.
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+
+		c.storeSymbol(symbol)
+		c.symbolTable.bind(node.Name.Value)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.unwindTries(0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.setPosition(c.emit(code.OpThrow), node)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.ImportStatement:
+		path := c.addConstant(&object.String{Value: node.Path.Value})
+
+		if len(node.Names) == 0 {
+			c.setPosition(c.emit(code.OpImport, path), node)
+			c.storeSymbol(c.symbolTable.Define(node.Alias.Value))
+		}
+
+		// Importing the module again for each name is cheap: it is only loaded once.
+		for _, name := range node.Names {
+			c.setPosition(c.emit(code.OpImport, path), node)
+			c.setPosition(c.emit(code.OpMember, c.addConstant(&object.String{Value: name.Value})), name)
+			c.storeSymbol(c.symbolTable.Define(name.Value))
+		}
+
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+
+		c.setPosition(c.emit(code.OpMember, c.addConstant(&object.String{Value: node.Member.Value})), node)
+
+	case *ast.Identifier:
+		c.loadBindings(c.bindings(node.Value), node)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
+
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.setPosition(c.emit(op), node)
+
+	case *ast.PrefixExpression:
+		op, ok := prefixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.setPosition(c.emit(op), node)
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// Emit an `OpJumpNotTruthy` with a bogus value, patched once the consequence is compiled.
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileBlockValue(node.Consequence); err != nil {
+			return err
+		}
+
+		// Emit an `OpJump` with a bogus value, patched once the alternative is compiled.
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.WhileStatement:
+		start := len(c.currentInstructions())
+
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		end, err := c.compileLoopBody(node.Body, start, func() int {
+			return start
+		})
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpNotTruthyPos, end)
+
+	case *ast.ForStatement:
+		if node.Init != nil {
+			if err := c.Compile(node.Init); err != nil {
+				return err
+			}
+		}
+
+		start := len(c.currentInstructions())
+
+		jumpNotTruthyPos := -1
+		if node.Condition != nil {
+			if err := c.Compile(node.Condition); err != nil {
+				return err
+			}
+			jumpNotTruthyPos = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		var postErr error
+		end, err := c.compileLoopBody(node.Body, start, func() int {
+			// continue jumps to the post statement, which runs before looping back.
+			postPos := len(c.currentInstructions())
+			if node.Post != nil {
+				postErr = c.Compile(node.Post)
+			}
+			return postPos
+		})
+		if err != nil {
+			return err
+		}
+		if postErr != nil {
+			return postErr
+		}
+
+		if jumpNotTruthyPos >= 0 {
+			c.changeOperand(jumpNotTruthyPos, end)
+		}
+
+	case *ast.ForInStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+
+		c.setPosition(c.emit(code.OpIter), node.Iterable)
+
+		// The iterator lives in a hidden variable, so break and continue leave the stack alone.
+		iterator := c.symbolTable.Define(fmt.Sprintf("@iterator%d", len(c.scopes[c.scopeIndex].loops)))
+		c.storeSymbol(iterator)
+
+		start := len(c.currentInstructions())
+
+		c.loadSymbol(iterator)
+
+		numVars := 1
+		if node.Key != nil {
+			numVars = 2
+		}
+		iterNextPos := c.emit(code.OpIterNext, 9999, numVars)
+
+		c.storeSymbol(c.symbolTable.Define(node.Value.Value))
+		if node.Key != nil {
+			c.storeSymbol(c.symbolTable.Define(node.Key.Value))
+		}
+
+		end, err := c.compileLoopBody(node.Body, start, func() int {
+			return start
+		})
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(iterNextPos, end, numVars)
+
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("%s: %s outside of a loop", node.Pos(), node.TokenLiteral())
+		}
+
+		current := loops[len(loops)-1]
+		if err := c.unwindTries(current.tries); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		if _, ok := node.(*ast.BreakStatement); ok {
+			current.breaks = append(current.breaks, jumpPos)
+		} else {
+			current.continues = append(current.continues, jumpPos)
+		}
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// The pairs are built in the order they were written in, which the hash keeps.
+		for _, k := range ast.SortedKeys(node) {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+
+		c.setPosition(c.emit(code.OpHash, len(node.Pairs)*2), node)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		c.setPosition(c.emit(code.OpIndex), node)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" && !assigns(node.Body, node.Name) {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+		c.declareLets(node.Body)
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.Locals()
+		positions := c.scopes[c.scopeIndex].positions
+		instructions := c.leaveScope()
+
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			freeNames[i] = s.Name
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Parameters:    node.Parameters,
+			Body:          node.Body,
+			Positions:     positions,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
+		}
+
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		c.setPosition(c.emit(code.OpCall, len(node.Arguments)), node)
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+
+	return nil
+}
+
+// Bytecode returns the compiled program.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Globals:      c.symbolTable.Globals(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+	}
+}
+
+// compileLoopBody compiles the body of a loop that starts at start, followed by a jump back
+// to it. continueTarget emits whatever must run before looping back and returns where
+// continue statements should jump to. It returns the loop's end, where break statements
+// and the loop condition exit to.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, start int, continueTarget func() int) (int, error) {
+	scope := &c.scopes[c.scopeIndex]
+	current := &loop{tries: len(scope.tries)}
+	scope.loops = append(scope.loops, current)
+
+	err := c.Compile(body)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	if err != nil {
+		return 0, err
+	}
+
+	continuePos := continueTarget()
+	c.emit(code.OpJump, start)
+	end := len(c.currentInstructions())
+
+	for _, pos := range current.continues {
+		c.changeOperand(pos, continuePos)
+	}
+	for _, pos := range current.breaks {
+		c.changeOperand(pos, end)
+	}
+
+	// Loops are statements: leave null rather than the body's last value as the result.
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+
+	return end, nil
+}
+
+// compileBlockValue compiles a block used as an expression, leaving its value on the stack.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionIs(code.OpReturnValue) {
+		// An empty block, or one ending in a let, has no value.
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileTry compiles a try expression, leaving the value of its block, or of its catch
+// if the block raised an error, on the stack.
+//
+// The catch is guarded by an OpTry whose handler binds the error's value to the catch
+// variable. A finally block is compiled twice: once where the try ends normally, and once
+// behind an outer handler that runs it and throws the error again. Return, break and
+// continue statements that leave the try early run it too, through unwindTries.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	current := &try{finally: node.Finally}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, current)
+
+	finallyHandler := -1
+	if node.Finally != nil {
+		finallyHandler = c.emit(code.OpTry, 9999, 0)
+		current.handlers++
+	}
+
+	catchHandler := -1
+	if node.Catch != nil {
+		catchHandler = c.emit(code.OpTry, 9999, 1)
+		current.handlers++
+	}
+
+	if err := c.compileBlockValue(node.Block); err != nil {
+		return err
+	}
+
+	if node.Catch != nil {
+		c.emit(code.OpEndTry)
+		current.handlers--
+
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		// The handler starts here, with the caught error's value on the stack.
+		c.changeOperand(catchHandler, len(c.currentInstructions()), 1)
+		c.storeSymbol(c.symbolTable.Define(node.Param.Value))
+
+		if err := c.compileBlockValue(node.Catch); err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	if node.Finally != nil {
+		c.emit(code.OpEndTry)
+		current.handlers--
+	}
+
+	// The finally block itself runs outside the try.
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	// The handler starts here, with the error to throw again on the stack.
+	c.changeOperand(finallyHandler, len(c.currentInstructions()), 0)
+
+	if err := c.Compile(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpThrow)
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// unwindTries emits what leaving the tries opened after the first depth ones requires
+// before jumping out of them: removing their handlers and running their finally blocks,
+// innermost first.
+func (c *Compiler) unwindTries(depth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+
+	for i := len(tries) - 1; i >= depth; i-- {
+		if tries[i].handlers == 0 {
+			continue
+		}
+
+		for j := 0; j < tries[i].handlers; j++ {
+			c.emit(code.OpEndTry)
+		}
+
+		if tries[i].finally == nil {
+			continue
+		}
+
+		// The finally block runs outside its try, and those nested in it.
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		err := c.Compile(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) int {
+	switch s.Scope {
+	case GlobalScope:
+		return c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		return c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		return c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		return c.emit(code.OpGetFree, s.Index)
+	default:
+		return c.emit(code.OpCurrentClosure)
+	}
+}
+
+// compileLogical compiles && and ||, which skip their right side when the left one
+// decides the result.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	jump := code.OpJumpNotTruthyOrPop
+	if node.Operator == "||" {
+		jump = code.OpJumpTruthyOrPop
+	}
+	jumpPos := c.emit(jump, 9999)
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileAssign compiles an assignment, leaving the assigned value on the stack.
+// The target's operands are evaluated before the value, as in the evaluator.
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	var op code.Opcode
+	if operator != "" {
+		var ok bool
+		if op, ok = infixOpcodes[operator]; !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		bindings := c.bindings(target.Value)
+		if bindings[0].Scope == BuiltinScope {
+			return fmt.Errorf("%s: cannot assign to builtin %s", target.Pos(), target.Value)
+		}
+
+		if operator != "" {
+			c.loadBindings(bindings, target)
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if operator != "" {
+			c.setPosition(c.emit(op), node)
+		}
+
+		c.emit(code.OpDup, 1)
+		c.assignBindings(bindings, target)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+
+		if operator != "" {
+			c.emit(code.OpDup, 2)
+			c.setPosition(c.emit(code.OpIndex), target)
+		}
+
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		if operator != "" {
+			c.setPosition(c.emit(op), node)
+		}
+
+		c.setPosition(c.emit(code.OpSetIndex), target)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// bindings resolves name to the variables it may refer to; see SymbolTable.Bindings.
+func (c *Compiler) bindings(name string) []Symbol {
+	bindings, ok := c.symbolTable.Bindings(name)
+	if !ok {
+		// Unknown names are treated as globals that may still be defined before they
+		// are read; the VM reports "identifier not found" if they never are.
+		bindings = []Symbol{c.symbolTable.global().Define(name)}
+	}
+	return bindings
+}
+
+// loadBindings pushes the value of the first of bindings that is set, trying the last
+// one unconditionally.
+func (c *Compiler) loadBindings(bindings []Symbol, node ast.Node) {
+	last := len(bindings) - 1
+
+	jumps := make([]int, last)
+	for i, b := range bindings[:last] {
+		jumps[i] = c.emit(ifSetOpcode(b, code.OpGetLocalIfSet, code.OpGetFreeIfSet), b.Index, 9999)
+	}
+
+	c.setPosition(c.loadSymbol(bindings[last]), node)
+
+	for i, pos := range jumps {
+		c.changeOperand(pos, bindings[i].Index, len(c.currentInstructions()))
+	}
+}
+
+// assignBindings stores the value on top of the stack into the first of bindings that
+// is set, as loadBindings reads it.
+func (c *Compiler) assignBindings(bindings []Symbol, node ast.Node) {
+	// A builtin cannot be assigned to, so a let that shadows one is assigned to whether
+	// or not it has run.
+	if len(bindings) > 1 && bindings[len(bindings)-1].Scope == BuiltinScope {
+		bindings = bindings[:len(bindings)-1]
+	}
+	last := len(bindings) - 1
+
+	jumps := make([]int, last)
+	for i, b := range bindings[:last] {
+		jumps[i] = c.emit(ifSetOpcode(b, code.OpSetLocalIfSet, code.OpSetFreeIfSet), b.Index, 9999)
+	}
+
+	c.setPosition(c.assignSymbol(bindings[last]), node)
+
+	for i, pos := range jumps {
+		c.changeOperand(pos, bindings[i].Index, len(c.currentInstructions()))
+	}
+}
+
+// ifSetOpcode returns local or free, whichever matches the scope of s.
+func ifSetOpcode(s Symbol, local, free code.Opcode) code.Opcode {
+	if s.Scope == FreeScope {
+		return free
+	}
+	return local
+}
+
+// assignSymbol stores into a variable that must already exist.
+func (c *Compiler) assignSymbol(s Symbol) int {
+	switch s.Scope {
+	case GlobalScope:
+		return c.emit(code.OpAssignGlobal, s.Index)
+	case FreeScope:
+		return c.emit(code.OpSetFree, s.Index)
+	default:
+		return c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// declareLets gives the names bound by let statements in a function body their local
+// slots before the body is compiled. The evaluator binds them in the function's
+// environment, so a closure created before one of those lets, such as one of a pair of
+// mutually recursive functions, sees the binding when it is called afterwards.
+// Nested function literals have scopes of their own and are skipped.
+func (c *Compiler) declareLets(body *ast.BlockStatement) {
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.Declare(node.Name.Value)
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+// assigns reports whether body, or a function nested in it, assigns to name. A function
+// that assigns to its own name must reach it through the binding it was let to, as the
+// evaluator does, rather than through its FunctionScope symbol.
+func assigns(body *ast.BlockStatement, name string) bool {
+	found := false
+	ast.Inspect(body, func(node ast.Node) bool {
+		if assign, ok := node.(*ast.AssignExpression); ok {
+			if target, ok := assign.Target.(*ast.Identifier); ok && target.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// captureSymbol pushes a free variable of a closure that is about to be created.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// setPosition records the source position of the instruction at pos, so runtime
+// errors raised by it can say where they happened.
+func (c *Compiler) setPosition(pos int, node ast.Node) {
+	c.scopes[c.scopeIndex].positions[pos] = node.Pos()
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+// checkOperands remembers the first operand that Make would truncate, for Compile to report.
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	if c.operandErr != nil {
+		return
+	}
+
+	c.operandErr = code.CheckOperands(op, operands...)
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	newInstructions := old[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = newInstructions
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operands...)
+
+	newInstruction := code.Make(op, operands...)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+		positions:           map[int]token.Pos{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
@@ -0,0 +1,593 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/code"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 1; break; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 14),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (x in [1]) { continue; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpIterNext, 26, 1),
+				// 0017
+				code.Make(code.OpSetGlobal, 1),
+				// 0020
+				code.Make(code.OpJump, 10),
+				// 0023
+				code.Make(code.OpJump, 10),
+				// 0026
+				code.Make(code.OpNull),
+				// 0027
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup, 1),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let xs = [1]; xs[0] *= 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let n = 0; fn() { n = 1 } }",
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDup, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; two;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionsAndClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "let countDown = fn(x) { countDown(x - 1); }; countDown(1);",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltinsAndUndefinedIdentifiers(t *testing.T) {
+	comp := New()
+
+	if err := comp.Compile(parse("len([]); later;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+
+	lenSym, ok := comp.SymbolTable().Resolve("len")
+	if !ok || lenSym.Scope != BuiltinScope {
+		t.Fatalf("len not resolved as builtin. got=%+v", lenSym)
+	}
+
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetBuiltin, lenSym.Index),
+		code.Make(code.OpArray, 0),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpPop),
+	})
+
+	if bytecode.Instructions.String() != expected.String() {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", expected, bytecode.Instructions)
+	}
+
+	// Undefined names become global slots, named so the VM can report them.
+	if len(bytecode.Globals) != 1 || bytecode.Globals[0] != "later" {
+		t.Errorf("wrong globals. got=%v", bytecode.Globals)
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	repeat := func(n int, f func(i int) string) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = f(i)
+		}
+		return strings.Join(parts, "")
+	}
+
+	// Identifiers cannot hold digits, so the nth local is spelled in letters.
+	name := func(i int) string {
+		return "v" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+	}
+	lets := func(n int) string {
+		return "fn() { " + repeat(n, func(i int) string { return fmt.Sprintf("let %s = %d; ", name(i), i) }) + "vaa }"
+	}
+	call := func(n int) string {
+		return "f(1" + repeat(n-1, func(i int) string { return ", 1" }) + ")"
+	}
+	constants := func(n int) string {
+		return repeat(n, func(i int) string { return fmt.Sprintf("%d; ", i) })
+	}
+	ifBody := func(n int) string {
+		return "if (true) { " + repeat(n, func(i int) string { return "1; " }) + "}"
+	}
+
+	tests := []struct {
+		input    string
+		expected string // empty if the input should compile
+	}{
+		{lets(256), ""},
+		{lets(300), "OpSetLocal operand 256 is out of range 0-255"},
+		{call(255), ""},
+		{call(300), "OpCall operand 300 is out of range 0-255"},
+		{constants(65536), ""},
+		{constants(70000), "OpConstant operand 65536 is out of range 0-65535"},
+		{ifBody(17000), "OpJumpNotTruthy operand 68006 is out of range 0-65535"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("compiler error: %s", err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("expected an error containing %q, got none", tt.expected)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want it to contain %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		expected := concatInstructions(tt.expectedInstructions)
+		if bytecode.Instructions.String() != expected.String() {
+			t.Fatalf("wrong instructions for %q.\nwant=%q\ngot =%q", tt.input, expected, bytecode.Instructions)
+		}
+
+		testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of constants for %q. want=%d, got=%d", input, len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("constant %d wrong. want=%d, got=%+v", i, constant, actual[i])
+			}
+
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				t.Errorf("constant %d wrong. want=%q, got=%+v", i, constant, actual[i])
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d not a function. got=%T", i, actual[i])
+				continue
+			}
+
+			want := concatInstructions(constant)
+			if fn.Instructions.String() != want.String() {
+				t.Errorf("constant %d has wrong instructions.\nwant=%q\ngot =%q", i, want, fn.Instructions)
+			}
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 && 2; 3 || 4",
+			expectedConstants: []interface{}{1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpNotTruthyOrPop, 9),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpConstant, 2),
+				// 0013
+				code.Make(code.OpJumpTruthyOrPop, 19),
+				// 0016
+				code.Make(code.OpConstant, 3),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "throw 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:             "try { 1 } catch (e) { e } finally { 2 }",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 29, 0),
+				// 0004
+				code.Make(code.OpTry, 15, 1),
+				// 0008
+				code.Make(code.OpConstant, 0),
+				// 0011
+				code.Make(code.OpEndTry),
+				// 0012
+				code.Make(code.OpJump, 21),
+				// 0015
+				code.Make(code.OpSetGlobal, 0),
+				// 0018
+				code.Make(code.OpGetGlobal, 0),
+				// 0021
+				code.Make(code.OpEndTry),
+				// 0022
+				code.Make(code.OpConstant, 1),
+				// 0025
+				code.Make(code.OpPop),
+				// 0026
+				code.Make(code.OpJump, 34),
+				// 0029
+				code.Make(code.OpConstant, 2),
+				// 0032
+				code.Make(code.OpPop),
+				// 0033
+				code.Make(code.OpThrow),
+				// 0034
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { try { return 1 } finally { 2 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				2,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTry, 21, 0),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007: the return leaves the try, running its finally block first
+					code.Make(code.OpEndTry),
+					// 0008
+					code.Make(code.OpConstant, 1),
+					// 0011
+					code.Make(code.OpPop),
+					// 0012
+					code.Make(code.OpReturnValue),
+					// 0013
+					code.Make(code.OpEndTry),
+					// 0014
+					code.Make(code.OpConstant, 2),
+					// 0017
+					code.Make(code.OpPop),
+					// 0018
+					code.Make(code.OpJump, 26),
+					// 0021
+					code.Make(code.OpConstant, 3),
+					// 0024
+					code.Make(code.OpPop),
+					// 0025
+					code.Make(code.OpThrow),
+					// 0026
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 4, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestImports(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "import \"m.ys\" as m; m.x",
+			expectedConstants: []interface{}{"m.ys", "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpImport, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpMember, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "from \"m.ys\" import a, b",
+			expectedConstants: []interface{}{"m.ys", "a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpImport, 0),
+				code.Make(code.OpMember, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpImport, 0),
+				code.Make(code.OpMember, 2),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
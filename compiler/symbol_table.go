@@ -0,0 +1,238 @@
+// File: compiler/symbol_table.go
+
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+// Symbol is a name the compiler has resolved to a storage slot.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps names to symbols for one function scope (or the global scope),
+// chaining to the enclosing table through Outer.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// A name a function binds with let refers to the enclosing binding until the let has
+	// run, as it does in the evaluator's environments. lets holds those names, bound the
+	// ones whose let is known to have run at the point being compiled, and the others
+	// map to the bindings, in this table's terms, to fall back on.
+	lets      map[string]bool
+	bound     []string
+	fallbacks map[string][]Symbol
+	captured  map[string][]Symbol // the bindings of the free variables, by name
+
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	return &SymbolTable{store: s}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name in this table. Defining a name twice in the same scope reuses its slot,
+// which keeps forward references to globals and REPL redefinitions pointing at one place.
+func (s *SymbolTable) Define(name string) Symbol {
+	scope := GlobalScope
+	if s.Outer != nil {
+		scope = LocalScope
+	}
+
+	if symbol, ok := s.store[name]; ok && symbol.Scope == scope {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: scope}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+
+	return symbol
+}
+
+// Declare gives name a local slot ahead of the let statement that binds it, so that code
+// before the let, and functions nested in this one, can read the slot once the let has
+// run. Names that are already locals, such as parameters, are always bound.
+func (s *SymbolTable) Declare(name string) {
+	if symbol, ok := s.store[name]; ok && symbol.Scope == LocalScope {
+		return
+	}
+	if s.lets == nil {
+		s.lets = make(map[string]bool)
+	}
+
+	s.lets[name] = true
+	s.store[name] = Symbol{Name: name, Index: s.numDefinitions, Scope: LocalScope}
+	s.numDefinitions++
+}
+
+// bind records that the let statement for name has run at the point being compiled.
+func (s *SymbolTable) bind(name string) {
+	if s.lets[name] {
+		s.bound = append(s.bound, name)
+	}
+}
+
+func (s *SymbolTable) isBound(name string) bool {
+	for _, b := range s.bound {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DefineBuiltin binds name to the builtin function at the given index.
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name of the function currently being compiled,
+// so that it can refer to itself recursively.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	return Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+}
+
+// capture turns bindings of an enclosing table into bindings of this one: its locals
+// become free variables of this one.
+func (s *SymbolTable) capture(bindings []Symbol) []Symbol {
+	captured := make([]Symbol, len(bindings))
+	for i, b := range bindings {
+		if b.Scope == GlobalScope || b.Scope == BuiltinScope {
+			captured[i] = b
+		} else {
+			captured[i] = s.defineFree(b)
+		}
+	}
+	return captured
+}
+
+// Resolve looks name up in this table and its enclosing tables. Locals of an enclosing
+// function are turned into free variables of this one.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	bindings, ok := s.Outer.Bindings(name)
+	if !ok {
+		return Symbol{}, false
+	}
+	if b := bindings[0]; b.Scope == GlobalScope || b.Scope == BuiltinScope {
+		return b, true
+	}
+
+	bindings = s.capture(bindings)
+	if s.captured == nil {
+		s.captured = make(map[string][]Symbol)
+	}
+	s.captured[name] = bindings
+	s.store[name] = bindings[0]
+
+	return bindings[0], true
+}
+
+// Bindings resolves name to the variables it may refer to at the point being compiled,
+// innermost first. All but the last are bound by lets that may not have run yet; the
+// first of them that is set is the one name refers to.
+func (s *SymbolTable) Bindings(name string) ([]Symbol, bool) {
+	symbol, ok := s.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+
+	switch {
+	case symbol.Scope == LocalScope && s.lets[name] && !s.isBound(name):
+		return append([]Symbol{symbol}, s.fallback(name)...), true
+	case symbol.Scope == FreeScope:
+		return s.captured[name], true
+	}
+
+	return []Symbol{symbol}, true
+}
+
+// fallback returns the bindings name refers to outside this function, which are used
+// until its let has run. A name bound nowhere else is taken to be a global.
+func (s *SymbolTable) fallback(name string) []Symbol {
+	if bindings, ok := s.fallbacks[name]; ok {
+		return bindings
+	}
+
+	bindings, ok := s.Outer.Bindings(name)
+	if !ok {
+		bindings = []Symbol{s.global().Define(name)}
+	}
+
+	bindings = s.capture(bindings)
+	if s.fallbacks == nil {
+		s.fallbacks = make(map[string][]Symbol)
+	}
+	s.fallbacks[name] = bindings
+
+	return bindings
+}
+
+// Locals returns the names of the local slots, indexed by slot.
+func (s *SymbolTable) Locals() []string {
+	names := make([]string, s.numDefinitions)
+
+	for name, symbol := range s.store {
+		if symbol.Scope == LocalScope {
+			names[symbol.Index] = name
+		}
+	}
+
+	return names
+}
+
+// global returns the outermost table of the chain.
+func (s *SymbolTable) global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// Globals returns the names of the global slots, indexed by slot.
+func (s *SymbolTable) Globals() []string {
+	g := s.global()
+	names := make([]string, g.numDefinitions)
+
+	for name, symbol := range g.store {
+		if symbol.Scope == GlobalScope {
+			names[symbol.Index] = name
+		}
+	}
+
+	return names
+}
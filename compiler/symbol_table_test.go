@@ -0,0 +1,116 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+	if a := global.Define("a"); a != expected["a"] {
+		t.Errorf("expected a=%+v, got=%+v", expected["a"], a)
+	}
+	if b := global.Define("b"); b != expected["b"] {
+		t.Errorf("expected b=%+v, got=%+v", expected["b"], b)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	if c := local.Define("c"); c != expected["c"] {
+		t.Errorf("expected c=%+v, got=%+v", expected["c"], c)
+	}
+	if d := local.Define("d"); d != expected["d"] {
+		t.Errorf("expected d=%+v, got=%+v", expected["d"], d)
+	}
+
+	// Redefining a name in the same scope reuses its slot.
+	if a := global.Define("a"); a != expected["a"] {
+		t.Errorf("expected redefined a=%+v, got=%+v", expected["a"], a)
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 0},
+		{Name: "e", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if len(secondLocal.FreeSymbols) != 1 || secondLocal.FreeSymbols[0].Name != "c" {
+		t.Errorf("wrong free symbols. got=%+v", secondLocal.FreeSymbols)
+	}
+
+	if _, ok := secondLocal.Resolve("x"); ok {
+		t.Errorf("name x resolved, but was expected not to")
+	}
+}
+
+func TestResolveBuiltinsAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("fib")
+
+	if sym, _ := local.Resolve("len"); sym != (Symbol{Name: "len", Scope: BuiltinScope, Index: 0}) {
+		t.Errorf("len resolved wrongly. got=%+v", sym)
+	}
+
+	if sym, _ := local.Resolve("fib"); sym != (Symbol{Name: "fib", Scope: FunctionScope, Index: 0}) {
+		t.Errorf("fib resolved wrongly. got=%+v", sym)
+	}
+
+	// Shadowing the function name with a local creates a new local slot.
+	if sym := local.Define("fib"); sym != (Symbol{Name: "fib", Scope: LocalScope, Index: 0}) {
+		t.Errorf("shadowed fib defined wrongly. got=%+v", sym)
+	}
+}
+
+func TestBindingsOfDeclaredLets(t *testing.T) {
+	global := NewSymbolTable()
+	x := global.Define("x")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Declare("x")
+	declared := Symbol{Name: "x", Scope: LocalScope, Index: 0}
+
+	// Until its let has run, x falls back to the global.
+	if got, _ := local.Bindings("x"); len(got) != 2 || got[0] != declared || got[1] != x {
+		t.Errorf("wrong bindings before the let. got=%+v", got)
+	}
+
+	// A nested function created now captures both.
+	nested := NewEnclosedSymbolTable(local)
+	if got, _ := nested.Bindings("x"); len(got) != 2 || got[0].Scope != FreeScope || got[1] != x {
+		t.Errorf("wrong nested bindings. got=%+v", got)
+	}
+
+	local.Define("x")
+	local.bind("x")
+	if got, _ := local.Bindings("x"); len(got) != 1 || got[0] != declared {
+		t.Errorf("wrong bindings after the let. got=%+v", got)
+	}
+}
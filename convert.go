@@ -0,0 +1,638 @@
+package ys
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// ToObject converts a Go value to a Ys value:
+//
+//   - nil and nil pointers become null, and an object.Object is used as it is
+//   - booleans, strings, and integers and floats of any size become the same Ys type,
+//     as does a *big.Int
+//   - slices and arrays become arrays, and maps become hashes, with their keys sorted
+//   - structs become hashes of their exported fields, keyed by the field's name or the
+//     name given by a `ys:"name"` tag; a field tagged `ys:"-"` is left out
+//   - functions become builtins that convert their arguments and results back and forth;
+//     a function can fail by returning a non-nil error as its last result
+func ToObject(v interface{}) (object.Object, error) {
+	return converter{evaluator: evaluator.New()}.toObject(reflect.ValueOf(v))
+}
+
+// FromObject converts a Ys value to the Go value target points to, the reverse of
+// ToObject. Converting to an empty interface gives an int64, *big.Int, float64, string,
+// bool, nil, []interface{} or map[string]interface{} (map[interface{}]interface{} if a
+// hash has keys that are not strings), and any other object as it is. Ys functions can
+// be converted to Go functions that call them; a Go function without an error result
+// reports a failure of the Ys function by panicking with a CallbackError.
+func FromObject(obj object.Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("ys: FromObject needs a non-nil pointer, got %T", target)
+	}
+
+	return converter{evaluator: evaluator.New()}.fromObject(obj, ptr.Elem())
+}
+
+// converter converts between Go and Ys values, calling Ys functions converted to Go
+// functions with its evaluator.
+type converter struct {
+	evaluator *evaluator.Evaluator
+
+	// seen holds the slices, maps and pointers, or arrays and hashes, that the
+	// conversion under way is inside of, so a value that contains itself is reported
+	// rather than converted for ever. Each conversion starts with its own.
+	seen map[interface{}]bool
+}
+
+// goRef identifies a Go slice, map or pointer for cycle checks; a slice is only the same
+// as another with the same length.
+type goRef struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enter records that the conversion is inside ref, failing if it already was.
+func (c converter) enter(ref interface{}) bool {
+	if c.seen[ref] {
+		return false
+	}
+	c.seen[ref] = true
+	return true
+}
+
+func (c converter) leave(ref interface{}) {
+	delete(c.seen, ref)
+}
+
+func (c converter) toObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return evaluator.NULL, nil
+	}
+
+	if c.seen == nil {
+		c.seen = map[interface{}]bool{}
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer:
+		if v.IsNil() || v.Type() == bigIntType {
+			break
+		}
+
+		ref := goRef{v.Pointer(), v.Type(), 0}
+		if v.Kind() == reflect.Slice {
+			ref.len = v.Len()
+		}
+		if !c.enter(ref) {
+			return nil, fmt.Errorf("cannot convert %s: it contains itself", v.Type())
+		}
+		defer c.leave(ref)
+	}
+
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return object.NewInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+	}
+
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.NewInteger(new(big.Int).SetUint64(v.Uint())), nil
+
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return &object.Array{Elements: []object.Object{}}, nil
+		}
+
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := c.toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		var pairs []object.HashPair
+
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := c.toObject(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := key.(object.Hashable); !ok {
+				return nil, fmt.Errorf("cannot convert %s: unusable as hash key: %s", v.Type(), key.Type())
+			}
+
+			value, err := c.toObject(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+
+			pairs = append(pairs, object.HashPair{Key: key, Value: value})
+		}
+
+		// Go maps have no order, so the hash's keys are sorted to give it one.
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key.Inspect() < pairs[j].Key.Inspect() })
+
+		hash := object.NewHash()
+		for _, pair := range pairs {
+			hash.Set(pair.Key.(object.Hashable), pair.Value)
+		}
+		return hash, nil
+
+	case reflect.Struct:
+		hash := object.NewHash()
+
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+
+			value, err := c.toObject(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+
+			hash.Set(&object.String{Value: name}, value)
+		}
+		return hash, nil
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return c.toObject(v.Elem())
+
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return c.builtin("function", v), nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Ys value", v.Type())
+}
+
+// fieldName returns the hash key a struct field converts to, and false if the field is
+// unexported or tagged to be left out.
+func fieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+
+	tag := field.Tag.Get("ys")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+
+	return field.Name, true
+}
+
+// builtin wraps the Go function fn as a Ys builtin, reporting errors in its arguments
+// as errors in the arguments to name.
+func (c converter) builtin(name string, fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+	c.seen = nil // each call is a conversion of its own
+
+	return &object.Builtin{Fn: func(args ...object.Object) (result object.Object) {
+		numIn := t.NumIn()
+		if t.IsVariadic() {
+			numIn--
+			if len(args) < numIn {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want at least %d", len(args), numIn)}
+			}
+		} else if len(args) != numIn {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), numIn)}
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if i >= numIn {
+				paramType = t.In(numIn).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+
+			in[i] = reflect.New(paramType).Elem()
+			if err := c.fromObject(arg, in[i]); err != nil {
+				return &object.Error{Message: fmt.Sprintf("argument %d to `%s`: %s", i+1, name, err)}
+			}
+		}
+
+		// A Ys function passed in as a Go function that cannot return an error panics
+		// with the error instead, to be reported here.
+		defer func() {
+			if r := recover(); r != nil {
+				failure, ok := r.(CallbackError)
+				if !ok {
+					panic(r)
+				}
+				result = failure.Err
+			}
+		}()
+
+		return c.results(t, fn.Call(in))
+	}}
+}
+
+// CallbackError is the panic that reports an error from a Ys function through a Go
+// function, made by FromObject, that has no error result. A builtin made by ToObject
+// recovers it when it calls such a function, and fails with Err; a host that calls one
+// itself must recover it.
+type CallbackError struct {
+	Err *object.Error
+}
+
+func (e CallbackError) Error() string { return e.Err.Error() }
+
+func (e CallbackError) Unwrap() error { return e.Err }
+
+// results converts what a Go function of type t returned to a single Ys value: null for
+// no results, an array for several, or an error for a non-nil error last result.
+func (c converter) results(t reflect.Type, out []reflect.Value) object.Object {
+	if t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			if errObj, ok := err.(*object.Error); ok {
+				return errObj
+			}
+			return &object.Error{Message: err.Error()}
+		}
+		out = out[:len(out)-1]
+	}
+
+	values := make([]object.Object, len(out))
+	for i, value := range out {
+		obj, err := c.toObject(value)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		values[i] = obj
+	}
+
+	switch len(values) {
+	case 0:
+		return evaluator.NULL
+	case 1:
+		return values[0]
+	default:
+		return &object.Array{Elements: values}
+	}
+}
+
+func (c converter) fromObject(obj object.Object, target reflect.Value) error {
+	t := target.Type()
+
+	if c.seen == nil {
+		c.seen = map[interface{}]bool{}
+	}
+
+	// Pointer and interface targets pass obj on to be converted again, and are left to that.
+	if obj.Type() == object.ARRAY_OBJ || obj.Type() == object.HASH_OBJ {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+			if !c.enter(obj) {
+				return fmt.Errorf("cannot convert %s to %s: it contains itself", obj.Type(), t)
+			}
+			defer c.leave(obj)
+		}
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) && (t.Kind() == reflect.Interface && t.NumMethod() > 0 || t.Kind() == reflect.Pointer) {
+		target.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if obj == evaluator.NULL {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			target.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+
+	if t == bigIntType {
+		switch obj := obj.(type) {
+		case *object.Integer:
+			target.Set(reflect.ValueOf(big.NewInt(obj.Value)))
+			return nil
+		case *object.BigInteger:
+			target.Set(reflect.ValueOf(new(big.Int).Set(obj.Value)))
+			return nil
+		}
+		return cannotConvert(obj, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			return cannotConvert(obj, t)
+		}
+
+		value, err := c.goValue(obj)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			target.Set(reflect.ValueOf(value))
+		}
+		return nil
+
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			target.SetBool(b.Value)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			if target.OverflowInt(i.Value) {
+				return fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			target.SetInt(i.Value)
+			return nil
+		}
+		if obj.Type() == object.INTEGER_OBJ {
+			return fmt.Errorf("%s overflows %s", obj.Inspect(), t)
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var value *big.Int
+		switch obj := obj.(type) {
+		case *object.Integer:
+			value = big.NewInt(obj.Value)
+		case *object.BigInteger:
+			value = obj.Value
+		}
+		if value != nil {
+			if value.Sign() < 0 || !value.IsUint64() || target.OverflowUint(value.Uint64()) {
+				return fmt.Errorf("%s overflows %s", value, t)
+			}
+			target.SetUint(value.Uint64())
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch obj := obj.(type) {
+		case *object.Float:
+			target.SetFloat(obj.Value)
+			return nil
+		case *object.Integer:
+			target.SetFloat(float64(obj.Value))
+			return nil
+		case *object.BigInteger:
+			f, _ := new(big.Float).SetInt(obj.Value).Float64()
+			target.SetFloat(f)
+			return nil
+		}
+
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			target.SetString(s.Value)
+			return nil
+		}
+
+	case reflect.Slice:
+		if array, ok := obj.(*object.Array); ok {
+			slice := reflect.MakeSlice(t, len(array.Elements), len(array.Elements))
+			for i, element := range array.Elements {
+				if err := c.fromObject(element, slice.Index(i)); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+			target.Set(slice)
+			return nil
+		}
+
+	case reflect.Array:
+		if array, ok := obj.(*object.Array); ok {
+			if len(array.Elements) != t.Len() {
+				return fmt.Errorf("cannot convert an array of %d elements to %s", len(array.Elements), t)
+			}
+			for i, element := range array.Elements {
+				if err := c.fromObject(element, target.Index(i)); err != nil {
+					return fmt.Errorf("element %d: %w", i, err)
+				}
+			}
+			return nil
+		}
+
+	case reflect.Map:
+		if hash, ok := obj.(*object.Hash); ok {
+			m := reflect.MakeMapWithSize(t, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key := reflect.New(t.Key()).Elem()
+				if err := c.fromObject(pair.Key, key); err != nil {
+					return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+
+				value := reflect.New(t.Elem()).Elem()
+				if err := c.fromObject(pair.Value, value); err != nil {
+					return fmt.Errorf("value of %s: %w", pair.Key.Inspect(), err)
+				}
+
+				m.SetMapIndex(key, value)
+			}
+			target.Set(m)
+			return nil
+		}
+
+	case reflect.Struct:
+		if hash, ok := obj.(*object.Hash); ok {
+			for i := 0; i < t.NumField(); i++ {
+				name, ok := fieldName(t.Field(i))
+				if !ok {
+					continue
+				}
+
+				key := &object.String{Value: name}
+				pair, ok := hash.Pairs[key.HashKey()]
+				if !ok {
+					continue
+				}
+
+				if err := c.fromObject(pair.Value, target.Field(i)); err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+			}
+			return nil
+		}
+
+	case reflect.Pointer:
+		value := reflect.New(t.Elem())
+		if err := c.fromObject(obj, value.Elem()); err != nil {
+			return err
+		}
+		target.Set(value)
+		return nil
+
+	case reflect.Func:
+		if obj.Type() == object.FUNCTION_OBJ {
+			if t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+				return fmt.Errorf("cannot convert FUNCTION to %s: it can only return a value and an error", t)
+			}
+			target.Set(c.goFunc(obj, t))
+			return nil
+		}
+	}
+
+	return cannotConvert(obj, t)
+}
+
+func cannotConvert(obj object.Object, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// goValue converts obj to the Go value it naturally corresponds to.
+func (c converter) goValue(obj object.Object) (interface{}, error) {
+	if c.seen == nil {
+		c.seen = map[interface{}]bool{}
+	}
+
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.BigInteger:
+		return new(big.Int).Set(obj.Value), nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+
+	case *object.Array:
+		if !c.enter(obj) {
+			return nil, fmt.Errorf("cannot convert %s: it contains itself", obj.Type())
+		}
+		defer c.leave(obj)
+
+		values := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := c.goValue(element)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+
+	case *object.Hash:
+		var target reflect.Value
+		if allStringKeys(obj) {
+			target = reflect.New(reflect.TypeOf(map[string]interface{}{})).Elem()
+		} else {
+			target = reflect.New(reflect.TypeOf(map[interface{}]interface{}{})).Elem()
+		}
+
+		if err := c.fromObject(obj, target); err != nil {
+			return nil, err
+		}
+		return target.Interface(), nil
+	}
+
+	return obj, nil
+}
+
+func allStringKeys(hash *object.Hash) bool {
+	for _, pair := range hash.Pairs {
+		if pair.Key.Type() != object.STRING_OBJ {
+			return false
+		}
+	}
+	return true
+}
+
+// goFunc returns a Go function of type t that calls the Ys function fn. If fn fails
+// and t has no error result to report it with, the Go function panics with a
+// CallbackError.
+func (c converter) goFunc(fn object.Object, t reflect.Type) reflect.Value {
+	c.seen = nil // each call is a conversion of its own
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+
+		fail := func(err *object.Error) []reflect.Value {
+			if t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType {
+				var failure error = err
+				out[len(out)-1] = reflect.ValueOf(&failure).Elem()
+				return out
+			}
+			panic(CallbackError{err})
+		}
+
+		args := make([]object.Object, len(in))
+		for i, arg := range in {
+			obj, err := c.toObject(arg)
+			if err != nil {
+				return fail(&object.Error{Message: err.Error()})
+			}
+			args[i] = obj
+		}
+
+		result := c.evaluator.Call(fn, args)
+		if err, ok := result.(*object.Error); ok {
+			return fail(err)
+		}
+
+		if t.NumOut() > 0 && t.Out(0) != errorType {
+			value := reflect.New(t.Out(0)).Elem()
+			if err := c.fromObject(result, value); err != nil {
+				return fail(&object.Error{Message: "result: " + err.Error()})
+			}
+			out[0] = value
+		}
+
+		return out
+	})
+}
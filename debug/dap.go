@@ -0,0 +1,482 @@
+// File: debug/dap.go
+
+package debug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+// threadID is the ID of the only thread a program has.
+const threadID = 1
+
+// steps are the steps the requests that resume a program take.
+var steps = map[string]Step{"continue": Continue, "next": StepOver, "stepIn": StepIn, "stepOut": StepOut}
+
+// DAPServer serves the Debug Adapter Protocol to an editor, which it lets debug one
+// program: the one the editor asks it to launch.
+type DAPServer struct {
+	conn *dapConn
+	d    *Debugger
+
+	program     *ast.Program // the program launched; nil until it is
+	args        []string
+	stopOnEntry bool
+	configured  bool          // the editor has set its breakpoints
+	started     bool          // the program is running, or has run
+	done        chan struct{} // closed once the program has ended and the editor been told
+
+	// The values the editor can ask for the variables of, by the references it was
+	// given for them while the program has been paused: environments and values with
+	// members.
+	mu      sync.Mutex
+	handles []interface{}
+}
+
+// NewDAPServer returns a server that reads requests from in and writes responses and
+// events to out. The programs it launches look for imports in searchPath.
+func NewDAPServer(in io.Reader, out io.Writer, searchPath []string) *DAPServer {
+	s := &DAPServer{conn: newDAPConn(in, out), done: make(chan struct{})}
+
+	streams := object.NewIO(strings.NewReader(""), &outputWriter{s.conn, "stdout"}, &outputWriter{s.conn, "stderr"})
+	s.d = New(streams, evaluator.NewModules(searchPath))
+
+	return s
+}
+
+// outputWriter sends what the program writes to a stream to the editor as output events.
+type outputWriter struct {
+	conn     *dapConn
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if err := w.conn.event("output", OutputEventBody{Category: w.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Run serves requests until the editor disconnects, or the input ends, and stops the
+// program if it is still running.
+func (s *DAPServer) Run() error {
+	defer s.terminate()
+
+	for {
+		req, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		body, failure := s.handle(req)
+		if err := s.conn.respond(req, body, failure); err != nil {
+			return err
+		}
+
+		// What follows a request happens once the editor has its response, so that,
+		// say, the program stopping again after a step is not reported before the step.
+		switch req.Command {
+		case "initialize":
+			if err := s.conn.event("initialized", nil); err != nil {
+				return err
+			}
+		case "launch", "configurationDone":
+			s.start()
+		case "continue", "next", "stepIn", "stepOut":
+			if failure == nil {
+				s.d.Resume(steps[req.Command])
+			}
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// errNotLaunched is returned by requests that need a program before one is launched.
+var errNotLaunched = errors.New("no program has been launched")
+
+// handle handles a request, and returns the body of its response.
+func (s *DAPServer) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+
+	case "launch":
+		var args LaunchArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.setBreakpoints(args), nil
+
+	case "setExceptionBreakpoints":
+		return nil, nil
+
+	case "threads":
+		return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+
+	case "stackTrace":
+		var args StackTraceArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(args)
+
+	case "scopes":
+		var args ScopesArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(args)
+
+	case "variables":
+		var args VariablesArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args)
+
+	case "evaluate":
+		var args EvaluateArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+
+	case "continue", "next", "stepIn", "stepOut":
+		if err := s.forget(); err != nil {
+			return nil, err
+		}
+		if req.Command == "continue" {
+			return ContinueResponseBody{AllThreadsContinued: true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		if !s.started {
+			return nil, errNotLaunched
+		}
+		s.d.Pause()
+		return nil, nil
+
+	case "terminate", "disconnect":
+		s.terminate()
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown request %q", req.Command)
+}
+
+// decode decodes the arguments of a request into v.
+func decode(req *request, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, v); err != nil {
+		return fmt.Errorf("bad arguments to %s: %w", req.Command, err)
+	}
+	return nil
+}
+
+// launch parses the program to debug. It starts once the editor has set its breakpoints.
+func (s *DAPServer) launch(args LaunchArguments) error {
+	if s.program != nil {
+		return errors.New("a program has already been launched")
+	}
+
+	file := filepath.Clean(args.Program)
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	p := parser.New(lexer.NewFile(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	s.program, s.args, s.stopOnEntry = program, args.Args, args.StopOnEntry
+	return nil
+}
+
+// start starts the program once it has been launched and the editor has set its
+// breakpoints, and tells the editor when it pauses and when it ends.
+func (s *DAPServer) start() {
+	if s.started || s.program == nil || !s.configured {
+		return
+	}
+	s.started = true
+
+	scriptArgs := &object.Array{Elements: make([]object.Object, len(s.args))}
+	for i, arg := range s.args {
+		scriptArgs.Elements[i] = &object.String{Value: arg}
+	}
+
+	env := object.NewEnvironment()
+	env.Set("args", scriptArgs)
+
+	s.d.Start(s.program, env, s.stopOnEntry)
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			stop, result := s.d.Wait()
+			if stop != nil {
+				s.conn.event("stopped", StoppedEventBody{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true})
+				continue
+			}
+
+			exitCode := 0
+			if err, ok := result.(*object.Error); ok {
+				exitCode = 1
+				s.conn.event("output", OutputEventBody{Category: "stderr", Output: err.Inspect() + "\n" + err.StackTrace()})
+			}
+			s.conn.event("exited", ExitedEventBody{ExitCode: exitCode})
+			s.conn.event("terminated", nil)
+			return
+		}
+	}()
+}
+
+// terminate stops the program, if it has started, and waits for it to end.
+func (s *DAPServer) terminate() {
+	if !s.started {
+		return
+	}
+
+	select {
+	case <-s.done:
+	default:
+		s.d.Terminate()
+		<-s.done
+	}
+}
+
+// setBreakpoints sets the breakpoints of a file, moving each to the first line at or
+// after it that a statement starts on.
+func (s *DAPServer) setBreakpoints(args SetBreakpointsArguments) SetBreakpointsResponseBody {
+	file := filepath.Clean(args.Source.Path)
+	lines, err := statementLines(file)
+
+	var set []int
+	breakpoints := []Breakpoint{}
+	for _, requested := range args.Breakpoints {
+		if err != nil {
+			breakpoints = append(breakpoints, Breakpoint{Line: requested.Line, Message: err.Error()})
+			continue
+		}
+
+		i := sort.SearchInts(lines, requested.Line)
+		if i == len(lines) {
+			breakpoints = append(breakpoints, Breakpoint{Line: requested.Line, Message: "no code at or after this line"})
+			continue
+		}
+
+		set = append(set, lines[i])
+		breakpoints = append(breakpoints, Breakpoint{Verified: true, Line: lines[i], Source: &args.Source})
+	}
+
+	s.d.SetBreakpoints(file, set)
+
+	return SetBreakpointsResponseBody{Breakpoints: breakpoints}
+}
+
+// statementLines returns the lines, in order, that statements in a file start on.
+func statementLines(file string) ([]int, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.NewFile(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, errors.New(p.Errors()[0])
+	}
+
+	seen := map[int]bool{}
+	var lines []int
+	ast.Inspect(program, func(node ast.Node) bool {
+		if isStatement(node) && !seen[node.Pos().Line] {
+			seen[node.Pos().Line] = true
+			lines = append(lines, node.Pos().Line)
+		}
+		return true
+	})
+	sort.Ints(lines)
+
+	return lines, nil
+}
+
+// paused returns where the program is paused, or an error if it is not.
+func (s *DAPServer) paused() (*Stop, error) {
+	stop := s.d.Paused()
+	if stop == nil {
+		return nil, errNotPaused
+	}
+	return stop, nil
+}
+
+// stackTrace describes the calls in progress. The ID of each frame is one more than its
+// place on the stack, counting from the innermost.
+func (s *DAPServer) stackTrace(args StackTraceArguments) (interface{}, error) {
+	stop, err := s.paused()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []StackFrame{}
+	for i, frame := range stop.Stack {
+		if i < args.StartFrame || (args.Levels > 0 && len(frames) == args.Levels) {
+			continue
+		}
+
+		sf := StackFrame{ID: i + 1, Name: frame.Function, Line: frame.Pos.Line, Column: frame.Pos.Column}
+		if frame.Pos.File != "" {
+			sf.Source = &Source{Name: filepath.Base(frame.Pos.File), Path: frame.Pos.File}
+		}
+		frames = append(frames, sf)
+	}
+
+	return StackTraceResponseBody{StackFrames: frames, TotalFrames: len(stop.Stack)}, nil
+}
+
+// frame returns the frame with an ID, or the innermost if the ID is 0.
+func (s *DAPServer) frame(id int) (evaluator.Frame, error) {
+	stop, err := s.paused()
+	if err != nil {
+		return evaluator.Frame{}, err
+	}
+
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(stop.Stack) {
+		return evaluator.Frame{}, fmt.Errorf("no frame %d", id)
+	}
+
+	return stop.Stack[id-1], nil
+}
+
+func (s *DAPServer) scopes(args ScopesArguments) (interface{}, error) {
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []DAPScope{}
+	for _, scope := range Scopes(frame) {
+		scopes = append(scopes, DAPScope{Name: scope.Name, VariablesReference: s.reference(scope.Env)})
+	}
+
+	return ScopesResponseBody{Scopes: scopes}, nil
+}
+
+func (s *DAPServer) variables(args VariablesArguments) (interface{}, error) {
+	if _, err := s.paused(); err != nil {
+		return nil, err
+	}
+
+	var members []Variable
+
+	s.mu.Lock()
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("no variables with reference %d", args.VariablesReference)
+	}
+	switch value := s.handles[args.VariablesReference-1].(type) {
+	case *object.Environment:
+		members = Variables(value)
+	case object.Object:
+		members = Members(value)
+	}
+	s.mu.Unlock()
+
+	variables := []DAPVariable{}
+	for _, member := range members {
+		variables = append(variables, s.variable(member))
+	}
+
+	return VariablesResponseBody{Variables: variables}, nil
+}
+
+func (s *DAPServer) variable(v Variable) DAPVariable {
+	variable := DAPVariable{Name: v.Name, Value: Value(v.Value), Type: string(v.Value.Type())}
+	if HasMembers(v.Value) {
+		variable.VariablesReference = s.reference(v.Value)
+	}
+	return variable
+}
+
+// reference returns a reference the editor can ask for the variables of value by.
+func (s *DAPServer) reference(value interface{}) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handles = append(s.handles, value)
+	return len(s.handles)
+}
+
+func (s *DAPServer) evaluate(args EvaluateArguments) (interface{}, error) {
+	frame := 0
+	if args.FrameID > 0 {
+		frame = args.FrameID - 1
+	}
+
+	result, err := s.d.Evaluate(args.Expression, frame)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := result.(*object.Error); ok {
+		return nil, errors.New(err.Message)
+	}
+
+	v := s.variable(Variable{Value: result})
+	return EvaluateResponseBody{Result: v.Value, Type: v.Type, VariablesReference: v.VariablesReference}, nil
+}
+
+// forget forgets the references to variables the editor was given while the program
+// was paused, which it is about to stop being.
+func (s *DAPServer) forget() error {
+	if _, err := s.paused(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handles = nil
+	return nil
+}
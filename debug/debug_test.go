@@ -0,0 +1,560 @@
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+const script = `let add = fn(a, b) {
+  let sum = a + b;
+  return sum;
+};
+let x = add(1, 2);
+let y = add(x, 10);
+puts(x + y);
+`
+
+// start starts script under a debugger, paused on entry, with what it writes going to out.
+func start(t *testing.T, out io.Writer) *Debugger {
+	t.Helper()
+
+	p := parser.New(lexer.NewFile("main.ys", script))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatal(p.Errors())
+	}
+
+	d := New(object.NewIO(strings.NewReader(""), out, out), evaluator.NewModules(nil))
+	d.Start(program, object.NewEnvironment(), true)
+
+	return d
+}
+
+func TestDebugger(t *testing.T) {
+	var out bytes.Buffer
+	d := start(t, &out)
+
+	// Each step resumes the program with step, after setting breakpoints if there are
+	// any, and expects it to stop for reason at line.
+	steps := []struct {
+		step        Step
+		breakpoints []int
+		reason      string
+		line        int
+		stack       string
+	}{
+		{-1, nil, ReasonEntry, 1, "<main>"},
+		{StepOver, nil, ReasonStep, 5, "<main>"},
+		{StepIn, nil, ReasonStep, 2, "add <main>"},
+		{StepOver, nil, ReasonStep, 3, "add <main>"},
+		{StepOut, nil, ReasonStep, 6, "<main>"},
+		{StepOver, []int{3}, ReasonBreakpoint, 3, "add <main>"},
+		{StepOut, nil, ReasonStep, 7, "<main>"},
+	}
+
+	for _, tt := range steps {
+		if tt.breakpoints != nil {
+			d.SetBreakpoints("./main.ys", tt.breakpoints)
+		}
+		if tt.step >= 0 {
+			if err := d.Resume(tt.step); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stop, result := d.Wait()
+		if stop == nil {
+			t.Fatalf("expected the program to stop at line %d, it ended with %v", tt.line, result)
+		}
+
+		var functions []string
+		for _, frame := range stop.Stack {
+			functions = append(functions, frame.Function)
+		}
+		if stop.Reason != tt.reason || stop.Pos.Line != tt.line || strings.Join(functions, " ") != tt.stack {
+			t.Errorf("wrong stop. want=%s at %d in %s, got=%s at %s in %s", tt.reason, tt.line, tt.stack, stop.Reason, stop.Pos, functions)
+		}
+
+		// Having stepped over line 6, the program is at its breakpoint inside the
+		// second call.
+		if tt.reason == ReasonBreakpoint {
+			evaluations := []struct {
+				source   string
+				frame    int
+				expected string
+			}{
+				{"sum", 0, "13"},
+				{"[a, b]", 0, "[3, 10]"},
+				{"x", 1, "3"},
+				{"sum", 1, "ERROR: 1:1: identifier not found: sum"},
+			}
+			for _, ev := range evaluations {
+				result, err := d.Evaluate(ev.source, ev.frame)
+				if err != nil {
+					t.Errorf("%s: %s", ev.source, err)
+				} else if result.Inspect() != ev.expected {
+					t.Errorf("%s in frame %d: want=%q, got=%q", ev.source, ev.frame, ev.expected, result.Inspect())
+				}
+			}
+
+			if _, err := d.Evaluate("(", 0); err == nil {
+				t.Errorf("expected a syntax error")
+			}
+			if _, err := d.Evaluate("x", 2); err == nil {
+				t.Errorf("expected an error for a frame that does not exist")
+			}
+
+			var scopes []string
+			for _, scope := range Scopes(stop.Stack[0]) {
+				var names []string
+				for _, v := range Variables(scope.Env) {
+					names = append(names, v.Name)
+				}
+				scopes = append(scopes, scope.Name+": "+strings.Join(names, " "))
+			}
+			expected := "Locals: a b sum; Globals: add x"
+			if got := strings.Join(scopes, "; "); got != expected {
+				t.Errorf("wrong scopes. want=%q, got=%q", expected, got)
+			}
+
+			d.SetBreakpoints("main.ys", nil)
+		}
+	}
+
+	if err := d.Resume(Continue); err != nil {
+		t.Fatal(err)
+	}
+	if stop, result := d.Wait(); stop != nil || result == nil || result.Type() == object.ERROR_OBJ {
+		t.Fatalf("expected the program to end, got stop=%+v, result=%v", stop, result)
+	}
+	if out.String() != "16\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	if err := d.Resume(Continue); err != errNotPaused {
+		t.Errorf("resuming a program that ended should fail. got=%v", err)
+	}
+}
+
+func TestPauseAndTerminate(t *testing.T) {
+	p := parser.New(lexer.New("let i = 0;\nwhile (true) {\n  i = i + 1;\n}"))
+	program := p.ParseProgram()
+
+	d := New(object.StandardIO(), evaluator.NewModules(nil))
+	d.Start(program, object.NewEnvironment(), false)
+	d.Pause()
+
+	stop, _ := d.Wait()
+	if stop == nil || stop.Reason != ReasonPause {
+		t.Fatalf("expected the program to pause. got=%+v", stop)
+	}
+	if result, err := d.Evaluate("2 * 3", 0); err != nil || result.Inspect() != "6" {
+		t.Errorf("wrong value of 2 * 3. got=%v, %v", result, err)
+	}
+
+	d.Terminate()
+
+	_, result := d.Wait()
+	if err, ok := result.(*object.Error); !ok || err.Kind != object.LimitErrorKind {
+		t.Errorf("expected the program to be terminated. got=%v", result)
+	}
+}
+
+func TestValue(t *testing.T) {
+	hash := object.NewHash()
+	for _, key := range []string{"b", "a"} {
+		k := &object.String{Value: key}
+		hash.Set(k, &object.Array{Elements: []object.Object{k, hash}})
+	}
+
+	tests := []struct {
+		obj      object.Object
+		expected string
+	}{
+		{&object.String{Value: "a\n"}, `"a\n"`},
+		{&object.Integer{Value: 5}, "5"},
+		{&object.Array{}, "[]"},
+		{hash, `{"b": ["b", {"b": ["b", {...}], "a": ["a", {...}]}], "a": ["a", {"b": ["b", {...}], "a": ["a", {...}]}]}`},
+	}
+
+	for _, tt := range tests {
+		if got := Value(tt.obj); got != tt.expected {
+			t.Errorf("wrong value. want=%s, got=%s", tt.expected, got)
+		}
+	}
+
+	members := Members(hash)
+	if len(members) != 2 || members[0].Name != `"b"` || members[1].Name != `"a"` || !HasMembers(hash) || HasMembers(&object.Array{}) {
+		t.Errorf("wrong members. got=%+v", members)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	var out bytes.Buffer
+	d := start(t, &out)
+
+	commands := "break 3\nbreakpoints\nc\nwhere\nlocals\np a * 10\n\nframe 1\nprint x\nframe 5\nlist\nclear 3\nbogus\nn\nn\nq\n"
+	result := RunTerminal(d, bufio.NewReader(strings.NewReader(commands)), &out, map[string]string{"main.ys": script})
+	if result != nil {
+		t.Errorf("expected no result after quitting. got=%s", result.Inspect())
+	}
+
+	expected := `stopped at main.ys:1:1 (entry)
+>    1  let add = fn(a, b) {
+(ysdb) breakpoint at main.ys:3
+(ysdb) main.ys:3
+(ysdb) stopped at main.ys:3:3 (breakpoint)
+>    3    return sum;
+(ysdb) > 0  add (main.ys:3:3)
+  1  <main> (main.ys:5:9)
+(ysdb) Locals:
+  a = 1
+  b = 2
+  sum = 3
+Globals:
+  add = fn(a, b)
+(ysdb) 10
+(ysdb) 10
+(ysdb) > 1  <main> (main.ys:5:9)
+(ysdb) ERROR: 1:1: identifier not found: x
+(ysdb) usage: frame n, where n is from 0 to 1
+(ysdb)      1  let add = fn(a, b) {
+     2    let sum = a + b;
+     3    return sum;
+     4  };
+>    5  let x = add(1, 2);
+     6  let y = add(x, 10);
+     7  puts(x + y);
+     8
+(ysdb) (ysdb) unknown command "bogus"; type help for a list
+(ysdb) stopped at main.ys:6:1 (step)
+>    6  let y = add(x, 10);
+(ysdb) stopped at main.ys:7:1 (step)
+>    7  puts(x + y);
+(ysdb) `
+	if out.String() != expected {
+		t.Errorf("wrong session.\nwant=%s\ngot= %s", expected, out.String())
+	}
+}
+
+// dapClient drives a DAP server running in another goroutine, as an editor would.
+type dapClient struct {
+	t      *testing.T
+	conn   *dapConn
+	events []message       // the events read while waiting for responses
+	output strings.Builder // what the program has written, as the output events say
+	done   chan error
+}
+
+// message is any message the server sends.
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &dapClient{t: t, conn: newDAPConn(clientIn, clientOut), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewDAPServer(serverIn, serverOut, nil).Run()
+		serverOut.Close()
+	}()
+
+	return c
+}
+
+// request sends a request and decodes the body of its response into body, returning
+// the message it failed with instead, if it did.
+func (c *dapClient) request(command string, args, body interface{}) string {
+	c.t.Helper()
+
+	req := &request{Type: "request", Command: command}
+	if args != nil {
+		req.Arguments = mustMarshal(c.t, args)
+	}
+
+	c.conn.mu.Lock()
+	err := c.conn.write(req, &req.Seq)
+	c.conn.mu.Unlock()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+
+		if msg.RequestSeq != req.Seq || msg.Command != command {
+			c.t.Fatalf("%s: expected the response to request %d, got %+v", command, req.Seq, msg)
+		}
+		if !msg.Success {
+			return msg.Message
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("%s: decoding %s: %s", command, msg.Body, err)
+			}
+		}
+		return ""
+	}
+}
+
+// event returns the next event, other than output, decoding its body into body.
+func (c *dapClient) event(name string, body interface{}) {
+	c.t.Helper()
+
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.read()
+		}
+
+		if msg.Event == "output" {
+			continue
+		}
+		if msg.Type != "event" || msg.Event != name {
+			c.t.Fatalf("expected a %s event, got %+v", name, msg)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+func (c *dapClient) read() message {
+	c.t.Helper()
+
+	body, err := c.conn.readBody()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if msg.Event == "output" {
+		var output OutputEventBody
+		if err := json.Unmarshal(msg.Body, &output); err != nil {
+			c.t.Fatal(err)
+		}
+		c.output.WriteString(output.Category + ": " + output.Output)
+	}
+
+	return msg
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDAPServer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.ys")
+	if err := os.WriteFile(file, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDAPClient(t)
+
+	var capabilities Capabilities
+	if msg := c.request("initialize", map[string]string{"adapterID": "ys"}, &capabilities); msg != "" || !capabilities.SupportsConfigurationDoneRequest {
+		t.Fatalf("initialize failed: %s %+v", msg, capabilities)
+	}
+	c.event("initialized", nil)
+
+	if msg := c.request("launch", LaunchArguments{Program: filepath.Join(filepath.Dir(file), "nope.ys")}, nil); !strings.Contains(msg, "no such file") {
+		t.Errorf("launching a missing file should fail. got=%q", msg)
+	}
+	if msg := c.request("launch", LaunchArguments{Program: file, Args: []string{"hello"}}, nil); msg != "" {
+		t.Fatal(msg)
+	}
+
+	// Line 4 has no statement, so its breakpoint moves to line 5; there is nothing
+	// after line 7.
+	var set SetBreakpointsResponseBody
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: file}, Breakpoints: []SourceBreakpoint{{2}, {4}, {9}}}, &set)
+	got := mustMarshal(t, set.Breakpoints)
+	want := mustMarshal(t, []Breakpoint{
+		{Verified: true, Line: 2, Source: &Source{Path: file}},
+		{Verified: true, Line: 5, Source: &Source{Path: file}},
+		{Line: 9, Message: "no code at or after this line"},
+	})
+	if string(got) != string(want) {
+		t.Errorf("wrong breakpoints.\nwant=%s\ngot= %s", want, got)
+	}
+
+	c.request("setExceptionBreakpoints", map[string]interface{}{"filters": []string{}}, nil)
+	if msg := c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, nil); msg != errNotPaused.Error() {
+		t.Errorf("expected an error before the program starts. got=%q", msg)
+	}
+	c.request("configurationDone", nil, nil)
+
+	var stopped StoppedEventBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != ReasonBreakpoint {
+		t.Errorf("expected to stop at a breakpoint. got=%+v", stopped)
+	}
+
+	var threads ThreadsResponseBody
+	c.request("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != threadID {
+		t.Errorf("wrong threads. got=%+v", threads)
+	}
+
+	var trace StackTraceResponseBody
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	if len(trace.StackFrames) != 1 || trace.StackFrames[0].Line != 5 || trace.StackFrames[0].Source.Path != file {
+		t.Fatalf("wrong stack trace. got=%+v", trace)
+	}
+
+	// Stepping in stops at a line with a breakpoint, which is why it stops there.
+	c.request("stepIn", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	frames := mustMarshal(t, trace)
+	expectedFrames := mustMarshal(t, StackTraceResponseBody{TotalFrames: 2, StackFrames: []StackFrame{
+		{ID: 1, Name: "add", Source: &Source{Name: "main.ys", Path: file}, Line: 2, Column: 3},
+		{ID: 2, Name: "<main>", Source: &Source{Name: "main.ys", Path: file}, Line: 5, Column: 9},
+	}})
+	if stopped.Reason != ReasonBreakpoint || string(frames) != string(expectedFrames) {
+		t.Errorf("wrong stack after stepping in.\nwant=%s\ngot= %s (%+v)", expectedFrames, frames, stopped)
+	}
+
+	var scopes ScopesResponseBody
+	c.request("scopes", ScopesArguments{FrameID: 2}, &scopes)
+	if len(scopes.Scopes) != 1 || scopes.Scopes[0].Name != "Globals" {
+		t.Fatalf("wrong scopes of <main>. got=%+v", scopes)
+	}
+
+	var variables VariablesResponseBody
+	c.request("variables", VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &variables)
+	if len(variables.Variables) != 2 || variables.Variables[1].Name != "args" || variables.Variables[1].Value != `["hello"]` || variables.Variables[1].VariablesReference == 0 {
+		t.Fatalf("wrong globals. got=%+v", variables)
+	}
+
+	c.request("variables", VariablesArguments{VariablesReference: variables.Variables[1].VariablesReference}, &variables)
+	if got := mustMarshal(t, variables); string(got) != `{"variables":[{"name":"0","value":"\"hello\"","type":"STRING","variablesReference":0}]}` {
+		t.Errorf("wrong elements of args. got=%s", got)
+	}
+
+	var result EvaluateResponseBody
+	c.request("evaluate", EvaluateArguments{Expression: "{\"sum\": a + b}", FrameID: 1, Context: "watch"}, &result)
+	if result.Result != `{"sum": 3}` || result.Type != "HASH" || result.VariablesReference == 0 {
+		t.Errorf("wrong result of evaluation. got=%+v", result)
+	}
+	if msg := c.request("evaluate", EvaluateArguments{Expression: "a + b", FrameID: 2}, nil); msg != "identifier not found: a" {
+		t.Errorf("expected a in <main> not to be found. got=%q", msg)
+	}
+
+	c.request("stepOut", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	if trace.StackFrames[0].Line != 6 {
+		t.Errorf("expected to step out to line 6. got=%+v", trace)
+	}
+
+	if msg := c.request("variables", VariablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference + 10}, nil); !strings.Contains(msg, "no variables") {
+		t.Errorf("expected references to go once the program goes on. got=%q", msg)
+	}
+	if msg := c.request("restart", nil, nil); msg != `unknown request "restart"` {
+		t.Errorf("expected an unknown request. got=%q", msg)
+	}
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	c.request("next", map[string]int{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	if stopped.Reason != ReasonStep || trace.StackFrames[0].Line != 3 || trace.StackFrames[0].Name != "add" {
+		t.Errorf("expected to step to line 3 of add. got=%+v, %+v", stopped, trace)
+	}
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	if exited.ExitCode != 0 || c.output.String() != "stdout: 16\n" {
+		t.Errorf("wrong end of the program. got=%+v, output=%q", exited, c.output.String())
+	}
+
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("server failed: %s", err)
+	}
+}
+
+func TestDAPDisconnectWhilePaused(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.ys")
+	if err := os.WriteFile(file, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.ys")
+	if err := os.WriteFile(broken, []byte("let = 1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDAPClient(t)
+	c.request("initialize", nil, nil)
+	c.event("initialized", nil)
+
+	if msg := c.request("launch", LaunchArguments{Program: broken}, nil); !strings.Contains(msg, "broken.ys:1:5: expected next token to be IDENT") {
+		t.Errorf("launching a program that does not parse should fail. got=%q", msg)
+	}
+	c.request("launch", LaunchArguments{Program: file, StopOnEntry: true}, nil)
+	c.request("configurationDone", nil, nil)
+
+	var stopped StoppedEventBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != ReasonEntry {
+		t.Errorf("expected to stop on entry. got=%+v", stopped)
+	}
+
+	// Disconnecting stops the program before the server answers.
+	c.request("disconnect", nil, nil)
+
+	var exited ExitedEventBody
+	c.event("exited", &exited)
+	c.event("terminated", nil)
+	if exited.ExitCode != 1 || !strings.Contains(c.output.String(), "terminated by the debugger") {
+		t.Errorf("expected the program to be terminated. got=%+v, output=%q", exited, c.output.String())
+	}
+
+	if err := <-c.done; err != nil {
+		t.Errorf("server failed: %s", err)
+	}
+}
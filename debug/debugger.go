@@ -0,0 +1,305 @@
+// File: debug/debugger.go
+
+// Package debug runs Ys programs under a debugger, which pauses them at breakpoints and
+// after steps, and lets a front-end look at the calls in progress and their variables
+// while they are paused. There are two front-ends: a command line one for terminals, and
+// a Debug Adapter Protocol server for editors.
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/token"
+)
+
+// The reasons a program stops, which are also the ones the Debug Adapter Protocol uses.
+const (
+	ReasonEntry      = "entry"      // before its first statement
+	ReasonBreakpoint = "breakpoint" // at a line with a breakpoint
+	ReasonStep       = "step"       // at the end of a step
+	ReasonPause      = "pause"      // because the front-end asked it to
+)
+
+// Step is how a paused program goes on.
+type Step int
+
+const (
+	Continue  Step = iota // run until a breakpoint
+	StepIn                // stop at the next line, inside a function the line calls if it calls one
+	StepOver              // stop at the next line of the same function, or of its caller once it returns
+	StepOut               // stop once the function returns
+	terminate             // stop the program
+)
+
+// Stop is where and why a program paused.
+type Stop struct {
+	Reason string
+	Pos    token.Pos
+	Stack  []evaluator.Frame // the calls in progress, innermost first
+}
+
+// errNotPaused is returned by the methods that need the program to be paused when it
+// is not.
+var errNotPaused = errors.New("the program is not paused")
+
+// location is a line of a file at a depth of calls, which the debugger stops at once.
+type location struct {
+	file  string
+	line  int
+	depth int
+}
+
+// event is a program pausing, or ending with a result.
+type event struct {
+	stop   *Stop
+	result object.Object
+}
+
+// Debugger runs a program, pausing it at breakpoints and after steps.
+type Debugger struct {
+	e       *evaluator.Evaluator
+	streams *object.IO
+	modules *evaluator.Modules
+
+	mu          sync.Mutex              // guards what front-ends use while the program runs
+	breakpoints map[string]map[int]bool // the lines to stop at, by file
+	pause       bool                    // stop at the next statement
+	terminated  bool                    // stop the program at the next node
+	stop        *Stop                   // where the program is paused; nil while it runs
+
+	// What the program's goroutine owns.
+	step  Step
+	depth int      // the depth of calls the step started at
+	entry bool     // the next stop is the program's entry
+	last  location // where the last statement was
+
+	events chan event
+	resume chan Step
+}
+
+// New returns a debugger for programs that use streams for their input and output, and
+// load imports with modules.
+func New(streams *object.IO, modules *evaluator.Modules) *Debugger {
+	d := &Debugger{
+		e:           evaluator.New(),
+		streams:     streams,
+		modules:     modules,
+		breakpoints: map[string]map[int]bool{},
+		events:      make(chan event),
+		resume:      make(chan Step),
+	}
+
+	d.e.SetIO(streams)
+	d.e.SetModules(modules)
+	d.e.SetDebugger(d)
+
+	return d
+}
+
+// Start runs program in env on a goroutine of its own. If stopOnEntry, it pauses before
+// the first statement.
+func (d *Debugger) Start(program *ast.Program, env *object.Environment, stopOnEntry bool) {
+	if stopOnEntry {
+		d.step, d.entry = StepIn, true
+	}
+
+	go func() {
+		defer d.modules.Running(program.Pos().File)()
+
+		result := d.e.Eval(program, env)
+		d.events <- event{result: result}
+		close(d.events)
+	}()
+}
+
+// Wait waits for the program to pause, and returns where it did, or for it to end, and
+// returns its result. Once the program has ended it returns nil, nil.
+func (d *Debugger) Wait() (*Stop, object.Object) {
+	ev := <-d.events
+	return ev.stop, ev.result
+}
+
+// Paused returns where the program is paused, or nil if it is not.
+func (d *Debugger) Paused() *Stop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.stop
+}
+
+// Resume makes a paused program go on.
+func (d *Debugger) Resume(step Step) error {
+	if d.Paused() == nil {
+		return errNotPaused
+	}
+	d.resume <- step
+	return nil
+}
+
+// Pause makes a running program pause at its next statement.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pause = true
+}
+
+// Terminate stops the program, which ends with an error that cannot be caught. Wait must
+// still be called to see it end.
+func (d *Debugger) Terminate() {
+	d.mu.Lock()
+	d.terminated = true
+	paused := d.stop != nil
+	d.mu.Unlock()
+
+	if paused {
+		d.resume <- terminate
+	}
+}
+
+// SetBreakpoints replaces the breakpoints in file with ones at lines.
+func (d *Debugger) SetBreakpoints(file string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	file = filepath.Clean(file)
+	if len(lines) == 0 {
+		delete(d.breakpoints, file)
+		return
+	}
+
+	d.breakpoints[file] = map[int]bool{}
+	for _, line := range lines {
+		d.breakpoints[file][line] = true
+	}
+}
+
+// Before is called by the evaluator before it evaluates each node with a position. It
+// pauses the program, until a front-end resumes it, at the statements it should stop at.
+func (d *Debugger) Before(node ast.Node, env *object.Environment, depth int) *object.Error {
+	if d.isTerminated() {
+		return errTerminated()
+	}
+
+	if !isStatement(node) {
+		return nil
+	}
+
+	pos := node.Pos()
+	here := location{file: pos.File, line: pos.Line, depth: depth}
+	newLine := here != d.last
+	d.last = here
+
+	reason := d.reason(pos, depth, newLine)
+	if reason == "" {
+		return nil
+	}
+
+	stop := &Stop{Reason: reason, Pos: pos, Stack: d.e.Stack(pos, env)}
+	d.setStop(stop)
+	d.events <- event{stop: stop}
+	step := <-d.resume
+	d.setStop(nil)
+
+	if step == terminate {
+		return errTerminated()
+	}
+
+	d.step, d.depth = step, depth
+	return nil
+}
+
+// reason returns why the program should stop at a statement at pos, depth calls deep,
+// or "" if it should not. A line is stopped at only once each time it is reached, however
+// many statements it holds.
+func (d *Debugger) reason(pos token.Pos, depth int, newLine bool) string {
+	d.mu.Lock()
+	pause, breakpoint := d.pause, d.breakpoints[pos.File][pos.Line]
+	d.pause = false
+	d.mu.Unlock()
+
+	switch {
+	case pause:
+		return ReasonPause
+	case !newLine:
+		return ""
+	case d.entry:
+		d.entry = false
+		return ReasonEntry
+	case breakpoint:
+		return ReasonBreakpoint
+	case d.step == StepIn, d.step == StepOver && depth <= d.depth, d.step == StepOut && depth < d.depth:
+		return ReasonStep
+	}
+
+	return ""
+}
+
+func (d *Debugger) setStop(stop *Stop) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stop = stop
+}
+
+func (d *Debugger) isTerminated() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.terminated
+}
+
+// errTerminated is the error a program that was terminated ends with.
+func errTerminated() *object.Error {
+	return &object.Error{Message: "terminated by the debugger", Kind: object.LimitErrorKind}
+}
+
+// isStatement reports whether node is a statement the debugger can stop at; blocks are
+// not, though the statements in them are.
+func isStatement(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.BlockStatement:
+		return false
+	case ast.Statement:
+		return true
+	}
+	return false
+}
+
+// Evaluate evaluates source in the environment of a frame of the paused program, the
+// innermost being 0. What it evaluates cannot stop at breakpoints. Errors in evaluating
+// it are returned as an *object.Error; those that stop it being evaluated as an error.
+func (d *Debugger) Evaluate(source string, frame int) (object.Object, error) {
+	stop := d.Paused()
+	if stop == nil {
+		return nil, errNotPaused
+	}
+	if frame < 0 || frame >= len(stop.Stack) || stop.Stack[frame].Env == nil {
+		return nil, fmt.Errorf("no frame %d", frame)
+	}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	e := evaluator.New()
+	e.SetIO(d.streams)
+	e.SetModules(d.modules)
+
+	result := e.Eval(program, stop.Stack[frame].Env)
+	if result == nil {
+		result = evaluator.NULL
+	}
+	return result, nil
+}
@@ -0,0 +1,304 @@
+// File: debug/terminal.go
+
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+const terminalHelp = `Commands:
+  break [file:]line   stop at a line (b)
+  clear [file:]line   remove a breakpoint
+  breakpoints         list the breakpoints
+  continue            run until a breakpoint (c)
+  next                go to the next line, stepping over calls (n)
+  step                go to the next line, stepping into calls (s)
+  out                 run until the current function returns (o)
+  print expr          evaluate expr in the current frame (p)
+  locals              list the variables the current frame can see
+  where               show the calls in progress (bt)
+  frame n             make the nth call of where the current frame
+  list                show the source around the current line (l)
+  quit                stop the program and leave (q)
+An empty line repeats the last command.
+`
+
+// terminal is the debugger's command line front-end.
+type terminal struct {
+	d   *Debugger
+	in  *bufio.Reader
+	out io.Writer
+
+	stop    *Stop                   // where the program is paused
+	frame   int                     // the frame commands look at, the innermost being 0
+	last    string                  // the last command, which an empty line repeats
+	sources map[string][]string     // the lines of the files shown, by name
+	breaks  map[string]map[int]bool // the breakpoints, by file
+}
+
+// RunTerminal lets a user control a program d has started, with commands read from in,
+// until it ends, and returns its result, or nil if the user quit. It should be started
+// paused on entry, to give the user a chance to set breakpoints. sources are the texts
+// of files it cannot read from disk, such as a program given on the command line.
+//
+// The program can share in with the debugger: it can only read from it while it runs,
+// and the debugger only while it is paused.
+func RunTerminal(d *Debugger, in *bufio.Reader, out io.Writer, sources map[string]string) object.Object {
+	t := &terminal{d: d, in: in, out: out, sources: map[string][]string{}, breaks: map[string]map[int]bool{}}
+	for name, src := range sources {
+		t.sources[filepath.Clean(name)] = strings.Split(src, "\n")
+	}
+
+	quit := false
+	for {
+		stop, result := d.Wait()
+		if stop == nil {
+			if quit {
+				return nil
+			}
+			return result
+		}
+
+		t.stop, t.frame = stop, 0
+		fmt.Fprintf(t.out, "stopped at %s (%s)\n", stop.Pos, stop.Reason)
+		t.showLine(stop.Pos, stop.Pos.Line)
+
+		if !t.commands() {
+			quit = true
+			d.Terminate()
+		}
+	}
+}
+
+// commands runs commands until one resumes the program, reporting false if the user
+// quits.
+func (t *terminal) commands() bool {
+	for {
+		fmt.Fprint(t.out, "(ysdb) ")
+
+		line, err := t.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(t.out)
+			return false
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = t.last
+		}
+		t.last = line
+
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch name {
+		case "":
+
+		case "help", "h":
+			fmt.Fprint(t.out, terminalHelp)
+
+		case "continue", "c":
+			return t.resume(Continue)
+
+		case "next", "n":
+			return t.resume(StepOver)
+
+		case "step", "s":
+			return t.resume(StepIn)
+
+		case "out", "o":
+			return t.resume(StepOut)
+
+		case "quit", "q":
+			return false
+
+		case "break", "b":
+			if file, line, ok := t.location(arg); ok {
+				t.setBreakpoint(file, line, true)
+				fmt.Fprintf(t.out, "breakpoint at %s:%d\n", file, line)
+			}
+
+		case "clear":
+			if file, line, ok := t.location(arg); ok {
+				if !t.breaks[file][line] {
+					fmt.Fprintf(t.out, "no breakpoint at %s:%d\n", file, line)
+				}
+				t.setBreakpoint(file, line, false)
+			}
+
+		case "breakpoints":
+			t.listBreakpoints()
+
+		case "print", "p":
+			t.print(arg)
+
+		case "locals":
+			t.locals()
+
+		case "where", "bt":
+			t.where()
+
+		case "frame":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n >= len(t.stop.Stack) {
+				fmt.Fprintf(t.out, "usage: frame n, where n is from 0 to %d\n", len(t.stop.Stack)-1)
+				continue
+			}
+			t.frame = n
+			t.showFrame(n)
+
+		case "list", "l":
+			pos := t.stop.Stack[t.frame].Pos
+			for line := pos.Line - 5; line <= pos.Line+5; line++ {
+				t.showLine(pos, line)
+			}
+
+		default:
+			fmt.Fprintf(t.out, "unknown command %q; type help for a list\n", name)
+		}
+	}
+}
+
+func (t *terminal) resume(step Step) bool {
+	if err := t.d.Resume(step); err != nil {
+		fmt.Fprintln(t.out, err)
+	}
+	return true
+}
+
+// location parses a breakpoint's [file:]line. The file defaults to the one paused in.
+func (t *terminal) location(arg string) (string, int, bool) {
+	file := t.stop.Pos.File
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		file, arg = arg[:i], arg[i+1:]
+	}
+
+	line, err := strconv.Atoi(arg)
+	if err != nil || line < 1 {
+		fmt.Fprintln(t.out, "usage: break [file:]line")
+		return "", 0, false
+	}
+
+	return filepath.Clean(file), line, true
+}
+
+func (t *terminal) setBreakpoint(file string, line int, set bool) {
+	if t.breaks[file] == nil {
+		t.breaks[file] = map[int]bool{}
+	}
+	if set {
+		t.breaks[file][line] = true
+	} else {
+		delete(t.breaks[file], line)
+	}
+
+	var lines []int
+	for line := range t.breaks[file] {
+		lines = append(lines, line)
+	}
+	t.d.SetBreakpoints(file, lines)
+}
+
+func (t *terminal) listBreakpoints() {
+	var breakpoints []string
+	for file, lines := range t.breaks {
+		for line := range lines {
+			breakpoints = append(breakpoints, fmt.Sprintf("%s:%d", file, line))
+		}
+	}
+
+	if len(breakpoints) == 0 {
+		fmt.Fprintln(t.out, "no breakpoints")
+		return
+	}
+
+	sort.Strings(breakpoints)
+	for _, breakpoint := range breakpoints {
+		fmt.Fprintln(t.out, breakpoint)
+	}
+}
+
+func (t *terminal) print(expr string) {
+	if expr == "" {
+		fmt.Fprintln(t.out, "usage: print expr")
+		return
+	}
+
+	result, err := t.d.Evaluate(expr, t.frame)
+	if err != nil {
+		fmt.Fprintln(t.out, err)
+		return
+	}
+	fmt.Fprintln(t.out, Value(result))
+}
+
+func (t *terminal) locals() {
+	for _, scope := range Scopes(t.stop.Stack[t.frame]) {
+		variables := Variables(scope.Env)
+		if len(variables) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(t.out, "%s:\n", scope.Name)
+		for _, v := range variables {
+			fmt.Fprintf(t.out, "  %s = %s\n", v.Name, Value(v.Value))
+		}
+	}
+}
+
+func (t *terminal) where() {
+	for i := range t.stop.Stack {
+		t.showFrame(i)
+	}
+}
+
+func (t *terminal) showFrame(i int) {
+	marker := " "
+	if i == t.frame {
+		marker = ">"
+	}
+	frame := t.stop.Stack[i]
+	fmt.Fprintf(t.out, "%s %d  %s (%s)\n", marker, i, frame.Function, frame.Pos)
+}
+
+// showLine prints a line of the file pos is in, marking it if pos is on it. Lines the
+// file does not have are left out.
+func (t *terminal) showLine(pos token.Pos, line int) {
+	lines := t.lines(pos.File)
+	if line < 1 || line > len(lines) {
+		return
+	}
+
+	marker := " "
+	if line == pos.Line {
+		marker = ">"
+	}
+	fmt.Fprintln(t.out, strings.TrimRight(fmt.Sprintf("%s %4d  %s", marker, line, lines[line-1]), " "))
+}
+
+// lines returns the lines of a file, reading it the first time it is shown.
+func (t *terminal) lines(file string) []string {
+	file = filepath.Clean(file)
+
+	if lines, ok := t.sources[file]; ok {
+		return lines
+	}
+
+	var lines []string
+	if src, err := os.ReadFile(file); err == nil {
+		lines = strings.Split(string(src), "\n")
+	}
+	t.sources[file] = lines
+
+	return lines
+}
@@ -0,0 +1,135 @@
+// File: debug/variables.go
+
+package debug
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+// Scope is one of the environments a frame can see: its own, those of the functions
+// enclosing it, and the global one.
+type Scope struct {
+	Name string // "Locals", "Closure" or "Globals"
+	Env  *object.Environment
+}
+
+// Scopes returns the environments a frame can see, innermost first.
+func Scopes(frame evaluator.Frame) []Scope {
+	var scopes []Scope
+
+	for env := frame.Env; env != nil; env = env.Outer() {
+		name := "Closure"
+		switch {
+		case env.Outer() == nil:
+			name = "Globals"
+		case env == frame.Env:
+			name = "Locals"
+		}
+		scopes = append(scopes, Scope{Name: name, Env: env})
+	}
+
+	return scopes
+}
+
+// Variable is a named value: a variable of an environment, or a member of a value.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Variables returns the variables bound in env itself, sorted by name.
+func Variables(env *object.Environment) []Variable {
+	var variables []Variable
+	for _, name := range env.LocalNames() {
+		value, _ := env.Get(name)
+		variables = append(variables, Variable{Name: name, Value: value})
+	}
+	return variables
+}
+
+// Members returns the elements of an array, the pairs of a hash in their order, or the
+// top-level variables of a module. Other values have none.
+func Members(obj object.Object) []Variable {
+	var members []Variable
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		for i, element := range obj.Elements {
+			members = append(members, Variable{Name: strconv.Itoa(i), Value: element})
+		}
+
+	case *object.Hash:
+		for _, pair := range obj.OrderedPairs() {
+			members = append(members, Variable{Name: Value(pair.Key), Value: pair.Value})
+		}
+
+	case *object.Module:
+		members = Variables(obj.Env)
+	}
+
+	return members
+}
+
+// HasMembers reports whether Members returns any for obj.
+func HasMembers(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return len(obj.Elements) > 0
+	case *object.Hash:
+		return len(obj.Pairs) > 0
+	case *object.Module:
+		return len(obj.Env.LocalNames()) > 0
+	}
+	return false
+}
+
+// maxValueDepth is how deeply Value shows arrays and hashes nested in each other, which
+// keeps it from going round one that holds itself for ever.
+const maxValueDepth = 4
+
+// Value formats a value as the debugger shows it: like Inspect, but with strings quoted
+// so that they can be told from other values, and functions shown by their parameters
+// rather than their code.
+func Value(obj object.Object) string {
+	return value(obj, 0)
+}
+
+func value(obj object.Object, depth int) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+
+	case *object.Function:
+		params := make([]string, len(obj.Parameters))
+		for i, param := range obj.Parameters {
+			params[i] = param.Value
+		}
+		return "fn(" + strings.Join(params, ", ") + ")"
+
+	case *object.Array:
+		if depth == maxValueDepth && len(obj.Elements) > 0 {
+			return "[...]"
+		}
+		elements := make([]string, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = value(element, depth+1)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+
+	case *object.Hash:
+		if depth == maxValueDepth && len(obj.Pairs) > 0 {
+			return "{...}"
+		}
+		var pairs []string
+		for _, member := range Members(obj) {
+			pairs = append(pairs, member.Name+": "+value(member.Value, depth+1))
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+
+	return obj.Inspect()
+}
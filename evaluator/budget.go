@@ -0,0 +1,212 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/shafik23/ys/object"
+)
+
+// Limits bounds the resources a program may use, for hosts that run programs they do
+// not trust. A zero field means no limit.
+type Limits struct {
+	// MaxSteps bounds the work done: the nodes the evaluator evaluates, or the
+	// instructions the VM executes.
+	MaxSteps int64
+
+	// MaxCallDepth bounds how deeply function calls may nest.
+	MaxCallDepth int
+
+	// MaxElements and MaxStringBytes bound the total size of the arrays, hashes,
+	// strings and big integers that literals, operators and builtins create: the
+	// elements of arrays and pairs of hashes, including those index assignments add,
+	// and the bytes of strings and of integers too big for 64 bits.
+	MaxElements    int64
+	MaxStringBytes int64
+}
+
+// contextCheckInterval is how many steps pass between checks of the context, which
+// are too slow to make at every step.
+const contextCheckInterval = 1 << 10
+
+// largeResultBytes is the size from which an operation checks the context before it
+// creates its result.
+const largeResultBytes = 1 << 12
+
+// Budget tracks what a run of a program has used of its limits, and stops it once its
+// context is done. Both engines charge the same Budget for a program and the modules it
+// imports. A nil Budget imposes no limits.
+type Budget struct {
+	ctx    context.Context
+	limits Limits
+
+	steps    int64
+	elements int64
+	bytes    int64
+}
+
+// NewBudget returns a Budget that enforces limits until ctx is done.
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Budget{ctx: ctx, limits: limits}
+}
+
+// Step charges one step, and checks the context every so often.
+func (b *Budget) Step() *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	b.steps++
+
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return newLimitError("step limit of %d exceeded", b.limits.MaxSteps)
+	}
+
+	if b.steps%contextCheckInterval == 0 {
+		if err := b.ctx.Err(); err != nil {
+			return newLimitError("evaluation stopped: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Call checks that a function may be called from depth calls deep.
+func (b *Budget) Call(depth int) *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	if b.limits.MaxCallDepth > 0 && depth >= b.limits.MaxCallDepth {
+		return newLimitError("call depth limit of %d exceeded", b.limits.MaxCallDepth)
+	}
+
+	return nil
+}
+
+// Allocate charges for obj, which has just been created, if it is an array, a hash,
+// a string or a big integer.
+func (b *Budget) Allocate(obj object.Object) *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		b.elements += int64(len(obj.Elements))
+	case *object.Hash:
+		b.elements += int64(len(obj.Pairs))
+	case *object.String:
+		b.bytes += int64(len(obj.Value))
+	case *object.BigInteger:
+		b.bytes += int64(bitsToBytes(obj.Value.BitLen()))
+	}
+
+	return b.check()
+}
+
+// Operation checks, before operator is applied to left and right, that the string or
+// big integer it would create is within the limits, so that one operation cannot run
+// for long or exhaust memory before its result is charged with Allocate. An operation
+// with a large result also checks the context.
+func (b *Budget) Operation(operator string, left, right object.Object) *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	n := resultBytes(operator, left, right)
+	if n == 0 {
+		return nil
+	}
+
+	if b.limits.MaxStringBytes > 0 && n > b.limits.MaxStringBytes-b.bytes {
+		return newLimitError("allocation limit of %d bytes exceeded", b.limits.MaxStringBytes)
+	}
+	if n >= largeResultBytes {
+		if err := b.ctx.Err(); err != nil {
+			return newLimitError("evaluation stopped: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// resultBytes returns at most how many bytes the result of applying operator to left
+// and right takes, when it is a concatenated string or a big integer, and 0 otherwise.
+func resultBytes(operator string, left, right object.Object) int64 {
+	switch left := left.(type) {
+	case *object.String:
+		if right, ok := right.(*object.String); ok && operator == "+" {
+			return int64(len(left.Value) + len(right.Value))
+		}
+	case *object.Integer, *object.BigInteger:
+		_, leftBig := left.(*object.BigInteger)
+		_, rightBig := right.(*object.BigInteger)
+		if right.Type() != object.INTEGER_OBJ || !leftBig && !rightBig {
+			return 0
+		}
+
+		leftBits, rightBits := toBig(left).BitLen(), toBig(right).BitLen()
+		switch operator {
+		case "+", "-":
+			return int64(bitsToBytes(max(leftBits, rightBits) + 1))
+		case "*":
+			return int64(bitsToBytes(leftBits + rightBits))
+		}
+	}
+
+	return 0
+}
+
+func bitsToBytes(bits int) int {
+	return (bits + 7) / 8
+}
+
+// AddElements charges for n elements added to an array or a hash that already exists.
+func (b *Budget) AddElements(n int64) *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	b.elements += n
+
+	return b.check()
+}
+
+// Reserve checks that n more elements would be within the limits, and that the context
+// is not done, before they are created. It charges nothing: what is created is charged
+// with Allocate.
+func (b *Budget) Reserve(n int64) *object.Error {
+	if b == nil {
+		return nil
+	}
+
+	if b.limits.MaxElements > 0 && n > b.limits.MaxElements-b.elements {
+		return newLimitError("allocation limit of %d elements exceeded", b.limits.MaxElements)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return newLimitError("evaluation stopped: %s", err)
+	}
+
+	return nil
+}
+
+// check fails once the values allocated exceed the limits.
+func (b *Budget) check() *object.Error {
+	if b.limits.MaxElements > 0 && b.elements > b.limits.MaxElements {
+		return newLimitError("allocation limit of %d elements exceeded", b.limits.MaxElements)
+	}
+	if b.limits.MaxStringBytes > 0 && b.bytes > b.limits.MaxStringBytes {
+		return newLimitError("allocation limit of %d bytes exceeded", b.limits.MaxStringBytes)
+	}
+
+	return nil
+}
+
+func newLimitError(format string, a ...interface{}) *object.Error {
+	err := newError(format, a...)
+	err.Kind = object.LimitErrorKind
+	return err
+}
@@ -1,6 +1,16 @@
 package evaluator
 
-import "github.com/shafik23/ys/object"
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shafik23/ys/object"
+)
 
 var builtins = map[string]*object.Builtin{
 	// Go compiler can infer the type of the struct literal from the the decalaration above.
@@ -14,6 +24,8 @@ var builtins = map[string]*object.Builtin{
 			return &object.Integer{Value: int64(len(arg.Value))}
 		case *object.Array:
 			return &object.Integer{Value: int64(len(arg.Elements))}
+		case *object.Hash:
+			return &object.Integer{Value: int64(len(arg.Pairs))}
 		default:
 			return newError("argument to `len` not supported, got type %s", args[0].Type())
 		}
@@ -100,11 +112,1151 @@ var builtins = map[string]*object.Builtin{
 		return &object.Array{Elements: newElements}
 	}},
 
-	"puts": {Fn: func(args ...object.Object) object.Object {
+	// map returns the results of calling a function on each element of an array.
+	"map": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("map", args)
+		if err != nil {
+			return err
+		}
+
+		results := make([]object.Object, len(arr.Elements))
+		for i, elem := range arr.Elements {
+			result := rt.Call(fn, elem)
+			if isError(result) {
+				return result
+			}
+			results[i] = result
+		}
+
+		return &object.Array{Elements: results}
+	}},
+
+	// filter returns the elements of an array that a function returns a truthy value for.
+	"filter": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("filter", args)
+		if err != nil {
+			return err
+		}
+
+		var kept []object.Object
+		for _, elem := range arr.Elements {
+			result := rt.Call(fn, elem)
+			if isError(result) {
+				return result
+			}
+			if isTruthy(result) {
+				kept = append(kept, elem)
+			}
+		}
+
+		return &object.Array{Elements: kept}
+	}},
+
+	// reduce combines the elements of an array from the first to the last, calling a
+	// function with the result so far and each element. Without an initial value the
+	// first element is the result so far.
+	"reduce": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) != 2 && len(args) != 3 {
+			return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+		}
+
+		arr, fn, err := arrayAndFunction("reduce", args[:2])
+		if err != nil {
+			return err
+		}
+
+		elements := arr.Elements
+		var result object.Object
+		if len(args) == 3 {
+			result = args[2]
+		} else if len(elements) > 0 {
+			result, elements = elements[0], elements[1:]
+		} else {
+			return newError("`reduce` of an empty ARRAY with no initial value")
+		}
+
+		for _, elem := range elements {
+			result = rt.Call(fn, result, elem)
+			if isError(result) {
+				return result
+			}
+		}
+
+		return result
+	}},
+
+	// each calls a function on each element of an array, for its effects.
+	"each": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("each", args)
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range arr.Elements {
+			if result := rt.Call(fn, elem); isError(result) {
+				return result
+			}
+		}
+
+		return NULL
+	}},
+
+	// sort returns the elements of an array in ascending order, which is that of the <
+	// operator, or that of a comparator: a function returning a negative integer, zero
+	// or a positive integer as its first argument comes before, with or after its
+	// second. The sort is stable.
+	"sort": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) != 1 && len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+		}
+
+		arr, ok := args[0].(*object.Array)
+		if !ok {
+			return newError("argument to `sort` must be ARRAY, got %s", args[0].Type())
+		}
+
+		if len(args) == 1 {
+			return sortArray(arr.Elements, arr.Elements, compareValues)
+		}
+
+		if args[1].Type() != object.FUNCTION_OBJ {
+			return newError("second argument to `sort` must be FUNCTION, got %s", args[1].Type())
+		}
+
+		return sortArray(arr.Elements, arr.Elements, func(a, b object.Object) (int, object.Object) {
+			result := rt.Call(args[1], a, b)
+			if isError(result) {
+				return 0, result
+			}
+			switch order := result.(type) {
+			case *object.Integer:
+				return int(order.Value), nil
+			case *object.BigInteger:
+				return order.Value.Sign(), nil
+			}
+			return 0, newError("comparator given to `sort` must return INTEGER, got %s", result.Type())
+		})
+	}},
+
+	// sort_by returns the elements of an array in the ascending order of the keys a
+	// function returns for them. The sort is stable.
+	"sort_by": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("sort_by", args)
+		if err != nil {
+			return err
+		}
+
+		keys := make([]object.Object, len(arr.Elements))
+		for i, elem := range arr.Elements {
+			key := rt.Call(fn, elem)
+			if isError(key) {
+				return key
+			}
+			keys[i] = key
+		}
+
+		return sortArray(arr.Elements, keys, compareValues)
+	}},
+
+	// zip returns arrays of the elements at each index of its arrays, as many as
+	// there are elements in the shortest.
+	"zip": {Fn: func(args ...object.Object) object.Object {
+		if len(args) < 1 {
+			return newError("wrong number of arguments. got=%d, want at least 1", len(args))
+		}
+
+		arrays, err := arrayArguments("zip", args)
+		if err != nil {
+			return err
+		}
+
+		length := len(arrays[0].Elements)
+		for _, arr := range arrays[1:] {
+			length = min(length, len(arr.Elements))
+		}
+
+		tuples := make([]object.Object, length)
+		for i := range tuples {
+			tuple := make([]object.Object, len(arrays))
+			for j, arr := range arrays {
+				tuple[j] = arr.Elements[i]
+			}
+			tuples[i] = &object.Array{Elements: tuple}
+		}
+
+		return &object.Array{Elements: tuples}
+	}},
+
+	// range returns the integers from start up to but not including end, counting by
+	// step, which may be negative. With one argument it counts from 0 to that.
+	"range": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) < 1 || len(args) > 3 {
+			return newError("wrong number of arguments. got=%d, want=1, 2 or 3", len(args))
+		}
+
+		bounds := []int64{0, 0, 1}
+		if len(args) == 1 {
+			args = []object.Object{&object.Integer{Value: 0}, args[0]}
+		}
+		for i, arg := range args {
+			integer, err := integerArgument("arguments to `range`", arg)
+			if err != nil {
+				return err
+			}
+			bounds[i] = integer
+		}
+
+		start, end, step := bounds[0], bounds[1], bounds[2]
+		if step == 0 {
+			return newError("step of `range` must not be 0")
+		}
+
+		// The integers are counted, and reserved, before any is created.
+		count := rangeLength(start, end, step)
+		if err := rt.Reserve(count); err != nil {
+			return err
+		}
+
+		elements := make([]object.Object, 0, min(count, 1<<16))
+		for i := int64(0); i < count; i++ {
+			elements = append(elements, &object.Integer{Value: start + i*step})
+		}
+
+		return &object.Array{Elements: elements}
+	}},
+
+	// any reports whether a function returns a truthy value for any element of an array.
+	"any": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		return anyOrAll("any", true, rt, args)
+	}},
+
+	// all reports whether a function returns a truthy value for every element of an array.
+	"all": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		return anyOrAll("all", false, rt, args)
+	}},
+
+	// find returns the first element of an array that a function returns a truthy value
+	// for, or null if there is none.
+	"find": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("find", args)
+		if err != nil {
+			return err
+		}
+
+		for _, elem := range arr.Elements {
+			result := rt.Call(fn, elem)
+			if isError(result) {
+				return result
+			}
+			if isTruthy(result) {
+				return elem
+			}
+		}
+
+		return NULL
+	}},
+
+	// group_by returns a hash from each key a function returns for the elements of an
+	// array to the elements it returned it for, in their order.
+	"group_by": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		arr, fn, err := arrayAndFunction("group_by", args)
+		if err != nil {
+			return err
+		}
+
+		groups := object.NewHash()
+		for _, elem := range arr.Elements {
+			key := rt.Call(fn, elem)
+			if isError(key) {
+				return key
+			}
+
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", key.Type())
+			}
+
+			group := &object.Array{}
+			if pair, ok := groups.Pairs[hashable.HashKey()]; ok {
+				group = pair.Value.(*object.Array)
+			}
+			group.Elements = append(group.Elements, elem)
+			groups.Set(hashable, group)
+		}
+
+		return groups
+	}},
+
+	// flatten returns the elements of an array with the arrays among them replaced by
+	// their elements, all the way down or, with a depth, that many levels down.
+	"flatten": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) != 1 && len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+		}
+
+		arr, ok := args[0].(*object.Array)
+		if !ok {
+			return newError("argument to `flatten` must be ARRAY, got %s", args[0].Type())
+		}
+
+		depth := int64(-1)
+		if len(args) == 2 {
+			integer, err := integerArgument("second argument to `flatten`", args[1])
+			if err != nil {
+				return err
+			}
+			depth = integer
+		}
+
+		flat, err := flatten(rt, nil, arr, depth, map[*object.Array]bool{})
+		if err != nil {
+			return err
+		}
+
+		return &object.Array{Elements: flat}
+	}},
+
+	// uniq returns the elements of an array without those equal to an earlier one.
+	"uniq": {RuntimeFn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		arr, ok := args[0].(*object.Array)
+		if !ok {
+			return newError("argument to `uniq` must be ARRAY, got %s", args[0].Type())
+		}
+
+		seen := map[object.HashKey]bool{}
+		// Values that cannot be hash keys are only equal to themselves.
+		seenValues := map[object.Object]bool{}
+		var kept []object.Object
+
+		for i, elem := range arr.Elements {
+			if i%contextCheckInterval == 0 {
+				if err := rt.Reserve(int64(len(kept))); err != nil {
+					return err
+				}
+			}
+
+			if hashable, ok := elem.(object.Hashable); ok {
+				if seen[hashable.HashKey()] {
+					continue
+				}
+				seen[hashable.HashKey()] = true
+			} else {
+				if seenValues[elem] {
+					continue
+				}
+				seenValues[elem] = true
+			}
+			kept = append(kept, elem)
+		}
+
+		return &object.Array{Elements: kept}
+	}},
+
+	// reverse returns the elements of an array, or the characters of a string, in
+	// reverse order.
+	"reverse": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		switch arg := args[0].(type) {
+		case *object.Array:
+			elements := make([]object.Object, len(arg.Elements))
+			for i, elem := range arg.Elements {
+				elements[len(elements)-1-i] = elem
+			}
+			return &object.Array{Elements: elements}
+		case *object.String:
+			runes := []rune(arg.Value)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return &object.String{Value: string(runes)}
+		default:
+			return newError("argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+		}
+	}},
+
+	// slice returns the elements of an array, or the bytes of a string, from start up
+	// to but not including end, or the last if there is no end. Negative indexes count
+	// back from the end, and indexes out of range are moved into it.
+	"slice": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 2 && len(args) != 3 {
+			return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+		}
+
+		var length int
+		switch arg := args[0].(type) {
+		case *object.Array:
+			length = len(arg.Elements)
+		case *object.String:
+			length = len(arg.Value)
+		default:
+			return newError("argument to `slice` must be ARRAY or STRING, got %s", args[0].Type())
+		}
+
+		bounds := []int{0, length}
+		for i, arg := range args[1:] {
+			integer, err := integerArgument("indexes given to `slice`", arg)
+			if err != nil {
+				return err
+			}
+			bounds[i] = sliceIndex(integer, length)
+		}
+
+		start, end := bounds[0], max(bounds[0], bounds[1])
+		if str, ok := args[0].(*object.String); ok {
+			return &object.String{Value: str.Value[start:end]}
+		}
+
+		elements := make([]object.Object, end-start)
+		copy(elements, args[0].(*object.Array).Elements[start:end])
+		return &object.Array{Elements: elements}
+	}},
+
+	// concat returns the elements of its arrays one after the other.
+	"concat": {Fn: func(args ...object.Object) object.Object {
+		arrays, err := arrayArguments("concat", args)
+		if err != nil {
+			return err
+		}
+
+		var elements []object.Object
+		for _, arr := range arrays {
+			elements = append(elements, arr.Elements...)
+		}
+
+		return &object.Array{Elements: elements}
+	}},
+
+	// keys returns the keys of a hash, in its order.
+	"keys": {Fn: func(args ...object.Object) object.Object {
+		return hashElements("keys", args, func(pair object.HashPair) object.Object {
+			return pair.Key
+		})
+	}},
+
+	// values returns the values of a hash, in its order.
+	"values": {Fn: func(args ...object.Object) object.Object {
+		return hashElements("values", args, func(pair object.HashPair) object.Object {
+			return pair.Value
+		})
+	}},
+
+	// entries returns the pairs of a hash as arrays of a key and its value, in its order.
+	"entries": {Fn: func(args ...object.Object) object.Object {
+		return hashElements("entries", args, func(pair object.HashPair) object.Object {
+			return &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+		})
+	}},
+
+	// has reports whether a hash has a key.
+	"has": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=2", len(args))
+		}
+
+		hash, key, err := hashAndKey("has", args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		_, ok := hash.Pairs[key.HashKey()]
+		return nativeBoolToBooleanObject(ok)
+	}},
+
+	// delete returns a hash with the pairs of another but the one with a key.
+	"delete": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=2", len(args))
+		}
+
+		hash, key, err := hashAndKey("delete", args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		deleted := key.HashKey()
+		result := object.NewHash()
+		for _, pair := range hash.OrderedPairs() {
+			if pair.Key.(object.Hashable).HashKey() != deleted {
+				result.Set(pair.Key.(object.Hashable), pair.Value)
+			}
+		}
+
+		return result
+	}},
+
+	// merge returns a hash with the pairs of its hashes, where a later hash's value for
+	// a key replaces an earlier one's. Keys are in the order they first appear in.
+	"merge": {Fn: func(args ...object.Object) object.Object {
+		if len(args) < 1 {
+			return newError("wrong number of arguments. got=%d, want at least 1", len(args))
+		}
+
+		result := object.NewHash()
+		for _, arg := range args {
+			hash, ok := arg.(*object.Hash)
+			if !ok {
+				return newError("arguments to `merge` must be HASH, got %s", arg.Type())
+			}
+			for _, pair := range hash.OrderedPairs() {
+				result.Set(pair.Key.(object.Hashable), pair.Value)
+			}
+		}
+
+		return result
+	}},
+
+	// get returns the value of a key in a hash, or a default, null unless it is given,
+	// if the hash does not have the key.
+	"get": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 2 && len(args) != 3 {
+			return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+		}
+
+		hash, key, err := hashAndKey("get", args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		if pair, ok := hash.Pairs[key.HashKey()]; ok {
+			return pair.Value
+		}
+		if len(args) == 3 {
+			return args[2]
+		}
+		return NULL
+	}},
+
+	"int": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		switch arg := args[0].(type) {
+		case *object.Integer, *object.BigInteger:
+			return arg
+		case *object.Float:
+			return floatToInteger("int", math.Trunc(arg.Value))
+		case *object.String:
+			value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+			if !ok {
+				return newError("cannot convert %q to INTEGER", arg.Value)
+			}
+			return object.NewInteger(value)
+		default:
+			return newError("argument to `int` not supported, got type %s", args[0].Type())
+		}
+	}},
+
+	"float": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		switch arg := args[0].(type) {
+		case *object.Integer, *object.BigInteger:
+			return &object.Float{Value: toFloat(arg)}
+		case *object.Float:
+			return arg
+		case *object.String:
+			value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+			if err != nil {
+				return newError("cannot convert %q to FLOAT", arg.Value)
+			}
+			return &object.Float{Value: value}
+		default:
+			return newError("argument to `float` not supported, got type %s", args[0].Type())
+		}
+	}},
+
+	// round rounds half away from zero to an integer, or with a second argument
+	// to that many decimal places: a float to a float, and an integer, exactly, to
+	// an integer.
+	"round": {Fn: func(args ...object.Object) object.Object {
+		if len(args) != 1 && len(args) != 2 {
+			return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+		}
+
+		if !isNumber(args[0]) {
+			return newError("argument to `round` must be INTEGER or FLOAT, got %s", args[0].Type())
+		}
+
+		if len(args) == 1 {
+			if args[0].Type() == object.INTEGER_OBJ {
+				return args[0]
+			}
+			return floatToInteger("round", math.Round(toFloat(args[0])))
+		}
+
+		places, err := integerArgument("second argument to `round`", args[1])
+		if err != nil {
+			return err
+		}
+
+		if args[0].Type() == object.INTEGER_OBJ {
+			// An integer has no decimal places to lose.
+			if places >= 0 {
+				return args[0]
+			}
+			return roundIntegerPlaces(toBig(args[0]), places)
+		}
+		return &object.Float{Value: roundPlaces(toFloat(args[0]), places)}
+	}},
+
+	"floor": {Fn: func(args ...object.Object) object.Object {
+		return roundingBuiltin("floor", math.Floor, args)
+	}},
+
+	"ceil": {Fn: func(args ...object.Object) object.Object {
+		return roundingBuiltin("ceil", math.Ceil, args)
+	}},
+
+	// puts writes each argument on a line of its own.
+	"puts": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
+		return writeLines("puts", streams.Out, args)
+	}},
+
+	// warn is puts for the error stream.
+	"warn": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
+		return writeLines("warn", streams.Err, args)
+	}},
+
+	// print writes its arguments one after the other, with no newline.
+	"print": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
 		for _, arg := range args {
-			println(arg.Inspect())
+			if _, err := io.WriteString(streams.Out, arg.Inspect()); err != nil {
+				return newError("`print` failed: %s", err)
+			}
+		}
+
+		return NULL
+	}},
+
+	// printf formats its arguments with Go's fmt verbs, such as %s, %d, %.2f and %v.
+	"printf": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
+		if len(args) < 1 {
+			return newError("wrong number of arguments. got=%d, want at least 1", len(args))
+		}
+
+		format, ok := args[0].(*object.String)
+		if !ok {
+			return newError("first argument to `printf` must be STRING, got %s", args[0].Type())
+		}
+
+		values := make([]interface{}, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = formatValue(arg)
+		}
+
+		if _, err := fmt.Fprintf(streams.Out, format.Value, values...); err != nil {
+			return newError("`printf` failed: %s", err)
 		}
 
 		return NULL
 	}},
+
+	// readline reads a line of input, without its line ending, or returns null at the
+	// end of the input.
+	"readline": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
+		if len(args) != 0 {
+			return newError("wrong number of arguments. got=%d, want=0", len(args))
+		}
+
+		return readLine("readline", streams)
+	}},
+
+	// input writes a prompt and reads the line typed after it, like readline.
+	"input": {IOFn: func(streams *object.IO, args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		if _, err := io.WriteString(streams.Out, args[0].Inspect()); err != nil {
+			return newError("`input` failed: %s", err)
+		}
+
+		return readLine("input", streams)
+	}},
+}
+
+// arrayAndFunction checks the arguments of a builtin that calls a function on the
+// elements of an array.
+func arrayAndFunction(name string, args []object.Object) (*object.Array, object.Object, *object.Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	if args[1].Type() != object.FUNCTION_OBJ {
+		return nil, nil, newError("second argument to `%s` must be FUNCTION, got %s", name, args[1].Type())
+	}
+
+	return arr, args[1], nil
+}
+
+// arrayArguments checks that every argument of a builtin is an array.
+func arrayArguments(name string, args []object.Object) ([]*object.Array, *object.Error) {
+	arrays := make([]*object.Array, len(args))
+	for i, arg := range args {
+		arr, ok := arg.(*object.Array)
+		if !ok {
+			return nil, newError("arguments to `%s` must be ARRAY, got %s", name, arg.Type())
+		}
+		arrays[i] = arr
+	}
+
+	return arrays, nil
+}
+
+// hashElements implements keys, values and entries, which return an element for each
+// pair of a hash.
+func hashElements(name string, args []object.Object, element func(object.HashPair) object.Object) object.Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+
+	pairs := hash.OrderedPairs()
+	elements := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = element(pair)
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// hashAndKey checks the arguments of a builtin that looks up a key in a hash.
+func hashAndKey(name string, hash, key object.Object) (*object.Hash, object.Hashable, *object.Error) {
+	h, ok := hash.(*object.Hash)
+	if !ok {
+		return nil, nil, newError("argument to `%s` must be HASH, got %s", name, hash.Type())
+	}
+
+	k, ok := key.(object.Hashable)
+	if !ok {
+		return nil, nil, newError("unusable as hash key: %s", key.Type())
+	}
+
+	return h, k, nil
+}
+
+// rangeLength returns how many integers range counts from start to end by step, which
+// is not 0.
+func rangeLength(start, end, step int64) int64 {
+	var distance, stride uint64
+	switch {
+	case step > 0 && start < end:
+		distance, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		distance, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return 0
+	}
+
+	count := (distance-1)/stride + 1
+	if count > math.MaxInt64 {
+		// No such array could be created anyway.
+		return math.MaxInt64
+	}
+	return int64(count)
+}
+
+// anyOrAll implements any and all, which stop at the first element the function
+// returns a value for that is as truthy as want.
+func anyOrAll(name string, want bool, rt object.Runtime, args []object.Object) object.Object {
+	arr, fn, err := arrayAndFunction(name, args)
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range arr.Elements {
+		result := rt.Call(fn, elem)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) == want {
+			return nativeBoolToBooleanObject(want)
+		}
+	}
+
+	return nativeBoolToBooleanObject(!want)
+}
+
+// sortArray returns elements sorted stably by their keys, in the order compare gives,
+// or the first error compare returns.
+func sortArray(elements, keys []object.Object, compare func(a, b object.Object) (int, object.Object)) object.Object {
+	order := make([]int, len(elements))
+	for i := range order {
+		order[i] = i
+	}
+
+	var err object.Object
+	sort.SliceStable(order, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var c int
+		c, err = compare(keys[order[i]], keys[order[j]])
+		return c < 0
+	})
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]object.Object, len(elements))
+	for i, index := range order {
+		sorted[i] = elements[index]
+	}
+
+	return &object.Array{Elements: sorted}
+}
+
+// compareValues orders two values with the < operator, failing for values it does not
+// apply to.
+func compareValues(a, b object.Object) (int, object.Object) {
+	for _, pair := range [][2]object.Object{{a, b}, {b, a}} {
+		less := evalInfixExpression("<", pair[0], pair[1])
+		if isError(less) {
+			return 0, newError("cannot compare %s with %s", a.Type(), b.Type())
+		}
+		if less == TRUE {
+			if pair[0] == a {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// flatten appends the elements of arr to flat, with the arrays among them replaced by
+// their elements depth levels down, or all the way down if depth is negative. enclosing
+// holds the arrays being flattened, as one that holds itself cannot be flattened all the
+// way down. The arrays it descends into are kept on a stack of its own rather than Go's,
+// so arrays nested however deeply do not overflow it. Each array it descends into checks
+// that what has been flattened so far fits in the budget, and that the run has not been
+// stopped.
+func flatten(rt object.Runtime, flat []object.Object, arr *object.Array, depth int64, enclosing map[*object.Array]bool) ([]object.Object, *object.Error) {
+	// level is an array being flattened, depth levels above the bottom, and the index
+	// of its next element.
+	type level struct {
+		arr   *object.Array
+		depth int64
+		next  int
+	}
+
+	var stack []*level
+
+	enter := func(arr *object.Array, depth int64) *object.Error {
+		if depth < 0 {
+			if enclosing[arr] {
+				return newError("cannot flatten an ARRAY that contains itself")
+			}
+			enclosing[arr] = true
+		}
+
+		if err := rt.Reserve(int64(len(flat))); err != nil {
+			return err
+		}
+
+		stack = append(stack, &level{arr: arr, depth: depth})
+		return nil
+	}
+
+	if err := enter(arr, depth); err != nil {
+		return nil, err
+	}
+
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+
+		if top.next == len(top.arr.Elements) {
+			delete(enclosing, top.arr)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		elem := top.arr.Elements[top.next]
+		top.next++
+
+		inner, ok := elem.(*object.Array)
+		if !ok || top.depth == 0 {
+			flat = append(flat, elem)
+			continue
+		}
+
+		if err := enter(inner, top.depth-1); err != nil {
+			return nil, err
+		}
+	}
+
+	return flat, nil
+}
+
+// integerArgument returns the value of arg, which what describes, if it is an integer
+// that fits in 64 bits.
+func integerArgument(what string, arg object.Object) (int64, *object.Error) {
+	switch arg := arg.(type) {
+	case *object.Integer:
+		return arg.Value, nil
+	case *object.BigInteger:
+		return 0, newError("%s must be between %d and %d, got %s", what, int64(math.MinInt64), int64(math.MaxInt64), arg.Inspect())
+	}
+	return 0, newError("%s must be INTEGER, got %s", what, arg.Type())
+}
+
+// sliceIndex turns an index given to slice into one within 0 and length.
+func sliceIndex(index int64, length int) int {
+	if index < 0 {
+		index += int64(length)
+	}
+
+	return int(max(0, min(index, int64(length))))
+}
+
+// writeLines implements puts and warn.
+func writeLines(name string, w io.Writer, args []object.Object) object.Object {
+	for _, arg := range args {
+		if _, err := io.WriteString(w, arg.Inspect()+"\n"); err != nil {
+			return newError("`%s` failed: %s", name, err)
+		}
+	}
+
+	return NULL
+}
+
+// formatValue returns the Go value printf formats obj as.
+func formatValue(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value
+	case *object.BigInteger:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	default:
+		return obj.Inspect()
+	}
+}
+
+// readLine implements readline and input.
+func readLine(name string, streams *object.IO) object.Object {
+	line, err := streams.In.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return NULL
+	}
+	if err != nil && err != io.EOF {
+		return newError("`%s` failed: %s", name, err)
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+
+	return &object.String{Value: line}
+}
+
+// roundingBuiltin implements floor and ceil, which turn a number into an integer.
+func roundingBuiltin(name string, round func(float64) float64, args []object.Object) object.Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer, *object.BigInteger:
+		return arg
+	case *object.Float:
+		return floatToInteger(name, round(arg.Value))
+	default:
+		return newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, args[0].Type())
+	}
+}
+
+// roundPlaces rounds x half away from zero to the given number of decimal places, or to
+// a multiple of 10^-places if places is negative. x is returned as it is when scaling it
+// would overflow, or when it is too big for the rounding to change it.
+func roundPlaces(x float64, places int64) float64 {
+	if x == 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+
+	// Beyond 2^52 a float has no fractional part left to round away.
+	const integral = 1 << 52
+
+	if places >= 0 {
+		scale := math.Pow10(int(places))
+		scaled := x * scale
+		if math.IsInf(scaled, 0) || math.Abs(scaled) >= integral {
+			return x
+		}
+		return math.Round(scaled) / scale
+	}
+
+	// No float reaches half of 10^309.
+	if places < -308 {
+		return math.Copysign(0, x)
+	}
+
+	// Dividing by 10^-places rather than multiplying by 10^places, which underflows
+	// to zero long before the division stops being representable.
+	scale := math.Pow10(int(-places))
+	scaled := x / scale
+	if math.Abs(scaled) >= integral {
+		return x
+	}
+	rounded := math.Round(scaled) * scale
+	if math.IsInf(rounded, 0) {
+		return x
+	}
+	return rounded
+}
+
+// roundIntegerPlaces rounds x half away from zero to a multiple of 10^-places, for a
+// negative number of places.
+func roundIntegerPlaces(x *big.Int, places int64) object.Object {
+	// An integer with fewer digits than -places is less than half of 10^-places.
+	digits := int64(len(new(big.Int).Abs(x).String()))
+	if places < -digits {
+		return &object.Integer{Value: 0}
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(-places), nil)
+	quotient, remainder := new(big.Int).QuoRem(new(big.Int).Abs(x), scale, new(big.Int))
+	if remainder.Lsh(remainder, 1).Cmp(scale) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+
+	rounded := quotient.Mul(quotient, scale)
+	if x.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+	return object.NewInteger(rounded)
+}
+
+// floatToInteger converts an already integral float, failing if it is infinite or NaN.
+func floatToInteger(name string, value float64) object.Object {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return newError("`%s` of %s is not an INTEGER", name, (&object.Float{Value: value}).Inspect())
+	}
+
+	if value >= math.MinInt64 && value < math.MaxInt64 {
+		return &object.Integer{Value: int64(value)}
+	}
+
+	integer, _ := big.NewFloat(value).Int(nil)
+	return object.NewInteger(integer)
+}
+
+// BuiltinNames returns the names of all builtin functions in a stable, sorted order.
+// The compiler and VM use the position in this list to refer to a builtin.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+
+	for name := range builtins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// LookupBuiltin returns the builtin function registered under the given name.
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// builtinParameters names the parameters of each builtin, for tools that check calls
+// before they run. A parameter ending in ? is optional, and one ending in ... takes any
+// number of arguments.
+var builtinParameters = map[string][]string{
+	"len":      {"value"},
+	"first":    {"array"},
+	"last":     {"array"},
+	"rest":     {"array"},
+	"push":     {"array", "value"},
+	"map":      {"array", "fn"},
+	"filter":   {"array", "fn"},
+	"reduce":   {"array", "fn", "initial?"},
+	"each":     {"array", "fn"},
+	"sort":     {"array", "comparator?"},
+	"sort_by":  {"array", "fn"},
+	"zip":      {"array", "arrays..."},
+	"range":    {"start", "end?", "step?"},
+	"any":      {"array", "fn"},
+	"all":      {"array", "fn"},
+	"find":     {"array", "fn"},
+	"group_by": {"array", "fn"},
+	"flatten":  {"array", "depth?"},
+	"uniq":     {"array"},
+	"reverse":  {"value"},
+	"slice":    {"value", "start", "end?"},
+	"concat":   {"arrays..."},
+	"keys":     {"hash"},
+	"values":   {"hash"},
+	"entries":  {"hash"},
+	"has":      {"hash", "key"},
+	"delete":   {"hash", "key"},
+	"merge":    {"hash", "hashes..."},
+	"get":      {"hash", "key", "default?"},
+	"int":      {"value"},
+	"float":    {"value"},
+	"round":    {"number", "places?"},
+	"floor":    {"number"},
+	"ceil":     {"number"},
+	"puts":     {"values..."},
+	"warn":     {"values..."},
+	"print":    {"values..."},
+	"printf":   {"format", "values..."},
+	"readline": {},
+	"input":    {"prompt"},
+}
+
+// BuiltinArity returns the fewest and the most arguments the builtin called name takes,
+// with max -1 if there is no limit. It returns false if there is no such builtin.
+func BuiltinArity(name string) (min, max int, ok bool) {
+	params, ok := builtinParameters[name]
+	if !ok {
+		return 0, 0, false
+	}
+
+	for _, param := range params {
+		switch {
+		case strings.HasSuffix(param, "..."):
+			return min, -1, true
+		case !strings.HasSuffix(param, "?"):
+			min++
+		}
+		max++
+	}
+
+	return min, max, true
+}
+
+// BuiltinSignature returns how the builtin called name is called, such as
+// "round(number, places?)". It returns false if there is no such builtin.
+func BuiltinSignature(name string) (string, bool) {
+	params, ok := builtinParameters[name]
+	if !ok {
+		return "", false
+	}
+
+	return name + "(" + strings.Join(params, ", ") + ")", true
 }
@@ -0,0 +1,44 @@
+package evaluator
+
+import (
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+// Debugger watches the evaluator evaluate a program. It is told about every node that
+// has a position before the node is evaluated, and can pause the program there by not
+// returning until it should go on.
+type Debugger interface {
+	// Before is called with the node about to be evaluated, the environment it is
+	// evaluated in and the number of calls in progress. If it returns an error,
+	// evaluation stops with it.
+	Before(node ast.Node, env *object.Environment, depth int) *object.Error
+}
+
+// SetDebugger makes the evaluator tell d about the nodes it evaluates. A nil d detaches
+// the debugger.
+func (e *Evaluator) SetDebugger(d Debugger) {
+	e.debugger = d
+}
+
+// Frame is a call in progress, as a debugger sees it.
+type Frame struct {
+	Function string              // the name of the function, as stack traces give it
+	Pos      token.Pos           // the position the call has reached
+	Env      *object.Environment // the environment its code is evaluated in; nil if not known
+}
+
+// Stack returns the calls in progress, innermost first, given the position the innermost
+// one has reached and the environment it is evaluated in. It is meant to be called by a
+// debugger paused in Before.
+func (e *Evaluator) Stack(pos token.Pos, env *object.Environment) []Frame {
+	stack := make([]Frame, 0, len(e.frames)+1)
+
+	for i := len(e.frames) - 1; i >= 0; i-- {
+		stack = append(stack, Frame{Function: e.frames[i].function, Pos: pos, Env: env})
+		pos, env = e.frames[i].callSite, e.frames[i].callerEnv
+	}
+
+	return append(stack, Frame{Function: object.MainFunction, Pos: pos, Env: env})
+}
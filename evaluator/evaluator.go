@@ -2,68 +2,285 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strings"
 
 	"github.com/shafik23/ys/ast"
 	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// EvalInfix applies an infix operator to two already evaluated operands.
+// It is shared with the VM so that both engines agree on operator semantics.
+func EvalInfix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// EvalPrefix applies a prefix operator to an already evaluated operand.
+func EvalPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// EvalIndex indexes an array or hash with an already evaluated index.
+func EvalIndex(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// EvalSetIndex stores value at index in an array or hash, returning the value. A key
+// added to a hash is charged to budget.
+func EvalSetIndex(left, index, value object.Object, budget *Budget) object.Object {
+	return evalSetIndexExpression(left, index, value, budget)
+}
+
+// IterationPairs returns the keys and values a for-in loop visits over iterable,
+// and false if it cannot be iterated over.
+func IterationPairs(iterable object.Object) ([]object.Object, []object.Object, bool) {
+	return iterationPairs(iterable)
+}
+
+// EvalMember reads the member name of a module, or the string key name of a hash.
+func EvalMember(obj object.Object, name string) object.Object {
+	return evalMemberExpression(obj, name)
+}
+
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// Throw returns the error that a throw statement raises with value. A thrown hash
+// can give the error's message and kind with its "message" and "kind" keys.
+func Throw(value object.Object) *object.Error {
+	err := &object.Error{Message: value.Inspect(), Kind: object.ThrownErrorKind, Value: value}
+
+	switch value := value.(type) {
+	case *object.String:
+		err.Message = value.Value
+	case *object.Hash:
+		if message, ok := hashString(value, "message"); ok {
+			err.Message = message
+		}
+		if kind, ok := hashString(value, "kind"); ok {
+			err.Kind = kind
+		}
+	}
+
+	return err
+}
+
+// ErrorValue returns the hash a catch clause binds to err: its "message", "kind" and
+// "stack", and for an error raised by a throw statement, the "value" thrown.
+func ErrorValue(err *object.Error) object.Object {
+	kind := err.Kind
+	if kind == "" {
+		kind = object.RuntimeErrorKind
+	}
+
+	stack := make([]object.Object, len(err.Stack))
+	for i, frame := range err.Stack {
+		stack[i] = &object.String{Value: frame.String()}
+	}
+
+	hash := object.NewHash()
+	setHashString(hash, "message", &object.String{Value: err.Message})
+	setHashString(hash, "kind", &object.String{Value: kind})
+	setHashString(hash, "stack", &object.Array{Elements: stack})
+	if err.Value != nil {
+		setHashString(hash, "value", err.Value)
+	}
+
+	return hash
+}
+
+// MaxCallDepth is how deeply function calls may nest before evaluation fails with a
+// stack overflow, instead of exhausting the Go stack.
+const MaxCallDepth = 1 << 14
+
+// Evaluator evaluates programs by walking their AST. It keeps the stack of calls in
+// progress so that errors can report where they happened.
+type Evaluator struct {
+	frames   []frame // outermost first
+	modules  *Modules
+	budget   *Budget
+	io       *object.IO
+	debugger Debugger
+	profiler Profiler
+}
+
+// frame is a call in progress: the name of the function called, the call's position and
+// the environment the call was made from.
+type frame struct {
+	function  string
+	callSite  token.Pos
+	callerEnv *object.Environment
+}
+
+// New returns an Evaluator with an empty call stack, which finds imports relative to
+// the importing file and gives programs the standard streams.
+func New() *Evaluator {
+	return &Evaluator{modules: NewModules(nil), io: object.StandardIO()}
+}
+
+// SetIO makes the builtins that programs call read from and write to streams.
+func (e *Evaluator) SetIO(streams *object.IO) {
+	e.io = streams
+}
+
+// SetModules makes the evaluator load imports with m, sharing its cache of loaded modules.
+func (e *Evaluator) SetModules(m *Modules) {
+	e.modules = m
+}
+
+// SetBudget makes the evaluator charge its work to b, and stop with a LimitError once b
+// runs out or its context is done. A nil b lifts the limits.
+func (e *Evaluator) SetBudget(b *Budget) {
+	e.budget = b
+}
+
+// Eval evaluates node in env with a new Evaluator.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+// Eval evaluates node in env.
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+	if err := e.budget.Step(); err != nil {
+		result = err
+	} else if err := e.debug(node, env); err != nil {
+		result = err
+	} else {
+		e.profile(node)
+		result = e.eval(node, env)
+	}
+
+	// Errors are created without knowing where they happened, so the innermost
+	// node an error passes through stamps it with its own position and the calls
+	// that were in progress there.
+	if err, ok := result.(*object.Error); ok {
+		if !err.Pos.IsValid() {
+			err.Pos = node.Pos()
+		}
+		if err.Stack == nil {
+			err.Stack = e.stackTrace(err.Pos)
+		}
+	}
+
+	return result
+}
+
+// debug tells the debugger, if there is one, that node is about to be evaluated in env.
+func (e *Evaluator) debug(node ast.Node, env *object.Environment) *object.Error {
+	if e.debugger == nil || !node.Pos().IsValid() {
+		return nil
+	}
+	return e.debugger.Before(node, env, len(e.frames))
+}
+
+// Call applies fn, a function or builtin, to args on behalf of a Go caller rather than a
+// call expression. An error it returns has a stack trace that starts at fn.
+func (e *Evaluator) Call(fn object.Object, args []object.Object) object.Object {
+	result := e.applyFunction(fn, args, token.Pos{}, nil)
+
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		err.Stack = e.stackTrace(err.Pos)
+	}
+
+	return result
+}
+
+// stackTrace describes the calls in progress, innermost first, given the position
+// the innermost one has reached.
+func (e *Evaluator) stackTrace(pos token.Pos) []object.StackFrame {
+	trace := make([]object.StackFrame, 0, len(e.frames)+1)
+
+	for i := len(e.frames) - 1; i >= 0; i-- {
+		trace = append(trace, object.StackFrame{Function: e.frames[i].function, Pos: pos})
+		pos = e.frames[i].callSite
+	}
+
+	return append(trace, object.StackFrame{Function: object.MainFunction, Pos: pos})
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+
+		// && and || only evaluate their right side if the left one does not decide,
+		// and produce whichever operand decided.
+		if (node.Operator == "&&" && !isTruthy(left)) || (node.Operator == "||" && isTruthy(left)) {
+			return left
+		}
+
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		if node.Operator == "&&" || node.Operator == "||" {
+			return right
+		}
+		if err := e.budget.Operation(node.Operator, left, right); err != nil {
+			return err
+		}
+		return e.allocated(evalInfixExpression(node.Operator, left, right))
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
@@ -72,61 +289,242 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Name: node.Name, Parameters: params, Body: body, Env: env}
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return e.allocated(&object.Array{Elements: elements})
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
 
-		index := Eval(node.Index, env)
+		index := e.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 
 		return evalIndexExpression(left, index)
 
+	case *ast.MemberExpression:
+		obj := e.Eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Member.Value)
+
+	case *ast.ImportStatement:
+		return e.evalImportStatement(node, env)
+
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
+
 	case *ast.CallExpression:
 		// Evaluate the function.
-		fun := Eval(node.Function, env)
+		fun := e.Eval(node.Function, env)
 		if isError(fun) {
 			return fun
 		}
 
 		// Evaluate the arguments.
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(fun, args)
+		return e.applyFunction(fun, args, node.Pos(), env)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
+
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return e.evalForStatement(node, env)
+
+	case *ast.ForInStatement:
+		return e.evalForInStatement(node, env)
+
+	case *ast.ThrowStatement:
+		val := e.Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return Throw(val)
+
+	case *ast.TryExpression:
+		return e.evalTryExpression(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+	}
+
+	return nil
+}
+
+func (e *Evaluator) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		// Evaluate the condition.
+		condition := e.Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		// Evaluate the body, stopping on break, return or error.
+		if result, done := e.evalLoopBody(ws.Body, env); done {
+			return result
+		}
+	}
+}
+
+func (e *Evaluator) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	// Run the init statement once, in the enclosing scope.
+	if fs.Init != nil {
+		if init := e.Eval(fs.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for {
+		// Evaluate the condition; a missing condition loops forever.
+		if fs.Condition != nil {
+			condition := e.Eval(fs.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+
+			if !isTruthy(condition) {
+				return nil
+			}
+		}
+
+		// Evaluate the body, stopping on break, return or error.
+		if result, done := e.evalLoopBody(fs.Body, env); done {
+			return result
+		}
+
+		// Run the post statement, also after a continue.
+		if fs.Post != nil {
+			if post := e.Eval(fs.Post, env); isError(post) {
+				return post
+			}
+		}
+	}
+}
+
+func (e *Evaluator) evalForInStatement(fi *ast.ForInStatement, env *object.Environment) object.Object {
+	// Evaluate the iterable.
+	iterable := e.Eval(fi.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	// Collect the keys and values to iterate over.
+	keys, values, ok := iterationPairs(iterable)
+	if !ok {
+		err := newError("cannot iterate over %s", iterable.Type())
+		err.Pos = fi.Iterable.Pos()
+		return err
+	}
+
+	// With a single variable, hashes yield their keys.
+	if fi.Key == nil && iterable.Type() == object.HASH_OBJ {
+		values = keys
+	}
+
+	for i := range values {
+		if fi.Key != nil {
+			env.Set(fi.Key.Value, keys[i])
+		}
+		env.Set(fi.Value.Value, values[i])
+
+		// Evaluate the body, stopping on break, return or error.
+		if result, done := e.evalLoopBody(fi.Body, env); done {
+			return result
+		}
 	}
 
 	return nil
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+// iterationPairs returns the keys and values a for-in loop visits: indexes and elements of
+// an array, indexes and characters of a string, or the keys and values of a hash
+// ordered by key.
+func iterationPairs(iterable object.Object) ([]object.Object, []object.Object, bool) {
+	var keys, values []object.Object
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		for i, el := range iterable.Elements {
+			keys = append(keys, &object.Integer{Value: int64(i)})
+			values = append(values, el)
+		}
+
+	case *object.String:
+		i := 0
+		for _, ch := range iterable.Value {
+			keys = append(keys, &object.Integer{Value: int64(i)})
+			values = append(values, &object.String{Value: string(ch)})
+			i++
+		}
+
+	case *object.Hash:
+		for _, pair := range iterable.OrderedPairs() {
+			keys = append(keys, pair.Key)
+			values = append(values, pair.Value)
+		}
+
+	default:
+		return nil, nil, false
+	}
+
+	return keys, values, true
+}
+
+// evalLoopBody runs one iteration of a loop body. It reports done, along with the
+// value the loop statement should produce, when the loop must stop.
+func (e *Evaluator) evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := e.Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.BREAK_OBJ:
+		return nil, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	}
+
+	return nil, false
+}
+
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	// Create a new hash.
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
+
+	// Evaluate each key-value pair, in the order they were written in.
+	for _, keyNode := range ast.SortedKeys(node) {
+		valueNode := node.Pairs[keyNode]
 
-	// Evaluate each key-value pair.
-	for keyNode, valueNode := range node.Pairs {
 		// Evaluate the key.
-		key := Eval(keyNode, env)
+		key := e.Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
@@ -138,18 +536,17 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		}
 
 		// Evaluate the value.
-		value := Eval(valueNode, env)
+		value := e.Eval(valueNode, env)
 		if isError(value) {
 			return value
 		}
 
 		// Add the key-value pair to the hash.
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
 	// Return the hash.
-	return &object.Hash{Pairs: pairs}
+	return e.allocated(hash)
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -163,6 +560,174 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// evalMemberExpression reads the member name of a module, or the value of a hash under
+// the string key name.
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Module:
+		if value, ok := obj.Member(name); ok {
+			return value
+		}
+		return newError("module %s has no member %s", obj.Name, name)
+	case *object.Hash:
+		return evalHashIndexExpression(obj, &object.String{Value: name})
+	default:
+		return newError("%s has no member %s", obj.Type(), name)
+	}
+}
+
+func (e *Evaluator) evalImportStatement(is *ast.ImportStatement, env *object.Environment) object.Object {
+	module, err := e.modules.Load(is.Path.Value, is.Pos().File, func(module *object.Module, program *ast.Program) *object.Error {
+		// The module's top level shows up in stack traces as a call made by the import.
+		e.frames = append(e.frames, frame{function: object.ModuleFunction(module.Name), callSite: is.Pos(), callerEnv: env})
+		defer func() { e.frames = e.frames[:len(e.frames)-1] }()
+
+		if e.profiler != nil {
+			e.profiler.Enter(object.ModuleFunction(module.Name), program.Pos())
+			defer e.profiler.Exit()
+		}
+
+		if errObj, ok := e.Eval(program, module.Env).(*object.Error); ok {
+			return errObj
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(is.Names) == 0 {
+		env.Set(is.Alias.Value, module)
+		return nil
+	}
+
+	for _, name := range is.Names {
+		value := evalMemberExpression(module, name.Value)
+		if err, ok := value.(*object.Error); ok {
+			err.Pos = name.Pos()
+			return err
+		}
+		env.Set(name.Value, value)
+	}
+
+	return nil
+}
+
+// evalAssignExpression evaluates the target's operands, then the value, and stores it.
+// A compound assignment such as x += 1 applies its operator to the current value first.
+func (e *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if operator != "" {
+			current = e.Eval(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+
+		value := e.evalAssignedValue(operator, current, node.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		if _, ok := env.Assign(target.Value, value); !ok {
+			if _, isBuiltin := builtins[target.Value]; isBuiltin {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("identifier not found: " + target.Value)
+		}
+
+		return value
+
+	case *ast.IndexExpression:
+		left := e.Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index := e.Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		var current object.Object
+		if operator != "" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+
+		value := e.evalAssignedValue(operator, current, node.Value, env)
+		if isError(value) {
+			return value
+		}
+
+		return evalSetIndexExpression(left, index, value, e.budget)
+
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the right-hand side of an assignment, combining it with the
+// current value of the target when operator is not empty.
+func (e *Evaluator) evalAssignedValue(operator string, current object.Object, node ast.Expression, env *object.Environment) object.Object {
+	value := e.Eval(node, env)
+	if isError(value) || operator == "" {
+		return value
+	}
+
+	if err := e.budget.Operation(operator, current, value); err != nil {
+		return err
+	}
+	return e.allocated(evalInfixExpression(operator, current, value))
+}
+
+func evalSetIndexExpression(left, index, value object.Object, budget *Budget) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		if index.Type() != object.INTEGER_OBJ {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+
+		integer, ok := index.(*object.Integer)
+		if !ok {
+			return newError("index out of range: %s (length %d)", index.Inspect(), len(left.Elements))
+		}
+
+		// Unlike reading, writing out of bounds is an error rather than null.
+		idx := integer.Value
+		if idx < 0 || idx >= int64(len(left.Elements)) {
+			return newError("index out of range: %d (length %d)", idx, len(left.Elements))
+		}
+
+		left.Elements[idx] = value
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+
+		if _, ok := left.Pairs[key.HashKey()]; !ok {
+			if err := budget.AddElements(1); err != nil {
+				return err
+			}
+		}
+
+		left.Set(key, value)
+
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return value
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	// Cast the objects to the correct types.
 	hashObject := hash.(*object.Hash)
@@ -185,7 +750,11 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	// Cast the objects to the correct types.
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	integer, ok := index.(*object.Integer)
+	if !ok { // a big integer is always out of bounds
+		return NULL
+	}
+	idx := integer.Value
 	max := int64(len(arrayObject.Elements) - 1)
 
 	// Check that the index is within bounds.
@@ -197,22 +766,121 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (e *Evaluator) evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := e.Eval(te.Block, env)
+
+	// Running out of budget stops the whole program, finally blocks included.
+	if err, ok := result.(*object.Error); ok && err.Kind == object.LimitErrorKind {
+		return err
+	}
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		env.Set(te.Param.Value, ErrorValue(err))
+		result = e.Eval(te.Catch, env)
+	}
+
+	if te.Finally != nil {
+		// The finally block's own value is dropped, but an error, a return or a loop
+		// control signal from it takes over from however the rest ended.
+		if final := e.Eval(te.Finally, env); final != nil {
+			switch final.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return final
+			}
+		}
+	}
+
+	return result
+}
+
+// hashString looks up a string key of hash whose value is a string.
+func hashString(hash *object.Hash, key string) (string, bool) {
+	pair, ok := hash.Pairs[(&object.String{Value: key}).HashKey()]
+	if !ok {
+		return "", false
+	}
+
+	str, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+
+	return str.Value, true
+}
+
+// setHashString stores value under a string key of hash.
+func setHashString(hash *object.Hash, key string, value object.Object) {
+	hash.Set(&object.String{Value: key}, value)
+}
+
+// applyFunction calls fn with args. The call was made at callSite in env, which are
+// unknown when Go code makes it.
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object, callSite token.Pos, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+
+		if len(e.frames) >= MaxCallDepth {
+			return newError("stack overflow")
+		}
+		if err := e.budget.Call(len(e.frames)); err != nil {
+			return err
+		}
+
+		name := fn.Name
+		if name == "" {
+			name = object.AnonymousFunction
+		}
+
+		e.frames = append(e.frames, frame{function: name, callSite: callSite, callerEnv: env})
+		if e.profiler != nil {
+			e.profiler.Enter(name, fn.Body.Pos())
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := e.Eval(fn.Body, extendedEnv)
+		if e.profiler != nil {
+			e.profiler.Exit()
+		}
+		e.frames = e.frames[:len(e.frames)-1]
+
+		if evaluated == nil {
+			// A body ending in a statement, such as a let or a loop, has no value.
+			return NULL
+		}
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if e.profiler != nil {
+			e.profiler.Enter(builtinName(fn), token.Pos{})
+			defer e.profiler.Exit()
+		}
+		rt := &builtinRuntime{e: e, callSite: callSite, env: env}
+		return e.allocated(fn.Call(e.io, rt, args...))
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// builtinRuntime is the object.Runtime of a builtin called at callSite in env. The
+// functions it calls back are called from there.
+type builtinRuntime struct {
+	e        *Evaluator
+	callSite token.Pos
+	env      *object.Environment
+}
+
+func (rt *builtinRuntime) Call(fn object.Object, args ...object.Object) object.Object {
+	return rt.e.applyFunction(fn, args, rt.callSite, rt.env)
+}
+
+func (rt *builtinRuntime) Reserve(n int64) *object.Error {
+	return rt.e.budget.Reserve(n)
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	// Create a new environment.
 	env := object.NewClosureEnvironment(fn.Env)
@@ -226,6 +894,18 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
 	return env
 }
 
+// allocated charges the budget for obj, a value that has just been created, and returns
+// it, or the error for a limit it exceeds.
+func (e *Evaluator) allocated(obj object.Object) object.Object {
+	if err := e.budget.Allocate(obj); err != nil {
+		return err
+	}
+	if e.profiler != nil {
+		e.profiler.Allocate(obj)
+	}
+	return obj
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	// Check if the object is a return-value.
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
@@ -236,12 +916,12 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	// Evaluate each expression.
-	for _, e := range exps {
-		evaluated := Eval(e, env)
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -267,9 +947,9 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	// Evaluate the condition.
-	condition := Eval(ie.Condition, env)
+	condition := e.Eval(ie.Condition, env)
 
 	if isError(condition) {
 		return condition
@@ -278,10 +958,10 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	// Check if the condition is true.
 	if isTruthy(condition) {
 		// Evaluate the consequence.
-		return Eval(ie.Consequence, env)
+		return e.Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		// Evaluate the alternative.
-		return Eval(ie.Alternative, env)
+		return e.Eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
@@ -304,30 +984,147 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	// Check that the objects are integers.
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		_, leftBig := left.(*object.BigInteger)
+		_, rightBig := right.(*object.BigInteger)
+		if leftBig || rightBig {
+			return evalBigIntegerInfixExpression(operator, toBig(left), toBig(right))
+		}
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// One side is a float. Comparisons are exact; for arithmetic the other side
+		// is promoted to a float.
+		if compare, ok := numberComparisons[operator]; ok {
+			return nativeBoolToBooleanObject(compareNumbers(compare, left, right))
+		}
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	// Check that the operator is the addition operator.
-	if operator != "+" {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	// Strings concatenate with + and compare by value, byte-wise.
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+}
 
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
+func evalFloatInfixExpression(operator string, leftVal, rightVal float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+	}
+}
 
-	return &object.String{Value: leftVal + rightVal}
+// numberComparisons turn the result of comparing two numbers, -1, 0 or +1, into the
+// result of each comparison operator.
+var numberComparisons = map[string]func(cmp int) bool{
+	"<":  func(cmp int) bool { return cmp < 0 },
+	">":  func(cmp int) bool { return cmp > 0 },
+	"<=": func(cmp int) bool { return cmp <= 0 },
+	">=": func(cmp int) bool { return cmp >= 0 },
+	"==": func(cmp int) bool { return cmp == 0 },
+	"!=": func(cmp int) bool { return cmp != 0 },
+}
+
+// compareNumbers compares two numbers, at least one of them a float, by their exact
+// values rather than converting an integer to the nearest float64, which big integers
+// can overflow and integers beyond 2^53 lose digits in. As with floats, NaN is unequal
+// to everything, and only != holds for it.
+func compareNumbers(compare func(cmp int) bool, left, right object.Object) bool {
+	l, r := exactNumber(left), exactNumber(right)
+	if l == nil || r == nil {
+		return compare(1) && compare(-1)
+	}
+	return compare(l.Cmp(r))
+}
+
+// exactNumber returns the exact value of an integer or float, or nil for NaN.
+func exactNumber(obj object.Object) *big.Float {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return new(big.Float).SetInt64(obj.Value)
+	case *object.BigInteger:
+		return new(big.Float).SetInt(obj.Value)
+	default:
+		value := obj.(*object.Float).Value
+		if math.IsNaN(value) {
+			return nil
+		}
+		return new(big.Float).SetFloat64(value)
+	}
+}
+
+// isNumber reports whether obj is an integer or a float.
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts an integer or float to a float64.
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInteger:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value
+	default:
+		return obj.(*object.Float).Value
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
@@ -335,20 +1132,42 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
-	// Perform the operation.
+	// Perform the operation. Arithmetic that overflows breaks out of the switch
+	// and is redone with big integers.
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		if sum := leftVal + rightVal; (sum > leftVal) == (rightVal > 0) {
+			return &object.Integer{Value: sum}
+		}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		if diff := leftVal - rightVal; (diff < leftVal) == (rightVal > 0) {
+			return &object.Integer{Value: diff}
+		}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		product := leftVal * rightVal
+		if leftVal == 0 || (product/leftVal == rightVal && !(leftVal == -1 && rightVal == math.MinInt64)) {
+			return &object.Integer{Value: product}
+		}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if !(leftVal == math.MinInt64 && rightVal == -1) {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -356,13 +1175,59 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+
+	return evalBigIntegerInfixExpression(operator, big.NewInt(leftVal), big.NewInt(rightVal))
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func evalBigIntegerInfixExpression(operator string, leftVal, rightVal *big.Int) object.Object {
+	switch operator {
+	case "+":
+		return object.NewInteger(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return object.NewInteger(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return object.NewInteger(new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// Quo and Rem truncate towards zero, like the int64 operators.
+		return object.NewInteger(new(big.Int).Quo(leftVal, rightVal))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(new(big.Int).Rem(leftVal, rightVal))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+}
+
+// toBig converts an integer of either representation to a big.Int.
+func toBig(obj object.Object) *big.Int {
+	if integer, ok := obj.(*object.Integer); ok {
+		return big.NewInt(integer.Value)
+	}
+	return obj.(*object.BigInteger).Value
+}
+
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		result = e.Eval(stmt, env)
 
 		switch result := result.(type) {
 		// If the result is a return-value OR an error, then short-circuit early and
@@ -377,14 +1242,18 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
-
-		if result != nil && (result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ) {
-			return result
+		result = e.Eval(stmt, env)
+
+		if result != nil {
+			// Return values, errors and loop control signals all stop the block early.
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return result
+			}
 		}
 	}
 
@@ -403,6 +1272,17 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Neg(right.Value))
+	case *object.Integer:
+		if right.Value == math.MinInt64 { // the negation does not fit in an int64
+			return object.NewInteger(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+	}
+
 	// Check that the object is an integer.
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
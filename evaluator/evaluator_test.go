@@ -1,52 +1,126 @@
-package evaluator
+package evaluator_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/compiler"
+	"github.com/shafik23/ys/evaluator"
 	"github.com/shafik23/ys/lexer"
 	"github.com/shafik23/ys/object"
 	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/token"
+	"github.com/shafik23/ys/vm"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"5", 5},
-		{"10", 10},
-		{"-5", -5},
-		{"-10", -10},
-		{"-100", -100},
-		{"5 + 5 + 5 + 5 - 10", 10},
-		{"2 * 2 * 2 * 2 * 2", 32},
-		{"-50 + 100 + -50", 0},
-		{"5 * 2 + 10", 20},
-		{"5 + 2 * 10", 25},
-		{"20 + 2 * -10", 0},
-		{"50 / 2 * 2 + 10", 60},
-		{"2 * (5 + 10)", 30},
-		{"3 * 3 * 3 + 10", 37},
-		{"3 * (3 * 3) + 10", 37},
-		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
-	}
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"5", 5},
+			{"10", 10},
+			{"-5", -5},
+			{"-10", -10},
+			{"-100", -100},
+			{"5 + 5 + 5 + 5 - 10", 10},
+			{"2 * 2 * 2 * 2 * 2", 32},
+			{"-50 + 100 + -50", 0},
+			{"5 * 2 + 10", 20},
+			{"5 + 2 * 10", 25},
+			{"20 + 2 * -10", 0},
+			{"50 / 2 * 2 + 10", 60},
+			{"2 * (5 + 10)", 30},
+			{"3 * 3 * 3 + 10", 37},
+			{"3 * (3 * 3) + 10", 37},
+			{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+			{"10 % 3", 1},
+			{"-7 % 3", -1},
+			{"2 + 10 % 4 * 3", 8},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+// evalFunc runs a program and returns its result.
+type evalFunc func(input string) object.Object
+
+// engines lists every way of running a program with the given streams, charging it to
+// a budget if one is given; each test runs against all of them so the tree-walking
+// evaluator and the bytecode VM are held to the same behaviour.
+var engines = []struct {
+	name string
+	eval func(input string, streams *object.IO, budget *evaluator.Budget) object.Object
+}{
+	{"evaluator", testEval},
+	{"vm", testRun},
+}
+
+func runEngines(t *testing.T, test func(t *testing.T, testEval evalFunc)) {
+	for _, engine := range engines {
+		engine := engine
+		t.Run(engine.name, func(t *testing.T) {
+			test(t, func(input string) object.Object {
+				return engine.eval(input, object.NewIO(strings.NewReader(""), io.Discard, io.Discard), nil)
+			})
+		})
 	}
 }
 
-func testEval(input string) object.Object {
+func testEval(input string, streams *object.IO, budget *evaluator.Budget) object.Object {
 	// Create a new lexer and parser for each test case.
 	l := lexer.New(input)
 	p := parser.New(l)
 
-	ast := p.ParseProgram()
+	program := p.ParseProgram()
 	env := object.NewEnvironment()
 
 	// Evaluate the program.
-	return Eval(ast, env)
+	e := evaluator.New()
+	e.SetIO(streams)
+	e.SetBudget(budget)
+	return e.Eval(program, env)
+}
+
+func testRun(input string, streams *object.IO, budget *evaluator.Budget) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+
+	// Compile the program to bytecode.
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	// Run the bytecode, reporting runtime errors as the program's result.
+	machine := vm.New(comp.Bytecode())
+	machine.SetIO(streams)
+	machine.SetBudget(budget)
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	return machine.LastPoppedStackElem()
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -67,36 +141,229 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func TestBigIntegers(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			// Overflow promotes, and results that fit again demote.
+			{"9223372036854775807 + 1", "9223372036854775808"},
+			{"-9223372036854775807 - 2", "-9223372036854775809"},
+			{"4611686018427387904 * 2", "9223372036854775808"},
+			{"-9223372036854775807 - 1", int64(math.MinInt64)},
+			{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+			{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+			{"9223372036854775807 + 1 - 1", int64(math.MaxInt64)},
+			{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000"},
+			{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(30) / f(28)", int64(870)},
+			// Literals of any size.
+			{"123456789012345678901234567890", "123456789012345678901234567890"},
+			{"123456789012345678901234567890 % 1000", int64(890)},
+			{"123456789012345678901234567890 > 1", true},
+			{"123456789012345678901234567890 == 123456789012345678901234567890", true},
+			{"99999999999999999999 - 99999999999999999999", int64(0)},
+			{"99999999999999999999 + 1 == 1e20", true},
+			{"99999999999999999999 / 0", "division by zero"},
+			{`{99999999999999999999: "big"}[99999999999999999998 + 1]`, "big"},
+			{`{100000000000000000000: "big"}[1e20]`, "big"},
+			{"[1, 2][99999999999999999999]", nil},
+			{`int("-123456789012345678901234567890")`, "-123456789012345678901234567890"},
+			{"int(1e20)", "100000000000000000000"},
+			{"float(99999999999999999999)", 1e20},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int64:
+				testIntegerObject(t, evaluated, expected)
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case float64:
+				if f, ok := evaluated.(*object.Float); !ok || f.Value != expected {
+					t.Errorf("%q: wrong float. want=%g, got=%+v", tt.input, expected, evaluated)
+				}
+			case nil:
+				testNullObject(t, evaluated)
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+					}
+					continue
+				}
+				if str, ok := evaluated.(*object.String); ok {
+					testStringObject(t, str, expected)
+					continue
+				}
+				if evaluated.Type() != object.INTEGER_OBJ || evaluated.Inspect() != expected {
+					t.Errorf("%q: wrong integer. want=%s, got=%s (%T)", tt.input, expected, evaluated.Inspect(), evaluated)
+				}
+			}
+		}
+	})
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"1.5", 1.5},
+			{"-2.5", -2.5},
+			{".5 + .25", 0.75},
+			{"1.5 + 1", 2.5},
+			{"1 + 1.5", 2.5},
+			{"3 * 0.5", 1.5},
+			{"7 / 2.0", 3.5},
+			{"7 / 2", 3},
+			{"5.5 % 2", 1.5},
+			{"1e3 - 1", 999.0},
+			{"let xs = [1, 2, 3, 4]; let sum = 0; for (x in xs) { sum += x; }; float(sum) / len(xs)", 2.5},
+			{"1.5 < 2", true},
+			{"2 >= 2.0", true},
+			{"1 == 1.0", true},
+			{"0.1 + 0.2 == 0.3", false},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(400) == p(401) * 1.0", false},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(400) < p(401) * 1.0", true},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(401) * 1.0 > p(400)", true},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(400) < float(\"inf\")", true},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(20) + 1 == 100000000000000000000.0", false},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(20) + 1 > 100000000000000000000.0", true},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; p(20) == 100000000000000000000.0", true},
+			{"9007199254740993 == 9007199254740992.0", false},
+			{"9007199254740993 != 9007199254740992.0", true},
+			{"9007199254740993 >= 9007199254740992.0", true},
+			{"9007199254740992.0 <= 9007199254740992", true},
+			{"float(\"nan\") == 1", false},
+			{"float(\"nan\") != 1", true},
+			{"1 < float(\"nan\")", false},
+			{`{1: "one"}[1.0]`, "one"},
+			{`{1.5: "x"}[1.5]`, "x"},
+			{"1.0 / 0", "division by zero"},
+			{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+			{"int(2.9)", 2},
+			{"int(-2.9)", -2},
+			{`int(" 42 ")`, 42},
+			{`int("4x")`, `cannot convert "4x" to INTEGER`},
+			{`int(float("inf"))`, "`int` of +Inf is not an INTEGER"},
+			{"float(3)", 3.0},
+			{`float("2.5")`, 2.5},
+			{"round(2.5)", 3},
+			{"round(-2.5)", -3},
+			{"round(7)", 7},
+			{"round(3.14159, 2)", 3.14},
+			{"round(1234.5, -2)", 1200.0},
+			{"round(-1250.0, -2)", -1300.0},
+			{"round(1.5, 400)", 1.5},
+			{"round(1.5, -400)", 0.0},
+			{"round(1e300, 10)", 1e300},
+			{"round(0.1, 20)", 0.1},
+			{"round(1e20, -5)", 1e20},
+			{"round(1.7e308, -308)", 1.7e308},
+			{"round(4.9e-324, 400)", 4.9e-324},
+			{"round(1e308, -308)", 1e308},
+			{"round(1.5, -9223372036854775808)", 0.0},
+			{"round(9007199254740993, 0)", 9007199254740993},
+			{"round(99999999999999999999, 2) == 99999999999999999999", true},
+			{"let p = fn(n) { let r = 1; for (let i = 0; i < n; i += 1) { r *= 10 }; r }; round(p(400), 1) == p(400)", true},
+			{"round(1250, -2)", 1300},
+			{"round(-1249, -2)", -1200},
+			{"round(-1250, -2)", -1300},
+			{"round(499, -3)", 0},
+			{"round(500, -3)", 1000},
+			{"round(99999999999999999999, -5) == 100000000000000000000", true},
+			{"round(7, -9223372036854775808)", 0},
+			{"round(1.5, 99999999999999999999)", "second argument to `round` must be between -9223372036854775808 and 9223372036854775807, got 99999999999999999999"},
+			{"floor(-1.5)", -2},
+			{"ceil(1.2)", 2},
+			{"floor(4)", 4},
+			{`ceil("x")`, "argument to `ceil` must be INTEGER or FLOAT, got STRING"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case float64:
+				result, ok := evaluated.(*object.Float)
+				if !ok {
+					t.Errorf("%q: object is not Float. got=%T (%+v)", tt.input, evaluated, evaluated)
+					continue
+				}
+				if result.Value != expected {
+					t.Errorf("%q: object has wrong value. got=%g, want=%g", tt.input, result.Value, expected)
+				}
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+					}
+					continue
+				}
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	})
+}
+
 func TestEvailBooleanExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"true", true},
-		{"false", false},
-		{"5 < 10", true},
-		{"5 > 10", false},
-		{"5 == 5", true},
-		{"5 != 5", false},
-		{"5 == 10", false},
-		{"5 != 10", true},
-		{"true == true", true},
-		{"false == false", true},
-		{"true == false", false},
-		{"true != false", true},
-		{"false != true", true},
-		{"(5 < 10) == true", true},
-		{"(5 < 10) == false", false},
-		{"(5 > 10) == true", false},
-		{"(5 > 10) == false", true},
-	}
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{"true", true},
+			{"false", false},
+			{"5 < 10", true},
+			{"5 > 10", false},
+			{"5 == 5", true},
+			{"5 != 5", false},
+			{"5 == 10", false},
+			{"5 != 10", true},
+			{"true == true", true},
+			{"false == false", true},
+			{"true == false", false},
+			{"true != false", true},
+			{"false != true", true},
+			{"(5 < 10) == true", true},
+			{"(5 < 10) == false", false},
+			{"(5 > 10) == true", false},
+			{"(5 > 10) == false", true},
+			{"5 <= 5", true},
+			{"5 <= 4", false},
+			{"5 >= 5", true},
+			{"4 >= 5", false},
+			{`"a" == "a"`, true},
+			{`"a" != "a"`, false},
+			{`"a" == "b"`, false},
+			{`"a" != "b"`, true},
+			{`"abc" < "abd"`, true},
+			{`"b" > "abc"`, true},
+			{`"ab" <= "ab"`, true},
+			{`"ab" >= "abc"`, false},
+			{"true && true", true},
+			{"true && false", false},
+			{"false || true", true},
+			{"false || false", false},
+			{"1 < 2 && 2 < 3", true},
+			{"1 > 2 || 2 > 3", false},
+		}
 
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
-	}
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func testBooleanObject(t *testing.T, evaluated object.Object, expected bool) {
@@ -114,60 +381,106 @@ func testBooleanObject(t *testing.T, evaluated object.Object, expected bool) {
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			// The operand that decided the result is returned.
+			{"1 && 2", 2},
+			{"0 || 5", 0},
+			{"false || 5", 5},
+			{"let x = if (false) { 1 }; x || 7", 7},
+			{`"" && 3`, 3},
+			// The right side is not evaluated when the left side decides.
+			{"false && undefined", false},
+			{"true || undefined", true},
+			{"let n = 0; let bump = fn() { n += 1; true }; false && bump(); true || bump(); n", 0},
+			{"let n = 0; let bump = fn() { n += 1; true }; true && bump(); false || bump(); n", 2},
+			{"true && undefined", "identifier not found: undefined"},
+			{"let f = fn(x) { x > 0 && x < 10 }; [f(5), f(50)]", "[true, false]"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+					}
+				} else if evaluated.Inspect() != expected {
+					t.Errorf("%q: wrong result. expected=%q, got=%q", tt.input, expected, evaluated.Inspect())
+				}
+			}
+		}
+	})
+}
+
 func TestBangOperator(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"!true", false},
-		{"!false", true},
-		{"!5", false},
-		{"!!true", true},
-		{"!!false", false},
-		{"!!5", true},
-	}
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{"!true", false},
+			{"!false", true},
+			{"!5", false},
+			{"!!true", true},
+			{"!!false", false},
+			{"!!5", true},
+		}
 
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
-	}
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestIfElseExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{"if (true) { 10 }", 10},
-		{"if (false) { 10 }", nil},
-		{"if (1) { 10 }", 10},
-		{"if (1 < 2) { 10 }", 10},
-		{"if (1 > 2) { 10 }", nil},
-		{"if (1 > 2) { 10 } else { 20 }", 20},
-		{"if (1 < 2) { 10 } else { 20 }", 10},
-	}
-
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"if (true) { 10 }", 10},
+			{"if (false) { 10 }", nil},
+			{"if (1) { 10 }", 10},
+			{"if (1 < 2) { 10 }", 10},
+			{"if (1 > 2) { 10 }", nil},
+			{"if (1 > 2) { 10 } else { 20 }", 20},
+			{"if (1 < 2) { 10 } else { 20 }", 10},
+		}
 
-		// Check if the expected value is an integer.
-		integer, ok := tt.expected.(int)
-		if ok {
-			// Compare the value of the integer.
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			// Compare the value of the null.
-			testNullObject(t, evaluated)
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
+
+			// Check if the expected value is an integer.
+			integer, ok := tt.expected.(int)
+			if ok {
+				// Compare the value of the integer.
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				// Compare the value of the null.
+				testNullObject(t, evaluated)
+			}
 		}
-	}
+	})
 }
 
 func testNullObject(t *testing.T, evaluated object.Object) bool {
-	if evaluated != NULL {
+	if evaluated != evaluator.NULL {
 		t.Errorf("object is not NULL. got=%T (%+v)", evaluated, evaluated)
 		return false
 	}
@@ -176,177 +489,246 @@ func testNullObject(t *testing.T, evaluated object.Object) bool {
 }
 
 func TestReturnStatements(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"return 10;", 10},
-		{"return 10; 9;", 10},
-		{"return 2 * 5; 9;", 10},
-		{"9; return 2 * 5; 9;", 10},
-		{`
-		if (10 > 1) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"return 10;", 10},
+			{"return 10; 9;", 10},
+			{"return 2 * 5; 9;", 10},
+			{"9; return 2 * 5; 9;", 10},
+			{`
 			if (10 > 1) {
-				return 10;
-			}
-
-			return 1;
-		}
-		`, 10},
-		{`	let f = fn(x) {
-				return x;
-				x + 10;
-			};
-			f(10);`, 10},
-		{`	let f = fn(x) {
-				let result = x + 10;
-				return result;
-				return 10;
-			};
-			f(10);`, 20},
-		{`	let f = fn(x) {
-				let result = x + 10;
-				return result;
-				return 10;
-			};
-			f(10) + f(10);`, 40},
-		{`	let f = fn(x) {
-				let result = x + 10;
-				return result;
-				return 10;
-			};
-			let x = f(10);
-			x;`, 20},
-	}
+				if (10 > 1) {
+					return 10;
+				}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
-	}
+				return 1;
+			}
+			`, 10},
+			{`	let f = fn(x) {
+					return x;
+					x + 10;
+				};
+				f(10);`, 10},
+			{`	let f = fn(x) {
+					let result = x + 10;
+					return result;
+					return 10;
+				};
+				f(10);`, 20},
+			{`	let f = fn(x) {
+					let result = x + 10;
+					return result;
+					return 10;
+				};
+				f(10) + f(10);`, 40},
+			{`	let f = fn(x) {
+					let result = x + 10;
+					return result;
+					return 10;
+				};
+				let x = f(10);
+				x;`, 20},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestErrorHandling(t *testing.T) {
-	tests := []struct {
-		input          string
-		expectedErrMsg string
-	}{
-		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
-		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
-		{"-true", "unknown operator: -BOOLEAN"},
-		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
-		{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
-		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
-		{`
-		if (10 > 1) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input          string
+			expectedErrMsg string
+		}{
+			{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+			{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+			{"-true", "unknown operator: -BOOLEAN"},
+			{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+			{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
+			{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+			{`
 			if (10 > 1) {
-				return true + false;
-			}
+				if (10 > 1) {
+					return true + false;
+				}
 
-			return 1;
+				return 1;
+			}
+			`, "unknown operator: BOOLEAN + BOOLEAN"},
+			{"foobar", "identifier not found: foobar"},
+			{`"Hello" - "World!"`, "unknown operator: STRING - STRING"},
+			{"1 / 0", "division by zero"},
+			{"5 % 0", "division by zero"},
+			{"true && 1 < true", "type mismatch: INTEGER < BOOLEAN"},
+			{`{"name": "MadHatter"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+			{"fn(x) { x }()", "wrong number of arguments: want=1, got=0"},
+			{"fn() { 1 }(1, 2)", "wrong number of arguments: want=0, got=2"},
+			{"let f = fn() { if (false) { let y = 1; }; y }; f()", "identifier not found: y"},
+			{"let f = fn() { let g = fn() { later }; let r = g(); let later = 1; r }; f()", "identifier not found: later"},
 		}
-		`, "unknown operator: BOOLEAN + BOOLEAN"},
-		{"foobar", "identifier not found: foobar"},
-		{`"Hello" - "World!"`, "unknown operator: STRING - STRING"},
-		{`{"name": "MadHatter"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
-	}
 
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
 
-		// Cast the object to an error.
-		errObj, ok := evaluated.(*object.Error)
+			// Cast the object to an error.
+			errObj, ok := evaluated.(*object.Error)
 
-		if !ok {
-			t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
-			continue
-		}
+			if !ok {
+				t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
 
-		// Compare the error message.
-		if errObj.Message != tt.expectedErrMsg {
-			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedErrMsg, errObj.Message)
+			// Compare the error message.
+			if errObj.Message != tt.expectedErrMsg {
+				t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedErrMsg, errObj.Message)
+			}
 		}
-	}
+	})
 }
 
 func TestLetStatements(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"let a = 5; a;", 5},
-		{"let a = 5 * 5; a;", 25},
-		{"let a = 5; let b = a; b;", 5},
-		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
-	}
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let a = 5; a;", 5},
+			{"let a = 5 * 5; a;", 25},
+			{"let a = 5; let b = a; b;", 5},
+			{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		}
 
-	for _, tt := range tests {
-		// Evaluate the input.
-		testIntegerObject(t, testEval(tt.input), tt.expected)
-	}
+		for _, tt := range tests {
+			// Evaluate the input.
+			testIntegerObject(t, testEval(tt.input), tt.expected)
+		}
+	})
 }
 
 func TestFunctionObject(t *testing.T) {
-	// Create a new function.
-	input := "fn(x) { x + 2; };"
-
-	// Evaluate the input.
-	evaluated := testEval(input)
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		// Create a new function.
+		input := "fn(x) { x + 2; };"
 
-	// Cast the object to a function.
-	fn, ok := evaluated.(*object.Function)
-	if !ok {
-		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
-	}
+		// Evaluate the input.
+		evaluated := testEval(input)
+
+		// Cast the object to a function; the VM produces a closure over compiled code.
+		var parameters []*ast.Identifier
+		var body *ast.BlockStatement
+
+		switch fn := evaluated.(type) {
+		case *object.Function:
+			parameters, body = fn.Parameters, fn.Body
+		case *object.Closure:
+			parameters, body = fn.Fn.Parameters, fn.Fn.Body
+		default:
+			t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+		}
 
-	// Compare the parameters.
-	if len(fn.Parameters) != 1 {
-		t.Fatalf("function has wrong parameters. Parameters=%+v", fn.Parameters)
-	}
+		// Compare the parameters.
+		if len(parameters) != 1 {
+			t.Fatalf("function has wrong parameters. Parameters=%+v", parameters)
+		}
 
-	// Compare the body.
-	expectedBody := "(x + 2)"
-	if fn.Body.String() != expectedBody {
-		t.Fatalf("function has wrong body. Body=%q", fn.Body.String())
-	}
+		// Compare the body.
+		expectedBody := "(x + 2)"
+		if body.String() != expectedBody {
+			t.Fatalf("function has wrong body. Body=%q", body.String())
+		}
+	})
 }
 
 func TestFunctionApplication(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"let identity = fn(x) { x; }; identity(5);", 5},
-		{"let identity = fn(x) { return x; }; identity(5);", 5},
-		{"let double = fn(x) { x * 2; }; double(5);", 10},
-		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
-		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
-		{"fn(x) { x; }(5)", 5},
-	}
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let identity = fn(x) { x; }; identity(5);", 5},
+			{"let identity = fn(x) { return x; }; identity(5);", 5},
+			{"let double = fn(x) { x * 2; }; double(5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+			{"fn(x) { x; }(5)", 5},
+		}
 
-	for _, tt := range tests {
-		// Evaluate the input.
-		testIntegerObject(t, testEval(tt.input), tt.expected)
-	}
+		for _, tt := range tests {
+			// Evaluate the input.
+			testIntegerObject(t, testEval(tt.input), tt.expected)
+		}
+	})
 }
 
 func TestClosures(t *testing.T) {
-	input := `
-	let newAdder = fn(x) {
-		fn(y) { x + y };
-	};
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := `
+		let newAdder = fn(x) {
+			fn(y) { x + y };
+		};
+
+		let addTwo = newAdder(2);
+		addTwo(2);
+		`
+
+		testIntegerObject(t, testEval(input), 4)
+	})
+}
 
-	let addTwo = newAdder(2);
-	addTwo(2);
-	`
+func TestLocalForwardReferences(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := `
+		let f = fn() {
+			let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+			let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+			even(10)
+		};
+		f();
+		`
+		testBooleanObject(t, testEval(input), true)
+
+		input = `
+		let x = 1;
+		let f = fn() { let before = x; let x = 2; before * 10 + x };
+		f();
+		`
+		testIntegerObject(t, testEval(input), 12)
+
+		// A name refers to the enclosing binding until its let has run, however it is reached.
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"let x = 1; let f = fn() { let g = fn() { x }; let r = g(); let x = 2; [r, g()] }; f()", "[1, 2]"},
+			{"let x = 99; let f = fn() { let r = []; for (i in [0, 1]) { r = push(r, x); let x = i } r }; f()", "[99, 0]"},
+			{"let x = 99; let f = fn() { let i = 0; let r = -1; while (i < 2) { if (i == 1) { r = x }; let x = i; i += 1 }; r }; f()", "0"},
+			{"let x = 1; let f = fn() { let g = fn() { fn() { x } }; let h = g(); let r = h(); let x = 3; [r, h()] }; f()", "[1, 3]"},
+			{"let x = 1; let f = fn() { let set = fn() { x = 5 }; set(); let a = x; let x = 2; set(); [a, x] }; [f(), x]", "[[5, 5], 5]"},
+			{"let f = fn() { if (false) { let x = 1 }; let g = fn() { x }; g() }; let x = 7; f()", "7"},
+			{"let f = fn() { let r = len([1]); let len = 3; [r, len] }; f()", "[1, 3]"},
+		}
 
-	testIntegerObject(t, testEval(input), 4)
+		for _, tt := range tests {
+			if got := testEval(tt.input).Inspect(); got != tt.expected {
+				t.Errorf("wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, got)
+			}
+		}
+	})
 }
 
 func TestStringConcatenation(t *testing.T) {
-	input := `"Hello" + " " + "World!";`
-	testStringObject(t, testEval(input), "Hello World!")
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := `"Hello" + " " + "World!";`
+		testStringObject(t, testEval(input), "Hello World!")
+	})
 }
 
 func testStringObject(t *testing.T, evaluated object.Object, expected string) {
@@ -365,199 +747,1027 @@ func testStringObject(t *testing.T, evaluated object.Object, expected string) {
 }
 
 func TestBuiltinFunctions(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`len("")`, 0},
+			{`len("four")`, 4},
+			{`len("hello world")`, 11},
+			{`len(1)`, "argument to `len` not supported, got type INTEGER"},
+			{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+			{`len([1, 2, 3])`, 3},
+			{`len([])`, 0},
+			{`puts("hello", "world!")`, nil},
+			{`first([1, 2, 3])`, 1},
+			{`first([])`, nil},
+			{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+			{`last([1, 2, 3])`, 3},
+			{`last([])`, nil},
+			{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+			{`rest([1, 2, 3])`, []int{2, 3}},
+			{`rest([])`, nil},
+			{`push([], 1)`, []int{1}},
+			{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case nil:
+				testNullObject(t, evaluated)
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("object is not Error. got=%T (%+v)",
+						evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q",
+						expected, errObj.Message)
+				}
+			case []int:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("obj not Array. got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("wrong num of elements. want=%d, got=%d",
+						len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, expectedElem := range expected {
+					testIntegerObject(t, array.Elements[i], int64(expectedElem))
+				}
+			}
+		}
+	})
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string // the result's Inspect, or the error's message
+		}{
+			{`map([1, 2, 3], fn(x) { x * 2 })`, "[2, 4, 6]"},
+			{`len(map([], fn(x) { x }))`, "0"},
+			{`map(["a", "bc"], len)`, "[1, 2]"},
+			{`map([1], fn(x) { x + "a" })`, "type mismatch: INTEGER + STRING"},
+			{`map([1], fn(x, y) { x })`, "wrong number of arguments: want=2, got=1"},
+			{`map(1, fn(x) { x })`, "argument to `map` must be ARRAY, got INTEGER"},
+			{`map([1], 1)`, "second argument to `map` must be FUNCTION, got INTEGER"},
+			{`map([1])`, "wrong number of arguments. got=1, want=2"},
+			{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, "[2, 4]"},
+			{`len(filter([1, 2], fn(x) { if (false) { 1 } }))`, "0"},
+			{`reduce([1, 2, 3], fn(sum, x) { sum + x })`, "6"},
+			{`reduce([1, 2, 3], fn(acc, x) { push(acc, x * x) }, [])`, "[1, 4, 9]"},
+			{`reduce([], fn(sum, x) { sum + x }, 0)`, "0"},
+			{`reduce([], fn(sum, x) { sum + x })`, "`reduce` of an empty ARRAY with no initial value"},
+			{`let seen = []; each([1, 2], fn(x) { seen = push(seen, x) }); seen`, "[1, 2]"},
+			{`each([1, 2], fn(x) { x })`, "null"},
+			{`sort([3, 1.5, 2])`, "[1.5, 2, 3]"},
+			{`sort(["b", "c", "a"])`, "[a, b, c]"},
+			{`sort([3, 1, 2], fn(a, b) { b - a })`, "[3, 2, 1]"},
+			{`sort([1, "a"])`, "cannot compare STRING with INTEGER"},
+			{`sort([1, 2], fn(a, b) { true })`, "comparator given to `sort` must return INTEGER, got BOOLEAN"},
+			{`sort([1, 2, 3], fn(a, b) { (b - a) * 99999999999999999999 })`, "[3, 2, 1]"},
+			{`sort_by(["ccc", "a", "bb", "b"], len)`, "[a, b, bb, ccc]"},
+			{`sort_by([[2, "x"], [1, "y"]], first)`, "[[1, y], [2, x]]"},
+			{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+			{`zip([1, 2])`, "[[1], [2]]"},
+			{`zip([1], 2)`, "arguments to `zip` must be ARRAY, got INTEGER"},
+			{`range(4)`, "[0, 1, 2, 3]"},
+			{`range(2, 5)`, "[2, 3, 4]"},
+			{`range(5, 0, -2)`, "[5, 3, 1]"},
+			{`range(9223372036854775805, 9223372036854775807)`, "[9223372036854775805, 9223372036854775806]"},
+			{`range(-9223372036854775807, -9223372036854775808, -1)`, "[-9223372036854775807]"},
+			{`len(range(3, 1))`, "0"},
+			{`range(0, 1, 0)`, "step of `range` must not be 0"},
+			{`range("a")`, "arguments to `range` must be INTEGER, got STRING"},
+			{`range(99999999999999999999)`, "arguments to `range` must be between -9223372036854775808 and 9223372036854775807, got 99999999999999999999"},
+			{`range()`, "wrong number of arguments. got=0, want=1, 2 or 3"},
+			{`any([1, 2, 3], fn(x) { x > 2 })`, "true"},
+			{`any([], fn(x) { true })`, "false"},
+			{`all([1, 2, 3], fn(x) { x > 0 })`, "true"},
+			{`all([1, 2, 3], fn(x) { x < 2 })`, "false"},
+			{`let calls = 0; any([1, 2, 3], fn(x) { calls += 1; x == 1 }); calls`, "1"},
+			{`find([1, 2, 3, 4], fn(x) { x > 2 })`, "3"},
+			{`find([1], fn(x) { false })`, "null"},
+			{`group_by([1, 2, 3, 4, 5], fn(x) { x % 2 })[1]`, "[1, 3, 5]"},
+			{`group_by([1], fn(x) { [x] })`, "unusable as hash key: ARRAY"},
+			{`flatten([1, [2, [3, [4]]], []])`, "[1, 2, 3, 4]"},
+			{`flatten([1, [2, [3, [4]]]], 1)`, "[1, 2, [3, [4]]]"},
+			{`let a = [1, [2]]; a[1][0] = a; flatten(a)`, "cannot flatten an ARRAY that contains itself"},
+			{`let a = [1, [2]]; a[1][0] = a; len(flatten(a, 2))`, "3"},
+			{`flatten([[1]], 99999999999999999999)`, "second argument to `flatten` must be between -9223372036854775808 and 9223372036854775807, got 99999999999999999999"},
+			{`uniq([1, 2, 1, "a", "a", true, true])`, "[1, 2, a, true]"},
+			{`let a = [1]; uniq([a, a, [1]])`, "[[1], [1]]"},
+			{`reverse([1, 2, 3])`, "[3, 2, 1]"},
+			{`reverse("héllo")`, "olléh"},
+			{`reverse(1)`, "argument to `reverse` must be ARRAY or STRING, got INTEGER"},
+			{`slice([1, 2, 3, 4], 1, 3)`, "[2, 3]"},
+			{`slice([1, 2, 3, 4], -2)`, "[3, 4]"},
+			{`len(slice([1, 2, 3], 2, 1))`, "0"},
+			{`slice([1, 2, 3], -10, 10)`, "[1, 2, 3]"},
+			{`slice("hello", 1, -1)`, "ell"},
+			{`slice([1], "a")`, "indexes given to `slice` must be INTEGER, got STRING"},
+			{`slice([1, 2, 3], 99999999999999999999)`, "indexes given to `slice` must be between -9223372036854775808 and 9223372036854775807, got 99999999999999999999"},
+			{`concat([1], [], [2, 3])`, "[1, 2, 3]"},
+			{`len(concat())`, "0"},
+			{`concat([1], 2)`, "arguments to `concat` must be ARRAY, got INTEGER"},
+			{`map(filter(range(10), fn(x) { x % 3 == 0 }), fn(x) { map([x], fn(y) { y + 1 }) })`, "[[1], [4], [7], [10]]"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			got := evaluated.Inspect()
+			if errObj, ok := evaluated.(*object.Error); ok {
+				got = errObj.Message
+			}
+			if got != tt.expected {
+				t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+			}
+		}
+	})
+}
+
+// TestFlattenDeepArray flattens an array nested far more deeply than Go's stack, held
+// small here, would allow flatten to recurse.
+func TestFlattenDeepArray(t *testing.T) {
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		evaluated := testEval("let a = [1]; let i = 0; while (i < 200000) { a = [a]; i += 1 }; flatten(a)")
+		if evaluated.Inspect() != "[1]" {
+			t.Errorf("wrong result. want=[1], got=%s", evaluated.Inspect())
+		}
+	})
+}
+
+func TestHashBuiltins(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string // the result's Inspect, or the error's message
+		}{
+			{`{"b": 1, "a": 2, 3: 4}`, "{b: 1, a: 2, 3: 4}"},
+			{`let h = {"b": 1}; h["a"] = 2; h["b"] = 3; h`, "{b: 3, a: 2}"},
+			{`len({"a": 1, "b": 2})`, "2"},
+			{`keys({"b": 1, "a": 2})`, "[b, a]"},
+			{`values({"b": 1, "a": 2})`, "[1, 2]"},
+			{`entries({"b": 1, "a": 2})`, "[[b, 1], [a, 2]]"},
+			{`len(keys({}))`, "0"},
+			{`keys([1])`, "argument to `keys` must be HASH, got ARRAY"},
+			{`has({"a": 1}, "a")`, "true"},
+			{`has({"a": 1}, "b")`, "false"},
+			{`has({1: 1}, 1.0)`, "true"},
+			{`has({"a": 1}, [1])`, "unusable as hash key: ARRAY"},
+			{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+			{`delete({"a": 1}, "z")`, "{a: 1}"},
+			{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+			{`merge({"a": 1, "b": 2}, {"c": 3, "a": 4})`, "{a: 4, b: 2, c: 3}"},
+			{`let h = {"a": 1}; merge(h, {"a": 2}); h`, "{a: 1}"},
+			{`merge({"a": 1}, 1)`, "arguments to `merge` must be HASH, got INTEGER"},
+			{`merge()`, "wrong number of arguments. got=0, want at least 1"},
+			{`get({"a": 1}, "a", 0)`, "1"},
+			{`get({"a": 1}, "b", 0)`, "0"},
+			{`get({"a": 1}, "b")`, "null"},
+			{`get(1, "a")`, "argument to `get` must be HASH, got INTEGER"},
+			{`get({})`, "wrong number of arguments. got=1, want=2 or 3"},
+			{`group_by(["bb", "a", "cc"], len)`, "{2: [bb, cc], 1: [a]}"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			got := evaluated.Inspect()
+			if errObj, ok := evaluated.(*object.Error); ok {
+				got = errObj.Message
+			}
+			if got != tt.expected {
+				t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+			}
+		}
+	})
+}
+
+func TestCallbacks(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			// A try in the callback catches its errors.
+			{`map([1, 2], fn(x) { try { throw x * 10 } catch (e) { e["value"] } })`, "[10, 20]"},
+			// A try around the builtin catches them too.
+			{`try { map([1, 2], fn(x) { throw x }) } catch (e) { e["value"] }`, "1"},
+			{`let f = fn() { each([1], fn(x) { throw x }); 2 }; try { f() } catch (e) { e["value"] }`, "1"},
+			// A return leaves the callback, not the function that called the builtin.
+			{`let f = fn() { let xs = map([1, 2], fn(x) { return x + 1; 0 }); len(xs) }; f()`, "2"},
+			// Recursion through a builtin.
+			{`let sum = fn(n) { if (n == 0) { 0 } else { n + reduce(map([n - 1], sum), fn(a, b) { a + b }) } }; sum(4)`, "10"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			got := evaluated.Inspect()
+			if errObj, ok := evaluated.(*object.Error); ok {
+				got = "error: " + errObj.Message
+			}
+			if got != tt.expected {
+				t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+			}
+		}
+	})
+}
+
+func TestBuiltinArity(t *testing.T) {
+	for _, name := range evaluator.BuiltinNames() {
+		if _, _, ok := evaluator.BuiltinArity(name); !ok {
+			t.Errorf("builtin %s has no parameters listed", name)
+		}
+	}
+
 	tests := []struct {
-		input    string
-		expected interface{}
+		name     string
+		min, max int
 	}{
-		{`len("")`, 0},
-		{`len("four")`, 4},
-		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got type INTEGER"},
-		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
-		{`len([1, 2, 3])`, 3},
-		{`len([])`, 0},
-		{`puts("hello", "world!")`, nil},
-		{`first([1, 2, 3])`, 1},
-		{`first([])`, nil},
-		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
-		{`last([1, 2, 3])`, 3},
-		{`last([])`, nil},
-		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
-		{`rest([1, 2, 3])`, []int{2, 3}},
-		{`rest([])`, nil},
-		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{"len", 1, 1},
+		{"push", 2, 2},
+		{"round", 1, 2},
+		{"readline", 0, 0},
+		{"puts", 0, -1},
+		{"printf", 1, -1},
+		{"range", 1, 3},
+		{"zip", 1, -1},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-
-		switch expected := tt.expected.(type) {
-		case int:
-			testIntegerObject(t, evaluated, int64(expected))
-		case nil:
-			testNullObject(t, evaluated)
-		case string:
+		min, max, ok := evaluator.BuiltinArity(tt.name)
+		if !ok || min != tt.min || max != tt.max {
+			t.Errorf("BuiltinArity(%q) wrong. want=%d, %d, got=%d, %d (ok=%t)", tt.name, tt.min, tt.max, min, max, ok)
+		}
+	}
+
+	if _, _, ok := evaluator.BuiltinArity("nope"); ok {
+		t.Errorf("BuiltinArity found a builtin called nope")
+	}
+
+	if got, ok := evaluator.BuiltinSignature("round"); !ok || got != "round(number, places?)" {
+		t.Errorf("BuiltinSignature(\"round\") wrong. got=%q (ok=%t)", got, ok)
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := "[1, 2 * 2, 3 + 3]"
+		evaluated := testEval(input)
+
+		// Cast the object to an array.
+		result, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		// Compare the length of the array.
+		if len(result.Elements) != 3 {
+			t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+		}
+
+		// Compare the elements of the array.
+		testIntegerObject(t, result.Elements[0], 1)
+		testIntegerObject(t, result.Elements[1], 4)
+		testIntegerObject(t, result.Elements[2], 6)
+	})
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"[1, 2, 3][0]", 1},
+			{"[1, 2, 3][1]", 2},
+			{"[1, 2, 3][2]", 3},
+			{"let i = 0; [1][i];", 1},
+			{"[1, 2, 3][1 + 1];", 3},
+			{"let myArray = [1, 2, 3]; myArray[2];", 3},
+			{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+			{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i];", 2},
+			{"[1, 2, 3][3]", nil},
+			{"[1, 2, 3][-1]", nil},
+		}
+
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
+
+			// Check if the expected value is an integer.
+			integer, ok := tt.expected.(int)
+			if ok {
+				// Compare the value of the integer.
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				// Compare the value of the null.
+				testNullObject(t, evaluated)
+			}
+		}
+	})
+}
+
+func TestHashLiterals(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := `
+		let two = "two";
+		{
+			"one": 10 - 9,
+			two: 1 + 1,
+			"thr" + "ee": 6 / 2,
+			4: 4,
+			true: 5,
+			false: 6
+		}
+		`
+		evaluated := testEval(input)
+
+		// Cast the object to a hash.
+		result, ok := evaluated.(*object.Hash)
+		if !ok {
+			t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		// Compare the length of the hash.
+		if len(result.Pairs) != 6 {
+			t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+		}
+
+		// Define the expected values.
+		expected := map[object.HashKey]int64{
+			(&object.String{Value: "one"}).HashKey():   1,
+			(&object.String{Value: "two"}).HashKey():   2,
+			(&object.String{Value: "three"}).HashKey(): 3,
+			(&object.Integer{Value: 4}).HashKey():      4,
+			evaluator.TRUE.HashKey():                   5,
+			evaluator.FALSE.HashKey():                  6,
+		}
+
+		// Iterate over each pair.
+		for expectedKey, expectedValue := range expected {
+			// Check if the pair exists.
+			pair, ok := result.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			// Compare the value of the integer.
+			testIntegerObject(t, pair.Value, expectedValue)
+		}
+	})
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`{"foo": 5}["foo"]`, 5},
+			{`{"foo": 5}["bar"]`, nil},
+			{`let key = "foo"; {"foo": 5}[key]`, 5},
+			{`{}["foo"]`, nil},
+			{`{5: 5}[5]`, 5},
+			{`{true: 5}[true]`, 5},
+			{`{false: 5}[false]`, 5},
+		}
+
+		// Iterate over each test case.
+		for _, tt := range tests {
+			// Evaluate the input.
+			evaluated := testEval(tt.input)
+
+			// Check if the expected value is an integer.
+			integer, ok := tt.expected.(int)
+			if ok {
+				// Compare the value of the integer.
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				// Compare the value of the null.
+				testNullObject(t, evaluated)
+			}
+		}
+	})
+}
+
+func TestErrorPositions(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"5 + true;", "ERROR: 1:1: type mismatch: INTEGER + BOOLEAN"},
+			{"let a = 1;\nlet b = a + foo;", "ERROR: 2:13: identifier not found: foo"},
+			{"let f = fn(x) {\n  x - \"s\"\n};\nf(1)", "ERROR: 2:3: type mismatch: INTEGER - STRING"},
+			{"let xs = [1];\nlen(xs, xs)", "ERROR: 2:1: wrong number of arguments. got=2, want=1"},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
 			errObj, ok := evaluated.(*object.Error)
 			if !ok {
-				t.Errorf("object is not Error. got=%T (%+v)",
-					evaluated, evaluated)
+				t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
 				continue
 			}
-			if errObj.Message != expected {
-				t.Errorf("wrong error message. expected=%q, got=%q",
-					expected, errObj.Message)
+
+			if errObj.Inspect() != tt.expected {
+				t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Inspect())
 			}
-		case []int:
-			array, ok := evaluated.(*object.Array)
+		}
+	})
+}
+
+func TestStackTraces(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected []string
+		}{
+			{"1 + true", []string{"at <main> (1:1)"}},
+			{
+				"let add = fn(a, b) {\n  a + b\n};\nlet compute = fn(x) {\n  add(x, \"s\")\n};\ncompute(1);",
+				[]string{"at add (2:3)", "at compute (5:3)", "at <main> (7:1)"},
+			},
+			{
+				"let f = fn() { len(1) };\nfn() { f() }()",
+				[]string{"at f (1:16)", "at <anonymous> (2:8)", "at <main> (2:1)"},
+			},
+			{
+				"let f = fn(n) { f(n + 1) };\nf(0)",
+				nil, // checked below: a runaway recursion overflows instead of crashing
+			},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
 			if !ok {
-				t.Errorf("obj not Array. got=%T (%+v)", evaluated, evaluated)
+				t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
 				continue
 			}
 
-			if len(array.Elements) != len(expected) {
-				t.Errorf("wrong num of elements. want=%d, got=%d",
-					len(expected), len(array.Elements))
+			if tt.expected == nil {
+				if errObj.Message != "stack overflow" {
+					t.Errorf("wrong error message. expected=%q, got=%q", "stack overflow", errObj.Message)
+				}
 				continue
 			}
 
-			for i, expectedElem := range expected {
-				testIntegerObject(t, array.Elements[i], int64(expectedElem))
+			var frames []string
+			for _, frame := range errObj.Stack {
+				frames = append(frames, frame.String())
+			}
+
+			if strings.Join(frames, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("wrong stack trace for %q. expected=%q, got=%q", tt.input, tt.expected, frames)
 			}
 		}
-	}
+	})
 }
 
-func TestArrayLiterals(t *testing.T) {
-	input := "[1, 2 * 2, 3 + 3]"
-	evaluated := testEval(input)
+func TestLoops(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+			{"let i = 0; while (false) { let i = i + 1; }; i", 0},
+			{"let i = 0; while (true) { let i = i + 1; if (i == 3) { break; } }; i", 3},
+			{"let n = 0; for (let i = 0; i < 4; let i = i + 1) { let n = n + i; }; n", 6},
+			{"let n = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 2) { continue; } if (i == 4) { break; } let n = n + i; }; n", 4},
+			{"let n = 0; for (;;) { let n = n + 1; if (n > 2) { break; } }; n", 3},
+			{"let n = 0; for (x in [1, 2, 3]) { let n = n + x; }; n", 6},
+			{"let n = 0; for (i, x in [10, 20, 30]) { let n = n + i * x; }; n", 80},
+			{"let s = \"\"; for (c in \"abc\") { let s = c + s; }; s", "cba"},
+			{"let s = \"\"; for (i, c in \"héllo\") { if (i == 1) { let s = s + c; } }; s", "é"},
+			{"let s = \"\"; for (k in {\"b\": 2, \"a\": 1, \"c\": 3}) { let s = s + k; }; s", "bac"},
+			{"let h = {\"b\": 1}; h[\"a\"] = 2; h[\"b\"] = 3; let s = \"\"; for (k in h) { let s = s + k; }; s", "ba"},
+			{"let n = 0; for (k, v in {\"a\": 1, \"b\": 2}) { let n = n + v; }; n", 3},
+			{"let n = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } let n = n + x; }; n", 8},
+			{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } let n = n + 1; } }; n", 2},
+			{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10; } } return 0; }; f()", 20},
+			{"let f = fn() { let i = 0; while (true) { let i = i + 1; if (i == 7) { return i; } } }; f()", 7},
+			{"let f = fn(xs) { let n = 0; for (x in xs) { let n = n + x; } n }; f([4, 5])", 9},
+			{"for (x in [1]) { x }", nil},
+			{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		}
 
-	// Cast the object to an array.
-	result, ok := evaluated.(*object.Array)
-	if !ok {
-		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+					}
+					continue
+				}
+				testStringObject(t, evaluated, expected)
+			case nil:
+				if evaluated != nil && evaluated != evaluator.NULL {
+					t.Errorf("loop produced a value. got=%T (%+v)", evaluated, evaluated)
+				}
+			}
+		}
+	})
+}
+
+func TestTryCatch(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"try { 1 } catch (e) { 2 }", 1},
+			{"try { 1 + true } catch (e) { e[\"message\"] }", "type mismatch: INTEGER + BOOLEAN"},
+			{"try { 1 + true } catch (e) { e[\"kind\"] }", "RuntimeError"},
+			{"try { throw \"boom\" } catch (e) { e[\"message\"] }", "boom"},
+			{"try { throw \"boom\" } catch (e) { e[\"kind\"] }", "Error"},
+			{"try { throw {\"message\": \"bad input\", \"kind\": \"ParseError\"} } catch (e) { e[\"kind\"] + \": \" + e[\"message\"] }", "ParseError: bad input"},
+			{"try { throw 42 } catch (e) { e[\"value\"] }", 42},
+			{"let f = fn() { len(1) }; try { f() } catch (e) { e[\"stack\"][0] }", "at f (1:16)"},
+			{"let f = fn(n) { if (n == 0) { throw \"deep\" } f(n - 1) }; try { f(3) } catch (e) { len(e[\"stack\"]) }", 5},
+			{"let safe = fn(x) { try { int(x) } catch (e) { -1 } }; safe(\"12\") + safe(\"x\")", 11},
+			{"1 + try { throw 1 } catch (e) { 2 }", 3},
+			{"let f = fn() { throw \"x\" }; let g = fn() { try { f() } catch (e) { 1 } }; g() + g()", 2},
+			{"try { throw \"a\" } catch (e) { throw \"b\" }", "b"},
+			{"try { try { throw \"a\" } catch (e) { throw e } } catch (e) { e[\"message\"] }", "a"},
+			{"throw \"uncaught\"", "uncaught"},
+			{"let f = fn() { try { return 1 } catch (e) { 2 } }; f(); 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+
+			// finally runs however the try ends.
+			{"let x = 0; try { x = 1 } finally { x += 10 }; x", 11},
+			{"let x = 0; try { try { throw \"a\" } finally { x = 5 } } catch (e) { x + len(e[\"message\"]) }", 6},
+			{"let x = 0; try { throw \"a\" } catch (e) { x = 1 } finally { x += 10 }; x", 11},
+			{"let x = 0; try { try { throw \"a\" } catch (e) { throw \"b\" } finally { x = 1 } } catch (e) { if (x == 1) { e[\"message\"] } }", "b"},
+			{"let x = 0; let f = fn() { try { return 1 } finally { x = 2 } }; f() + x", 3},
+			{"let f = fn() { try { throw \"x\" } finally { return 7 } }; f()", 7},
+			{"try { 1 } finally { 2 }", 1},
+			{"try { 1 + true } finally { 2 }", "type mismatch: INTEGER + BOOLEAN"},
+			{"let n = 0; for (let i = 0; i < 5; i += 1) { try { if (i == 2) { break } } finally { n += 1 } }; n", 3},
+			{"let n = 0; for (x in [1, 2, 3]) { try { continue; n += 100 } finally { n += 1 } }; n", 3},
+			{"let n = 0; while (true) { try { try { break } finally { n += 1 } } finally { n += 10 } }; n", 11},
+		}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+					}
+					continue
+				}
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	})
+}
+
+func TestRethrownErrorKeepsItsOrigin(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		input := "let f = fn() {\n  1 + true\n};\ntry { f() } finally { 0 }"
+
+		errObj, ok := testEval(input).(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned")
+		}
+
+		expected := "ERROR: 2:3: type mismatch: INTEGER + BOOLEAN"
+		if errObj.Inspect() != expected {
+			t.Errorf("wrong error. expected=%q, got=%q", expected, errObj.Inspect())
+		}
+		if len(errObj.Stack) != 2 || errObj.Stack[0].Function != "f" {
+			t.Errorf("wrong stack. got=%v", errObj.Stack)
+		}
+	})
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib/math.ys":    "from \"helpers.ys\" import double\nlet square = fn(x) { x * x };\nlet quad = fn(x) { double(double(x)) };",
+		"lib/helpers.ys": "let double = fn(x) { x * 2 };",
+		"lib/counter.ys": "let count = 0;\nlet inc = fn() { count += 1 };",
+		"broken.ys":      "let x = 1;\nx + true;",
+		"a.ys":           "import \"b.ys\"",
+		"b.ys":           "import \"a.ys\"",
 	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"import \"DIR/lib/math.ys\" as m; m.square(3)", 9},
+			{"import \"DIR/lib/math.ys\"; math.quad(1)", 4},
+			{"from \"DIR/lib/math.ys\" import square, quad; square(2) + quad(2)", 12},
+			{"import \"DIR/lib/math.ys\" as a; import \"DIR/lib/math.ys\" as b; a == b", true},
+			{"let f = fn() { import \"DIR/lib/helpers.ys\"; helpers.double(4) }; f()", 8},
+			{"import \"DIR/lib/counter.ys\"; counter.inc(); counter.inc(); counter.count", 2},
+			{"from \"DIR/lib/counter.ys\" import inc, count; inc(); count", 0},
+			{"let h = {\"a\": 1}; h.a", 1},
+			{"let h = {\"a\": {\"b\": 2}}; h.a.b", 2},
+			{"let h = {}; h.a", nil},
+			{"import \"DIR/lib/math.ys\" as m; m.cube", "module math has no member cube"},
+			{"from \"DIR/lib/math.ys\" import cube", "module math has no member cube"},
+			{"let x = 5; x.y", "INTEGER has no member y"},
+			{"import \"DIR/missing.ys\"", "cannot find module \"DIR/missing.ys\""},
+			{"import \"DIR/a.ys\"", "import cycle: a.ys -> b.ys -> a.ys"},
+			{"import \"DIR/broken.ys\"", "type mismatch: INTEGER + BOOLEAN"},
+		}
+
+		for _, tt := range tests {
+			input := strings.ReplaceAll(tt.input, "DIR", filepath.ToSlash(dir))
+			evaluated := testEval(input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case nil:
+				testNullObject(t, evaluated)
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("no error object returned for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != strings.ReplaceAll(expected, "DIR", filepath.ToSlash(dir)) {
+					t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+			}
+		}
+
+		// An error in a module is reported where it happened, below the import.
+		errObj, ok := testEval(strings.ReplaceAll("import \"DIR/broken.ys\"", "DIR", filepath.ToSlash(dir))).(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned")
+		}
 
-	// Compare the length of the array.
-	if len(result.Elements) != 3 {
-		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+		var frames []string
+		for _, frame := range errObj.Stack {
+			frames = append(frames, frame.String())
+		}
+
+		expected := []string{"at <module broken> (" + filepath.Join(dir, "broken.ys") + ":2:1)", "at <main> (1:1)"}
+		if strings.Join(frames, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("wrong stack trace. expected=%q, got=%q", expected, frames)
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	for _, engine := range engines {
+		engine := engine
+		t.Run(engine.name, func(t *testing.T) {
+			tests := []struct {
+				input    string
+				limits   evaluator.Limits
+				expected string
+			}{
+				{"while (true) {}", evaluator.Limits{MaxSteps: 1000}, "step limit of 1000 exceeded"},
+				{"let f = fn(n) { f(n + 1) }; f(0)", evaluator.Limits{MaxCallDepth: 50}, "call depth limit of 50 exceeded"},
+				{"let xs = []; while (true) { xs = push(xs, 1) }", evaluator.Limits{MaxElements: 10000}, "allocation limit of 10000 elements exceeded"},
+				{"[[1, 2], {1: 2, 3: 4}, [5]]", evaluator.Limits{MaxElements: 7}, "allocation limit of 7 elements exceeded"},
+				{"len(range(50000000))", evaluator.Limits{MaxElements: 1000}, "allocation limit of 1000 elements exceeded"},
+				{"let h = {}; let n = 0; while (n < 200000) { h[n] = n; n += 1 }; len(h)", evaluator.Limits{MaxElements: 1000}, "allocation limit of 1000 elements exceeded"},
+				{`let s = "ab"; while (true) { s += s }`, evaluator.Limits{MaxStringBytes: 1 << 20}, "allocation limit of 1048576 bytes exceeded"},
+				{"let x = 3; let i = 0; while (i < 27) { x = x * x; i += 1 }; 1", evaluator.Limits{MaxElements: 1000, MaxStringBytes: 1000, MaxSteps: 10000}, "allocation limit of 1000 bytes exceeded"},
+				{"99999999999999999999 * 99999999999999999999", evaluator.Limits{MaxStringBytes: 10}, "allocation limit of 10 bytes exceeded"},
+				{"while (true) { try { 1 } catch (e) { 2 } }", evaluator.Limits{MaxSteps: 1000}, "step limit of 1000 exceeded"},
+				{"let f = fn() { f() }; while (true) { try { f() } catch (e) { 1 } finally { 2 } }", evaluator.Limits{MaxCallDepth: 10, MaxSteps: 100000}, "call depth limit of 10 exceeded"},
+			}
+
+			for _, tt := range tests {
+				result := engine.eval(tt.input, object.StandardIO(), evaluator.NewBudget(context.Background(), tt.limits))
+
+				errObj, ok := result.(*object.Error)
+				if !ok {
+					t.Errorf("%q: no error object returned. got=%T (%+v)", tt.input, result, result)
+					continue
+				}
+				if errObj.Message != tt.expected || errObj.Kind != object.LimitErrorKind {
+					t.Errorf("%q: wrong error. want=%q (%s), got=%q (%s)", tt.input, tt.expected, object.LimitErrorKind, errObj.Message, errObj.Kind)
+				}
+			}
+
+			// Programs within their limits are not affected by them.
+			limits := evaluator.Limits{MaxSteps: 10000, MaxCallDepth: 20, MaxElements: 100, MaxStringBytes: 100}
+			result := engine.eval(`let f = fn(n) { if (n == 0) { [] } else { push(f(n - 1), "a" + "b") } }; len(f(10))`, object.StandardIO(), evaluator.NewBudget(context.Background(), limits))
+			testIntegerObject(t, result, 10)
+		})
 	}
+}
 
-	// Compare the elements of the array.
-	testIntegerObject(t, result.Elements[0], 1)
-	testIntegerObject(t, result.Elements[1], 4)
-	testIntegerObject(t, result.Elements[2], 6)
+func TestContextCancellation(t *testing.T) {
+	for _, engine := range engines {
+		engine := engine
+		t.Run(engine.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			result := engine.eval("let f = fn() { while (true) { try { 1 } finally { 2 } } }; f()", object.StandardIO(), evaluator.NewBudget(ctx, evaluator.Limits{}))
+
+			errObj, ok := result.(*object.Error)
+			if !ok {
+				t.Fatalf("no error object returned. got=%T (%+v)", result, result)
+			}
+			if errObj.Message != "evaluation stopped: context deadline exceeded" || errObj.Kind != object.LimitErrorKind {
+				t.Errorf("wrong error. got=%q (%s)", errObj.Message, errObj.Kind)
+			}
+
+			ctx, cancel = context.WithCancel(context.Background())
+			cancel()
+
+			// Builtins that do a lot of work in one call check the context too.
+			for _, input := range []string{
+				"let i = 0; while (i < 5000) { i += 1 }",
+				"let a = [1]; let i = 0; while (i < 50) { a = [a]; i += 1 }; flatten(a)",
+				"uniq([fn() {}, fn() {}])",
+			} {
+				result = engine.eval(input, object.StandardIO(), evaluator.NewBudget(ctx, evaluator.Limits{}))
+				if errObj, ok := result.(*object.Error); !ok || errObj.Message != "evaluation stopped: context canceled" {
+					t.Errorf("wrong result for %q with a canceled context. got=%+v", input, result)
+				}
+			}
+		})
+	}
 }
 
-func TestArrayIndexExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{"[1, 2, 3][0]", 1},
-		{"[1, 2, 3][1]", 2},
-		{"[1, 2, 3][2]", 3},
-		{"let i = 0; [1][i];", 1},
-		{"[1, 2, 3][1 + 1];", 3},
-		{"let myArray = [1, 2, 3]; myArray[2];", 3},
-		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
-		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i];", 2},
-		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+func TestIOBuiltins(t *testing.T) {
+	for _, engine := range engines {
+		engine := engine
+		t.Run(engine.name, func(t *testing.T) {
+			tests := []struct {
+				input      string
+				stdin      string
+				expected   interface{}
+				wantStdout string
+				wantStderr string
+			}{
+				{`puts("a", 1, [2])`, "", nil, "a\n1\n[2]\n", ""},
+				{`print("a", 1); print(true)`, "", nil, "a1true", ""},
+				{`printf("%s has %d items costing %.2f (%v)", "cart", 3, 9.5, [1])`, "", nil, "cart has 3 items costing 9.50 ([1])", ""},
+				{`printf("%d", 99999999999999999999)`, "", nil, "99999999999999999999", ""},
+				{`warn("careful")`, "", nil, "", "careful\n"},
+				{`readline()`, "first\r\nsecond\n", "first", "", ""},
+				{`readline(); readline()`, "first\nsecond", "second", "", ""},
+				{`readline()`, "", nil, "", ""},
+				{`let name = input("name? "); "hi " + name`, "ys\n", "hi ys", "name? ", ""},
+				{`printf(1)`, "", "first argument to `printf` must be STRING, got INTEGER", "", ""},
+				{`readline(1)`, "", "wrong number of arguments. got=1, want=0", "", ""},
+			}
+
+			for _, tt := range tests {
+				var stdout, stderr bytes.Buffer
+				evaluated := engine.eval(tt.input, object.NewIO(strings.NewReader(tt.stdin), &stdout, &stderr), nil)
+
+				switch expected := tt.expected.(type) {
+				case nil:
+					testNullObject(t, evaluated)
+				case string:
+					if errObj, ok := evaluated.(*object.Error); ok {
+						if errObj.Message != expected {
+							t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+						}
+						continue
+					}
+					testStringObject(t, evaluated, expected)
+				}
+
+				if stdout.String() != tt.wantStdout {
+					t.Errorf("%q: wrong output. want=%q, got=%q", tt.input, tt.wantStdout, stdout.String())
+				}
+				if stderr.String() != tt.wantStderr {
+					t.Errorf("%q: wrong error output. want=%q, got=%q", tt.input, tt.wantStderr, stderr.String())
+				}
+			}
+		})
 	}
+}
 
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
+func TestLongLoopDoesNotGrowTheStack(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		evaluated := testEval("let i = 0; while (i < 100000) { let i = i + 1; }; i")
+		testIntegerObject(t, evaluated, 100000)
+	})
+}
 
-		// Check if the expected value is an integer.
-		integer, ok := tt.expected.(int)
-		if ok {
-			// Compare the value of the integer.
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			// Compare the value of the null.
-			testNullObject(t, evaluated)
+func TestAssignExpressions(t *testing.T) {
+	runEngines(t, func(t *testing.T, testEval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"let x = 1; x = 5; x", 5},
+			{"let x = 1; x = 5", 5},
+			{"let x = 1; let y = 2; x = y = 7; x + y", 14},
+			{"let x = 10; x += 5; x", 15},
+			{"let x = 10; x -= 5; x", 5},
+			{"let x = 10; x *= 5; x", 50},
+			{"let x = 10; x /= 5; x", 2},
+			{"let s = \"a\"; s += \"b\"; s", "ab"},
+			{"let x = 1; let f = fn() { x = 2; }; f(); x", 2},
+			{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+			{"let counter = fn() { let n = 0; fn() { n += 1 } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+			{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f() + f()", 4},
+			{"let f = fn(n) { let g = fn() { n *= 2 }; g(); g(); n }; f(3)", 12},
+			{"let f = fn() { let n = 1; fn() { fn() { n += 10 } } }; let g = f()(); g(); g()", 21},
+			{"let f = fn() { f = 1; 2 }; [f(), f]", "[2, 1]"},
+			{"let f = fn() { let g = fn(n) { if (n == 0) { g = 5; 0 } else { g(n - 1) } }; [g(2), g] }; f()", "[0, 5]"},
+			{"let sum = 0; for (x in [1, 2, 3]) { sum += x; }; sum", 6},
+			{"let i = 0; while (i < 10) { i += 1; }; i", 10},
+			{"let xs = [1, 2, 3]; xs[1] = 20; xs[1]", 20},
+			{"let xs = [1, 2, 3]; xs[2] *= 10; xs[2]", 30},
+			{"let xs = [1, 2, 3]; let ys = xs; ys[0] = 9; xs[0]", 9},
+			{"let h = {}; h[\"a\"] = 1; h[\"a\"]", 1},
+			{"let h = {\"a\": 1}; h[\"a\"] += 41; h[\"a\"]", 42},
+			{"let h = {}; h[true] = 2; h[1] = 3; h[true] + h[1]", 5},
+			{"let i = 0; let xs = [1, 2]; let next = fn() { i += 1; i - 1 }; xs[next()] += 5; [i, xs[0], xs[1]]", "[1, 6, 2]"},
+			{"y = 5", "identifier not found: y"},
+			{"y += 5", "identifier not found: y"},
+			{"let xs = [1]; xs[1] = 2", "index out of range: 1 (length 1)"},
+			{"let xs = [1]; xs[\"a\"] = 2", "array index must be INTEGER, got STRING"},
+			{"let h = {}; h[fn() {}] = 1", "unusable as hash key: FUNCTION"},
+			{"let s = \"abc\"; s[0] = \"x\"", "index assignment not supported: STRING"},
+			{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
 		}
-	}
+
+		for _, tt := range tests {
+			evaluated := testEval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				switch evaluated := evaluated.(type) {
+				case *object.Error:
+					if evaluated.Message != expected {
+						t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, evaluated.Message)
+					}
+				case *object.Array:
+					if evaluated.Inspect() != expected {
+						t.Errorf("%q: wrong array. expected=%q, got=%q", tt.input, expected, evaluated.Inspect())
+					}
+				default:
+					testStringObject(t, evaluated, expected)
+				}
+			}
+		}
+	})
 }
 
-func TestHashLiterals(t *testing.T) {
-	input := `
-	let two = "two";
-	{
-		"one": 10 - 9,
-		two: 1 + 1,
-		"thr" + "ee": 6 / 2,
-		4: 4,
-		true: 5,
-		false: 6
+// recordingDebugger records the statements it is told about, and the stack at the one
+// on stackLine.
+type recordingDebugger struct {
+	e         *evaluator.Evaluator
+	stackLine int
+	stopLine  int // the line to stop evaluation at, if any
+
+	statements []string
+	stack      []evaluator.Frame
+}
+
+func (d *recordingDebugger) Before(node ast.Node, env *object.Environment, depth int) *object.Error {
+	if _, ok := node.(ast.Statement); !ok {
+		return nil
 	}
-	`
-	evaluated := testEval(input)
 
-	// Cast the object to a hash.
-	result, ok := evaluated.(*object.Hash)
-	if !ok {
-		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	pos := node.Pos()
+	d.statements = append(d.statements, fmt.Sprintf("%d@%d", pos.Line, depth))
+
+	if pos.Line == d.stackLine && d.stack == nil {
+		d.stack = d.e.Stack(pos, env)
+	}
+	if pos.Line == d.stopLine {
+		return &object.Error{Message: "stopped"}
 	}
+	return nil
+}
+
+func TestDebugger(t *testing.T) {
+	input := `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = add(1, 2);
+x`
+
+	program := parser.New(lexer.NewFile("d.ys", input)).ParseProgram()
+	env := object.NewEnvironment()
+
+	e := evaluator.New()
+	d := &recordingDebugger{e: e, stackLine: 2}
+	e.SetDebugger(d)
 
-	// Compare the length of the hash.
-	if len(result.Pairs) != 6 {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result := e.Eval(program, env); result.Inspect() != "3" {
+		t.Fatalf("wrong result. got=%s", result.Inspect())
 	}
 
-	// Define the expected values.
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	// Blocks are statements too, so the body of add is told about before its
+	// statements.
+	expected := "1@0 5@0 1@1 2@1 3@1 6@0"
+	if got := strings.Join(d.statements, " "); got != expected {
+		t.Errorf("wrong statements. want=%q, got=%q", expected, got)
 	}
 
-	// Iterate over each pair.
-	for expectedKey, expectedValue := range expected {
-		// Check if the pair exists.
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
-		}
+	if len(d.stack) != 2 {
+		t.Fatalf("wrong stack depth. got=%d", len(d.stack))
+	}
+	if d.stack[0].Function != "add" || d.stack[0].Pos.String() != "d.ys:2:3" {
+		t.Errorf("wrong innermost frame. got=%+v", d.stack[0])
+	}
+	if a, _ := d.stack[0].Env.Get("a"); a == nil || a.Inspect() != "1" {
+		t.Errorf("innermost frame has the wrong environment")
+	}
+	if d.stack[1].Function != object.MainFunction || d.stack[1].Pos.String() != "d.ys:5:9" || d.stack[1].Env != env {
+		t.Errorf("wrong outermost frame. got=%+v", d.stack[1])
+	}
+
+	// An error from the debugger stops evaluation.
+	d = &recordingDebugger{e: e, stopLine: 3}
+	e.SetDebugger(d)
 
-		// Compare the value of the integer.
-		testIntegerObject(t, pair.Value, expectedValue)
+	result := e.Eval(program, object.NewEnvironment())
+	if err, ok := result.(*object.Error); !ok || err.Message != "stopped" || err.Pos.String() != "d.ys:3:3" {
+		t.Errorf("expected the debugger's error. got=%s", result.Inspect())
 	}
 }
 
-func TestHashIndexExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{`{"foo": 5}["foo"]`, 5},
-		{`{"foo": 5}["bar"]`, nil},
-		{`let key = "foo"; {"foo": 5}[key]`, 5},
-		{`{}["foo"]`, nil},
-		{`{5: 5}[5]`, 5},
-		{`{true: 5}[true]`, 5},
-		{`{false: 5}[false]`, 5},
-	}
+// recordingProfiler records the calls it is told about, and the values allocated.
+type recordingProfiler struct {
+	events []string
+}
 
-	// Iterate over each test case.
-	for _, tt := range tests {
-		// Evaluate the input.
-		evaluated := testEval(tt.input)
+func (p *recordingProfiler) Enter(name string, pos token.Pos) {
+	p.events = append(p.events, fmt.Sprintf("enter %s@%d", name, pos.Line))
+}
 
-		// Check if the expected value is an integer.
-		integer, ok := tt.expected.(int)
-		if ok {
-			// Compare the value of the integer.
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			// Compare the value of the null.
-			testNullObject(t, evaluated)
-		}
+func (p *recordingProfiler) Exit() { p.events = append(p.events, "exit") }
+
+func (p *recordingProfiler) Step(pos token.Pos) {}
+
+func (p *recordingProfiler) Allocate(obj object.Object) {
+	p.events = append(p.events, "allocate "+string(obj.Type()))
+}
+
+func TestProfiler(t *testing.T) {
+	input := `let pair = fn(a) {
+  [a, len("ab")]
+};
+pair(1);`
+
+	program := parser.New(lexer.NewFile("p.ys", input)).ParseProgram()
+
+	e := evaluator.New()
+	p := &recordingProfiler{}
+	e.SetProfiler(p)
+	e.Eval(program, object.NewEnvironment())
+
+	expected := "enter pair@1, enter len@0, allocate INTEGER, exit, allocate ARRAY, exit"
+	if got := strings.Join(p.events, ", "); got != expected {
+		t.Errorf("wrong events. want=%q, got=%q", expected, got)
 	}
 }
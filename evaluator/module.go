@@ -0,0 +1,125 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+// Modules loads the files that programs import. Each file is evaluated once and its
+// module cached, and the files still being loaded are tracked to report import cycles.
+// Both engines use it; the VM through its own run function.
+type Modules struct {
+	searchPath []string
+	loaded     map[string]*object.Module // by absolute path
+	loading    []string                  // the files being loaded, outermost first
+}
+
+// NewModules returns a loader that looks for imports relative to the importing file
+// and then in each directory of searchPath, in order.
+func NewModules(searchPath []string) *Modules {
+	return &Modules{searchPath: searchPath, loaded: map[string]*object.Module{}}
+}
+
+// Load returns the module for the file path imported from the file importer, which is
+// empty for a program that was not read from a file. The first time a file is imported,
+// run evaluates its program, leaving the names it defines at its top level in module.Env,
+// or in the globals of module.Unit.
+func (m *Modules) Load(path, importer string, run func(module *object.Module, program *ast.Program) *object.Error) (*object.Module, *object.Error) {
+	file, ok := m.resolve(path, importer)
+	if !ok {
+		return nil, newError("cannot find module %q", path)
+	}
+
+	key, err := filepath.Abs(file)
+	if err != nil {
+		return nil, newError("cannot import %q: %s", path, err)
+	}
+
+	if module, ok := m.loaded[key]; ok {
+		return module, nil
+	}
+
+	for i, loading := range m.loading {
+		if loading == key {
+			cycle := append(append([]string{}, m.loading[i:]...), key)
+			for j := range cycle {
+				cycle[j] = filepath.Base(cycle[j])
+			}
+			return nil, newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, newError("cannot import %q: %s", path, err)
+	}
+
+	p := parser.New(lexer.NewFile(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, newError("cannot import %q: %s", path, p.Errors()[0])
+	}
+
+	module := &object.Module{
+		Name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		Path: file,
+		Env:  object.NewEnvironment(),
+	}
+
+	m.loading = append(m.loading, key)
+	errObj := run(module, program)
+	m.loading = m.loading[:len(m.loading)-1]
+
+	if errObj != nil {
+		return nil, errObj
+	}
+
+	m.loaded[key] = module
+
+	return module, nil
+}
+
+// Running records that the program in file is being run, as the outermost of the files
+// being loaded, so a module it imports that imports it in turn is reported as a cycle
+// instead of loading it again. The function it returns records that the program has
+// finished. A program that was not read from a file, with an empty file, cannot be
+// imported, and is not recorded.
+func (m *Modules) Running(file string) func() {
+	key, err := filepath.Abs(file)
+	if file == "" || err != nil {
+		return func() {}
+	}
+
+	m.loading = append(m.loading, key)
+	depth := len(m.loading)
+
+	return func() {
+		m.loading = m.loading[:depth-1]
+	}
+}
+
+// resolve finds the file an import of path refers to.
+func (m *Modules) resolve(path, importer string) (string, bool) {
+	candidates := []string{path}
+
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(filepath.Dir(importer), path)}
+		for _, dir := range m.searchPath {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
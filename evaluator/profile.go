@@ -0,0 +1,64 @@
+package evaluator
+
+import (
+	"sync"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+// Profiler measures where a program spends its time. The evaluator tells it as calls
+// begin and end, as it moves from line to line, and as it creates values.
+type Profiler interface {
+	// Enter is called as a call begins: of a function, a builtin or the top level of an
+	// imported module. name is the name stack traces give it, and pos where it is
+	// defined, which is not valid for builtins.
+	Enter(name string, pos token.Pos)
+
+	// Exit is called as the call Enter was last called for ends.
+	Exit()
+
+	// Step is called before each node that has a position is evaluated.
+	Step(pos token.Pos)
+
+	// Allocate is called with each value that operators, literals and builtins create.
+	Allocate(obj object.Object)
+}
+
+// SetProfiler makes the evaluator tell p what it does. A nil p stops it.
+func (e *Evaluator) SetProfiler(p Profiler) {
+	e.profiler = p
+}
+
+// profile tells the profiler, if there is one, that node is about to be evaluated.
+func (e *Evaluator) profile(node ast.Node) {
+	if e.profiler == nil {
+		return
+	}
+	if pos := node.Pos(); pos.IsValid() {
+		e.profiler.Step(pos)
+	}
+}
+
+// builtinNamesByValue maps the builtins to their names, for profiles to call them by.
+var (
+	builtinNamesOnce    sync.Once
+	builtinNamesByValue map[*object.Builtin]string
+)
+
+// builtinName returns the name a builtin is defined with, or "<builtin>" for one a host
+// defined.
+func builtinName(fn *object.Builtin) string {
+	builtinNamesOnce.Do(func() {
+		builtinNamesByValue = make(map[*object.Builtin]string, len(builtins))
+		for name, builtin := range builtins {
+			builtinNamesByValue[builtin] = name
+		}
+	})
+
+	if name, ok := builtinNamesByValue[fn]; ok {
+		return name
+	}
+	return "<builtin>"
+}
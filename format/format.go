@@ -0,0 +1,550 @@
+// File: format/format.go
+
+// Package format prints Ys programs in their canonical style: two spaces of indentation,
+// a space around binary operators and after commas, one statement to a line, and calls,
+// arrays and hashes broken one item to a line when they do not fit in maxWidth columns.
+// Comments are kept, as are single blank lines between statements, and formatting
+// formatted source leaves it as it is.
+package format
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/token"
+)
+
+const (
+	indentation = "  "
+	maxWidth    = 80
+)
+
+// Source formats the program in src, which came from file. It returns the parser's
+// errors, one to a line, if src is not a valid program. A shebang line, which the lexer
+// skips, is kept as it is.
+func Source(file string, src []byte) ([]byte, error) {
+	p := parser.New(lexer.NewFile(file, string(src)))
+
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	var shebang string
+	if strings.HasPrefix(string(src), "#!") {
+		shebang, _, _ = strings.Cut(string(src), "\n")
+		shebang += "\n"
+	}
+
+	return []byte(shebang + Program(program)), nil
+}
+
+// Program returns the source of a parsed program, with its comments, in the canonical style.
+func Program(program *ast.Program) string {
+	p := &printer{comments: program.Comments}
+
+	l := p.lines(0)
+	p.statements(l, program.Statements, false)
+	l.commentsBefore(-1)
+
+	return l.out.String()
+}
+
+// printer prints the nodes of a program. Comments are not part of the tree, so it prints
+// each one, in order, when it reaches the first node that comes after it.
+type printer struct {
+	comments []*ast.Comment
+	next     int // the index of the next comment to print
+}
+
+// comment returns the next comment to print, or nil if there are no more.
+func (p *printer) comment() *ast.Comment {
+	if p.next < len(p.comments) {
+		return p.comments[p.next]
+	}
+	return nil
+}
+
+// commentsWithin reports whether any comment to print comes between start and end.
+func (p *printer) commentsWithin(start, end token.Pos) bool {
+	for _, c := range p.comments[p.next:] {
+		if c.Pos().Offset >= end.Offset {
+			break
+		}
+		if c.Pos().Offset >= start.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// trailing returns the comment that follows the node ending at end on the same line, if
+// it comes before the offset limit, as the text to print after the node.
+func (p *printer) trailing(end token.Pos, limit int) string {
+	c := p.comment()
+	if c == nil || c.Pos().Line != end.Line || c.Pos().Offset < end.Offset || (limit >= 0 && c.Pos().Offset >= limit) {
+		return ""
+	}
+
+	p.next++
+	return " " + text(c)
+}
+
+func text(c *ast.Comment) string {
+	return strings.TrimRight(c.Text, " \t\r")
+}
+
+// lines collects whole lines at one level of indentation, such as the statements of a
+// block, with the comments that come before and after them.
+type lines struct {
+	p        *printer
+	out      strings.Builder
+	indent   int
+	lastLine int // the source line of what was written last, or 0 before anything is
+}
+
+func (p *printer) lines(indent int) *lines {
+	return &lines{p: p, indent: indent}
+}
+
+// gap writes a blank line if the source had one or more between what was written last
+// and what starts on line.
+func (l *lines) gap(line int) {
+	if l.lastLine > 0 && line > l.lastLine+1 {
+		l.out.WriteString("\n")
+	}
+}
+
+// commentsBefore writes the comments that come before offset, one to a line, or all the
+// rest of them if offset is negative. A comment from inside what was written last, such
+// as one between the operands of an operator, which has nowhere else to go, is written
+// on the line after it, with no blank line around it that was not in the source.
+func (l *lines) commentsBefore(offset int) {
+	for c := l.p.comment(); c != nil && (offset < 0 || c.Pos().Offset < offset); c = l.p.comment() {
+		l.gap(c.Pos().Line)
+		l.out.WriteString(strings.Repeat(indentation, l.indent) + text(c) + "\n")
+		l.lastLine = max(l.lastLine, c.Pos().Line)
+		l.p.next++
+	}
+}
+
+// write writes a line holding a node that spans start to end in the source, which is
+// followed by the comment on its last line, if any, that comes before limit.
+func (l *lines) write(s string, start, end token.Pos, limit int) {
+	l.gap(start.Line)
+	l.out.WriteString(strings.Repeat(indentation, l.indent) + s + l.p.trailing(end, limit) + "\n")
+	l.lastLine = end.Line
+}
+
+// statements writes a list of statements, one to a line. The last expression statement
+// of a block is its value, and goes without a semicolon.
+func (p *printer) statements(l *lines, stmts []ast.Statement, inBlock bool) {
+	for i, stmt := range stmts {
+		l.commentsBefore(stmt.Pos().Offset)
+
+		var next ast.Statement
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+		}
+
+		s := p.statement(stmt, l.indent, len(indentation)*l.indent)
+		if needsSemicolon(stmt, next, inBlock) {
+			s += ";"
+		}
+
+		limit := -1
+		if next != nil {
+			limit = next.Pos().Offset
+		}
+
+		l.write(s, stmt.Pos(), stmt.End(), limit)
+	}
+}
+
+// needsSemicolon reports whether stmt, followed by next (nil at the end of a block or
+// program), should end in a semicolon. Semicolons are optional in Ys, but without them
+// some statements would run on into the next: an if followed by a line starting with
+// (, [ or - would be called, indexed or subtracted from.
+func needsSemicolon(stmt, next ast.Statement, inBlock bool) bool {
+	switch stmt := stmt.(type) {
+	case *ast.WhileStatement, *ast.ForStatement, *ast.ForInStatement:
+		return false
+
+	case *ast.ExpressionStatement:
+		if inBlock && next == nil {
+			return false
+		}
+
+		switch stmt.Expression.(type) {
+		case *ast.IfExpression, *ast.TryExpression:
+			nextExp, ok := next.(*ast.ExpressionStatement)
+			return ok && strings.ContainsRune("([-", opensWith(nextExp.Expression))
+		}
+	}
+
+	return true
+}
+
+// statement returns the source of a statement, without the semicolon that may end it,
+// indented indent levels deep and starting at column col.
+func (p *printer) statement(stmt ast.Statement, indent, col int) string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		prefix := "let " + stmt.Name.Value + " = "
+		return prefix + p.expression(stmt.Value, indent, col+len(prefix))
+
+	case *ast.ReturnStatement:
+		return "return " + p.expression(stmt.ReturnValue, indent, col+len("return "))
+
+	case *ast.ThrowStatement:
+		return "throw " + p.expression(stmt.Value, indent, col+len("throw "))
+
+	case *ast.ExpressionStatement:
+		return p.expression(stmt.Expression, indent, col)
+
+	case *ast.BreakStatement:
+		return "break"
+
+	case *ast.ContinueStatement:
+		return "continue"
+
+	case *ast.ImportStatement:
+		path := `"` + stmt.Path.Value + `"`
+
+		if len(stmt.Names) > 0 {
+			names := make([]string, len(stmt.Names))
+			for i, name := range stmt.Names {
+				names[i] = name.Value
+			}
+			return "from " + path + " import " + strings.Join(names, ", ")
+		}
+
+		// A module named after its file was imported without `as`.
+		if stmt.Alias.Pos() == stmt.Path.Pos() {
+			return "import " + path
+		}
+		return "import " + path + " as " + stmt.Alias.Value
+
+	case *ast.WhileStatement:
+		s := "while (" + p.expression(stmt.Condition, indent, col+len("while (")) + ") "
+		return s + p.block(stmt.Body, indent, endColumn(col, s))
+
+	case *ast.ForStatement:
+		s := "for ("
+		if stmt.Init != nil {
+			s += p.statement(stmt.Init, indent, endColumn(col, s))
+		}
+		s += ";"
+		if stmt.Condition != nil {
+			s += " " + p.expression(stmt.Condition, indent, endColumn(col, s)+1)
+		}
+		s += ";"
+		if stmt.Post != nil {
+			s += " " + p.statement(stmt.Post, indent, endColumn(col, s)+1)
+		}
+		s += ") "
+		return s + p.block(stmt.Body, indent, endColumn(col, s))
+
+	case *ast.ForInStatement:
+		s := "for ("
+		if stmt.Key != nil {
+			s += stmt.Key.Value + ", "
+		}
+		s += stmt.Value.Value + " in "
+		s += p.expression(stmt.Iterable, indent, endColumn(col, s)) + ") "
+		return s + p.block(stmt.Body, indent, endColumn(col, s))
+	}
+
+	return stmt.String()
+}
+
+// block returns the source of a block. A block with a single statement stays on one
+// line if it was written on one; any other is written a statement to a line.
+func (p *printer) block(b *ast.BlockStatement, indent, col int) string {
+	comments := p.commentsWithin(b.Pos(), b.End())
+
+	if len(b.Statements) == 0 && !comments {
+		return "{}"
+	}
+
+	if len(b.Statements) == 1 && !comments && b.Pos().Line == b.Rbrace.Pos.Line {
+		saved := p.next
+
+		s := p.statement(b.Statements[0], indent, col+2)
+		if needsSemicolon(b.Statements[0], nil, true) {
+			s += ";"
+		}
+		if !strings.Contains(s, "\n") {
+			return "{ " + s + " }"
+		}
+
+		p.next = saved
+	}
+
+	limit := b.Rbrace.Pos.Offset
+	if len(b.Statements) > 0 {
+		limit = b.Statements[0].Pos().Offset
+	}
+
+	s := "{" + p.trailing(b.Token.End, limit) + "\n"
+
+	l := p.lines(indent + 1)
+	p.statements(l, b.Statements, true)
+	l.commentsBefore(b.Rbrace.Pos.Offset)
+
+	return s + l.out.String() + strings.Repeat(indentation, indent) + "}"
+}
+
+// item is one of the items of a list: an argument of a call, an element of an array or
+// a pair of a hash.
+type item struct {
+	start, end token.Pos
+	print      func(indent, col int) string
+}
+
+// list returns the source of the items of a call, array or hash between the brackets
+// open and close, which span openToken to end in the source. The items go on one line if
+// they fit, letting the last one run on over more, and otherwise one to a line.
+func (p *printer) list(open, close string, items []item, openToken token.Token, end token.Pos, indent, col int) string {
+	if len(items) == 0 {
+		return open + close
+	}
+
+	if !p.commentsBetween(items, openToken.Pos, end) {
+		saved := p.next
+
+		s := open
+		fits := true
+		for i, it := range items {
+			if i > 0 {
+				s += ", "
+			}
+			printed := it.print(indent, endColumn(col, s))
+			if i < len(items)-1 && strings.Contains(printed, "\n") {
+				fits = false
+				break
+			}
+			s += printed
+		}
+		s += close
+
+		if firstLine, _, _ := strings.Cut(s, "\n"); fits && col+len(firstLine) <= maxWidth {
+			return s
+		}
+
+		p.next = saved
+	}
+
+	s := open + p.trailing(openToken.End, items[0].start.Offset) + "\n"
+
+	l := p.lines(indent + 1)
+	for i, it := range items {
+		l.commentsBefore(it.start.Offset)
+
+		printed := it.print(indent+1, len(indentation)*(indent+1))
+		limit := end.Offset
+		if i < len(items)-1 {
+			printed += ","
+			limit = items[i+1].start.Offset
+		}
+
+		l.write(printed, it.start, it.end, limit)
+	}
+	l.commentsBefore(end.Offset)
+
+	return s + l.out.String() + strings.Repeat(indentation, indent) + close
+}
+
+// commentsBetween reports whether any comment comes between the items of a list that
+// spans start to end, rather than inside one of them.
+func (p *printer) commentsBetween(items []item, start, end token.Pos) bool {
+	for _, c := range p.comments[p.next:] {
+		offset := c.Pos().Offset
+		if offset >= end.Offset {
+			break
+		}
+		if offset < start.Offset {
+			continue
+		}
+
+		inside := false
+		for _, it := range items {
+			if offset >= it.start.Offset && offset < it.end.Offset {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) expressionItems(exps []ast.Expression) []item {
+	items := make([]item, len(exps))
+	for i, exp := range exps {
+		exp := exp
+		items[i] = item{exp.Pos(), exp.End(), func(indent, col int) string {
+			return p.expression(exp, indent, col)
+		}}
+	}
+	return items
+}
+
+// expression returns the source of an expression indented indent levels deep and
+// starting at column col, with the parentheses its operators need and no others.
+func (p *printer) expression(exp ast.Expression, indent, col int) string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return exp.Value
+
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		return exp.TokenLiteral()
+
+	case *ast.StringLiteral:
+		return `"` + exp.Value + `"`
+
+	case *ast.PrefixExpression:
+		return exp.Operator + p.operand(exp.Right, precedence(exp.Right) < parser.PREFIX, indent, col+len(exp.Operator))
+
+	case *ast.InfixExpression:
+		prec := precedence(exp)
+		left := p.operand(exp.Left, precedence(exp.Left) < prec, indent, col)
+		s := left + " " + exp.Operator + " "
+		return s + p.operand(exp.Right, precedence(exp.Right) <= prec, indent, endColumn(col, s))
+
+	case *ast.AssignExpression:
+		s := p.expression(exp.Target, indent, col) + " " + exp.Operator + " "
+		return s + p.expression(exp.Value, indent, endColumn(col, s))
+
+	case *ast.CallExpression:
+		s := p.operand(exp.Function, precedence(exp.Function) < parser.CALL, indent, col)
+		return s + p.list("(", ")", p.expressionItems(exp.Arguments), exp.Token, exp.Rparen.End, indent, endColumn(col, s))
+
+	case *ast.IndexExpression:
+		s := p.operand(exp.Left, precedence(exp.Left) < parser.CALL, indent, col) + "["
+		return s + p.expression(exp.Index, indent, endColumn(col, s)) + "]"
+
+	case *ast.MemberExpression:
+		return p.operand(exp.Object, precedence(exp.Object) < parser.CALL, indent, col) + "." + exp.Member.Value
+
+	case *ast.ArrayLiteral:
+		return p.list("[", "]", p.expressionItems(exp.Elements), exp.Token, exp.Rbracket.End, indent, col)
+
+	case *ast.HashLiteral:
+		// The pairs are kept in the order they were written in.
+		keys := ast.SortedKeys(exp)
+		items := make([]item, len(keys))
+		for i, key := range keys {
+			key, value := key, exp.Pairs[key]
+			items[i] = item{key.Pos(), value.End(), func(indent, col int) string {
+				s := p.expression(key, indent, col) + ": "
+				return s + p.expression(value, indent, endColumn(col, s))
+			}}
+		}
+		return p.list("{", "}", items, exp.Token, exp.Rbrace.End, indent, col)
+
+	case *ast.FunctionLiteral:
+		params := make([]item, len(exp.Parameters))
+		for i, param := range exp.Parameters {
+			param := param
+			params[i] = item{param.Pos(), param.End(), func(int, int) string { return param.Value }}
+		}
+		s := "fn" + p.list("(", ")", params, exp.Token, exp.Rparen.End, indent, col+len("fn")) + " "
+		return s + p.block(exp.Body, indent, endColumn(col, s))
+
+	case *ast.IfExpression:
+		s := "if (" + p.expression(exp.Condition, indent, col+len("if (")) + ") "
+		s += p.block(exp.Consequence, indent, endColumn(col, s))
+		if exp.Alternative != nil {
+			s += " else "
+			s += p.block(exp.Alternative, indent, endColumn(col, s))
+		}
+		return s
+
+	case *ast.TryExpression:
+		s := "try "
+		s += p.block(exp.Block, indent, endColumn(col, s))
+		if exp.Catch != nil {
+			s += " catch (" + exp.Param.Value + ") "
+			s += p.block(exp.Catch, indent, endColumn(col, s))
+		}
+		if exp.Finally != nil {
+			s += " finally "
+			s += p.block(exp.Finally, indent, endColumn(col, s))
+		}
+		return s
+	}
+
+	return exp.String()
+}
+
+// operand returns the source of the operand of an operator, in parentheses if parens is set.
+func (p *printer) operand(exp ast.Expression, parens bool, indent, col int) string {
+	if parens {
+		return "(" + p.expression(exp, indent, col+1) + ")"
+	}
+	return p.expression(exp, indent, col)
+}
+
+// precedence returns how tightly an expression holds together, as the parser's
+// precedences: operators by their own, and anything else more tightly than any operator.
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(exp.Token.Type)
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.MemberExpression:
+		return parser.INDEX
+	}
+	return parser.INDEX + 1
+}
+
+// opensWith returns the character the source of an expression starts with, if it is one
+// that could also continue the expression before it, and 0 otherwise.
+func opensWith(exp ast.Expression) rune {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		if precedence(exp.Left) < precedence(exp) {
+			return '('
+		}
+		return opensWith(exp.Left)
+	case *ast.AssignExpression:
+		return opensWith(exp.Target)
+	case *ast.CallExpression:
+		return opensWithOperand(exp.Function)
+	case *ast.IndexExpression:
+		return opensWithOperand(exp.Left)
+	case *ast.MemberExpression:
+		return opensWithOperand(exp.Object)
+	case *ast.PrefixExpression:
+		return rune(exp.Operator[0])
+	case *ast.ArrayLiteral:
+		return '['
+	}
+	return 0
+}
+
+func opensWithOperand(exp ast.Expression) rune {
+	if precedence(exp) < parser.CALL {
+		return '('
+	}
+	return opensWith(exp)
+}
+
+// endColumn returns the column after s, when s starts at column col.
+func endColumn(col int, s string) int {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return col + len(s)
+}
@@ -0,0 +1,108 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Spacing and semicolons.
+		{"let x=1\nlet   y = x*2\nputs(x,y)", "let x = 1;\nlet y = x * 2;\nputs(x, y);\n"},
+		{"x+=1;y = z = 2", "x += 1;\ny = z = 2;\n"},
+		{"return -x; throw {\"message\":\"no\"}", "return -x;\nthrow {\"message\": \"no\"};\n"},
+		{"import \"lib/math.ys\"\nimport \"lib/math.ys\"   as m\nfrom \"x.ys\" import a,b", "import \"lib/math.ys\";\nimport \"lib/math.ys\" as m;\nfrom \"x.ys\" import a, b;\n"},
+		{"", ""},
+		{"#!/usr/bin/env ys\nputs(  1)", "#!/usr/bin/env ys\nputs(1);\n"},
+		{"#!/usr/bin/env ys", "#!/usr/bin/env ys\n"},
+
+		// Only the parentheses the operators need are kept.
+		{"((a + b)) * c", "(a + b) * c;\n"},
+		{"a - (b - c); (a - b) - c; a - b + c", "a - (b - c);\na - b - c;\na - b + c;\n"},
+		{"-(a + b); -(-a); (-a).b; -a.b", "-(a + b);\n--a;\n(-a).b;\n-a.b;\n"},
+		{"(a + b)(c)[d].e; f(g)(h)", "(a + b)(c)[d].e;\nf(g)(h);\n"},
+		{"a && (b || c); (a && b) || c", "a && (b || c);\na && b || c;\n"},
+		{"x + (y = 1)", "x + (y = 1);\n"},
+
+		// Blocks stay on one line if they were written on one and hold a single statement.
+		{"let add = fn(a,b){a+b}", "let add = fn(a, b) { a + b };\n"},
+		{"let f = fn() {}", "let f = fn() {};\n"},
+		{"if (x) { return 1 } else { 2 }", "if (x) { return 1; } else { 2 }\n"},
+		{"let f = fn(x) {\nlet y = x\ny }", "let f = fn(x) {\n  let y = x;\n  y\n};\n"},
+		{"let f = fn(x) { let y = x; y }", "let f = fn(x) {\n  let y = x;\n  y\n};\n"},
+		{"while (x < 3) { x += 1 };\nfor (let i = 0; i < 3; i += 1) {\nputs(i)\n}", "while (x < 3) { x += 1 }\nfor (let i = 0; i < 3; i += 1) {\n  puts(i)\n}\n"},
+		{"for (;;) { break }\nfor (k, v in h) { continue }", "for (;;) { break; }\nfor (k, v in h) { continue; }\n"},
+		{"let r = try { f() } catch (e) { 0 } finally { done() }", "let r = try { f() } catch (e) { 0 } finally { done() };\n"},
+
+		// An if or try only ends in a semicolon when the next line would continue it.
+		{"if (x) { 1 };\n[1, 2]", "if (x) { 1 };\n[1, 2];\n"},
+		{"if (x) { 1 };\n-1", "if (x) { 1 };\n-1;\n"},
+		{"if (x) { 1 };\n(a + b) * 2", "if (x) { 1 };\n(a + b) * 2;\n"},
+		{"if (x) { 1 };\nputs(2)", "if (x) { 1 }\nputs(2);\n"},
+
+		// Lists that do not fit are broken one item to a line.
+		{
+			"let call = some_function_with_a_long_name(first_argument, second_argument, third)",
+			"let call = some_function_with_a_long_name(\n  first_argument,\n  second_argument,\n  third\n);\n",
+		},
+		{
+			"let config = {\"name\": \"ys\", \"version\": 1, \"tags\": [\"a\", \"b\"], \"description\": \"a small language\"}",
+			"let config = {\n  \"name\": \"ys\",\n  \"version\": 1,\n  \"tags\": [\"a\", \"b\"],\n  \"description\": \"a small language\"\n};\n",
+		},
+		{
+			"let xs = [[1, 2, 3, 4, 5, 6, 7, 8, 9, 10], [11, 12, 13, 14, 15, 16, 17, 18, 19, 20], [21]]",
+			"let xs = [\n  [1, 2, 3, 4, 5, 6, 7, 8, 9, 10],\n  [11, 12, 13, 14, 15, 16, 17, 18, 19, 20],\n  [21]\n];\n",
+		},
+		{"let h = {\n\"a\": 1,\n\"b\": 2,\n}", "let h = {\"a\": 1, \"b\": 2};\n"},
+		{"let h = {\"b\": 1, \"a\": 2, \"c\": 3}", "let h = {\"b\": 1, \"a\": 2, \"c\": 3};\n"},
+
+		// A function as the last argument runs on over more lines.
+		{"puts(map(xs, fn(x) {\nlet y = x * 2\ny\n}))", "puts(map(xs, fn(x) {\n  let y = x * 2;\n  y\n}));\n"},
+		{"f(fn() {\n1;\n2\n}, x)", "f(\n  fn() {\n    1;\n    2\n  },\n  x\n);\n"},
+
+		// Comments and blank lines.
+		{"// A program.\nlet x = 1 // one\n\n\n\nlet y = 2", "// A program.\nlet x = 1; // one\n\nlet y = 2;\n"},
+		{"let f = fn(x) { // doubles\n  x * 2\n}", "let f = fn(x) { // doubles\n  x * 2\n};\n"},
+		{"let f = fn() {\n  // nothing yet\n}", "let f = fn() {\n  // nothing yet\n};\n"},
+		{"let f = fn() {\n\n  a;\n\n  b\n\n}", "let f = fn() {\n  a;\n\n  b\n};\n"},
+		{"let h = {\n  \"a\": 1, // first\n  // before b\n  \"b\": 2\n}", "let h = {\n  \"a\": 1, // first\n  // before b\n  \"b\": 2\n};\n"},
+		{"f(x, // why\n  y)", "f(\n  x, // why\n  y\n);\n"},
+		{"let x = 1 + // odd place\n  2", "let x = 1 + 2;\n// odd place\n"},
+		{"let y = fn(a, // p\n b) { a + b };\nlet z = 1", "let y = fn(\n  a, // p\n  b\n) { a + b };\nlet z = 1;\n"},
+		{"let x = 1 + // one\n  2;\nputs(x)", "let x = 1 + 2;\n// one\nputs(x);\n"},
+		{"if (a && // both\n b) { 1 }\n\nputs(2)", "if (a && b) { 1 }\n// both\n\nputs(2);\n"},
+		{"x  \n// the end  ", "x;\n// the end\n"},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source("", []byte(tt.input))
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+
+		if string(formatted) != tt.expected {
+			t.Errorf("input %q: wrong output.\nwant=%q\ngot= %q", tt.input, tt.expected, formatted)
+			continue
+		}
+
+		again, err := Source("", formatted)
+		if err != nil || string(again) != string(formatted) {
+			t.Errorf("input %q: formatting is not idempotent. got=%q (err=%v)", tt.input, again, err)
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("bad.ys", []byte("let = 1"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if !strings.HasPrefix(err.Error(), "bad.ys:1:5: expected next token to be IDENT") {
+		t.Errorf("wrong error. got=%q", err)
+	}
+}
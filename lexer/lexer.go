@@ -3,6 +3,7 @@
 package lexer
 
 import (
+	"sort"
 	"unicode"
 	"unicode/utf8"
 
@@ -11,47 +12,95 @@ import (
 
 // keywords defines a map for reserved words and their token types.
 var keywords = map[string]token.TokenType{
-	"fn":     token.FUNCTION,
-	"let":    token.LET,
-	"if":     token.IF,
-	"else":   token.ELSE,
-	"return": token.RETURN,
-	"true":   token.TRUE,
-	"false":  token.FALSE,
+	"fn":       token.FUNCTION,
+	"let":      token.LET,
+	"if":       token.IF,
+	"else":     token.ELSE,
+	"return":   token.RETURN,
+	"true":     token.TRUE,
+	"false":    token.FALSE,
+	"while":    token.WHILE,
+	"for":      token.FOR,
+	"in":       token.IN,
+	"break":    token.BREAK,
+	"continue": token.CONTINUE,
+	"try":      token.TRY,
+	"catch":    token.CATCH,
+	"finally":  token.FINALLY,
+	"throw":    token.THROW,
+	"import":   token.IMPORT,
+	"from":     token.FROM,
+	"as":       token.AS,
 }
 
 // Lexer represents a lexer with the input, current position, and reading position.
 type Lexer struct {
 	input        string
-	pos          int  // current position in input (points to current char)
-	readPosition int  // current reading position in input (after current char)
-	ch           rune // current char under examination
+	file         string // name of the file the input came from, if any
+	pos          int    // current position in input (points to current char)
+	readPosition int    // current reading position in input (after current char)
+	ch           rune   // current char under examination
+	line         int    // line of the current char, starting at 1
+	lineStart    int    // offset of the first char of the current line
 }
 
 // New returns a new instance of Lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a new instance of Lexer whose token positions carry the given file name.
+func NewFile(file, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar() // Initialize the first char
+
+	if l.ch == '#' && l.peekChar() == '!' { // skip a shebang line such as `#!/usr/bin/env ys`
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
+
 	return l
 }
 
 // readChar gets the next character and advances our position in the input string.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' { // moving past a newline starts a new line
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
+	width := 0
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // ASCII code for "NUL" character, signifies we're at EOF
 	} else {
-		l.ch, _ = utf8.DecodeRuneInString(l.input[l.readPosition:])
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.pos = l.readPosition
-	l.readPosition++
+	l.readPosition += width
+}
+
+// position returns the source position of the current char.
+func (l *Lexer) position() token.Pos {
+	return token.Pos{File: l.file, Offset: l.pos, Line: l.line, Column: l.pos - l.lineStart + 1}
 }
 
 // NextToken returns the next token from the input.
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipWhitespace()
 
+	start := l.position()
+	tok := l.readToken()
+	tok.Pos = start
+	tok.End = l.position()
+
+	return tok
+}
+
+// readToken reads the token starting at the current char.
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -71,10 +120,28 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		if unicode.IsDigit(l.peekChar()) { // a float such as .5
+			tok.Literal, tok.Type = l.readNumber()
+			return tok
+		}
+		tok = newToken(token.DOT, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
@@ -91,15 +158,55 @@ func (l *Lexer) NextToken() token.Token {
 			// Do not advance the lexer here; readComment will handle it
 			tok.Type = token.COMMENT
 			tok.Literal = l.readComment()
+		} else if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = newToken(token.SLASH, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -117,8 +224,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = lookupIdent(tok.Literal)
 			return tok
 		} else if unicode.IsDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -137,6 +243,19 @@ func lookupIdent(ident string) token.TokenType {
 	return token.IDENT
 }
 
+// Keywords returns the reserved words, sorted.
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+
+	for word := range keywords {
+		words = append(words, word)
+	}
+
+	sort.Strings(words)
+
+	return words
+}
+
 // readIdentifier reads in an identifier and advances the lexer's positions until it encounters a non-letter-character.
 func (l *Lexer) readIdentifier() string {
 	position := l.pos
@@ -146,13 +265,49 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.pos]
 }
 
-// readNumber reads a number and advances the lexer's positions until it encounters a non-digit character.
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer such as 42, or a float such as 1.5, .5 or 1e9, and reports which it read.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.pos
+	tokType := token.INT
+
 	for unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.pos]
+
+	if l.ch == '.' && unicode.IsDigit(l.peekChar()) { // a fraction
+		tokType = token.FLOAT
+		l.readChar()
+		for unicode.IsDigit(l.ch) {
+			l.readChar()
+		}
+	}
+
+	if l.isExponentStart() { // an exponent, e.g. e9, E+2 or e-3
+		tokType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		for unicode.IsDigit(l.ch) {
+			l.readChar()
+		}
+	}
+
+	return l.input[position:l.pos], tokType
+}
+
+// isExponentStart reports whether the current char starts the exponent of a number.
+func (l *Lexer) isExponentStart() bool {
+	if l.ch != 'e' && l.ch != 'E' {
+		return false
+	}
+
+	rest := l.input[l.readPosition:]
+	if len(rest) > 0 && (rest[0] == '+' || rest[0] == '-') {
+		rest = rest[1:]
+	}
+
+	return len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9'
 }
 
 // skipWhitespace skips any whitespace characters in the input.
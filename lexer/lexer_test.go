@@ -122,6 +122,108 @@ func TestLexer(t *testing.T) {
 				{Type: token.EOF, Literal: ""},
 			},
 		},
+		{
+			name:  "Loop keywords",
+			input: "while for in break continue",
+			expected: []token.Token{
+				{Type: token.WHILE, Literal: "while"},
+				{Type: token.FOR, Literal: "for"},
+				{Type: token.IN, Literal: "in"},
+				{Type: token.BREAK, Literal: "break"},
+				{Type: token.CONTINUE, Literal: "continue"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			name:  "Exception keywords",
+			input: "try catch finally throw",
+			expected: []token.Token{
+				{Type: token.TRY, Literal: "try"},
+				{Type: token.CATCH, Literal: "catch"},
+				{Type: token.FINALLY, Literal: "finally"},
+				{Type: token.THROW, Literal: "throw"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			name:  "Imports",
+			input: `import "lib.ys" as lib; from "lib.ys" import a; lib.a`,
+			expected: []token.Token{
+				{Type: token.IMPORT, Literal: "import"},
+				{Type: token.STRING, Literal: "lib.ys"},
+				{Type: token.AS, Literal: "as"},
+				{Type: token.IDENT, Literal: "lib"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.FROM, Literal: "from"},
+				{Type: token.STRING, Literal: "lib.ys"},
+				{Type: token.IMPORT, Literal: "import"},
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "lib"},
+				{Type: token.DOT, Literal: "."},
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			name:  "Compound assignment",
+			input: "x += 1; x -= 2; x *= 3; x /= 4;",
+			expected: []token.Token{
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.PLUS_ASSIGN, Literal: "+="},
+				{Type: token.INT, Literal: "1"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.MINUS_ASSIGN, Literal: "-="},
+				{Type: token.INT, Literal: "2"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.ASTERISK_ASSIGN, Literal: "*="},
+				{Type: token.INT, Literal: "3"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.SLASH_ASSIGN, Literal: "/="},
+				{Type: token.INT, Literal: "4"},
+				{Type: token.SEMICOLON, Literal: ";"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			name:  "Comparison and logical operators",
+			input: "a <= b >= c % d && e || f",
+			expected: []token.Token{
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.LT_EQ, Literal: "<="},
+				{Type: token.IDENT, Literal: "b"},
+				{Type: token.GT_EQ, Literal: ">="},
+				{Type: token.IDENT, Literal: "c"},
+				{Type: token.PERCENT, Literal: "%"},
+				{Type: token.IDENT, Literal: "d"},
+				{Type: token.AND, Literal: "&&"},
+				{Type: token.IDENT, Literal: "e"},
+				{Type: token.OR, Literal: "||"},
+				{Type: token.IDENT, Literal: "f"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
+		{
+			name:  "Float literals",
+			input: "1.5 .5 1e9 2.5E-3 7e+2 3 4.x 5e",
+			expected: []token.Token{
+				{Type: token.FLOAT, Literal: "1.5"},
+				{Type: token.FLOAT, Literal: ".5"},
+				{Type: token.FLOAT, Literal: "1e9"},
+				{Type: token.FLOAT, Literal: "2.5E-3"},
+				{Type: token.FLOAT, Literal: "7e+2"},
+				{Type: token.INT, Literal: "3"},
+				{Type: token.INT, Literal: "4"},
+				{Type: token.DOT, Literal: "."},
+				{Type: token.IDENT, Literal: "x"},
+				{Type: token.INT, Literal: "5"},
+				{Type: token.IDENT, Literal: "e"},
+				{Type: token.EOF, Literal: ""},
+			},
+		},
 		// Add more test cases as needed
 	}
 
@@ -288,3 +390,62 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = \"hi\";\n  fn(é) {\n}"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Pos
+		expectedEnd  token.Pos
+	}{
+		{token.LET, token.Pos{File: "main.ys", Offset: 0, Line: 1, Column: 1}, token.Pos{File: "main.ys", Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Pos{File: "main.ys", Offset: 4, Line: 1, Column: 5}, token.Pos{File: "main.ys", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Pos{File: "main.ys", Offset: 6, Line: 1, Column: 7}, token.Pos{File: "main.ys", Offset: 7, Line: 1, Column: 8}},
+		{token.STRING, token.Pos{File: "main.ys", Offset: 8, Line: 1, Column: 9}, token.Pos{File: "main.ys", Offset: 12, Line: 1, Column: 13}},
+		{token.SEMICOLON, token.Pos{File: "main.ys", Offset: 12, Line: 1, Column: 13}, token.Pos{File: "main.ys", Offset: 13, Line: 1, Column: 14}},
+		{token.FUNCTION, token.Pos{File: "main.ys", Offset: 16, Line: 2, Column: 3}, token.Pos{File: "main.ys", Offset: 18, Line: 2, Column: 5}},
+		{token.LPAREN, token.Pos{File: "main.ys", Offset: 18, Line: 2, Column: 5}, token.Pos{File: "main.ys", Offset: 19, Line: 2, Column: 6}},
+		// "é" is two bytes wide; columns count bytes.
+		{token.IDENT, token.Pos{File: "main.ys", Offset: 19, Line: 2, Column: 6}, token.Pos{File: "main.ys", Offset: 21, Line: 2, Column: 8}},
+		{token.RPAREN, token.Pos{File: "main.ys", Offset: 21, Line: 2, Column: 8}, token.Pos{File: "main.ys", Offset: 22, Line: 2, Column: 9}},
+		{token.LBRACE, token.Pos{File: "main.ys", Offset: 23, Line: 2, Column: 10}, token.Pos{File: "main.ys", Offset: 24, Line: 2, Column: 11}},
+		{token.RBRACE, token.Pos{File: "main.ys", Offset: 25, Line: 3, Column: 1}, token.Pos{File: "main.ys", Offset: 26, Line: 3, Column: 2}},
+		{token.EOF, token.Pos{File: "main.ys", Offset: 26, Line: 3, Column: 2}, token.Pos{File: "main.ys", Offset: 26, Line: 3, Column: 2}},
+	}
+
+	l := NewFile("main.ys", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+
+	if got := tests[5].expectedPos.String(); got != "main.ys:2:3" {
+		t.Errorf("pos formatted wrongly. got=%q", got)
+	}
+}
+
+func TestShebangIsSkipped(t *testing.T) {
+	l := New("#!/usr/bin/env ys\nlet x = 1;")
+
+	tok := l.NextToken()
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+
+	if tok.Pos.Line != 2 || tok.Pos.Column != 1 {
+		t.Errorf("position wrong. expected=2:1, got=%s", tok.Pos)
+	}
+}
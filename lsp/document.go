@@ -0,0 +1,183 @@
+// File: lsp/document.go
+
+package lsp
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/vet"
+	"github.com/shafik23/ys/ystest"
+)
+
+// predeclared are the names scripts can use without defining them.
+var predeclared = []string{"args"}
+
+// predeclaredNames returns the names the document can use without defining them, which
+// in a test file include the assertion builtins.
+func (d *document) predeclaredNames() []string {
+	if ystest.IsTestFile(d.uri) {
+		return append(predeclared[:len(predeclared):len(predeclared)], ystest.BuiltinNames()...)
+	}
+	return predeclared
+}
+
+// source is the text of a document, with where each of its lines starts.
+type source struct {
+	text  string
+	lines []int // the byte offset of the start of each line
+}
+
+func newSource(text string) source {
+	lines := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return source{text: text, lines: lines}
+}
+
+// offset returns the byte offset of pos, which is moved onto the nearest place in the
+// text if it is beyond the end of its line or of the text.
+func (s source) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(s.lines) {
+		return len(s.text)
+	}
+
+	offset := s.lines[pos.Line]
+	for units := 0; units < pos.Character && offset < len(s.text) && s.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(s.text[offset:])
+		units += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+// position returns the position of a byte offset.
+func (s source) position(offset int) Position {
+	offset = max(0, min(offset, len(s.text)))
+
+	line := 0
+	for line+1 < len(s.lines) && s.lines[line+1] <= offset {
+		line++
+	}
+
+	character := 0
+	for _, r := range s.text[s.lines[line]:offset] {
+		character += utf16Len(r)
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+// utf16Len returns how many UTF-16 code units encode r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// nodeRange returns the range a node spans.
+func (s source) nodeRange(node ast.Node) Range {
+	return Range{Start: s.position(node.Pos().Offset), End: s.position(node.End().Offset)}
+}
+
+// analysis is a version of a document that parsed without errors.
+type analysis struct {
+	source
+	program *ast.Program
+	info    *vet.Info
+}
+
+// document is a file open in the editor.
+type document struct {
+	uri     string
+	version int
+	source
+
+	errors []*parser.Error // the syntax errors in the current text
+
+	// The last version of the document that parsed, which is what go-to-definition,
+	// hover and the other features that need the syntax tree work on while the text
+	// being edited does not parse. It is nil if no version has parsed.
+	parsed *analysis
+}
+
+// setText replaces the document's text, and parses it.
+func (d *document) setText(text string) {
+	d.source = newSource(text)
+
+	p := parser.New(lexer.NewFile(d.uri, text))
+	program := p.ParseProgram()
+
+	d.errors = p.ErrorList()
+	if len(d.errors) == 0 {
+		d.parsed = &analysis{source: d.source, program: program, info: vet.Resolve(program, d.predeclaredNames()...)}
+	}
+}
+
+// apply makes the changes an editor sent, in order, to the text.
+func (d *document) apply(changes []TextDocumentContentChangeEvent) {
+	text := d.text
+
+	for _, change := range changes {
+		if change.Range == nil {
+			text = change.Text
+			continue
+		}
+
+		s := newSource(text)
+		start, end := s.offset(change.Range.Start), s.offset(change.Range.End)
+		if end < start {
+			start, end = end, start
+		}
+		text = text[:start] + change.Text + text[end:]
+	}
+
+	d.setText(text)
+}
+
+// identifierAt returns the identifier at, or just before, a byte offset in the parsed
+// version of the document.
+func (a *analysis) identifierAt(offset int) *ast.Identifier {
+	var found *ast.Identifier
+
+	ast.Inspect(a.program, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Identifier); ok && found == nil && id.Pos().Offset <= offset && offset <= id.End().Offset {
+			found = id
+		}
+		return found == nil
+	})
+
+	return found
+}
+
+// wordBefore returns the part of an identifier that ends at a byte offset.
+func (s source) wordBefore(offset int) string {
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(s.text[:start])
+		if !unicode.IsLetter(r) && r != '_' {
+			break
+		}
+		start -= size
+	}
+	return s.text[start:offset]
+}
+
+// lineEnd returns the byte offset of the end of the line holding offset.
+func (s source) lineEnd(offset int) int {
+	if i := strings.IndexByte(s.text[offset:], '\n'); i >= 0 {
+		return offset + i
+	}
+	return len(s.text)
+}
@@ -0,0 +1,321 @@
+// File: lsp/features.go
+
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/format"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/vet"
+)
+
+// diagnostics returns the syntax errors in the document or, if it has none, the
+// mistakes `ys vet` finds in it.
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	for _, err := range d.errors {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.wordRange(err.Pos.Offset),
+			Severity: SeverityError,
+			Source:   "ys",
+			Message:  err.Msg,
+		})
+	}
+
+	if len(d.errors) > 0 {
+		return diagnostics
+	}
+
+	for _, found := range vet.Check(d.parsed.program, vet.Rules(), d.predeclaredNames()...) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.wordRange(found.Pos.Offset),
+			Severity: SeverityWarning,
+			Code:     found.Rule,
+			Source:   "ys vet",
+			Message:  found.Message,
+		})
+	}
+
+	return diagnostics
+}
+
+// wordRange returns the range of the identifier that starts at a byte offset or, if
+// none does, of the character there.
+func (s source) wordRange(offset int) Range {
+	offset = max(0, min(offset, len(s.text)))
+
+	end := offset
+	for end < len(s.text) {
+		r, size := utf8.DecodeRuneInString(s.text[end:])
+		if !unicode.IsLetter(r) && r != '_' {
+			break
+		}
+		end += size
+	}
+
+	if end == offset && offset < s.lineEnd(offset) {
+		_, size := utf8.DecodeRuneInString(s.text[offset:])
+		end += size
+	}
+
+	return Range{Start: s.position(offset), End: s.position(end)}
+}
+
+// definition returns where the variable named at pos is defined, or nil if it is not
+// a variable the document defines.
+func (d *document) definition(pos Position) *Location {
+	a := d.parsed
+	if a == nil {
+		return nil
+	}
+
+	id := a.identifierAt(a.offset(pos))
+	if id == nil {
+		return nil
+	}
+
+	b := a.info.Refs[id]
+	if b == nil || b.Name == nil {
+		return nil
+	}
+
+	return &Location{URI: d.uri, Range: a.nodeRange(b.Name)}
+}
+
+// hover describes the variable or builtin named at pos, or returns nil if there is
+// none.
+func (d *document) hover(pos Position) *Hover {
+	a := d.parsed
+	if a == nil {
+		return nil
+	}
+
+	id := a.identifierAt(a.offset(pos))
+	if id == nil {
+		return nil
+	}
+
+	var text string
+	if a.info.Builtins[id] {
+		signature, _ := evaluator.BuiltinSignature(id.Value)
+		text = "builtin " + signature
+	} else if b := a.info.Refs[id]; b != nil {
+		text = describe(id.Value, b)
+	} else {
+		return nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```ys\n" + text + "\n```"},
+		Range:    a.nodeRange(id),
+	}
+}
+
+// describe returns a line of Ys-like source saying what a variable is.
+func describe(name string, b *vet.Binding) string {
+	switch b.Kind {
+	case vet.Let:
+		if fn, ok := b.Value.(*ast.FunctionLiteral); ok {
+			return "let " + name + " = " + signature(fn)
+		}
+		return "let " + name
+	case vet.Parameter:
+		return "parameter " + name
+	case vet.LoopVariable:
+		return "loop variable " + name
+	case vet.CatchVariable:
+		return "catch (" + name + ")"
+	case vet.Import:
+		return "import " + name
+	}
+	return "predeclared " + name
+}
+
+// signature returns the first line of a function literal, without its body.
+func signature(fn *ast.FunctionLiteral) string {
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = param.Value
+	}
+	return "fn(" + strings.Join(params, ", ") + ")"
+}
+
+// symbols returns the variables defined with let or import outside any function, each
+// with those defined in its value if that is a function.
+func (d *document) symbols() []DocumentSymbol {
+	if d.parsed == nil {
+		return []DocumentSymbol{}
+	}
+	return d.parsed.symbols(d.parsed.program)
+}
+
+// symbols returns the variables defined in node, not counting those in the functions it
+// holds unless a let binds them.
+func (a *analysis) symbols(node ast.Node) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			symbol := DocumentSymbol{
+				Name:           node.Name.Value,
+				Kind:           SymbolVariable,
+				Range:          a.nodeRange(node),
+				SelectionRange: a.nodeRange(node.Name),
+			}
+			if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+				symbol.Kind = SymbolFunction
+				symbol.Detail = signature(fn)
+				symbol.Children = a.symbols(fn.Body)
+			}
+			symbols = append(symbols, symbol)
+			return false
+
+		case *ast.ImportStatement:
+			if node.Alias != nil {
+				symbols = append(symbols, DocumentSymbol{
+					Name:           node.Alias.Value,
+					Detail:         `"` + node.Path.Value + `"`,
+					Kind:           SymbolModule,
+					Range:          a.nodeRange(node),
+					SelectionRange: a.nodeRange(node.Alias),
+				})
+			}
+			for _, name := range node.Names {
+				symbols = append(symbols, DocumentSymbol{
+					Name:           name.Value,
+					Detail:         `from "` + node.Path.Value + `"`,
+					Kind:           SymbolVariable,
+					Range:          a.nodeRange(node),
+					SelectionRange: a.nodeRange(name),
+				})
+			}
+			return false
+
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+
+	return symbols
+}
+
+// completion returns the keywords, builtins and variables that could complete the
+// identifier before pos.
+func (d *document) completion(pos Position) []CompletionItem {
+	offset := d.offset(pos)
+	prefix := d.wordBefore(offset)
+
+	// The members of modules and hashes are not known until the program runs.
+	if start := offset - len(prefix); start > 0 && d.text[start-1] == '.' {
+		return []CompletionItem{}
+	}
+
+	items := map[string]CompletionItem{}
+
+	for _, keyword := range lexer.Keywords() {
+		items[keyword] = CompletionItem{Label: keyword, Kind: CompletionKeyword}
+	}
+
+	for _, name := range evaluator.BuiltinNames() {
+		signature, _ := evaluator.BuiltinSignature(name)
+		items[name] = CompletionItem{Label: name, Kind: CompletionFunction, Detail: signature}
+	}
+
+	for _, name := range d.predeclaredNames() {
+		items[name] = CompletionItem{Label: name, Kind: CompletionVariable}
+	}
+
+	if a := d.parsed; a != nil {
+		// The text may have changed since it last parsed, but not usually by enough to
+		// move the cursor into another function.
+		for name, item := range a.variablesAt(a.offset(pos)) {
+			items[name] = item
+		}
+	}
+
+	completions := []CompletionItem{}
+	for label, item := range items {
+		if strings.HasPrefix(label, prefix) && label != prefix {
+			completions = append(completions, item)
+		}
+	}
+	sort.Slice(completions, func(i, j int) bool { return completions[i].Label < completions[j].Label })
+
+	return completions
+}
+
+// variablesAt returns the variables that can be used at a byte offset: those defined
+// outside any function, and in the functions around the offset.
+func (a *analysis) variablesAt(offset int) map[string]CompletionItem {
+	items := map[string]CompletionItem{}
+	variable := func(id *ast.Identifier) {
+		items[id.Value] = CompletionItem{Label: id.Value, Kind: CompletionVariable}
+	}
+
+	ast.Inspect(a.program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral:
+			if offset < node.Pos().Offset || offset > node.End().Offset {
+				return false
+			}
+			for _, param := range node.Parameters {
+				variable(param)
+			}
+
+		case *ast.LetStatement:
+			if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+				items[node.Name.Value] = CompletionItem{Label: node.Name.Value, Kind: CompletionFunction, Detail: signature(fn)}
+			} else {
+				variable(node.Name)
+			}
+
+		case *ast.ForInStatement:
+			if node.Key != nil {
+				variable(node.Key)
+			}
+			variable(node.Value)
+
+		case *ast.TryExpression:
+			if node.Param != nil {
+				variable(node.Param)
+			}
+
+		case *ast.ImportStatement:
+			if node.Alias != nil {
+				items[node.Alias.Value] = CompletionItem{Label: node.Alias.Value, Kind: CompletionModule}
+			}
+			for _, name := range node.Names {
+				variable(name)
+			}
+		}
+		return true
+	})
+
+	return items
+}
+
+// formatting returns the edit that formats the document, if it needs one.
+func (d *document) formatting() ([]TextEdit, error) {
+	formatted, err := format.Source(d.uri, []byte(d.text))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(formatted) == d.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{
+		Range:   Range{Start: Position{}, End: d.position(len(d.text))},
+		NewText: string(formatted),
+	}}, nil
+}
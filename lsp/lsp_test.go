@@ -0,0 +1,369 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// client drives a server running in another goroutine, as an editor would.
+type client struct {
+	t      *testing.T
+	conn   *conn
+	nextID int
+	done   chan error // the error the server's Run returned
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &client{t: t, conn: newConn(clientIn, clientOut), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+	}()
+
+	return c
+}
+
+// call sends a request and decodes its result into result, returning the error the
+// server answered with instead, if any.
+func (c *client) call(method string, params, result interface{}) *responseError {
+	c.t.Helper()
+
+	c.nextID++
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+
+	msg := c.read()
+	if msg.ID == nil || string(*msg.ID) != strings.TrimSpace(string(mustMarshal(c.t, c.nextID))) {
+		c.t.Fatalf("%s: expected a response to request %d, got %+v", method, c.nextID, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("%s: decoding result %s: %s", method, msg.Result, err)
+		}
+	}
+	return nil
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// diagnostics reads the diagnostics the server publishes after a document changes.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params
+}
+
+func (c *client) send(msg interface{}) {
+	c.t.Helper()
+	if err := c.conn.write(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() *message {
+	c.t.Helper()
+	msg, err := c.conn.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+const uri = "file:///work/main.ys"
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{line, character}}
+}
+
+func span(startLine, startChar, endLine, endChar int) Range {
+	return Range{Start: Position{startLine, startChar}, End: Position{endLine, endChar}}
+}
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+
+	var init InitializeResult
+	if err := c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &init); err != nil {
+		t.Fatal(err)
+	}
+	if init.Capabilities.TextDocumentSync.Change != SyncIncremental || !init.Capabilities.DefinitionProvider || init.ServerInfo.Name != "ys" {
+		t.Errorf("wrong capabilities. got=%+v", init)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	// A syntax error.
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI: uri, LanguageID: "ys", Version: 1,
+		Text: "let add = fn(a, b) { a + b };\nlet x = add(1;\n",
+	}})
+	diags := c.diagnostics()
+	if len(diags.Diagnostics) == 0 || diags.Diagnostics[0].Severity != SeverityError || diags.Version != 1 {
+		t.Fatalf("expected a syntax error. got=%+v", diags)
+	}
+	if got := diags.Diagnostics[0].Range.Start; got.Line != 1 || got.Character != 13 {
+		t.Errorf("syntax error at the wrong place. got=%+v", diags.Diagnostics[0])
+	}
+
+	// Navigation works on the last version that parsed, which there is none of yet.
+	var loc *Location
+	if err := c.call("textDocument/definition", at(0, 25), &loc); err != nil || loc != nil {
+		t.Errorf("definition before the document parses. got=%+v (err=%v)", loc, err)
+	}
+
+	// Fixing the error, then adding a mistake vet finds.
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Range: &Range{Start: Position{1, 13}, End: Position{1, 14}}, Text: ", 2);"}},
+	})
+	if diags := c.diagnostics(); len(diags.Diagnostics) != 0 || diags.Version != 2 {
+		t.Errorf("expected no diagnostics. got=%+v", diags)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: 3},
+		ContentChanges: []TextDocumentContentChangeEvent{
+			{Range: &Range{Start: Position{2, 0}, End: Position{2, 0}}, Text: "puts(y)\n"},
+			{Range: &Range{Start: Position{2, 5}, End: Position{2, 6}}, Text: "x, why"},
+		},
+	})
+	diags = c.diagnostics()
+	expected := []Diagnostic{{Range: span(2, 8, 2, 11), Severity: SeverityWarning, Code: "undefined", Source: "ys vet", Message: "undefined: why"}}
+	if got, want := mustMarshal(t, diags.Diagnostics), mustMarshal(t, expected); string(got) != string(want) {
+		t.Errorf("wrong diagnostics.\nwant=%s\ngot= %s", want, got)
+	}
+
+	// The text is now:
+	//
+	//	let add = fn(a, b) { a + b };
+	//	let x = add(1, 2);
+	//	puts(x, why)
+
+	definitions := []struct {
+		pos      TextDocumentPositionParams
+		expected *Location
+	}{
+		{at(1, 9), &Location{URI: uri, Range: span(0, 4, 0, 7)}},
+		{at(1, 11), &Location{URI: uri, Range: span(0, 4, 0, 7)}},
+		{at(0, 25), &Location{URI: uri, Range: span(0, 16, 0, 17)}},
+		{at(2, 5), &Location{URI: uri, Range: span(1, 4, 1, 5)}},
+		{at(2, 1), nil},
+		{at(2, 9), nil},
+	}
+	for _, tt := range definitions {
+		var loc *Location
+		if err := c.call("textDocument/definition", tt.pos, &loc); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := mustMarshal(t, loc), mustMarshal(t, tt.expected); string(got) != string(want) {
+			t.Errorf("definition at %+v wrong.\nwant=%s\ngot= %s", tt.pos.Position, want, got)
+		}
+	}
+
+	hovers := []struct {
+		pos      TextDocumentPositionParams
+		expected string
+	}{
+		{at(1, 9), "let add = fn(a, b)"},
+		{at(2, 2), "builtin puts(values...)"},
+		{at(0, 21), "parameter a"},
+		{at(1, 0), ""},
+	}
+	for _, tt := range hovers {
+		var hover *Hover
+		if err := c.call("textDocument/hover", tt.pos, &hover); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if hover != nil {
+			got = strings.TrimSuffix(strings.TrimPrefix(hover.Contents.Value, "```ys\n"), "\n```")
+		}
+		if got != tt.expected {
+			t.Errorf("hover at %+v wrong. want=%q, got=%q", tt.pos.Position, tt.expected, got)
+		}
+	}
+
+	var symbols []DocumentSymbol
+	if err := c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &symbols); err != nil {
+		t.Fatal(err)
+	}
+	expectedSymbols := []DocumentSymbol{
+		{Name: "add", Detail: "fn(a, b)", Kind: SymbolFunction, Range: span(0, 0, 0, 28), SelectionRange: span(0, 4, 0, 7)},
+		{Name: "x", Kind: SymbolVariable, Range: span(1, 0, 1, 17), SelectionRange: span(1, 4, 1, 5)},
+	}
+	if got, want := mustMarshal(t, symbols), mustMarshal(t, expectedSymbols); string(got) != string(want) {
+		t.Errorf("wrong symbols.\nwant=%s\ngot= %s", want, got)
+	}
+
+	// Breaking the document again leaves navigation working on the version before.
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 4},
+		ContentChanges: []TextDocumentContentChangeEvent{{Range: &Range{Start: Position{2, 12}, End: Position{2, 12}}, Text: "\nlet f = fn(n) { le"}},
+	})
+	if diags := c.diagnostics(); len(diags.Diagnostics) == 0 || diags.Diagnostics[0].Severity != SeverityError {
+		t.Errorf("expected a syntax error. got=%+v", diags)
+	}
+	if err := c.call("textDocument/definition", at(1, 9), &loc); err != nil || loc == nil || loc.Range != span(0, 4, 0, 7) {
+		t.Errorf("definition in a broken document wrong. got=%+v (err=%v)", loc, err)
+	}
+
+	completions := []struct {
+		pos      TextDocumentPositionParams
+		expected []string
+	}{
+		{at(3, 18), []string{"len", "let"}},
+		{at(2, 2), []string{"push", "puts"}},
+	}
+	for _, tt := range completions {
+		var items []CompletionItem
+		if err := c.call("textDocument/completion", tt.pos, &items); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		if strings.Join(labels, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("completion at %+v wrong. want=%q, got=%q", tt.pos.Position, tt.expected, labels)
+		}
+	}
+
+	var edits []TextEdit
+	if err := c.call("textDocument/formatting", DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &edits); err == nil || !strings.Contains(err.Message, uri+":5:1: expected }") {
+		t.Errorf("formatting a broken document should fail. got=%+v", err)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 5},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let  f = fn(n){ n }\nf( 1 )"}},
+	})
+	c.diagnostics()
+
+	if err := c.call("textDocument/formatting", DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &edits); err != nil {
+		t.Fatal(err)
+	}
+	expectedEdits := []TextEdit{{Range: span(0, 0, 1, 6), NewText: "let f = fn(n) { n };\nf(1);\n"}}
+	if got, want := mustMarshal(t, edits), mustMarshal(t, expectedEdits); string(got) != string(want) {
+		t.Errorf("wrong formatting edits.\nwant=%s\ngot= %s", want, got)
+	}
+
+	// Completion inside a function offers its parameters, and outside it does not.
+	var items []CompletionItem
+	if err := c.call("textDocument/completion", at(0, 16), &items); err != nil {
+		t.Fatal(err)
+	}
+	if !hasLabel(items, "n") || !hasLabel(items, "f") || !hasLabel(items, "args") || !hasLabel(items, "while") {
+		t.Errorf("completion inside a function is missing names. got=%+v", items)
+	}
+	if err := c.call("textDocument/completion", at(1, 0), &items); err != nil {
+		t.Fatal(err)
+	}
+	if hasLabel(items, "n") || !hasLabel(items, "f") {
+		t.Errorf("completion outside a function wrong. got=%+v", items)
+	}
+
+	if err := c.call("textDocument/rename", at(0, 0), nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("expected method not found. got=%+v", err)
+	}
+	if err := c.call("textDocument/hover", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: "file:///other.ys"}}, nil); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("expected invalid params for a document that is not open. got=%+v", err)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if diags := c.diagnostics(); len(diags.Diagnostics) != 0 {
+		t.Errorf("closing a document should clear its diagnostics. got=%+v", diags)
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call("textDocument/hover", at(0, 0), nil); err == nil || err.Code != codeInvalidRequest {
+		t.Errorf("expected requests after shutdown to fail. got=%+v", err)
+	}
+	c.notify("exit", nil)
+
+	if err := <-c.done; err != nil {
+		t.Errorf("Run returned an error: %s", err)
+	}
+}
+
+func hasLabel(items []CompletionItem, label string) bool {
+	for _, item := range items {
+		if item.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	c.notify("exit", nil)
+
+	if err := <-c.done; err != errExitWithoutShutdown {
+		t.Errorf("wrong error. want=%v, got=%v", errExitWithoutShutdown, err)
+	}
+}
+
+func TestSourcePositions(t *testing.T) {
+	// é is two bytes and one UTF-16 unit; 𝄞 is four bytes and two units.
+	s := newSource("aé𝄞b\nxy")
+
+	tests := []struct {
+		pos    Position
+		offset int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 1}, 1},
+		{Position{0, 2}, 3},
+		{Position{0, 4}, 7},
+		{Position{0, 5}, 8},
+		{Position{1, 1}, 10},
+		{Position{1, 2}, 11},
+	}
+
+	for _, tt := range tests {
+		if got := s.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset(%+v) wrong. want=%d, got=%d", tt.pos, tt.offset, got)
+		}
+		if got := s.position(tt.offset); got != tt.pos {
+			t.Errorf("position(%d) wrong. want=%+v, got=%+v", tt.offset, tt.pos, got)
+		}
+	}
+
+	// Positions past the end of a line or of the text are moved back onto it.
+	if got := s.offset(Position{0, 99}); got != 8 {
+		t.Errorf("offset past the end of a line wrong. got=%d", got)
+	}
+	if got := s.offset(Position{5, 0}); got != 11 {
+		t.Errorf("offset past the end of the text wrong. got=%d", got)
+	}
+}
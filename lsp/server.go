@@ -0,0 +1,256 @@
+// File: lsp/server.go
+
+// Package lsp is a Language Server Protocol server for Ys, which gives editors
+// diagnostics, go-to-definition, hover, document symbols, completion and formatting
+// for the Ys files open in them. It speaks JSON-RPC over a pair of streams, usually
+// the standard input and output of `ys lsp`.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Server serves one editor.
+type Server struct {
+	conn      *conn
+	documents map[string]*document
+
+	shutdown bool // the editor has asked the server to shut down
+}
+
+// NewServer returns a server that reads messages from in and writes them to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{conn: newConn(in, out), documents: map[string]*document{}}
+}
+
+// errExitWithoutShutdown is returned by Run when the editor asks the server to exit
+// before asking it to shut down, which the protocol says is an error.
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Run serves requests until the editor asks the server to exit, or the input ends.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+
+		var rpcErr *responseError
+		if errors.As(err, &rpcErr) {
+			if err := s.conn.write(errorResponse{JSONRPC: "2.0", Error: rpcErr}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request or notification, and answers a request.
+func (s *Server) handle(msg *message) error {
+	result, err := s.dispatch(msg)
+
+	// Notifications are not answered, even when they fail, but the only errors other
+	// than protocol errors that handling one returns are those writing to the editor.
+	if msg.ID == nil {
+		var rpcErr *responseError
+		if err != nil && !errors.As(err, &rpcErr) {
+			return err
+		}
+		return nil
+	}
+
+	if err != nil {
+		rpcErr, ok := err.(*responseError)
+		if !ok {
+			rpcErr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		return s.conn.write(errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr})
+	}
+
+	return s.conn.write(response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+// dispatch calls the handler for a message's method, with its params decoded.
+func (s *Server) dispatch(msg *message) (result interface{}, err error) {
+	// A bug in a handler fails its request rather than the server.
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, &responseError{Code: codeInternalError, Message: fmt.Sprint("internal error: ", r)}
+		}
+	}()
+
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+
+	case "initialized", "textDocument/didSave":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didOpen(params)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didChange(params)
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.didClose(params)
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return s.withDocument(params.TextDocument.URI, func(d *document) (interface{}, error) {
+			return d.definition(params.Position), nil
+		})
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return s.withDocument(params.TextDocument.URI, func(d *document) (interface{}, error) {
+			return d.hover(params.Position), nil
+		})
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return s.withDocument(params.TextDocument.URI, func(d *document) (interface{}, error) {
+			return d.symbols(), nil
+		})
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return s.withDocument(params.TextDocument.URI, func(d *document) (interface{}, error) {
+			return d.completion(params.Position), nil
+		})
+
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return s.withDocument(params.TextDocument.URI, func(d *document) (interface{}, error) {
+			return d.formatting()
+		})
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// decode decodes the params of a message into v.
+func decode(msg *message, v interface{}) error {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// withDocument calls f with the open document at uri.
+func (s *Server) withDocument(uri string, f func(d *document) (interface{}, error)) (interface{}, error) {
+	d, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "document not open: " + uri}
+	}
+	return f(d)
+}
+
+func (s *Server) initialize() InitializeResult {
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           TextDocumentSyncOptions{OpenClose: true, Change: SyncIncremental},
+			DefinitionProvider:         true,
+			HoverProvider:              true,
+			DocumentSymbolProvider:     true,
+			CompletionProvider:         CompletionOptions{},
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "ys"},
+	}
+}
+
+func (s *Server) didOpen(params DidOpenTextDocumentParams) error {
+	d := &document{uri: params.TextDocument.URI, version: params.TextDocument.Version}
+	d.setText(params.TextDocument.Text)
+	s.documents[d.uri] = d
+
+	return s.publishDiagnostics(d)
+}
+
+func (s *Server) didChange(params DidChangeTextDocumentParams) error {
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+
+	d.version = params.TextDocument.Version
+	d.apply(params.ContentChanges)
+
+	return s.publishDiagnostics(d)
+}
+
+func (s *Server) didClose(params DidCloseTextDocumentParams) error {
+	d, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	delete(s.documents, d.uri)
+
+	// The editor keeps showing a closed document's diagnostics until they are cleared.
+	return s.conn.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: []Diagnostic{}},
+	})
+}
+
+func (s *Server) publishDiagnostics(d *document) error {
+	return s.conn.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: d.diagnostics()},
+	})
+}
@@ -1,5 +1,7 @@
 package object
 
+import "sort"
+
 func NewClosureEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -30,3 +32,50 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates an existing binding in the innermost environment that defines name,
+// walking outwards. It reports false, changing nothing, if name is not defined anywhere.
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return val, true
+		}
+	}
+
+	return nil, false
+}
+
+// Names returns the names bound in this environment and the ones enclosing it, sorted.
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+
+	for env := e; env != nil; env = env.outer {
+		for name := range env.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Outer returns the environment this one is enclosed by, or nil if it is outermost.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// LocalNames returns the names bound in this environment itself, sorted.
+func (e *Environment) LocalNames() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
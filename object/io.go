@@ -0,0 +1,35 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// IO is the streams a program reads from and writes to through its builtins, which the
+// host running the program provides.
+type IO struct {
+	In  *bufio.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+// NewIO returns an IO that reads from in and writes to out and errOut. A host that reads
+// from in itself should pass a *bufio.Reader and read through it, since a program
+// reading from the IO buffers what it reads.
+func NewIO(in io.Reader, out, errOut io.Writer) *IO {
+	reader, ok := in.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(in)
+	}
+
+	return &IO{In: reader, Out: out, Err: errOut}
+}
+
+var standardIO = NewIO(os.Stdin, os.Stdout, os.Stderr)
+
+// StandardIO returns the IO for the process's standard streams, which programs use
+// unless their host gives them another.
+func StandardIO() *IO {
+	return standardIO
+}
@@ -3,21 +3,33 @@ package object
 import (
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/code"
+	"github.com/shafik23/ys/token"
 )
 
 const (
 	NULL_OBJ         = "NULL"
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	MODULE_OBJ       = "MODULE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 //////////////////////////////////////////////////
@@ -41,6 +53,45 @@ func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
 //////////////////////////////////////////////////
 
+// BigInteger is an integer too large for an Integer. It is an INTEGER to the language:
+// arithmetic promotes to it on overflow, and NewInteger demotes results that fit again,
+// so a value only ever has one representation.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Inspect() string { return bi.Value.String() }
+
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+
+// NewInteger returns value as an Integer if it fits in an int64, or a BigInteger otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+//////////////////////////////////////////////////
+
+type Float struct {
+	Value float64
+}
+
+// Inspect prints the shortest representation that reads back as the same float,
+// always with a decimal point or exponent so that it is not mistaken for an integer.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+//////////////////////////////////////////////////
+
 type Boolean struct {
 	Value bool
 }
@@ -69,17 +120,100 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
 //////////////////////////////////////////////////
 
+// Break signals that the innermost loop should stop, like ReturnValue does for functions.
+type Break struct{}
+
+func (b *Break) Inspect() string { return "break" }
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+//////////////////////////////////////////////////
+
+// Continue signals that the innermost loop should move on to its next iteration.
+type Continue struct{}
+
+func (c *Continue) Inspect() string { return "continue" }
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+//////////////////////////////////////////////////
+
 type Error struct {
 	Message string
+	Kind    string       // what kind of error it is; empty for errors raised by the runtime
+	Value   Object       // the value thrown, for an error raised by a throw statement
+	Pos     token.Pos    // where the error happened, if known
+	Stack   []StackFrame // the calls in progress when it happened, innermost first
 }
 
-func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
+// Error lets the VM hand a runtime error back through a Go error return.
+func (e *Error) Error() string { return e.Message }
+
+// Kinds of error, as a caught error reports them.
+const (
+	RuntimeErrorKind = "RuntimeError" // raised by the runtime, such as a type mismatch
+	ThrownErrorKind  = "Error"        // raised by a throw statement that did not give a kind
+	LimitErrorKind   = "LimitError"   // raised when a limit set by the host is hit; never caught
+)
+
+// maxTraceFrames is how many frames StackTrace prints before eliding the middle
+// of a trace, which keeps a runaway recursion from printing thousands of lines.
+const maxTraceFrames = 20
+
+// StackTrace renders the error's stack, one indented frame per line, innermost first.
+func (e *Error) StackTrace() string {
+	var out strings.Builder
+
+	for i, frame := range e.Stack {
+		if len(e.Stack) > maxTraceFrames && i >= maxTraceFrames/2 && i < len(e.Stack)-maxTraceFrames/2 {
+			if i == maxTraceFrames/2 {
+				fmt.Fprintf(&out, "    ... %d more frames ...\n", len(e.Stack)-maxTraceFrames)
+			}
+			continue
+		}
+		out.WriteString("    " + frame.String() + "\n")
+	}
+
+	return out.String()
+}
+
+// Names that stack frames use for code that is not in a named function.
+const (
+	MainFunction      = "<main>"      // the top level of a program
+	AnonymousFunction = "<anonymous>" // a function literal not bound by a let statement
+)
+
+// ModuleFunction is the name stack frames give to the top level of the named module.
+func ModuleFunction(module string) string {
+	return "<module " + module + ">"
+}
+
+// StackFrame is one call in a stack trace: the function running and where it was.
+type StackFrame struct {
+	Function string
+	Pos      token.Pos
+}
+
+func (sf StackFrame) String() string {
+	if !sf.Pos.IsValid() {
+		return "at " + sf.Function
+	}
+	return "at " + sf.Function + " (" + sf.Pos.String() + ")"
+}
+
 //////////////////////////////////////////////////
 
 type Function struct {
+	Name       string // the name it was bound to by a let statement, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -93,6 +227,88 @@ func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 
 //////////////////////////////////////////////////
 
+// CompiledFunction is a function literal lowered to bytecode by the compiler.
+// Parameters and Body are kept from the source so it can be inspected like a Function.
+type CompiledFunction struct {
+	Name          string // the name it was bound to by a let statement, if any
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Parameters    []*ast.Identifier
+	Body          *ast.BlockStatement
+	Positions     map[int]token.Pos // source positions of instructions that can fail, by offset
+	LocalNames    []string          // the names of the locals, by slot
+	FreeNames     []string          // the names of the free variables, by index
+}
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("fn(%s) {\n%s\n}", cf.Parameters, cf.Body.String())
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+//////////////////////////////////////////////////
+
+// Closure pairs a CompiledFunction with the free variables it captured when it was created,
+// and the Unit it was compiled in.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+	Unit *Unit
+}
+
+func (c *Closure) Inspect() string { return c.Fn.Inspect() }
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+
+// Unit is what the closures of one compiled program share: its constant pool and its
+// global variables. A closure imported from a module keeps using its module's unit
+// wherever it is called.
+type Unit struct {
+	Constants   []Object
+	Globals     []Object
+	GlobalNames []string // the names of the globals, by index
+}
+
+//////////////////////////////////////////////////
+
+// Module is a file loaded by an import statement. Its members are the names defined
+// at its top level.
+type Module struct {
+	Name string // the name it is imported as by default: its file name without the extension
+	Path string // the file it was loaded from
+	Env  *Environment
+
+	// Unit is set for a module the VM ran, whose members are the globals of its unit
+	// rather than the names bound in Env.
+	Unit *Unit
+}
+
+// Member returns the value of the member called name. The members of a module the VM
+// ran are read from its globals, so that the module's own functions can change them.
+func (m *Module) Member(name string) (Object, bool) {
+	if m.Unit == nil {
+		return m.Env.Get(name)
+	}
+
+	// Globals named with an @ are the compiler's own, not the module's.
+	if strings.HasPrefix(name, "@") {
+		return nil, false
+	}
+	for i, global := range m.Unit.GlobalNames {
+		if global == name && m.Unit.Globals[i] != nil {
+			return m.Unit.Globals[i], true
+		}
+	}
+	return nil, false
+}
+
+func (m *Module) Inspect() string { return "<module " + m.Path + ">" }
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+
+//////////////////////////////////////////////////
+
 type String struct {
 	Value string
 }
@@ -105,8 +321,39 @@ func (s *String) Type() ObjectType { return STRING_OBJ }
 
 type BuiltinFunction func(args ...Object) Object
 
+// Runtime is what a builtin can use of the program calling it.
+type Runtime interface {
+	// Call calls a function of the program, as a call expression would, returning its
+	// result or the error it raised.
+	Call(fn Object, args ...Object) Object
+
+	// Reserve checks that the program may create n more elements of arrays and hashes,
+	// returning the error for a limit that would exceed. A builtin that creates many
+	// elements from few arguments reserves them before creating them.
+	Reserve(n int64) *Error
+}
+
 type Builtin struct {
 	Fn BuiltinFunction
+
+	// IOFn is set instead of Fn for a builtin that uses the streams of the program
+	// calling it.
+	IOFn func(streams *IO, args ...Object) Object
+
+	// RuntimeFn is set instead of Fn for a builtin that calls the functions it is given,
+	// or reserves what it creates, with rt.
+	RuntimeFn func(rt Runtime, args ...Object) Object
+}
+
+// Call calls the builtin on behalf of a program that uses streams, and that rt runs.
+func (b *Builtin) Call(streams *IO, rt Runtime, args ...Object) Object {
+	switch {
+	case b.IOFn != nil:
+		return b.IOFn(streams, args...)
+	case b.RuntimeFn != nil:
+		return b.RuntimeFn(rt, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Inspect() string { return "builtin function" }
@@ -120,19 +367,81 @@ type Array struct {
 }
 
 func (ao *Array) Inspect() string {
-	var out string
-	for i, e := range ao.Elements {
-		if i == 0 {
-			out += "["
+	return inspect(ao)
+}
+
+// inspect formats an array or hash as Inspect does. An array or hash that holds itself,
+// however deeply, is shown as [...] or {...} where it recurs, as it would otherwise be
+// printed for ever. What is left to write is kept on a stack of its own rather than
+// Go's, so that values nested however deeply can be printed, in time proportional to
+// what is printed.
+func inspect(obj Object) string {
+	// inspectTask is one thing left to write: an object, text to write as it is, or,
+	// once an array or hash has been written, leaving it.
+	type inspectTask struct {
+		obj   Object
+		text  string
+		leave Object
+	}
+
+	var out strings.Builder
+	enclosing := map[Object]bool{} // the arrays and hashes being written
+	work := []inspectTask{{obj: obj}}
+
+	for len(work) > 0 {
+		task := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		switch {
+		case task.leave != nil:
+			delete(enclosing, task.leave)
+			continue
+		case task.obj == nil:
+			out.WriteString(task.text)
+			continue
 		}
-		out += e.Inspect()
-		if i != len(ao.Elements)-1 {
-			out += ", "
-		} else {
-			out += "]"
+
+		switch obj := task.obj.(type) {
+		case *Array:
+			if enclosing[obj] {
+				out.WriteString("[...]")
+				continue
+			}
+			enclosing[obj] = true
+
+			// The stack is written from its end, so the elements go on it last first.
+			out.WriteString("[")
+			work = append(work, inspectTask{leave: obj}, inspectTask{text: "]"})
+			for i := len(obj.Elements) - 1; i >= 0; i-- {
+				work = append(work, inspectTask{obj: obj.Elements[i]})
+				if i > 0 {
+					work = append(work, inspectTask{text: ", "})
+				}
+			}
+
+		case *Hash:
+			if enclosing[obj] {
+				out.WriteString("{...}")
+				continue
+			}
+			enclosing[obj] = true
+
+			out.WriteString("{")
+			work = append(work, inspectTask{leave: obj}, inspectTask{text: "}"})
+			pairs := obj.OrderedPairs()
+			for i := len(pairs) - 1; i >= 0; i-- {
+				work = append(work, inspectTask{obj: pairs[i].Value}, inspectTask{text: ": "}, inspectTask{obj: pairs[i].Key})
+				if i > 0 {
+					work = append(work, inspectTask{text: ", "})
+				}
+			}
+
+		default:
+			out.WriteString(obj.Inspect())
 		}
 	}
-	return out
+
+	return out.String()
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -158,6 +467,30 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a big integer never collides with that of an Integer, since no
+// BigInteger holds a value that fits in one.
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+
+	return HashKey{Type: "BIG_" + bi.Type(), Value: h.Sum64()}
+}
+
+// HashKey of a float with an integral value is that of the equal integer,
+// since the two compare equal.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+
+		integer, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: integer}).HashKey()
+	}
+
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -172,18 +505,104 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values. It keeps its pairs in the order their keys were first set,
+// which is the order they are printed and iterated in. Pairs should be changed through
+// Set and Delete; pairs written to the map directly, as by a composite literal, come
+// after the others, in an order that is stable but otherwise arbitrary.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	keys  []HashKey // the keys of Pairs, in the order they were set
 }
 
-func (h *Hash) Inspect() string {
-	parts := make([]string, 0, len(h.Pairs))
+// NewHash returns an empty hash.
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
 
-	for _, pair := range h.Pairs {
-		parts = append(parts, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+// Set stores value under key. A key that is already in the hash keeps its place.
+func (h *Hash) Set(key Hashable, value Object) {
+	if h.Pairs == nil {
+		h.Pairs = make(map[HashKey]HashPair)
 	}
 
-	return fmt.Sprintf("{%s}", strings.Join(parts, ", "))
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		if len(h.keys) != len(h.Pairs) {
+			h.reconcile()
+		}
+		h.keys = append(h.keys, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Delete removes key from the hash, if it is there.
+func (h *Hash) Delete(key Hashable) {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		return
+	}
+
+	delete(h.Pairs, hashKey)
+	for i, k := range h.keys {
+		if k == hashKey {
+			h.keys = append(h.keys[:i], h.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// OrderedPairs returns the pairs of the hash in the order their keys were first set.
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := h.orderedPairs()
+	if len(pairs) != len(h.Pairs) || len(pairs) != len(h.keys) {
+		h.reconcile()
+		pairs = h.orderedPairs()
+	}
+	return pairs
+}
+
+func (h *Hash) orderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.keys))
+	for _, key := range h.keys {
+		if pair, ok := h.Pairs[key]; ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// reconcile brings keys back in line with Pairs after the map was changed directly:
+// keys no longer in the map, or recorded twice, are dropped, and keys missing from the
+// order are added after the others, sorted so that they keep one order from then on.
+func (h *Hash) reconcile() {
+	keys := make([]HashKey, 0, len(h.Pairs))
+	seen := make(map[HashKey]bool, len(h.Pairs))
+	for _, key := range h.keys {
+		if _, ok := h.Pairs[key]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	missing := len(keys)
+	for key := range h.Pairs {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys[missing:], func(i, j int) bool {
+		a, b := keys[missing+i], keys[missing+j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+
+	h.keys = keys
+}
+
+func (h *Hash) Inspect() string {
+	return inspect(h)
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -191,5 +610,6 @@ func (h *Hash) Type() ObjectType { return HASH_OBJ }
 //////////////////////////////////////////////////
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
@@ -1,6 +1,76 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/token"
+)
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() != (&Float{Value: 1.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float and equal integer have different hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestNewIntegerDemotes(t *testing.T) {
+	small := NewInteger(big.NewInt(42))
+	if integer, ok := small.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("small value not demoted to Integer. got=%T (%+v)", small, small)
+	}
+
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	if _, ok := NewInteger(huge).(*BigInteger); !ok {
+		t.Errorf("huge value not kept as BigInteger. got=%T", NewInteger(huge))
+	}
+}
+
+func TestBigIntegerHashKey(t *testing.T) {
+	a, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	b, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	c, _ := new(big.Int).SetString("123456789012345678901234567891", 10)
+
+	if (&BigInteger{Value: a}).HashKey() != (&BigInteger{Value: b}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+
+	if (&BigInteger{Value: a}).HashKey() == (&BigInteger{Value: c}).HashKey() {
+		t.Errorf("big integers with different values have same hash keys")
+	}
+
+	if (&BigInteger{Value: a}).Type() != INTEGER_OBJ || (&BigInteger{Value: a}).Inspect() != "123456789012345678901234567890" {
+		t.Errorf("big integer does not look like an integer")
+	}
+}
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -20,3 +90,142 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestInspectCycles(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	array.Elements[0] = array
+
+	hash := NewHash()
+	hash.Set(&String{Value: "self"}, hash)
+	hash.Set(&String{Value: "array"}, &Array{Elements: []Object{array, hash}})
+
+	shared := &Array{Elements: []Object{&Integer{Value: 1}}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[[...]]"},
+		{hash, "{self: {...}, array: [[[...]], {...}]}"},
+		{&Array{Elements: []Object{shared, shared}}, "[[1], [1]]"},
+		{&Array{}, "[]"},
+		{NewHash(), "{}"},
+		{&Array{Elements: []Object{&Array{}, NewHash()}}, "[[], {}]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestInspectDeepNesting(t *testing.T) {
+	// Deep enough that going over the enclosing arrays again for each one would take
+	// minutes.
+	const depth = 200000
+
+	var obj Object = &Array{}
+	for i := 0; i < depth; i++ {
+		obj = &Array{Elements: []Object{obj}}
+	}
+
+	expected := strings.Repeat("[", depth+1) + strings.Repeat("]", depth+1)
+	if got := obj.Inspect(); got != expected {
+		t.Errorf("wrong Inspect of an array nested %d deep", depth)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	h := NewHash()
+	for _, key := range []string{"c", "a", "b"} {
+		h.Set(&String{Value: key}, &Integer{Value: int64(len(h.Pairs))})
+	}
+	h.Set(&String{Value: "a"}, &Integer{Value: 9})
+
+	expected := "{c: 0, a: 9, b: 2}"
+	if got := h.Inspect(); got != expected {
+		t.Errorf("wrong Inspect. expected=%q, got=%q", expected, got)
+	}
+
+	// A deleted key that is set again goes to the end.
+	h.Delete(&String{Value: "c"})
+	h.Set(&String{Value: "c"}, &Integer{Value: 3})
+
+	expected = "{a: 9, b: 2, c: 3}"
+	if got := h.Inspect(); got != expected {
+		t.Errorf("wrong Inspect. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestHashPairsWrittenDirectly(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+
+	// A hash built as a literal has no recorded order, but still prints and iterates its pairs.
+	h := &Hash{Pairs: map[HashKey]HashPair{
+		two.HashKey(): {Key: two, Value: two},
+		one.HashKey(): {Key: one, Value: one},
+	}}
+	if got := h.Inspect(); got != "{1: 1, 2: 2}" {
+		t.Errorf("wrong Inspect. expected=%q, got=%q", "{1: 1, 2: 2}", got)
+	}
+
+	// A key deleted from the map and set again is only listed once.
+	delete(h.Pairs, one.HashKey())
+	h.Set(one, one)
+	if got := h.Inspect(); got != "{2: 2, 1: 1}" {
+		t.Errorf("wrong Inspect. expected=%q, got=%q", "{2: 2, 1: 1}", got)
+	}
+	if got := len(h.OrderedPairs()); got != 2 {
+		t.Errorf("wrong number of pairs. expected=2, got=%d", got)
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	err := &Error{Message: "boom", Stack: []StackFrame{
+		{Function: "f", Pos: token.Pos{Line: 2, Column: 3}},
+		{Function: MainFunction, Pos: token.Pos{Line: 5, Column: 1}},
+	}}
+
+	expected := "    at f (2:3)\n    at <main> (5:1)\n"
+	if got := err.StackTrace(); got != expected {
+		t.Errorf("wrong stack trace. expected=%q, got=%q", expected, got)
+	}
+
+	// A deep stack keeps its ends and elides the middle.
+	err.Stack = nil
+	for i := 0; i < 100; i++ {
+		err.Stack = append(err.Stack, StackFrame{Function: "f", Pos: token.Pos{Line: 1, Column: 1}})
+	}
+
+	lines := strings.Split(strings.TrimSuffix(err.StackTrace(), "\n"), "\n")
+	if len(lines) != maxTraceFrames+1 {
+		t.Fatalf("wrong number of lines. expected=%d, got=%d", maxTraceFrames+1, len(lines))
+	}
+	if lines[maxTraceFrames/2] != "    ... 80 more frames ..." {
+		t.Errorf("wrong elision line. got=%q", lines[maxTraceFrames/2])
+	}
+}
+
+func TestEnvironmentChain(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("b", &Integer{Value: 1})
+	outer.Set("a", &Integer{Value: 2})
+
+	inner := NewClosureEnvironment(outer)
+	inner.Set("c", &Integer{Value: 3})
+
+	if inner.Outer() != outer || outer.Outer() != nil {
+		t.Errorf("wrong outer environments")
+	}
+
+	if got := strings.Join(inner.LocalNames(), " "); got != "c" {
+		t.Errorf("wrong local names of the inner environment. got=%q", got)
+	}
+	if got := strings.Join(outer.LocalNames(), " "); got != "a b" {
+		t.Errorf("wrong local names of the outer environment. got=%q", got)
+	}
+	if got := strings.Join(inner.Names(), " "); got != "a b c" {
+		t.Errorf("wrong names. got=%q", got)
+	}
+}
@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"path"
 	"strconv"
+	"strings"
 
 	"github.com/shafik23/ys/ast"
 	"github.com/shafik23/ys/lexer"
@@ -12,8 +16,11 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // > or < or >= or <=
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -22,16 +29,27 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
 }
 
 type prefixParseFn func() ast.Expression
@@ -46,7 +64,11 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn // map of prefix parse functions
 	infixParseFns  map[token.TokenType]infixParseFn  // map of infix parse functions
 
-	errors []string
+	errors   []string
+	details  []*Error       // the errors, with their positions
+	comments []*ast.Comment // the comments read so far, which the tree leaves out
+
+	loopDepth int // how many loops enclose the current statement, for break and continue
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -56,10 +78,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn) // initialize the map
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
@@ -81,11 +105,23 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+
 	// Read two tokens, so curToken and peekToken are both set.
 	p.nextToken()
 	p.nextToken()
@@ -104,12 +140,27 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 		return nil
 	}
 
+	expression.Rbracket = p.curToken // remember the closing bracket
+
+	return expression
+}
+
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	expression := &ast.MemberExpression{Token: p.curToken, Object: left} // create a new member expression node and set its token and object fields
+
+	if !p.expectPeek(token.IDENT) { // if the next token is not an identifier
+		return nil
+	}
+
+	expression.Member = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal} // the name of the member
+
 	return expression
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}          // create a new array literal node and set its token field
 	array.Elements = p.parseExpressionList(token.RBRACKET) // parse the array elements
+	array.Rbracket = p.curToken                            // remember the closing bracket
 	return array
 }
 
@@ -141,6 +192,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	// create a new call expression node and set its token and function fields
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN) // parse the call arguments
+	exp.Rparen = p.curToken                             // remember the closing parenthesis
 	return exp
 }
 
@@ -152,12 +204,16 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	lit.Parameters = p.parseFunctionParameters() // parse the function parameters
+	lit.Rparen = p.curToken                      // remember the closing parenthesis
 
 	if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
 		return nil
 	}
 
+	loopDepth := p.loopDepth // a function body starts outside of any loop
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement() // parse the function body
+	p.loopDepth = loopDepth
 
 	return lit
 }
@@ -203,6 +259,11 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	for p.peekToken.Type == token.COMMENT { // comments are kept aside, not parsed
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken, Text: p.peekToken.Literal})
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -217,6 +278,8 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken() // advance the tokens
 	}
 
+	program.Comments = p.comments
+
 	return program
 }
 
@@ -226,6 +289,16 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement() // parse it
 	case token.RETURN: // if it is a return statement
 		return p.parseReturnStatement() // parse it
+	case token.WHILE: // if it is a while loop
+		return p.parseWhileStatement() // parse it
+	case token.FOR: // if it is a for loop
+		return p.parseForStatement() // parse it
+	case token.BREAK, token.CONTINUE: // if it is a loop control statement
+		return p.parseLoopControlStatement() // parse it
+	case token.THROW: // if it is a throw statement
+		return p.parseThrowStatement() // parse it
+	case token.IMPORT, token.FROM: // if it is an import statement
+		return p.parseImportStatement() // parse it
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -247,8 +320,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()                          // advance the tokens
 	stmt.Value = p.parseExpression(LOWEST) // parse the value
 
-	// Skip the expressions until we encounter a semicolon
-	for !p.curTokenIs(token.SEMICOLON) { // loop until we reach the end of the statement
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok { // if the value is a function literal
+		fl.Name = stmt.Name.Value // remember the name it is bound to
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) { // the semicolon is optional
 		p.nextToken() // advance the tokens
 	}
 
@@ -277,9 +353,30 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Error is a syntax error, at the position in the source where it was found.
+type Error struct {
+	Pos token.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList returns the errors Errors does, before they are formatted with their positions.
+func (p *Parser) ErrorList() []*Error {
+	return p.details
+}
+
 func (p *Parser) peekErrors(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type) // create an error message
-	p.errors = append(p.errors, msg)                                                        // append it to the errors slice
+	p.errorf(p.peekToken.Pos, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+// errorf records an error message prefixed with the file:line:col it refers to.
+func (p *Parser) errorf(pos token.Pos, format string, a ...interface{}) {
+	err := &Error{Pos: pos, Msg: fmt.Sprintf(format, a...)} // create an error
+	p.errors = append(p.errors, err.Error())                // append its message to the errors slice
+	p.details = append(p.details, err)
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
@@ -289,14 +386,86 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST) // parse the return value
 
-	// Skip the expressions until we encounter a semicolon
-	for !p.curTokenIs(token.SEMICOLON) { // loop until we reach the end of the statement
+	if p.peekTokenIs(token.SEMICOLON) { // the semicolon is optional
 		p.nextToken() // advance the tokens
 	}
 
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken} // create a new throw statement node and set its token field
+
+	p.nextToken() // advance the tokens
+
+	stmt.Value = p.parseExpression(LOWEST) // parse the thrown value
+
+	if p.peekTokenIs(token.SEMICOLON) { // the semicolon is optional
+		p.nextToken() // advance the tokens
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken} // create a new import statement node and set its token field
+	selective := p.curTokenIs(token.FROM)           // `from "path" import a, b` rather than `import "path"`
+
+	if !p.expectPeek(token.STRING) { // if the next token is not the path
+		return nil
+	}
+
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal} // the file to import
+
+	if selective {
+		if !p.expectPeek(token.IMPORT) { // if the next token is not the import keyword
+			return nil
+		}
+
+		for { // parse the comma separated names
+			if !p.expectPeek(token.IDENT) { // if the next token is not a name
+				return nil
+			}
+
+			stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+			if !p.peekTokenIs(token.COMMA) { // if there are no more names
+				break
+			}
+			p.nextToken() // advance past the comma
+		}
+	} else if p.peekTokenIs(token.AS) { // if the module is given a name
+		p.nextToken() // advance the tokens
+
+		if !p.expectPeek(token.IDENT) { // if the next token is not an identifier
+			return nil
+		}
+
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	} else { // otherwise the module is named after its file
+		name := strings.TrimSuffix(path.Base(stmt.Path.Value), path.Ext(stmt.Path.Value))
+		if !isIdentifier(name) {
+			p.errorf(stmt.Path.Pos(), "cannot name module %q after its file, import it with `as name`", stmt.Path.Value)
+			return nil
+		}
+
+		stmt.Alias = &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Pos: stmt.Path.Pos(), End: stmt.Path.End()}, Value: name}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) { // the semicolon is optional
+		p.nextToken() // advance the tokens
+	}
+
+	return stmt
+}
+
+// isIdentifier reports whether name would lex as a single identifier.
+func isIdentifier(name string) bool {
+	l := lexer.New(name)
+	tok := l.NextToken()
+	return tok.Type == token.IDENT && tok.Literal == name
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn // register a prefix parse function for a given token type
 }
@@ -347,10 +516,30 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64) // parse the integer literal
 
+	if errors.Is(err, strconv.ErrRange) { // too large for an int64, so keep it as a big integer
+		if lit.Big, _ = new(big.Int).SetString(p.curToken.Literal, 0); lit.Big != nil {
+			return lit
+		}
+	}
+
 	if err != nil { // if there was an error
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal) // create an error message
-		p.errors = append(p.errors, msg)                                        // append it to the errors slice
-		return nil                                                              // return nil
+		p.errorf(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value // set the value field
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken} // create a new float literal node and set its token field
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64) // parse the float literal
+
+	if err != nil { // if there was an error, e.g. the exponent is out of range
+		p.errorf(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
+		return nil
 	}
 
 	lit.Value = value // set the value field
@@ -359,8 +548,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t) // create an error message
-	p.errors = append(p.errors, msg)                               // append it to the errors slice
+	p.errorf(p.curToken.Pos, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -373,6 +561,15 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+// Precedence returns how tightly the infix operator t binds its operands, from LOWEST
+// for a token that is not an infix operator up to INDEX.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+	return LOWEST
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok { // if the peek token is in the precedences map
 		return p // return its precedence
@@ -401,6 +598,23 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{Token: p.curToken, Operator: p.curToken.Literal, Target: left} // create a new assignment node and set its token, operator, and target fields
+
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression: // only variables, elements and entries can be assigned to
+	default:
+		p.errorf(p.curToken.Pos, "cannot assign to %s", left.String())
+		return nil
+	}
+
+	p.nextToken() // advance the tokens
+
+	expression.Value = p.parseExpression(LOWEST) // parse the value; assignment is right-associative
+
+	return expression
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken() // advance the tokens
 
@@ -447,6 +661,57 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken} // create a new try expression node and set its token field
+
+	if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+		return nil
+	}
+
+	expression.Block = p.parseBlockStatement() // parse the guarded block
+
+	if p.peekTokenIs(token.CATCH) { // if the next token is a catch
+		p.nextToken() // advance the tokens
+
+		if !p.expectPeek(token.LPAREN) { // if the next token is not a left parenthesis
+			return nil
+		}
+
+		if !p.expectPeek(token.IDENT) { // if the next token is not an identifier
+			return nil
+		}
+
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal} // the variable bound to the error
+
+		if !p.expectPeek(token.RPAREN) { // if the next token is not a right parenthesis
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+			return nil
+		}
+
+		expression.Catch = p.parseBlockStatement() // parse the handler
+	}
+
+	if p.peekTokenIs(token.FINALLY) { // if the next token is a finally
+		p.nextToken() // advance the tokens
+
+		if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+			return nil
+		}
+
+		expression.Finally = p.parseBlockStatement() // parse the cleanup
+	}
+
+	if expression.Catch == nil && expression.Finally == nil { // a try must handle or clean up after its block
+		p.errorf(p.peekToken.Pos, "expected catch or finally after try block, got %s instead", p.peekToken.Type)
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken} // create a new block statement node and set its token field
 
@@ -462,6 +727,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken() // advance the tokens
 	}
 
+	if p.curTokenIs(token.EOF) { // if the input ended before the block did
+		p.errorf(p.curToken.Pos, "expected %s to close the block opened at line %d, got %s instead", token.RBRACE, block.Token.Pos.Line, token.EOF)
+	}
+
+	block.Rbrace = p.curToken // remember the closing brace
+
 	return block
 }
 
@@ -498,5 +769,153 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		return nil
 	}
 
+	hash.Rbrace = p.curToken // remember the closing brace
+
 	return hash
 }
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken} // create a new while statement node and set its token field
+
+	if !p.expectPeek(token.LPAREN) { // if the next token is not a left parenthesis
+		return nil
+	}
+
+	p.nextToken() // advance the tokens
+
+	stmt.Condition = p.parseExpression(LOWEST) // parse the condition
+
+	if !p.expectPeek(token.RPAREN) { // if the next token is not a right parenthesis
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody() // parse the body
+
+	return stmt
+}
+
+// parseForStatement parses both forms of for loop: for (init; condition; post) { ... }
+// and for (x in iterable) { ... }.
+func (p *Parser) parseForStatement() ast.Statement {
+	forToken := p.curToken
+
+	if !p.expectPeek(token.LPAREN) { // if the next token is not a left parenthesis
+		return nil
+	}
+
+	p.nextToken() // advance the tokens
+
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(forToken)
+	}
+
+	stmt := &ast.ForStatement{Token: forToken} // create a new for statement node and set its token field
+
+	if !p.curTokenIs(token.SEMICOLON) { // if there is an init statement
+		stmt.Init = p.parseStatement() // parse it; it consumes its own semicolon
+
+		if !p.curTokenIs(token.SEMICOLON) { // if the init statement was not followed by a semicolon
+			p.errorf(p.peekToken.Pos, "expected next token to be %s, got %s instead", token.SEMICOLON, p.peekToken.Type)
+			return nil
+		}
+	}
+
+	p.nextToken() // advance the tokens
+
+	if !p.curTokenIs(token.SEMICOLON) { // if there is a condition
+		stmt.Condition = p.parseExpression(LOWEST) // parse it
+
+		if !p.expectPeek(token.SEMICOLON) { // if the next token is not a semicolon
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.RPAREN) { // if there is a post statement
+		p.nextToken()                  // advance the tokens
+		stmt.Post = p.parseStatement() // parse it
+	}
+
+	if !p.expectPeek(token.RPAREN) { // if the next token is not a right parenthesis
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody() // parse the body
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement(forToken token.Token) ast.Statement {
+	stmt := &ast.ForInStatement{Token: forToken} // create a new for-in statement node and set its token field
+
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal} // parse the first variable
+
+	if p.peekTokenIs(token.COMMA) { // if there are two variables, the first one is the key
+		p.nextToken() // advance the tokens
+
+		if !p.expectPeek(token.IDENT) { // if the next token is not an identifier
+			return nil
+		}
+
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) { // if the next token is not the in keyword
+		return nil
+	}
+
+	p.nextToken() // advance the tokens
+
+	stmt.Iterable = p.parseExpression(LOWEST) // parse the iterable
+
+	if !p.expectPeek(token.RPAREN) { // if the next token is not a right parenthesis
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) { // if the next token is not a left brace
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody() // parse the body
+
+	return stmt
+}
+
+// parseLoopBody parses the block of a loop, inside which break and continue are allowed.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	body := p.parseBlockStatement()
+	p.loopDepth--
+
+	if p.peekTokenIs(token.SEMICOLON) { // a semicolon after the loop is optional
+		p.nextToken() // advance the tokens
+	}
+
+	return body
+}
+
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+
+	if p.loopDepth == 0 { // break and continue only make sense inside a loop
+		p.errorf(tok.Pos, "%s outside of a loop", tok.Literal)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) { // the semicolon is optional
+		p.nextToken() // advance the tokens
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+
+	return &ast.ContinueStatement{Token: tok}
+}
@@ -186,6 +186,52 @@ func TestIdentifier(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{".25", 0.25},
+		{"1e3", 1000},
+		{"2.5e-1", 0.25},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	l := lexer.New("123456789012345678901234567890")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+
+	if literal.Big == nil || literal.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.Big wrong. got=%v", literal.Big)
+	}
+}
+
 func TestIntegerLiteralExpression(t *testing.T) {
 	input := `5;`
 
@@ -371,6 +417,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"a <= b == b >= a", "((a <= b) == (b >= a))"},
+		{"a + b % c", "(a + (b % c))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"a == b && c != d", "((a == b) && (c != d))"},
+		{"x = a || b", "(x = (a || b))"},
+		{"-a.b * c", "((-(a.b)) * c)"},
+		{"a.b.c(d)[0]", "(((a.b).c)(d)[0])"},
 	}
 
 	for _, tt := range tests { // iterate over the slice
@@ -1083,3 +1137,382 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2][0])`
+
+	l := lexer.NewFile("pos.ys", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	fn := letStmt.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1]
+
+	tests := []struct {
+		node  ast.Node
+		start string
+		end   string
+	}{
+		{letStmt, "pos.ys:1:1", "pos.ys:3:2"},
+		{fn, "pos.ys:1:11", "pos.ys:3:2"},
+		{body, "pos.ys:2:3", "pos.ys:2:8"},
+		{call, "pos.ys:4:1", "pos.ys:4:15"},
+		{index, "pos.ys:4:8", "pos.ys:4:14"},
+		{program, "pos.ys:1:1", "pos.ys:4:15"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.start {
+			t.Errorf("%q starts wrongly. want=%s, got=%s", tt.node.String(), tt.start, got)
+		}
+
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("%q ends wrongly. want=%s, got=%s", tt.node.String(), tt.end, got)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := "let x = 5;\nlet = 10;"
+
+	l := lexer.NewFile("bad.ys", input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "bad.ys:2:5: expected next token to be IDENT, got = instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+
+	list := p.ErrorList()
+	if len(list) != len(errors) {
+		t.Fatalf("ErrorList has %d errors, Errors %d", len(list), len(errors))
+	}
+	if list[0].Pos.Line != 2 || list[0].Pos.Column != 5 || list[0].Msg != "expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong error in ErrorList. got=%+v", list[0])
+	}
+	if list[0].Error() != expected {
+		t.Errorf("wrong Error(). want=%q, got=%q", expected, list[0].Error())
+	}
+}
+
+func TestOptionalSemicolons(t *testing.T) {
+	input := `let x = 5
+let y = x
+return y`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+
+	if program.String() != "let x = 5;let y = x;return y;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 1 + // in the middle
+  2; // trailing
+let f = fn(a) { // after a brace
+  a
+};
+// last`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if program.String() != "let x = (1 + 2);let f = fn(a) a;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+
+	expected := []struct {
+		text string
+		pos  string
+	}{
+		{"// leading", "1:1"},
+		{"// in the middle", "2:13"},
+		{"// trailing", "3:6"},
+		{"// after a brace", "4:17"},
+		{"// last", "7:1"},
+	}
+
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("program.Comments has wrong length. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+
+	for i, tt := range expected {
+		c := program.Comments[i]
+		if c.Text != tt.text || c.Pos().String() != tt.pos {
+			t.Errorf("comment %d wrong. want=%q at %s, got=%q at %s", i, tt.text, tt.pos, c.Text, c.Pos())
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x; }", "while(x < 10) x"},
+		{"for (let i = 0; i < 10; i) { break; }", "for (let i = 0; (i < 10); i) break;"},
+		{"for (;;) { continue; };", "for (; ; ) continue;"},
+		{"for (x in xs) { x }", "for (x in xs) x"},
+		{"for (i, x in xs) { x }", "for (i, x in xs) x"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: program.String() wrong. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f(x) } catch (e) { e }", "try f(x) catch (e) e"},
+		{"try { f(x) } finally { g() }", "try f(x) finally g()"},
+		{"let y = try { f(x) } catch (err) { 0 } finally { g() };", "let y = try f(x) catch (err) 0 finally g();"},
+		{"throw \"boom\";", "throw boom;"},
+		{"throw {\"message\": m}", "throw {message:m};"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: program.String() wrong. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 }", "1:10: expected catch or finally after try block, got EOF instead"},
+		{"try { 1 } catch { 2 }", "1:17: expected next token to be (, got { instead"},
+		{"try { 1 } catch (1) { 2 }", "1:18: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected parser errors, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestImportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"import \"lib/math.ys\" as m", "import \"lib/math.ys\" as m;"},
+		{"import \"lib/math.ys\";", "import \"lib/math.ys\" as math;"},
+		{"import \"strings\"", "import \"strings\" as strings;"},
+		{"from \"lib/math.ys\" import square, cube;", "from \"lib/math.ys\" import square, cube;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+
+		if _, ok := program.Statements[0].(*ast.ImportStatement); !ok {
+			t.Fatalf("%q: statement is not *ast.ImportStatement. got=%T", tt.input, program.Statements[0])
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: program.String() wrong. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidImportStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"import math", "1:8: expected next token to be STRING, got IDENT instead"},
+		{"import \"my-lib.ys\"", "1:8: cannot name module \"my-lib.ys\" after its file, import it with `as name`"},
+		{"import \"m.ys\" as", "1:17: expected next token to be IDENT, got EOF instead"},
+		{"from \"m.ys\" import", "1:19: expected next token to be IDENT, got EOF instead"},
+		{"a.\"b\"", "1:3: expected next token to be IDENT, got STRING instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected parser errors, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestUnclosedBlocks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(n) { le", "1:19: expected } to close the block opened at line 1, got EOF instead"},
+		{"if (x) {\n  puts(x);\n", "3:1: expected } to close the block opened at line 1, got EOF instead"},
+		{"while (true) { if (x) { break; }", "1:33: expected } to close the block opened at line 1, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected parser errors, got none", tt.input)
+			continue
+		}
+
+		if errors[len(errors)-1] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errors[len(errors)-1])
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected parser errors, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "(x = 5)"},
+		{"x = y = 5;", "(x = (y = 5))"},
+		{"x += 1 + 2;", "(x += (1 + 2))"},
+		{"x -= 1", "(x -= 1)"},
+		{"x *= 2", "(x *= 2)"},
+		{"x /= 2", "(x /= 2)"},
+		{"xs[0] = 5", "((xs[0]) = 5)"},
+		{"h[\"a\"] += f(1)", "((h[a]) += f(1))"},
+		{"x = a == b", "(x = (a == b))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("%q: program.Statements[0] is not *ast.ExpressionStatement. got=%T", tt.input, program.Statements[0])
+		}
+
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Fatalf("%q: exp is not *ast.AssignExpression. got=%T", tt.input, stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: program.String() wrong. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("1 + 2 = 3")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "1:7: cannot assign to (1 + 2)"
+	if errors[0] != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, errors[0])
+	}
+}
@@ -0,0 +1,258 @@
+// File: profile/pprof.go
+
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// The fields of the messages of pprof's profile.proto that profiles use.
+const (
+	// Profile
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	// ValueType
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	// Mapping
+	mappingID             = 1
+	mappingFilename       = 5
+	mappingHasFunctions   = 7
+	mappingHasFilenames   = 8
+	mappingHasLineNumbers = 9
+
+	// Location
+	locationID        = 1
+	locationMappingID = 2
+	locationLine      = 4
+
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+
+	// Function
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format that pprof reads.
+// Each chain of calls is a sample, with three values: the nanoseconds spent at its
+// innermost location, the calls that started there, and the values created there.
+// Locations are lines of functions.
+func (p *Profile) WritePprof(w io.Writer) error {
+	p.Stop()
+
+	e := &pprofEncoder{strings: map[string]int{"": 0}, stringTable: []string{""}, functions: map[function]uint64{}, locations: map[location]uint64{}}
+
+	for _, sampleType := range [][2]string{{"time", "nanoseconds"}, {"calls", "count"}, {"allocations", "count"}} {
+		e.valueType(profileSampleType, sampleType[0], sampleType[1])
+	}
+
+	// Every location is in the one mapping, which says they need no symbolizing: pprof
+	// would otherwise look for a binary to find the functions' names in.
+	e.out.message(profileMapping, func(b *protoBuffer) {
+		b.uint64(mappingID, 1)
+		b.uint64(mappingFilename, e.string("ys"))
+		b.uint64(mappingHasFunctions, 1)
+		b.uint64(mappingHasFilenames, 1)
+		b.uint64(mappingHasLineNumbers, 1)
+	})
+
+	var visit func(n *node, stack []uint64)
+	visit = func(n *node, stack []uint64) {
+		if n != p.root {
+			stack = append([]uint64{e.location(n.loc)}, stack...)
+			if n.time > 0 || n.calls > 0 || n.allocs > 0 {
+				e.out.message(profileSample, func(b *protoBuffer) {
+					b.packed(sampleLocationID, stack)
+					b.packed(sampleValue, []uint64{uint64(n.time.Nanoseconds()), uint64(n.calls), uint64(n.allocs)})
+				})
+			}
+		}
+		for _, c := range n.sortedChildren() {
+			visit(c, stack)
+		}
+	}
+	visit(p.root, nil)
+
+	e.out.uint64(profileTimeNanos, uint64(p.start.UnixNano()))
+	e.out.uint64(profileDurationNanos, uint64(p.elapsed.Nanoseconds()))
+	e.valueType(profilePeriodType, "time", "nanoseconds")
+	e.out.uint64(profilePeriod, 1)
+	e.out.uint64(profileDefaultSampleType, e.string("time"))
+
+	// The strings come last, once everything that refers to them has been written.
+	for _, s := range e.stringTable {
+		e.out.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(e.out.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// sortedChildren returns the calls made from a node's line in a fixed order, so that a
+// profile is always written the same way.
+func (n *node) sortedChildren() []*node {
+	children := make([]*node, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		a, b := children[i].loc, children[j].loc
+		if a.fn.name != b.fn.name {
+			return a.fn.name < b.fn.name
+		}
+		if a.fn.pos.File != b.fn.pos.File {
+			return a.fn.pos.File < b.fn.pos.File
+		}
+		if a.fn.pos.Offset != b.fn.pos.Offset {
+			return a.fn.pos.Offset < b.fn.pos.Offset
+		}
+		return a.line < b.line
+	})
+	return children
+}
+
+// pprofEncoder builds a profile message, numbering the strings, functions and
+// locations it refers to as they are first met.
+type pprofEncoder struct {
+	out protoBuffer
+
+	strings     map[string]int
+	stringTable []string
+	functions   map[function]uint64
+	locations   map[location]uint64
+}
+
+func (e *pprofEncoder) string(s string) uint64 {
+	i, ok := e.strings[s]
+	if !ok {
+		i = len(e.stringTable)
+		e.strings[s] = i
+		e.stringTable = append(e.stringTable, s)
+	}
+	return uint64(i)
+}
+
+func (e *pprofEncoder) valueType(field int, typ, unit string) {
+	e.out.message(field, func(b *protoBuffer) {
+		b.uint64(valueTypeType, e.string(typ))
+		b.uint64(valueTypeUnit, e.string(unit))
+	})
+}
+
+func (e *pprofEncoder) function(f function) uint64 {
+	if id, ok := e.functions[f]; ok {
+		return id
+	}
+	id := uint64(len(e.functions) + 1)
+	e.functions[f] = id
+
+	e.out.message(profileFunction, func(b *protoBuffer) {
+		b.uint64(functionID, id)
+		b.uint64(functionName, e.string(f.label()))
+		b.uint64(functionSystemName, e.string(f.name))
+		b.uint64(functionFilename, e.string(f.pos.File))
+		b.uint64(functionStartLine, uint64(f.pos.Line))
+	})
+	return id
+}
+
+func (e *pprofEncoder) location(loc location) uint64 {
+	if id, ok := e.locations[loc]; ok {
+		return id
+	}
+	fn := e.function(loc.fn)
+	id := uint64(len(e.locations) + 1)
+	e.locations[loc] = id
+
+	e.out.message(profileLocation, func(b *protoBuffer) {
+		b.uint64(locationID, id)
+		b.uint64(locationMappingID, 1)
+		b.message(locationLine, func(b *protoBuffer) {
+			b.uint64(lineFunctionID, fn)
+			b.uint64(lineLine, uint64(loc.line))
+		})
+	})
+	return id
+}
+
+// protoBuffer encodes protocol buffer fields. Fields with the value 0 are left out, as
+// protocol buffers take them to be 0 when they are missing.
+type protoBuffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(x)
+}
+
+// string writes a string field, even an empty one: the string table must start with "".
+func (b *protoBuffer) string(field int, s string) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var inner protoBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.key(field, wireBytes)
+	b.varint(uint64(len(inner.data)))
+	b.data = append(b.data, inner.data...)
+}
+
+// message writes a field holding the message that fill writes.
+func (b *protoBuffer) message(field int, fill func(b *protoBuffer)) {
+	var inner protoBuffer
+	fill(&inner)
+	b.key(field, wireBytes)
+	b.varint(uint64(len(inner.data)))
+	b.data = append(b.data, inner.data...)
+}
@@ -0,0 +1,308 @@
+// File: profile/profile.go
+
+// Package profile measures where Ys programs spend their time. A Profile, attached to an
+// evaluator with SetProfiler, records the time spent, the calls made and the values
+// allocated in each function and on each line, by the chain of calls that led there. It
+// can then report them as a table, as folded stacks for flame graphs, or in the format of
+// pprof.
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+// function identifies a function: builtins by name, and others by where they are
+// defined too, so that two function literals with the same name are told apart.
+type function struct {
+	name string
+	pos  token.Pos
+}
+
+// label names a function in folded stacks and pprof: by its name, or where it is
+// defined if it has none. The angle brackets of names such as <main> are dropped, as
+// pprof takes what is between them for C++ template arguments and hides it.
+func (f function) label() string {
+	if f.name == object.AnonymousFunction && f.pos.IsValid() {
+		return fmt.Sprintf("anonymous %s:%d", f.pos.File, f.pos.Line)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(f.name, "<"), ">")
+}
+
+// location is a line of a function. It is 0 for builtins.
+type location struct {
+	fn   function
+	line int
+}
+
+// node is a chain of calls that led to a location, and what was measured there: the
+// root, which has no location, and then one for each call, leading to the innermost's
+// current line. Each line of a call is a node of its own, and the children of a node are
+// the calls made from its line.
+type node struct {
+	loc      location
+	parent   *node
+	children map[location]*node
+
+	time   time.Duration // spent at the location itself, not in the calls made from it
+	calls  int64         // how many calls started here
+	hits   int64         // how many times evaluation moved onto the line
+	allocs int64         // how many arrays, hashes and strings were created here
+}
+
+func (n *node) child(loc location) *node {
+	if c, ok := n.children[loc]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = map[location]*node{}
+	}
+	c := &node{loc: loc, parent: n}
+	n.children[loc] = c
+	return c
+}
+
+// Profile records what a program does as it runs. It implements evaluator.Profiler.
+type Profile struct {
+	root    *node
+	current *node // where the program is; the root before it starts
+
+	start   time.Time
+	last    time.Time // when the time up to was last charged to current
+	elapsed time.Duration
+	stopped bool
+}
+
+// New returns a Profile that starts measuring now.
+func New() *Profile {
+	now := time.Now()
+	root := &node{}
+	return &Profile{root: root, current: root, start: now, last: now}
+}
+
+// charge charges the time since it was last charged to where the program is.
+func (p *Profile) charge() {
+	now := time.Now()
+	p.current.time += now.Sub(p.last)
+	p.last = now
+}
+
+// Enter records the start of a call.
+func (p *Profile) Enter(name string, pos token.Pos) {
+	p.charge()
+	p.current = p.current.child(location{fn: function{name: name, pos: pos}, line: pos.Line})
+	p.current.calls++
+	p.current.hits++
+}
+
+// Exit records the end of a call, going back to the line it was made from.
+func (p *Profile) Exit() {
+	if p.current.parent == nil {
+		return
+	}
+	p.charge()
+	p.current = p.current.parent
+}
+
+// Step records that the program is about to evaluate the code at pos.
+func (p *Profile) Step(pos token.Pos) {
+	if p.current == p.root {
+		// The program's top level is the first thing it runs.
+		p.Enter(object.MainFunction, token.Pos{File: pos.File, Line: 1, Column: 1})
+	}
+	if pos.Line == p.current.loc.line {
+		return
+	}
+
+	p.charge()
+	p.current = p.current.parent.child(location{fn: p.current.loc.fn, line: pos.Line})
+	p.current.hits++
+}
+
+// Allocate records the creation of obj, if it is an array, a hash or a string.
+func (p *Profile) Allocate(obj object.Object) {
+	switch obj.(type) {
+	case *object.Array, *object.Hash, *object.String:
+		p.current.allocs++
+	}
+}
+
+// Stop ends the measurements, charging the time since the last event to where the
+// program was. The reports call it if it has not been called.
+func (p *Profile) Stop() {
+	if p.stopped {
+		return
+	}
+	p.charge()
+	p.elapsed = p.last.Sub(p.start)
+	p.stopped = true
+}
+
+// Stat is what was measured in a function, or on a line.
+type Stat struct {
+	Name   string        // the function's name, or the function the line is in
+	Pos    token.Pos     // where the function is defined, or the line; not valid for builtins
+	Self   time.Duration // the time spent in it, not counting the calls it made
+	Cum    time.Duration // the time spent in it, counting the calls it made
+	Calls  int64         // how many times the function was called, or the line run
+	Allocs int64         // how many arrays, hashes and strings it created itself
+}
+
+// Functions returns what was measured in each function, the one with the most time
+// spent in it first.
+func (p *Profile) Functions() []Stat {
+	p.Stop()
+	return p.stats(func(loc location) (function, bool) {
+		return loc.fn, true
+	}, func(n *node) int64 { return n.calls })
+}
+
+// Lines returns what was measured on each line, the one with the most time spent on it
+// first. Builtins have no lines.
+func (p *Profile) Lines() []Stat {
+	p.Stop()
+	return p.stats(func(loc location) (function, bool) {
+		if loc.line == 0 {
+			return function{}, false
+		}
+		return function{name: loc.fn.name, pos: token.Pos{File: loc.fn.pos.File, Line: loc.line}}, true
+	}, func(n *node) int64 { return n.hits })
+}
+
+// stats adds up the nodes by the name and position, if any, that key gives their
+// locations, with count saying what each node counts towards Calls.
+func (p *Profile) stats(key func(location) (function, bool), count func(*node) int64) []Stat {
+	totals := map[function]*Stat{}
+	active := map[function]int{} // how many of the calls in progress are at each key
+
+	// The time spent in calls made from a node counts towards the node's cumulative
+	// time, except for a recursive call, which was counted in its caller.
+	var visit func(n *node) time.Duration
+	visit = func(n *node) time.Duration {
+		k, ok := key(n.loc)
+
+		total := n.time
+		if ok {
+			active[k]++
+		}
+		for _, c := range n.children {
+			total += visit(c)
+		}
+		if !ok {
+			return total
+		}
+		active[k]--
+
+		s := totals[k]
+		if s == nil {
+			s = &Stat{Name: k.name, Pos: k.pos}
+			totals[k] = s
+		}
+		s.Self += n.time
+		s.Calls += count(n)
+		s.Allocs += n.allocs
+		if active[k] == 0 {
+			s.Cum += total
+		}
+
+		return total
+	}
+	for _, c := range p.root.children {
+		visit(c)
+	}
+
+	stats := make([]Stat, 0, len(totals))
+	for _, s := range totals {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Pos.String()+a.Name < b.Pos.String()+b.Name
+	})
+
+	return stats
+}
+
+// WriteText writes the functions and lines measured as tables, the ones the most time
+// was spent in first.
+func (p *Profile) WriteText(w io.Writer) error {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "Total time: %s\n", milliseconds(p.Elapsed()))
+
+	fmt.Fprintf(&out, "\n%12s %12s %10s %10s  %s\n", "self", "cum", "calls", "allocs", "function")
+	for _, s := range p.Functions() {
+		name := s.Name
+		if s.Pos.IsValid() {
+			name += " (" + s.Pos.String() + ")"
+		}
+		fmt.Fprintf(&out, "%12s %12s %10d %10d  %s\n", milliseconds(s.Self), milliseconds(s.Cum), s.Calls, s.Allocs, name)
+	}
+
+	fmt.Fprintf(&out, "\n%12s %12s %10s %10s  %s\n", "self", "cum", "runs", "allocs", "line")
+	for _, s := range p.Lines() {
+		fmt.Fprintf(&out, "%12s %12s %10d %10d  %s:%d (%s)\n", milliseconds(s.Self), milliseconds(s.Cum), s.Calls, s.Allocs, s.Pos.File, s.Pos.Line, s.Name)
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// milliseconds formats a duration in milliseconds, to the microsecond.
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+// Elapsed returns how long the program ran for.
+func (p *Profile) Elapsed() time.Duration {
+	p.Stop()
+	return p.elapsed
+}
+
+// WriteFolded writes the time spent in each chain of calls, as the lines flame graph
+// tools read: the functions called, outermost first and separated by semicolons, then a
+// space and the nanoseconds spent in the innermost one. The lines are sorted.
+func (p *Profile) WriteFolded(w io.Writer) error {
+	p.Stop()
+
+	totals := map[string]time.Duration{}
+	var visit func(n *node, stack string)
+	visit = func(n *node, stack string) {
+		if n != p.root {
+			stack += ";" + strings.ReplaceAll(n.loc.fn.label(), ";", ",")
+			totals[stack[1:]] += n.time
+		}
+		for _, c := range n.children {
+			visit(c, stack)
+		}
+	}
+	visit(p.root, "")
+
+	stacks := make([]string, 0, len(totals))
+	for stack, total := range totals {
+		if total > 0 {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Strings(stacks)
+
+	var out strings.Builder
+	for _, stack := range stacks {
+		fmt.Fprintf(&out, "%s %d\n", stack, totals[stack].Nanoseconds())
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,251 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+const program = `let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2)
+};
+let words = [];
+for (let i = 0; i < 3; i += 1) {
+  words = push(words, "w");
+}
+let fs = [fn() { 1 }];
+fs[0]();
+fib(5);
+`
+
+// run profiles the program.
+func run(t *testing.T) *Profile {
+	t.Helper()
+
+	p := parser.New(lexer.NewFile("p.ys", program))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	prof := New()
+	e := evaluator.New()
+	e.SetProfiler(prof)
+	if result := e.Eval(prog, object.NewEnvironment()); result.Inspect() != "5" {
+		t.Fatalf("wrong result. got=%s", result.Inspect())
+	}
+	prof.Stop()
+
+	return prof
+}
+
+func TestFunctions(t *testing.T) {
+	prof := run(t)
+
+	// Times vary, so they are only checked to add up.
+	var got []string
+	for _, s := range prof.Functions() {
+		got = append(got, s.Name+"@"+s.Pos.String()+" calls="+strconv.FormatInt(s.Calls, 10)+" allocs="+strconv.FormatInt(s.Allocs, 10))
+
+		if s.Self > s.Cum || s.Cum > prof.Elapsed() {
+			t.Errorf("%s: times do not add up: self=%s cum=%s total=%s", s.Name, s.Self, s.Cum, prof.Elapsed())
+		}
+	}
+	sort.Strings(got)
+
+	expected := []string{
+		"<anonymous>@p.ys:9:16 calls=1 allocs=0",
+		"<main>@p.ys:1:1 calls=1 allocs=2",
+		"fib@p.ys:1:17 calls=15 allocs=0",
+		"push@- calls=3 allocs=3",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong functions.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
+func TestLines(t *testing.T) {
+	prof := run(t)
+
+	runs := map[string]int64{}
+	for _, s := range prof.Lines() {
+		runs[s.Pos.File+":"+strconv.Itoa(s.Pos.Line)+" "+s.Name] = s.Calls
+	}
+
+	// The line a function is defined on is run as it is called, and its body's lines
+	// as evaluation moves onto them.
+	expected := map[string]int64{
+		"p.ys:1 fib": 15, "p.ys:2 fib": 15, "p.ys:3 fib": 7,
+		"p.ys:1 <main>": 1, "p.ys:5 <main>": 1, "p.ys:6 <main>": 4, "p.ys:7 <main>": 3,
+		"p.ys:9 <main>": 1, "p.ys:10 <main>": 1, "p.ys:11 <main>": 1,
+		"p.ys:9 <anonymous>": 1,
+	}
+	for line, want := range expected {
+		if runs[line] != want {
+			t.Errorf("%s: wrong runs. want=%d, got=%d", line, want, runs[line])
+		}
+	}
+	if len(runs) != len(expected) {
+		t.Errorf("wrong lines. got=%v", runs)
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	prof := run(t)
+
+	var out bytes.Buffer
+	if err := prof.WriteFolded(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var stacks []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		i := strings.LastIndex(line, " ")
+		stacks = append(stacks, line[:i])
+	}
+
+	for _, want := range []string{"main", "main;fib", "main;fib;fib;fib;fib;fib", "main;anonymous p.ys:9", "main;push"} {
+		if !contains(stacks, want) {
+			t.Errorf("no stack %q in:\n%s", want, out.String())
+		}
+	}
+	if !sort.StringsAreSorted(stacks) {
+		t.Errorf("stacks are not sorted:\n%s", out.String())
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	prof := run(t)
+
+	var out bytes.Buffer
+	if err := prof.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Total time: ", "calls     allocs  function", "15          0  fib (p.ys:1:17)", "runs     allocs  line", "p.ys:7 (<main>)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("text does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	prof := run(t)
+
+	var out bytes.Buffer
+	if err := prof.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := decode(t, data)
+
+	strs := fields[profileStringTable]
+	if len(strs) == 0 || len(strs[0]) != 0 {
+		t.Fatalf("the string table does not start with \"\"")
+	}
+	var table []string
+	for _, s := range strs {
+		table = append(table, string(s))
+	}
+	for _, want := range []string{"time", "nanoseconds", "calls", "allocations", "count", "main", "fib", "push", "anonymous p.ys:9", "p.ys"} {
+		if !contains(table, want) {
+			t.Errorf("string table has no %q: %q", want, table)
+		}
+	}
+
+	if n := len(fields[profileSampleType]); n != 3 {
+		t.Errorf("wrong number of sample types. got=%d", n)
+	}
+	if n := len(fields[profileFunction]); n != 4 {
+		t.Errorf("wrong number of functions. got=%d", n)
+	}
+	if len(fields[profileSample]) == 0 || len(fields[profileLocation]) == 0 || len(fields[profileMapping]) != 1 {
+		t.Errorf("no samples, locations or mapping")
+	}
+
+	// Each sample has a value of each type.
+	for _, sample := range fields[profileSample] {
+		values := decode(t, sample)[sampleValue]
+		if len(values) != 1 || len(varints(t, values[0])) != 3 {
+			t.Fatalf("sample does not have 3 values")
+		}
+	}
+}
+
+// decode splits a protocol buffer message into its fields, giving the bytes of
+// length-delimited ones and the encoding of varints.
+func decode(t *testing.T, data []byte) map[int][][]byte {
+	t.Helper()
+
+	fields := map[int][][]byte{}
+	for len(data) > 0 {
+		key, n := varint(data)
+		data = data[n:]
+
+		field, wireType := int(key>>3), int(key&7)
+		switch wireType {
+		case wireVarint:
+			_, n := varint(data)
+			fields[field] = append(fields[field], data[:n])
+			data = data[n:]
+		case wireBytes:
+			length, n := varint(data)
+			data = data[n:]
+			fields[field] = append(fields[field], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("unexpected wire type %d", wireType)
+		}
+	}
+	return fields
+}
+
+func varints(t *testing.T, data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		x, n := varint(data)
+		xs = append(xs, x)
+		data = data[n:]
+	}
+	return xs
+}
+
+func varint(data []byte) (uint64, int) {
+	var x uint64
+	for i, b := range data {
+		x |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return x, i + 1
+		}
+	}
+	return x, len(data)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
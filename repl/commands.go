@@ -0,0 +1,169 @@
+// File: repl/commands.go
+
+package repl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+const commandHelp = `Commands:
+  :help          show this help
+  :env           list the session's bindings and their types
+  :type expr     show the type of expr's value
+  :ast expr      show the syntax tree of expr
+  :tokens expr   show the tokens of expr
+  :load file.ys  run a file in the session
+  :reset         forget everything defined in the session
+  :time expr     evaluate expr and show how long it took
+  :quit          leave the REPL
+`
+
+// isCommand reports whether an entry is a command rather than code.
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// command runs a command, reporting false if it ends the session.
+func (s *session) command(input string) bool {
+	name, arg, _ := strings.Cut(strings.TrimSpace(input), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case ":help":
+		fmt.Fprint(s.out, commandHelp)
+
+	case ":quit":
+		return false
+
+	case ":env":
+		s.env()
+
+	case ":reset":
+		s.reset()
+
+	case ":type":
+		if s.needArg(name, arg, "expr") {
+			if program, ok := s.parse("", arg); ok {
+				s.printType(s.runner.run(program))
+			}
+		}
+
+	case ":ast":
+		if s.needArg(name, arg, "expr") {
+			if program, ok := s.parse("", arg); ok {
+				ast.Fprint(s.out, program)
+			}
+		}
+
+	case ":tokens":
+		if s.needArg(name, arg, "expr") {
+			s.tokens(arg)
+		}
+
+	case ":load":
+		if s.needArg(name, arg, "file.ys") {
+			s.load(arg)
+		}
+
+	case ":time":
+		if s.needArg(name, arg, "expr") {
+			if program, ok := s.parse("", arg); ok {
+				start := time.Now()
+				evaluated := s.runner.run(program)
+				elapsed := time.Since(start)
+
+				s.print(evaluated)
+				fmt.Fprintf(s.out, "time: %s\n", elapsed)
+			}
+		}
+
+	default:
+		fmt.Fprintf(s.out, "unknown command %s; type :help for a list\n", name)
+	}
+
+	return true
+}
+
+// needArg reports whether a command that needs an argument was given one, showing how
+// to use it if not.
+func (s *session) needArg(name, arg, usage string) bool {
+	if arg == "" {
+		fmt.Fprintf(s.out, "usage: %s %s\n", name, usage)
+		return false
+	}
+	return true
+}
+
+// env lists the session's bindings in order of name, with their types.
+func (s *session) env() {
+	bindings := s.runner.bindings()
+
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, bindings[name].Type())
+	}
+	w.Flush()
+}
+
+// printType shows the type of a value, or the error evaluating it raised.
+func (s *session) printType(evaluated object.Object) {
+	if evaluated == nil {
+		evaluated = evaluator.NULL
+	}
+
+	if _, ok := evaluated.(*object.Error); ok {
+		s.print(evaluated)
+		return
+	}
+
+	fmt.Fprintln(s.out, evaluated.Type())
+}
+
+// tokens lists the tokens of src with their positions.
+func (s *session) tokens(src string) {
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(w, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
+	}
+	w.Flush()
+}
+
+// load runs a file in the session, so that what it defines can be used in later entries.
+func (s *session) load(file string) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+
+	program, ok := s.parse(file, string(src))
+	if !ok {
+		return
+	}
+
+	if evaluated, isErr := s.runner.run(program).(*object.Error); isErr {
+		s.print(evaluated)
+		return
+	}
+
+	fmt.Fprintf(s.out, "loaded %s\n", file)
+}
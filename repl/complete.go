@@ -0,0 +1,166 @@
+// File: repl/complete.go
+
+package repl
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+)
+
+// Completer suggests how to finish what is being typed: names bound in a session, the
+// builtins and the keywords, and the keys of a hash after name[". The REPL completes
+// with it when Tab is pressed, and other front-ends can use it to offer the same.
+type Completer struct {
+	bindings func() map[string]object.Object
+}
+
+// NewCompleter returns a Completer for the names bound in env and the environments
+// enclosing it, as they are whenever it completes.
+func NewCompleter(env *object.Environment) *Completer {
+	return &Completer{bindings: func() map[string]object.Object {
+		return environmentBindings(env)
+	}}
+}
+
+// Complete returns the ways to complete the text before the byte offset pos in line,
+// sorted, and the offset where the text they replace starts. Completions of a hash key
+// end with the closing "]. There are none in the middle of a number, a member name or a
+// string other than a hash key, or when there is nothing to complete.
+func (c *Completer) Complete(line string, pos int) (completions []string, start int) {
+	before := line[:pos]
+
+	if name, prefix, ok := hashKeyPrefix(before); ok {
+		return c.hashKeys(name, prefix), pos - len(prefix)
+	}
+
+	start = pos
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(before[:start])
+		if !isIdentifierRune(r) {
+			break
+		}
+		start -= size
+	}
+
+	word := before[start:]
+	if word == "" || insideString(before[:start]) {
+		return nil, pos
+	}
+
+	// Neither numbers nor members can be completed from names.
+	if r, _ := utf8.DecodeLastRuneInString(before[:start]); start > 0 && (r == '.' || unicode.IsDigit(r)) {
+		return nil, pos
+	}
+
+	names := evaluator.BuiltinNames()
+	names = append(names, lexer.Keywords()...)
+	for name := range c.bindings() {
+		names = append(names, name)
+	}
+
+	return matching(names, word), start
+}
+
+// hashKeys returns the string keys of the hash bound to name that start with prefix,
+// each followed by the "] that closes the index.
+func (c *Completer) hashKeys(name, prefix string) []string {
+	hash, ok := c.bindings()[name].(*object.Hash)
+	if !ok {
+		return nil
+	}
+
+	keys := []string{}
+	for _, pair := range hash.OrderedPairs() {
+		// A key containing a quote cannot be written as a string literal.
+		if key, ok := pair.Key.(*object.String); ok && !strings.Contains(key.Value, `"`) {
+			keys = append(keys, key.Value)
+		}
+	}
+
+	completions := matching(keys, prefix)
+	for i := range completions {
+		completions[i] += `"]`
+	}
+
+	return completions
+}
+
+// hashKeyPrefix reports whether text ends in the middle of a string that indexes a
+// variable, as in name["pre, and returns the variable and the string so far.
+func hashKeyPrefix(text string) (name, prefix string, ok bool) {
+	quote := strings.LastIndexByte(text, '"')
+	if quote < 2 || text[quote-1] != '[' || !insideString(text[:quote+1]) {
+		return "", "", false
+	}
+
+	end := quote - 1
+	start := end
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isIdentifierRune(r) {
+			break
+		}
+		start -= size
+	}
+
+	if start == end {
+		return "", "", false
+	}
+
+	return text[start:end], text[quote+1:], true
+}
+
+// insideString reports whether text ends inside a string literal, as it does when it
+// has an odd number of quotes outside comments.
+func insideString(text string) bool {
+	inside := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '"':
+			inside = !inside
+		case !inside && strings.HasPrefix(text[i:], "//"):
+			if newline := strings.IndexByte(text[i:], '\n'); newline >= 0 {
+				i += newline
+			} else {
+				return false
+			}
+		}
+	}
+	return inside
+}
+
+// matching returns the distinct words that start with prefix, sorted.
+func matching(words []string, prefix string) []string {
+	seen := make(map[string]bool)
+	matches := []string{}
+
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) && !seen[word] {
+			seen[word] = true
+			matches = append(matches, word)
+		}
+	}
+
+	sort.Strings(matches)
+	return matches
+}
+
+// isIdentifierRune reports whether r can appear in an identifier, as the lexer reads them.
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+// environmentBindings returns the values bound in env and the environments enclosing it.
+func environmentBindings(env *object.Environment) map[string]object.Object {
+	bindings := make(map[string]object.Object)
+	for _, name := range env.Names() {
+		bindings[name], _ = env.Get(name)
+	}
+	return bindings
+}
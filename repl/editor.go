@@ -0,0 +1,436 @@
+// File: repl/editor.go
+
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned when the user abandons a line with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// Keys, as the bytes a terminal in raw mode sends for them.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyBackspace = 8
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// Keys that terminals send as escape sequences, given values no rune has.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyForwardDelete
+	keyUnknown
+)
+
+// editor reads lines typed at a terminal, with Emacs-style editing, history, reverse
+// search and completion. It only interprets the bytes it reads and writes the escape
+// codes to redraw the line, so putting the terminal in raw mode is up to its caller.
+type editor struct {
+	in        *bufio.Reader
+	out       io.Writer
+	history   *history
+	completer *Completer // nil for no completion
+
+	// The line being edited, which holds newlines when an entry of several lines is
+	// recalled from the history.
+	prompt string
+	buf    []rune
+	pos    int // the cursor's index in buf
+	row    int // how many rows of the terminal the cursor is below the prompt
+}
+
+func newEditor(in *bufio.Reader, out io.Writer, h *history, completer *Completer) *editor {
+	return &editor{in: in, out: out, history: h, completer: completer}
+}
+
+// readLine shows prompt and returns the line typed after it. It is up to the caller to
+// add the entry it is part of to the history, once the entry is complete. It returns
+// io.EOF for Ctrl-D on an empty line, and errInterrupted for Ctrl-C.
+func (e *editor) readLine(prompt string) (string, error) {
+	e.prompt = prompt
+	e.buf = e.buf[:0]
+	e.pos = 0
+	e.row = 0
+
+	// Moving through the history keeps the line being typed as the newest entry.
+	entry := len(e.history.lines)
+	draft := ""
+
+	e.refresh()
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			if err == io.EOF && len(e.buf) > 0 {
+				return e.finish(), nil
+			}
+			return "", err
+		}
+
+		switch key {
+		case keyEnter, keyLineFeed:
+			return e.finish(), nil
+
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+
+		case keyForwardDelete:
+			e.deleteAt(e.pos)
+
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+
+		case keyLeft, keyCtrlB:
+			if e.pos > 0 {
+				e.pos--
+			}
+
+		case keyRight, keyCtrlF:
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+
+		case keyHome, keyCtrlA:
+			e.pos = 0
+
+		case keyEnd, keyCtrlE:
+			e.pos = len(e.buf)
+
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+
+		case keyCtrlU:
+			e.buf = append(e.buf[:0], e.buf[e.pos:]...)
+			e.pos = 0
+
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+			e.pos = start
+
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			e.row = 0
+
+		case keyUp, keyCtrlP:
+			if entry > 0 {
+				if entry == len(e.history.lines) {
+					draft = string(e.buf)
+				}
+				entry--
+				e.setLine(e.history.lines[entry])
+			}
+
+		case keyDown, keyCtrlN:
+			if entry < len(e.history.lines) {
+				entry++
+				if entry == len(e.history.lines) {
+					e.setLine(draft)
+				} else {
+					e.setLine(e.history.lines[entry])
+				}
+			}
+
+		case keyCtrlR:
+			if e.search() {
+				return e.finish(), nil
+			}
+
+		case keyTab:
+			e.complete()
+
+		default:
+			if key >= ' ' {
+				e.insert(key)
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// finish ends the line being edited and returns it.
+func (e *editor) finish() string {
+	e.pos = len(e.buf)
+	e.refresh()
+	fmt.Fprint(e.out, "\r\n")
+
+	return string(e.buf)
+}
+
+// complete completes the word before the cursor, as far as all its completions agree,
+// and lists them when they agree no further. With nothing before the cursor to
+// complete, Tab indents.
+func (e *editor) complete() {
+	before := string(e.buf[:e.pos])
+
+	if last, _ := utf8.DecodeLastRuneInString(before); e.completer == nil || before == "" || unicode.IsSpace(last) {
+		e.insert(' ')
+		e.insert(' ')
+		return
+	}
+
+	completions, start := e.completer.Complete(before, len(before))
+	if len(completions) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+
+	common := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	for !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+
+	if len(completions) > 1 && common == before[start:] {
+		// Hash keys are listed without the "] that closes them.
+		listed := make([]string, len(completions))
+		for i, c := range completions {
+			listed[i] = strings.TrimSuffix(c, `"]`)
+		}
+
+		fmt.Fprint(e.out, "\r\n"+strings.Join(listed, "  ")+"\r\n")
+		e.row = 0
+		return
+	}
+
+	from := utf8.RuneCountInString(before[:start])
+	rest := append([]rune(common), e.buf[e.pos:]...)
+	e.buf = append(e.buf[:from], rest...)
+	e.pos = from + utf8.RuneCountInString(common)
+}
+
+func (e *editor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+func (e *editor) deleteAt(i int) {
+	if i < len(e.buf) {
+		e.buf = append(e.buf[:i], e.buf[i+1:]...)
+	}
+}
+
+func (e *editor) setLine(line string) {
+	e.buf = append(e.buf[:0], []rune(line)...)
+	e.pos = len(e.buf)
+}
+
+// refresh redraws the prompt and the line, and puts the cursor back in its place. The
+// lines of a recalled entry after its first follow the continuation prompt.
+func (e *editor) refresh() {
+	continued := "\n" + CONTINUATION_PROMPT
+	before := strings.ReplaceAll(string(e.buf[:e.pos]), "\n", continued)
+	after := strings.ReplaceAll(string(e.buf[e.pos:]), "\n", continued)
+
+	e.draw(e.prompt+before+after, utf8.RuneCountInString(e.prompt+before))
+}
+
+// draw replaces what was drawn last with text, and puts the cursor cursor runes into it.
+func (e *editor) draw(text string, cursor int) {
+	// Go back up to the row the last drawing started on, and clear from there down.
+	if e.row > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dA\r\x1b[J", e.row)
+	}
+
+	runes := []rune(text)
+	before, after := string(runes[:cursor]), string(runes[cursor:])
+
+	fmt.Fprint(e.out, "\r"+strings.ReplaceAll(text, "\n", "\r\n")+"\x1b[K")
+
+	if up := strings.Count(after, "\n"); up > 0 {
+		// Move up to the cursor's row, and across to its column from the left.
+		fmt.Fprintf(e.out, "\x1b[%dA\r", up)
+		if column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]); column > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dC", column)
+		}
+	} else if back := len(runes) - cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+
+	e.row = strings.Count(before, "\n")
+}
+
+// search runs a reverse incremental search of the history, as started by Ctrl-R: each
+// key typed narrows the search to older lines containing what has been typed so far,
+// and Ctrl-R again moves to the next older match. Enter runs the match, reported by
+// returning true; Ctrl-G or Ctrl-C goes back to the line as it was, and any other key
+// leaves the match to be edited.
+func (e *editor) search() bool {
+	original, originalPos := string(e.buf), e.pos
+
+	var query []rune
+	match := len(e.history.lines) // the index of the matching line
+	failed := false
+
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if strings.Contains(e.history.lines[i], string(query)) {
+				match, failed = i, false
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		found := ""
+		if match < len(e.history.lines) {
+			found = e.history.lines[match]
+		}
+
+		prefix := "(reverse-i-search)"
+		if failed {
+			prefix = "(failed reverse-i-search)"
+		}
+		text := fmt.Sprintf("%s`%s': %s", prefix, string(query), found)
+		e.draw(text, utf8.RuneCountInString(text))
+
+		key, err := e.readKey()
+		if err != nil {
+			e.setLine(original)
+			return false
+		}
+
+		switch key {
+		case keyCtrlR:
+			if match > 0 {
+				find(match - 1)
+			}
+
+		case keyBackspace, keyDelete:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history.lines) - 1)
+			}
+
+		case keyCtrlG, keyCtrlC:
+			e.setLine(original)
+			e.pos = originalPos
+			return false
+
+		case keyEnter, keyLineFeed:
+			e.setLine(found)
+			return true
+
+		default:
+			if key >= ' ' {
+				query = append(query, key)
+				find(min(match, len(e.history.lines)-1))
+				continue
+			}
+
+			e.setLine(found)
+			return false
+		}
+	}
+}
+
+// readKey reads a key press: a rune, a control character, or one of the keys that
+// terminals send as an escape sequence.
+func (e *editor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEscape {
+		return r, err
+	}
+
+	// A lone escape, or an escape sequence this editor does not know, does nothing.
+	next, _, err := e.in.ReadRune()
+	if err != nil {
+		return keyUnknown, nil
+	}
+	if next != '[' && next != 'O' {
+		return keyUnknown, nil
+	}
+
+	var param strings.Builder
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return keyUnknown, nil
+		}
+
+		if b >= '0' && b <= '9' || b == ';' {
+			param.WriteByte(b)
+			continue
+		}
+
+		switch {
+		case b == 'A':
+			return keyUp, nil
+		case b == 'B':
+			return keyDown, nil
+		case b == 'C':
+			return keyRight, nil
+		case b == 'D':
+			return keyLeft, nil
+		case b == 'H':
+			return keyHome, nil
+		case b == 'F':
+			return keyEnd, nil
+		case b == '~':
+			switch param.String() {
+			case "1", "7":
+				return keyHome, nil
+			case "4", "8":
+				return keyEnd, nil
+			case "3":
+				return keyForwardDelete, nil
+			}
+		}
+
+		return keyUnknown, nil
+	}
+}
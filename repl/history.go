@@ -0,0 +1,91 @@
+// File: repl/history.go
+
+package repl
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// maxHistory is how many entries of history are kept.
+const maxHistory = 1000
+
+// history is the entries made in the REPL, oldest first, which can be kept in a file
+// to carry over to later sessions. An entry of several lines is kept whole, and in the
+// file each of its lines but the last ends in a backslash.
+type history struct {
+	lines []string // the entries, each of one or more lines
+	file  string   // empty to keep the history in memory only
+}
+
+// loadHistory returns the history kept in file, which may not exist yet. Reading and
+// writing the history is best effort: a file that cannot be used leaves it in memory.
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var entry strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if continued, ok := strings.CutSuffix(line, "\\"); ok {
+			entry.WriteString(continued + "\n")
+			continue
+		}
+		h.lines = append(h.lines, entry.String()+line)
+		entry.Reset()
+	}
+
+	// The file is only ever appended to, so it is cut back to size once it has
+	// grown well past it.
+	if len(h.lines) > 2*maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+		var out strings.Builder
+		for _, entry := range h.lines {
+			out.WriteString(historyFileEntry(entry))
+		}
+		os.WriteFile(file, []byte(out.String()), 0o600)
+	} else if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+	}
+
+	return h
+}
+
+// add records an entry, unless it is blank or repeats the entry before it.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+
+	if h.file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(historyFileEntry(line))
+}
+
+// historyFileEntry returns an entry as it is written to the history file.
+func historyFileEntry(entry string) string {
+	return strings.ReplaceAll(entry, "\n", "\\\n") + "\n"
+}
@@ -0,0 +1,49 @@
+// File: repl/multiline.go
+
+package repl
+
+import (
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/token"
+)
+
+// continuesOnNextLine holds the tokens that cannot end an entry, because whatever
+// follows them must come on the next line.
+var continuesOnNextLine = map[token.TokenType]bool{
+	token.ASSIGN: true, token.PLUS_ASSIGN: true, token.MINUS_ASSIGN: true,
+	token.ASTERISK_ASSIGN: true, token.SLASH_ASSIGN: true,
+	token.PLUS: true, token.MINUS: true, token.ASTERISK: true, token.SLASH: true, token.PERCENT: true,
+	token.BANG: true, token.LT: true, token.GT: true, token.EQ: true, token.NOT_EQ: true,
+	token.LT_EQ: true, token.GT_EQ: true, token.AND: true, token.OR: true,
+	token.COMMA: true, token.DOT: true, token.COLON: true, token.ELSE: true,
+}
+
+// incomplete reports whether src is the start of an entry that goes on over more lines:
+// it has a bracket, brace or parenthesis that is not closed yet, a string that is not
+// terminated, or ends with an operator.
+func incomplete(src string) bool {
+	l := lexer.New(src)
+
+	depth := 0
+	var last token.Token
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.STRING:
+			// The lexer ends a string without its closing quote at the end of the input.
+			if tok.End.Offset-tok.Pos.Offset < len(tok.Literal)+2 {
+				return true
+			}
+		case token.COMMENT:
+			continue
+		}
+
+		last = tok
+	}
+
+	return depth > 0 || continuesOnNextLine[last.Type]
+}
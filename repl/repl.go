@@ -3,49 +3,339 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/compiler"
 	"github.com/shafik23/ys/evaluator"
 	"github.com/shafik23/ys/lexer"
 	"github.com/shafik23/ys/object"
 	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/vm"
 )
 
 const PROMPT = ">>> "
 
+// CONTINUATION_PROMPT is shown for the lines of an entry after its first.
+const CONTINUATION_PROMPT = "... "
+
+// The engines a program can be run with.
+const (
+	EngineEval = "eval" // the tree-walking evaluator
+	EngineVM   = "vm"   // the bytecode compiler and virtual machine
+)
+
+// Options configures a REPL session.
+type Options struct {
+	Engine      string   // EngineEval (the default) or EngineVM
+	SearchPath  []string // directories to search for imports not found relative to the working directory
+	HistoryFile string   // where to keep the history of a session at a terminal, if anywhere
+}
+
 // Start launches the REPL, taking input from an io.Reader and sending output to an io.Writer.
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// Programs read from and write to the same streams, so that `readline` reads the lines
+// typed after the one that called it.
+//
+// An entry goes on over as many lines as it takes to close its brackets and finish its
+// expressions. When in and out are a terminal, lines can be edited, and the history of
+// entries is kept in opts.HistoryFile.
+func Start(in io.Reader, out io.Writer, opts Options) {
+	s := newSession(out, object.NewIO(in, out, out), opts)
 
-	for {
-		fmt.Fprint(out, PROMPT)
+	s.run(newLineReader(in, out, s.streams, opts.HistoryFile, s.completer()))
+}
 
-		scanned := scanner.Scan()
-		if !scanned {
+// run reads entries from lines and runs them until the input ends or a command ends the
+// session.
+func (s *session) run(lines lineReader) {
+	for {
+		input, err := readInput(lines)
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		// An entry is recalled whole, however many lines it took.
+		lines.remember(strings.TrimRight(input, "\n"))
+
+		if isCommand(input) {
+			if !s.command(input) {
+				return
+			}
+			continue
+		}
+
+		s.eval("", input)
+	}
+}
+
+// session is the state of a REPL session, which entries and commands share.
+type session struct {
+	out     io.Writer
+	streams *object.IO
+	opts    Options
+	runner  runner
+}
+
+func newSession(out io.Writer, streams *object.IO, opts Options) *session {
+	s := &session{out: out, streams: streams, opts: opts}
+	s.reset()
+	return s
+}
+
+// completer returns a Completer for the names bound in the session.
+func (s *session) completer() *Completer {
+	return &Completer{bindings: func() map[string]object.Object {
+		return s.runner.bindings()
+	}}
+}
+
+// reset forgets everything defined in the session, and the modules it imported.
+func (s *session) reset() {
+	modules := evaluator.NewModules(s.opts.SearchPath)
+
+	if s.opts.Engine == EngineVM {
+		s.runner = newVMRunner(modules, s.streams)
+	} else {
+		s.runner = newEvaluatorRunner(modules, s.streams)
+	}
+}
+
+// parse parses src, which came from file if it is not empty, reporting any errors.
+func (s *session) parse(file, src string) (*ast.Program, bool) {
+	p := parser.New(lexer.NewFile(file, src))
 
-		program := p.ParseProgram()
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		printParserErrors(s.out, p.Errors())
+		return nil, false
+	}
+
+	return program, true
+}
+
+// eval runs src and prints its value.
+func (s *session) eval(file, src string) {
+	program, ok := s.parse(file, src)
+	if !ok {
+		return
+	}
+
+	s.print(s.runner.run(program))
+}
+
+// print shows the value of an entry, and the calls that led to it if it is an error
+// from inside a function.
+func (s *session) print(evaluated object.Object) {
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok && len(errObj.Stack) > 1 {
+		io.WriteString(s.out, errObj.StackTrace())
+	}
+}
+
+// readInput reads an entry, which ends at the first line that leaves it complete, or at
+// two blank lines in a row so that a mistake does not leave the REPL waiting forever. A
+// single blank line, as formatted code has inside function bodies, is part of the entry.
+// A command is an entry on its own. Ctrl-C abandons the entry and starts a new one.
+func readInput(lines lineReader) (string, error) {
+	var input strings.Builder
 
-		if len(p.Errors()) > 0 {
-			printParserErrors(out, p.Errors())
+	prompt := PROMPT
+	blank := false
+	for {
+		line, err := lines.readLine(prompt)
+		if err == errInterrupted {
+			input.Reset()
+			prompt = PROMPT
+			blank = false
+			continue
+		}
+		if err != nil {
+			if input.Len() > 0 {
+				return input.String(), nil
+			}
+			return "", err
+		}
+
+		if input.Len() > 0 && strings.TrimSpace(line) == "" {
+			if blank {
+				return input.String(), nil
+			}
+			blank = true
+			input.WriteString("\n")
 			continue
 		}
+		blank = false
+
+		// Commands take up a single line.
+		if input.Len() == 0 && isCommand(line) {
+			return line, nil
+		}
+
+		input.WriteString(line)
+		input.WriteString("\n")
+
+		if !incomplete(input.String()) {
+			return input.String(), nil
+		}
+		prompt = CONTINUATION_PROMPT
+	}
+}
+
+// lineReader reads the lines of input, one after a prompt, and keeps the history of
+// the entries they made up, if it has one.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+	remember(entry string)
+}
+
+// newLineReader returns a reader with line editing when in and out are a terminal, and
+// one that reads plain lines otherwise. The editor completes with completer.
+func newLineReader(in io.Reader, out io.Writer, streams *object.IO, historyFile string, completer *Completer) lineReader {
+	plain := &plainReader{streams: streams}
+
+	inFile, ok := in.(*os.File)
+	if !ok || !isTerminal(inFile.Fd()) {
+		return plain
+	}
+	if outFile, ok := out.(*os.File); !ok || !isTerminal(outFile.Fd()) {
+		return plain
+	}
+
+	return &terminalReader{
+		fd:     inFile.Fd(),
+		editor: newEditor(streams.In, out, loadHistory(historyFile), completer),
+		plain:  plain,
+	}
+}
+
+// plainReader reads lines as they come, for input that is not typed at a terminal.
+type plainReader struct {
+	streams *object.IO
+}
+
+// remember does nothing: input that is not typed has no history to recall.
+func (r *plainReader) remember(entry string) {}
+
+func (r *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.streams.Out, prompt)
+
+	line, err := r.streams.In.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// terminalReader reads lines typed at a terminal with the line editor. The terminal is
+// only in raw mode while a line is read, so that programs run with it as it was.
+type terminalReader struct {
+	fd     uintptr
+	editor *editor
+	plain  *plainReader // for when the terminal cannot be put in raw mode
+}
+
+func (r *terminalReader) remember(entry string) {
+	r.editor.history.add(entry)
+}
+
+func (r *terminalReader) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(r.fd)
+	if err != nil {
+		return r.plain.readLine(prompt)
+	}
+	defer restore()
+
+	return r.editor.readLine(prompt)
+}
+
+// runner evaluates the entries of a session, keeping whatever state its engine needs
+// between them.
+type runner interface {
+	run(program *ast.Program) object.Object
+
+	// bindings returns the values of the session's globals by name.
+	bindings() map[string]object.Object
+}
+
+type evaluatorRunner struct {
+	env       *object.Environment
+	evaluator *evaluator.Evaluator
+}
+
+func newEvaluatorRunner(modules *evaluator.Modules, streams *object.IO) *evaluatorRunner {
+	e := evaluator.New()
+	e.SetModules(modules)
+	e.SetIO(streams)
+
+	return &evaluatorRunner{env: object.NewEnvironment(), evaluator: e}
+}
+
+func (r *evaluatorRunner) run(program *ast.Program) object.Object {
+	return r.evaluator.Eval(program, r.env)
+}
+
+func (r *evaluatorRunner) bindings() map[string]object.Object {
+	return environmentBindings(r.env)
+}
+
+type vmRunner struct {
+	modules *evaluator.Modules
+	streams *object.IO
+
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newVMRunner(modules *evaluator.Modules, streams *object.IO) *vmRunner {
+	return &vmRunner{
+		modules:     modules,
+		streams:     streams,
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		symbolTable: compiler.New().SymbolTable(),
+	}
+}
+
+func (r *vmRunner) run(program *ast.Program) object.Object {
+	comp := compiler.NewWithState(r.symbolTable, r.constants)
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	bytecode := comp.Bytecode()
+	r.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, r.globals)
+	machine.SetModules(r.modules)
+	machine.SetIO(r.streams)
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
 
-		evaluated := evaluator.Eval(program, env)
+	return machine.LastPoppedStackElem()
+}
 
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+func (r *vmRunner) bindings() map[string]object.Object {
+	bindings := make(map[string]object.Object)
+	for index, name := range r.symbolTable.Globals() {
+		// A global whose definition failed has a slot but no value.
+		if name != "" && r.globals[index] != nil {
+			bindings[name] = r.globals[index]
 		}
 	}
+	return bindings
 }
 
 func printParserErrors(out io.Writer, errors []string) {
@@ -0,0 +1,399 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", false},
+		{"let add = fn(a, b) {", true},
+		{"let add = fn(a, b) {\n  a + b\n}", false},
+		{"[1, 2,", true},
+		{"{\"a\": 1", true},
+		{"puts(1,", true},
+		{"1 +", true},
+		{"let x =", true},
+		{"a &&", true},
+		{"xs.", true},
+		{"if (x) { 1 } else", true},
+		{"\"unterminated", true},
+		{"\"done\"", false},
+		{"\"{\"", false},
+		{"1 + // comment", true},
+		{"f(1)) + 2", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		engine   string
+		input    string
+		expected string
+	}{
+		{
+			EngineEval,
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1,\n2)\n",
+			">>> ... ... >>> ... 3\n>>> ",
+		},
+		{
+			EngineVM,
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1,\n2)\n",
+			">>> ... ... >>> ... 3\n>>> ",
+		},
+		{
+			// A blank line inside an entry is kept, as formatted code has them.
+			EngineEval,
+			"let a = fn() {\n\n  1\n};\na()\n",
+			">>> ... ... ... >>> 1\n>>> ",
+		},
+		{
+			// Two blank lines in a row give up on an entry that never completes.
+			EngineEval,
+			"let x = [1,\n\n\n5\n",
+			">>> ... ... Something UnWise happened:\n parser errors:\n\t3:1: no prefix parse function for EOF found\n\t3:1: expected next token to be ], got EOF instead\n>>> 5\n>>> ",
+		},
+		{
+			EngineEval,
+			"let s = readline();\nhello\ns\n",
+			">>> >>> hello\n>>> ",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out, Options{Engine: tt.engine})
+
+		if out.String() != tt.expected {
+			t.Errorf("engine %s, input %q: wrong output.\nwant=%q\ngot= %q", tt.engine, tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	lib := filepath.Join(dir, "lib.ys")
+	if err := os.WriteFile(lib, []byte("let square = fn(x) { x * x };\nlet names = [\"a\"];\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := filepath.Join(dir, "broken.ys")
+	if err := os.WriteFile(broken, []byte("let x = 1;\nx + true;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":help\n", commandHelp},
+		{":load " + lib + "\nsquare(3)\n", "loaded " + lib + "\n9\n"},
+		{":load " + broken + "\n", "ERROR: " + broken + ":2:1: type mismatch: INTEGER + BOOLEAN\n"},
+		{":load " + filepath.Join(dir, "missing.ys") + "\n", "open " + filepath.Join(dir, "missing.ys") + ": no such file or directory\n"},
+		{"let b = true;\nlet add = fn(a, b) { a + b };\nlet xs = [1];\n:env\n", "add  FUNCTION\nb    BOOLEAN\nxs   ARRAY\n"},
+		{":type 1 + 2\n:type \"s\"\n:type fn() {}\n", "INTEGER\nSTRING\nFUNCTION\n"},
+		{":type 1 + true\n", "ERROR: 1:1: type mismatch: INTEGER + BOOLEAN\n"},
+		{":ast 1 + 2\n", "Program 1:1\n  Statements:\n    [0]: ExpressionStatement 1:1\n      Expression: InfixExpression 1:1 Operator=\"+\"\n        Left: IntegerLiteral 1:1 Value=1\n        Right: IntegerLiteral 1:5 Value=2\n"},
+		{":ast 1 +\n", "Something UnWise happened:\n parser errors:\n\t1:4: no prefix parse function for EOF found\n"},
+		{":tokens let x = \"s\"\n", "1:1  LET     \"let\"\n1:5  IDENT   \"x\"\n1:7  =       \"=\"\n1:9  STRING  \"s\"\n"},
+		{"let x = 1;\n:reset\nx\n", "ERROR: 1:1: identifier not found: x\n"},
+		{":type\n:ast\n:tokens\n:load\n:time\n", "usage: :type expr\nusage: :ast expr\nusage: :tokens expr\nusage: :load file.ys\nusage: :time expr\n"},
+		{":bogus\n", "unknown command :bogus; type :help for a list\n"},
+		{"1\n:quit\n2\n", "1\n"},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			Start(strings.NewReader(tt.input), &out, Options{Engine: engine})
+
+			got := strings.ReplaceAll(out.String(), PROMPT, "")
+			if got != tt.expected {
+				t.Errorf("engine %s, input %q: wrong output.\nwant=%q\ngot= %q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	Start(strings.NewReader(":time 1 + 2\n"), &out, Options{})
+	if !strings.HasPrefix(out.String(), PROMPT+"3\ntime: ") {
+		t.Errorf(":time wrong output. got=%q", out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("total", &object.Integer{Value: 1})
+	env.Set("tally", &object.Integer{Value: 2})
+	env.Set("config", hash(map[string]object.Object{"name": evaluator.TRUE, "nest": evaluator.TRUE, "port": evaluator.TRUE, "say \"hi\"": evaluator.TRUE}))
+
+	inner := object.NewClosureEnvironment(env)
+	inner.Set("tiny", evaluator.TRUE)
+
+	tests := []struct {
+		line        string
+		completions []string
+		start       int
+	}{
+		{"ta", []string{"tally"}, 0},
+		{"t", []string{"tally", "throw", "tiny", "total", "true", "try"}, 0},
+		{"let x = to", []string{"total"}, 8},
+		{"pu", []string{"push", "puts"}, 0},
+		{"1 + fi", []string{"filter", "finally", "find", "first"}, 4},
+		{"re", []string{"readline", "reduce", "rest", "return", "reverse"}, 0},
+		{"conf", []string{"config"}, 0},
+		{"config[\"", []string{"name\"]", "nest\"]", "port\"]"}, 8},
+		{"config[\"n", []string{"name\"]", "nest\"]"}, 8},
+		{"puts(config[\"po", []string{"port\"]"}, 13},
+		{"total[\"", nil, 7},
+		{"missing[\"", nil, 9},
+		{"[\"", nil, 2},
+		{"puts(\"ta", nil, 8},
+		{"\"a\" + ta", []string{"tally"}, 6},
+		{"config.na", nil, 9},
+		{"xyz", nil, 0},
+		{"", nil, 0},
+		{"1 + ", nil, 4},
+	}
+
+	c := NewCompleter(inner)
+
+	for _, tt := range tests {
+		completions, start := c.Complete(tt.line, len(tt.line))
+
+		if strings.Join(completions, "|") != strings.Join(tt.completions, "|") {
+			t.Errorf("Complete(%q) wrong completions. want=%q, got=%q", tt.line, tt.completions, completions)
+		}
+		if len(completions) > 0 && start != tt.start {
+			t.Errorf("Complete(%q) wrong start. want=%d, got=%d", tt.line, tt.start, start)
+		}
+	}
+
+	// The cursor need not be at the end of the line.
+	if completions, start := c.Complete("ta + 1", 2); len(completions) != 1 || completions[0] != "tally" || start != 0 {
+		t.Errorf("Complete in the middle of a line wrong. got=%q, %d", completions, start)
+	}
+}
+
+func hash(pairs map[string]object.Object) *object.Hash {
+	h := object.NewHash()
+	for key, value := range pairs {
+		h.Set(&object.String{Value: key}, value)
+	}
+	return h
+}
+
+func TestEditorCompletion(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("total", &object.Integer{Value: 1})
+	env.Set("config", hash(map[string]object.Object{"name": evaluator.TRUE, "nest": evaluator.TRUE}))
+
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"tot\t + 1\r", "total + 1"},
+		{"config[\"na\t\r", "config[\"name\"]"},
+		{"pu\tt\t(1)\r", "puts(1)"},
+		{"\tx\r", "  x"},
+		{"xyz\t\r", "xyz"},
+		{"to + 1\x01\x06\x06\t\r", "total + 1"},
+	}
+
+	for _, tt := range tests {
+		e := newEditor(bufio.NewReader(strings.NewReader(tt.keys)), io.Discard, &history{}, NewCompleter(env))
+
+		line, err := e.readLine(PROMPT)
+		if err != nil {
+			t.Fatalf("keys %q: unexpected error: %s", tt.keys, err)
+		}
+		if line != tt.expected {
+			t.Errorf("keys %q: wrong line. want=%q, got=%q", tt.keys, tt.expected, line)
+		}
+	}
+
+	// Tab lists the completions when they agree no further.
+	var out bytes.Buffer
+	e := newEditor(bufio.NewReader(strings.NewReader("pu\t\rconfig[\"\t\t\r")), &out, &history{}, NewCompleter(env))
+	for i := 0; i < 2; i++ {
+		if _, err := e.readLine(PROMPT); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(out.String(), "\r\npush  puts\r\n") || !strings.Contains(out.String(), "\r\nname  nest\r\n") {
+		t.Errorf("completions not listed. got=%q", out.String())
+	}
+}
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []string
+		keys     string
+		expected []string
+	}{
+		{"typing", nil, "1 + 2\r", []string{"1 + 2"}},
+		{"line feed", nil, "abc\n", []string{"abc"}},
+		{"backspace", nil, "1 + 23\x7f\r", []string{"1 + 2"}},
+		{"insert after moving left", nil, "ac\x1b[Db\r", []string{"abc"}},
+		{"home and end", nil, "bc\x01a\x05d\r", []string{"abcd"}},
+		{"home and end sequences", nil, "bc\x1b[Ha\x1b[Fd\r", []string{"abcd"}},
+		{"forward delete", nil, "abxc\x1b[D\x1b[D\x1b[3~\r", []string{"abc"}},
+		{"kill to end", nil, "abc def\x01\x06\x06\x06\x0b\r", []string{"abc"}},
+		{"kill to start", nil, "abc def\x02\x02\x02\x15\r", []string{"def"}},
+		{"delete word", nil, "let total = \x17\x17x\r", []string{"let x"}},
+		{"ctrl-d deletes under the cursor", nil, "abxc\x02\x02\x04\r", []string{"abc"}},
+		{"up", []string{"one", "two"}, "\x1b[A\r", []string{"two"}},
+		{"up twice", []string{"one", "two"}, "\x1b[A\x1b[A\r", []string{"one"}},
+		{"up past the oldest", []string{"one", "two"}, "\x10\x10\x10\r", []string{"one"}},
+		{"down back to the draft", []string{"one", "two"}, "dr\x1b[A\x1b[A\x1b[B\x1b[Baft\r", []string{"draft"}},
+		{"edit recalled line", []string{"x + 1"}, "\x1b[A\x7f2\r", []string{"x + 2"}},
+		{"search", []string{"let a = 1", "puts(a)", "let b = 2"}, "\x12put\r", []string{"puts(a)"}},
+		{"search again", []string{"let a = 1", "puts(a)", "let b = 2"}, "\x12let\x12\r", []string{"let a = 1"}},
+		{"search then edit", []string{"let a = 1", "puts(a)"}, "\x12let\x05 + 1\r", []string{"let a = 1 + 1"}},
+		{"search cancelled", []string{"let a = 1"}, "x\x12let\x07y\r", []string{"xy"}},
+		{"search backspace", []string{"abc", "abd"}, "\x12abc\x7f\r", []string{"abd"}},
+		{"interrupt", nil, "abc\x03def\r", []string{"<interrupted>", "def"}},
+		{"ctrl-d on an empty line", nil, "\x04", []string{"<EOF>"}},
+		{"end of input", nil, "abc", []string{"abc", "<EOF>"}},
+		{"unknown sequence", nil, "a\x1b[5~b\r", []string{"ab"}},
+		{"unicode", nil, "\"héllo\"\x7f\x7f\x7f\x7f\"\r", []string{"\"hé\""}},
+	}
+
+	for _, tt := range tests {
+		h := &history{lines: append([]string(nil), tt.history...)}
+		e := newEditor(bufio.NewReader(strings.NewReader(tt.keys)), io.Discard, h, nil)
+
+		var got []string
+		for {
+			line, err := e.readLine(PROMPT)
+			if err == errInterrupted {
+				got = append(got, "<interrupted>")
+				continue
+			}
+			if err == io.EOF {
+				got = append(got, "<EOF>")
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", tt.name, err)
+			}
+			got = append(got, line)
+		}
+
+		want := tt.expected
+		if want[len(want)-1] != "<EOF>" {
+			want = append(want, "<EOF>")
+		}
+
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: wrong lines. want=%q, got=%q", tt.name, want, got)
+		}
+	}
+}
+
+// editorReader reads lines with an editor, as a terminalReader does without a terminal
+// to put in raw mode, and notes each line it reads.
+type editorReader struct {
+	editor *editor
+	read   []string
+}
+
+func (r *editorReader) readLine(prompt string) (string, error) {
+	line, err := r.editor.readLine(prompt)
+	if err == nil {
+		r.read = append(r.read, line)
+	}
+	return line, err
+}
+
+func (r *editorReader) remember(entry string) {
+	r.editor.history.add(entry)
+}
+
+func TestEntryHistory(t *testing.T) {
+	// An entry of three lines, then Up to recall it whole and Enter to run it again.
+	keys := "let f = fn(x) {\r  x * 2\r};\rf(2)\r\x1b[A\x1b[A\r"
+
+	var out bytes.Buffer
+	s := newSession(&out, object.NewIO(strings.NewReader(""), &out, &out), Options{Engine: EngineEval})
+	h := &history{}
+	var drawn bytes.Buffer
+	lines := &editorReader{editor: newEditor(bufio.NewReader(strings.NewReader(keys)), &drawn, h, nil)}
+	s.run(lines)
+
+	entry := "let f = fn(x) {\n  x * 2\n};"
+	expected := []string{entry, "f(2)", entry}
+	if strings.Join(h.lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong history. want=%q, got=%q", expected, h.lines)
+	}
+	if last := lines.read[len(lines.read)-1]; last != entry {
+		t.Errorf("wrong recalled entry. want=%q, got=%q", entry, last)
+	}
+	// The recalled entry is drawn with its later lines after the continuation prompt.
+	if !strings.Contains(drawn.String(), "\r"+PROMPT+"let f = fn(x) {\r\n"+CONTINUATION_PROMPT+"  x * 2\r\n"+CONTINUATION_PROMPT+"};") {
+		t.Errorf("recalled entry not drawn on its lines. got=%q", drawn.String())
+	}
+	if out.String() != "4\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "4\n", out.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	h := loadHistory(file)
+	for _, line := range []string{"one", "", "two", "two", "  ", "three", "let f = fn() {\n  1\n};"} {
+		h.add(line)
+	}
+
+	expected := []string{"one", "two", "three", "let f = fn() {\n  1\n};"}
+	if strings.Join(h.lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong history. want=%q, got=%q", expected, h.lines)
+	}
+
+	reloaded := loadHistory(file)
+	if strings.Join(reloaded.lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong history from file. want=%q, got=%q", expected, reloaded.lines)
+	}
+
+	var long strings.Builder
+	for i := 0; i < 2*maxHistory+1; i++ {
+		long.WriteString("line\n")
+	}
+	if err := os.WriteFile(file, []byte(long.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(loadHistory(file).lines); got != maxHistory {
+		t.Errorf("history not cut to size. want=%d lines, got=%d", maxHistory, got)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(content), "\n"); got != maxHistory {
+		t.Errorf("history file not cut to size. want=%d lines, got=%d", maxHistory, got)
+	}
+}
@@ -0,0 +1,12 @@
+// File: repl/term_bsd.go
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package repl
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
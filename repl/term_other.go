@@ -0,0 +1,17 @@
+// File: repl/term_other.go
+
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package repl
+
+import "errors"
+
+// Line editing needs a Unix terminal; elsewhere the REPL reads plain lines.
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
@@ -2,11 +2,44 @@
 
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Pos // position of the token's first character
+	End     Pos // position immediately after the token's last character
+}
+
+// Pos is a location in source code.
+type Pos struct {
+	File   string // file name, empty if the source did not come from a file
+	Offset int    // byte offset, starting at 0
+	Line   int    // line number, starting at 1
+	Column int    // column number in bytes, starting at 1
+}
+
+// IsValid reports whether the position was set by the lexer.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:col, or line:col when there is no file name.
+func (p Pos) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+		return "-"
+	}
+
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // Define the token types as constants.
@@ -17,6 +50,7 @@ const (
 	// Identifiers + literals
 	IDENT  TokenType = "IDENT"
 	INT    TokenType = "INT"
+	FLOAT  TokenType = "FLOAT"
 	STRING TokenType = "STRING"
 
 	// Comments
@@ -37,9 +71,23 @@ const (
 	GT       TokenType = ">"
 	EQ       TokenType = "=="
 	NOT_EQ   TokenType = "!="
+	LT_EQ    TokenType = "<="
+	GT_EQ    TokenType = ">="
+	PERCENT  TokenType = "%"
+
+	// Logical operators
+	AND TokenType = "&&"
+	OR  TokenType = "||"
+
+	// Compound assignment operators
+	PLUS_ASSIGN     TokenType = "+="
+	MINUS_ASSIGN    TokenType = "-="
+	ASTERISK_ASSIGN TokenType = "*="
+	SLASH_ASSIGN    TokenType = "/="
 
 	// Delimiters
 	COMMA     TokenType = ","
+	DOT       TokenType = "."
 	SEMICOLON TokenType = ";"
 	COLON     TokenType = ":"
 	LPAREN    TokenType = "("
@@ -56,4 +104,16 @@ const (
 	IF       TokenType = "IF"
 	ELSE     TokenType = "ELSE"
 	RETURN   TokenType = "RETURN"
+	WHILE    TokenType = "WHILE"
+	FOR      TokenType = "FOR"
+	IN       TokenType = "IN"
+	BREAK    TokenType = "BREAK"
+	CONTINUE TokenType = "CONTINUE"
+	TRY      TokenType = "TRY"
+	CATCH    TokenType = "CATCH"
+	FINALLY  TokenType = "FINALLY"
+	THROW    TokenType = "THROW"
+	IMPORT   TokenType = "IMPORT"
+	FROM     TokenType = "FROM"
+	AS       TokenType = "AS"
 )
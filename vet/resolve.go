@@ -0,0 +1,263 @@
+// File: vet/resolve.go
+
+package vet
+
+import (
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+)
+
+// BindingKind says how a name was defined.
+type BindingKind int
+
+const (
+	Predeclared BindingKind = iota // defined by the host
+	Let
+	Parameter
+	LoopVariable
+	CatchVariable
+	Import
+)
+
+// A Binding is a variable a program defines. Defining a name again in the same
+// function, with let or otherwise, sets the same variable rather than making a new one.
+type Binding struct {
+	Name  *ast.Identifier // where the variable is first defined; nil if predeclared
+	Kind  BindingKind
+	Local bool // defined inside a function rather than at the top level
+
+	// Value is the expression the variable is bound to if it is only ever bound to
+	// that one, by a let, and nil otherwise.
+	Value ast.Expression
+
+	Reads   int      // how many times the variable's value is read
+	Shadows *Binding // the variable of the same name in an enclosing scope it hides, if any
+}
+
+// Info is what the names in a program refer to.
+type Info struct {
+	Bindings []*Binding // the program's variables, in the order they are defined
+
+	// Refs maps each identifier that refers to a variable, where it is defined, read or
+	// assigned to, to the variable.
+	Refs map[*ast.Identifier]*Binding
+
+	Builtins  map[*ast.Identifier]bool // identifiers that refer to builtins
+	Undefined []*ast.Identifier        // identifiers that refer to nothing
+}
+
+// Resolve works out what each name in a program refers to. As when the program runs,
+// only functions have scopes of their own, and a name refers to the variable defined
+// most recently in the innermost scope that has one. A function's body is resolved once
+// the rest of the scope it is defined in has been, as it will usually be called after
+// the variables it uses have all been defined.
+func Resolve(program *ast.Program, predeclared ...string) *Info {
+	r := &resolver{info: &Info{
+		Refs:     map[*ast.Identifier]*Binding{},
+		Builtins: map[*ast.Identifier]bool{},
+	}, discarded: map[*ast.AssignExpression]bool{}}
+
+	universe := newScope(nil)
+	for _, name := range predeclared {
+		universe.names[name] = &Binding{Kind: Predeclared}
+	}
+
+	r.scope = newScope(universe)
+	r.statements(program.Statements)
+	r.close()
+
+	return r.info
+}
+
+type scope struct {
+	outer *scope
+	names map[string]*Binding
+
+	// The bodies of the functions defined in the scope, to resolve when it is closed.
+	deferred []func()
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, names: map[string]*Binding{}}
+}
+
+type resolver struct {
+	info  *Info
+	scope *scope
+
+	// discarded holds the assignments made as statements whose value is not used:
+	// those followed by another statement in their block, or in the body of a loop.
+	discarded map[*ast.AssignExpression]bool
+}
+
+// close resolves the bodies of the functions defined in the current scope, and those
+// defined in them in turn.
+func (r *resolver) close() {
+	for i := 0; i < len(r.scope.deferred); i++ {
+		r.scope.deferred[i]()
+	}
+}
+
+// local reports whether the current scope is a function's.
+func (r *resolver) local() bool {
+	return r.scope.outer.outer != nil
+}
+
+func (r *resolver) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.walk(stmt)
+	}
+}
+
+// walk resolves the names in node, in the order they would be evaluated.
+func (r *resolver) walk(node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			r.walk(node.Value)
+			r.define(node.Name, Let, node.Value)
+			return false
+
+		case *ast.BlockStatement:
+			if len(node.Statements) > 0 {
+				r.discard(node.Statements[:len(node.Statements)-1])
+			}
+
+		case *ast.WhileStatement:
+			r.discard(node.Body.Statements)
+
+		case *ast.ForStatement:
+			r.discard(node.Body.Statements)
+
+		case *ast.AssignExpression:
+			// Assigning to a variable only reads it with an operator such as +=, or when
+			// the assignment's value, which is the variable's new value, is used.
+			if target, ok := node.Target.(*ast.Identifier); ok {
+				r.walk(node.Value)
+				if b := r.lookup(target.Value); b != nil {
+					r.info.Refs[target] = b
+					b.Value = nil
+					if node.Operator != "=" || !r.discarded[node] {
+						b.Reads++
+					}
+				} else {
+					r.info.Undefined = append(r.info.Undefined, target)
+				}
+				return false
+			}
+
+		case *ast.ForInStatement:
+			r.discard(node.Body.Statements)
+			r.walk(node.Iterable)
+			if node.Key != nil {
+				r.define(node.Key, LoopVariable, nil)
+			}
+			r.define(node.Value, LoopVariable, nil)
+			r.walk(node.Body)
+			return false
+
+		case *ast.TryExpression:
+			r.walk(node.Block)
+			if node.Catch != nil {
+				r.define(node.Param, CatchVariable, nil)
+				r.walk(node.Catch)
+			}
+			if node.Finally != nil {
+				r.walk(node.Finally)
+			}
+			return false
+
+		case *ast.ImportStatement:
+			if node.Alias != nil {
+				r.define(node.Alias, Import, nil)
+			}
+			for _, name := range node.Names {
+				r.define(name, Import, nil)
+			}
+			return false
+
+		case *ast.MemberExpression:
+			// The member is a name in the module or hash, not a variable.
+			r.walk(node.Object)
+			return false
+
+		case *ast.FunctionLiteral:
+			outer := r.scope
+			outer.deferred = append(outer.deferred, func() {
+				r.scope = newScope(outer)
+				for _, param := range node.Parameters {
+					r.define(param, Parameter, nil)
+				}
+				r.walk(node.Body)
+				r.close()
+				r.scope = outer
+			})
+			return false
+
+		case *ast.Identifier:
+			r.read(node)
+		}
+
+		return true
+	})
+}
+
+// discard records the assignments among stmts whose value is not used.
+func (r *resolver) discard(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		if stmt, ok := stmt.(*ast.ExpressionStatement); ok {
+			if assign, ok := stmt.Expression.(*ast.AssignExpression); ok {
+				r.discarded[assign] = true
+			}
+		}
+	}
+}
+
+// define defines the variable name in the current scope, or sets it if it is already
+// defined there.
+func (r *resolver) define(name *ast.Identifier, kind BindingKind, value ast.Expression) {
+	if b, ok := r.scope.names[name.Value]; ok {
+		r.info.Refs[name] = b
+		b.Value = nil
+		return
+	}
+
+	b := &Binding{Name: name, Kind: kind, Local: r.local(), Value: value}
+
+	// Predeclared names are not shadowed, but replaced, by those defined at the top level.
+	for s := r.scope.outer; s != nil && s.outer != nil; s = s.outer {
+		if outer, ok := s.names[name.Value]; ok {
+			b.Shadows = outer
+			break
+		}
+	}
+
+	r.scope.names[name.Value] = b
+	r.info.Refs[name] = b
+	r.info.Bindings = append(r.info.Bindings, b)
+}
+
+// read resolves an identifier whose value is read.
+func (r *resolver) read(id *ast.Identifier) {
+	if b := r.lookup(id.Value); b != nil {
+		r.info.Refs[id] = b
+		b.Reads++
+		return
+	}
+
+	if _, ok := evaluator.LookupBuiltin(id.Value); ok {
+		r.info.Builtins[id] = true
+		return
+	}
+
+	r.info.Undefined = append(r.info.Undefined, id)
+}
+
+func (r *resolver) lookup(name string) *Binding {
+	for s := r.scope; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// File: vet/rules.go
+
+package vet
+
+import (
+	"fmt"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+func init() {
+	Register(&Rule{Name: "unused", Doc: "variables defined with let in a function that are never used", Run: unused})
+	Register(&Rule{Name: "shadow", Doc: "variables that hide a variable of the same name in an enclosing scope", Run: shadow})
+	Register(&Rule{Name: "arity", Doc: "calls to functions and builtins with the wrong number of arguments", Run: arity})
+	Register(&Rule{Name: "unreachable", Doc: "statements after a return, throw, break or continue", Run: unreachable})
+	Register(&Rule{Name: "undefined", Doc: "names that are not defined", Run: undefined})
+	Register(&Rule{Name: "compare", Doc: "comparisons of literals of types that cannot be equal or ordered", Run: compare})
+}
+
+// unused reports let bindings in functions whose values are never read. Those at the top
+// level are not reported, as another file may import them.
+func unused(pass *Pass) {
+	for _, b := range pass.Info.Bindings {
+		if b.Kind == Let && b.Local && b.Reads == 0 && b.Name.Value != "_" {
+			pass.Reportf(b.Name.Pos(), "%s is defined but never used", b.Name.Value)
+		}
+	}
+}
+
+func shadow(pass *Pass) {
+	for _, b := range pass.Info.Bindings {
+		if b.Shadows != nil {
+			pass.Reportf(b.Name.Pos(), "%s shadows the %s defined at line %d", b.Name.Value, b.Name.Value, b.Shadows.Name.Pos().Line)
+		}
+	}
+}
+
+// arity checks calls by name to builtins, and to variables only ever bound to a function
+// literal.
+func arity(pass *Pass) {
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return true
+		}
+
+		name, ok := call.Function.(*ast.Identifier)
+		if !ok {
+			return true
+		}
+
+		var min, max int
+		if b := pass.Info.Refs[name]; b != nil {
+			fn, ok := b.Value.(*ast.FunctionLiteral)
+			if !ok {
+				return true
+			}
+			min, max = len(fn.Parameters), len(fn.Parameters)
+		} else if pass.Info.Builtins[name] {
+			min, max, _ = evaluator.BuiltinArity(name.Value)
+		} else {
+			return true
+		}
+
+		if n := len(call.Arguments); n < min || (max >= 0 && n > max) {
+			pass.Reportf(call.Pos(), "%s takes %s, but is called with %d", name.Value, arguments(min, max), n)
+		}
+
+		return true
+	})
+}
+
+// arguments describes how many arguments a function takes.
+func arguments(min, max int) string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+
+	switch {
+	case max < 0:
+		return "at least " + plural(min)
+	case min == max:
+		return plural(min)
+	case max == min+1:
+		return fmt.Sprintf("%d or %s", min, plural(max))
+	default:
+		return fmt.Sprintf("%d to %s", min, plural(max))
+	}
+}
+
+// unreachable reports the first statement after one that always leaves the block it is
+// in.
+func unreachable(pass *Pass) {
+	check := func(stmts []ast.Statement) {
+		for i := 0; i+1 < len(stmts); i++ {
+			if terminates(stmts[i]) {
+				pass.Reportf(stmts[i+1].Pos(), "unreachable code")
+				return
+			}
+		}
+	}
+
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			check(node.Statements)
+		case *ast.BlockStatement:
+			check(node.Statements)
+		}
+		return true
+	})
+}
+
+// terminates reports whether a statement always leaves the block it is in: it returns,
+// throws, breaks or continues, or is an if whose branches all do.
+func terminates(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	case *ast.ExpressionStatement:
+		if exp, ok := stmt.Expression.(*ast.IfExpression); ok && exp.Alternative != nil {
+			return blockTerminates(exp.Consequence) && blockTerminates(exp.Alternative)
+		}
+	}
+	return false
+}
+
+func blockTerminates(block *ast.BlockStatement) bool {
+	return len(block.Statements) > 0 && terminates(block.Statements[len(block.Statements)-1])
+}
+
+func undefined(pass *Pass) {
+	for _, id := range pass.Info.Undefined {
+		pass.Reportf(id.Pos(), "undefined: %s", id.Value)
+	}
+}
+
+// compare reports comparisons between literals of different types: those with == and !=
+// always come out the same, and those with <, >, <= and >= fail.
+func compare(pass *Pass) {
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		exp, ok := node.(*ast.InfixExpression)
+		if !ok {
+			return true
+		}
+
+		left, right := literalType(exp.Left), literalType(exp.Right)
+		if left == "" || right == "" || left == right || (numeric(left) && numeric(right)) {
+			return true
+		}
+
+		switch exp.Operator {
+		case "==":
+			pass.Reportf(exp.Pos(), "comparison of %s and %s is always false", left, right)
+		case "!=":
+			pass.Reportf(exp.Pos(), "comparison of %s and %s is always true", left, right)
+		case "<", ">", "<=", ">=":
+			pass.Reportf(exp.Pos(), "cannot compare %s and %s with %s", left, right, exp.Operator)
+		}
+
+		return true
+	})
+}
+
+// literalType returns the type of a literal, or "" if exp is not one.
+func literalType(exp ast.Expression) object.ObjectType {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.FloatLiteral:
+		return object.FLOAT_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.ArrayLiteral:
+		return object.ARRAY_OBJ
+	case *ast.HashLiteral:
+		return object.HASH_OBJ
+	case *ast.FunctionLiteral:
+		return object.FUNCTION_OBJ
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			return object.BOOLEAN_OBJ
+		}
+		if t := literalType(exp.Right); numeric(t) {
+			return t
+		}
+	}
+	return ""
+}
+
+func numeric(t object.ObjectType) bool {
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
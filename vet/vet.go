@@ -0,0 +1,111 @@
+// File: vet/vet.go
+
+// Package vet reports suspicious constructs in Ys programs, such as variables that are
+// never used or calls with the wrong number of arguments, without running them. Each
+// kind of mistake is found by a Rule; the rules this package defines are registered
+// when it is loaded, and others can be added with Register.
+package vet
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/token"
+)
+
+// A Rule finds one kind of mistake.
+type Rule struct {
+	Name string // a short lower-case name, used to enable and disable the rule
+	Doc  string // a one-line description of what the rule reports
+
+	// Run looks for mistakes in pass.Program and reports them with pass.Reportf.
+	Run func(pass *Pass)
+}
+
+// A Pass is one rule's run over a program.
+type Pass struct {
+	Rule    *Rule
+	Program *ast.Program
+	Info    *Info // what each name in the program refers to
+
+	diagnostics *[]Diagnostic
+}
+
+// Reportf reports a mistake found at pos.
+func (p *Pass) Reportf(pos token.Pos, format string, a ...interface{}) {
+	*p.diagnostics = append(*p.diagnostics, Diagnostic{Pos: pos, Rule: p.Rule.Name, Message: fmt.Sprintf(format, a...)})
+}
+
+// A Diagnostic is a mistake a rule found.
+type Diagnostic struct {
+	Pos     token.Pos
+	Rule    string
+	Message string
+}
+
+// String formats the diagnostic as file:line:col: message (rule).
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// MarshalJSON encodes the diagnostic as an object with the fields file, line, column,
+// rule and message.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	}{d.Pos.File, d.Pos.Line, d.Pos.Column, d.Rule, d.Message})
+}
+
+var registry = map[string]*Rule{}
+
+// Register adds a rule to those Rules returns. It panics if a rule with the same name
+// is already registered.
+func Register(rule *Rule) {
+	if _, ok := registry[rule.Name]; ok {
+		panic("vet: rule registered twice: " + rule.Name)
+	}
+	registry[rule.Name] = rule
+}
+
+// Rules returns the registered rules, sorted by name.
+func Rules() []*Rule {
+	rules := make([]*Rule, 0, len(registry))
+	for _, rule := range registry {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// Lookup returns the registered rule called name.
+func Lookup(name string) (*Rule, bool) {
+	rule, ok := registry[name]
+	return rule, ok
+}
+
+// Check runs rules over a program that parsed without errors, and returns what they
+// report in the order it appears in the source. Predeclared names the variables the
+// host defines before the program runs, such as args.
+func Check(program *ast.Program, rules []*Rule, predeclared ...string) []Diagnostic {
+	info := Resolve(program, predeclared...)
+
+	var diagnostics []Diagnostic
+	for _, rule := range rules {
+		rule.Run(&Pass{Rule: rule, Program: program, Info: info, diagnostics: &diagnostics})
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Pos.Offset != diagnostics[j].Pos.Offset {
+			return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+		}
+		return diagnostics[i].Rule < diagnostics[j].Rule
+	})
+
+	return diagnostics
+}
@@ -0,0 +1,150 @@
+package vet
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("input %q: parser errors: %v", input, errs)
+	}
+
+	return program
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// unused
+		{"let f = fn() { let x = 1; 2 }; f()", []string{"1:20: x is defined but never used (unused)"}},
+		{"let f = fn() { let x = 1; x = 2; 3 }; f()", []string{"1:20: x is defined but never used (unused)"}},
+		{"let f = fn() { let x = 1; let x = x + 1; x }; f()", nil},
+		{"let r = fn() { let n = 0; let inc = fn() { n += 1 }; inc }; r()", nil},
+		{"let r = fn() { let n = 0; let set = fn(v) { n = v }; set }; r()", nil},
+		{"let f = fn() { let x = 1; while (true) { x = 2 }; 3 }; f()", []string{"1:20: x is defined but never used (unused)"}},
+		{"let f = fn() { let _ = 1; let g = fn() { 2 }; g() }; f()", nil},
+		{"let x = 1; let unusedAtTopLevel = 2", nil},
+
+		// shadow
+		{"let x = 1; let f = fn(x) { x }; f(x)", []string{"1:23: x shadows the x defined at line 1 (shadow)"}},
+		{"let x = 1;\nlet f = fn() { for (x in [1]) { puts(x) } };\nf()", []string{"2:21: x shadows the x defined at line 1 (shadow)"}},
+		{"let f = fn(a) { let a = a * 2; a }; f(1)", nil},
+		{"let args = 1; let f = fn(len) { len }; f(args)", nil},
+
+		// arity
+		{"let add = fn(a, b) { a + b }; add(1)", []string{"1:31: add takes 2 arguments, but is called with 1 (arity)"}},
+		{"len(1, 2); round(1, 2, 3); printf(); readline(1)", []string{
+			"1:1: len takes 1 argument, but is called with 2 (arity)",
+			"1:12: round takes 1 or 2 arguments, but is called with 3 (arity)",
+			"1:28: printf takes at least 1 argument, but is called with 0 (arity)",
+			"1:38: readline takes 0 arguments, but is called with 1 (arity)",
+		}},
+		{"let f = fn(a) { a }; f = fn(a, b) { a }; f(1, 2)", nil},
+		{"let len = fn(a, b) { a }; len(1, 2); puts(); puts(1, 2, 3)", nil},
+		{"let f = fn(n) { if (n > 0) { f(n - 1, 0) } else { 0 } }; f(3)", []string{"1:30: f takes 1 argument, but is called with 2 (arity)"}},
+
+		// unreachable
+		{"let f = fn() { return 1; puts(2) }; f()", []string{"1:26: unreachable code (unreachable)"}},
+		{"while (true) { break; puts(1); puts(2) }", []string{"1:23: unreachable code (unreachable)"}},
+		{"let f = fn(x) { if (x) { return 1 } else { throw 2 }; 3 }; f(true)", []string{"1:55: unreachable code (unreachable)"}},
+		{"let f = fn(x) { if (x) { return 1 }; 3 }; f(true)", nil},
+
+		// undefined
+		{"puts(y); z = 1", []string{"1:6: undefined: y (undefined)", "1:10: undefined: z (undefined)"}},
+		{"let f = fn() { g() }; let g = fn() { f() }; f()", nil},
+		{"let f = fn() { puts(x); let x = 1; x }; f()", []string{"1:21: undefined: x (undefined)"}},
+		{"for (let i = 0; i < 3; i += 1) { puts(i) }; for (k, v in {}) { puts(k, v) }", nil},
+		{"let r = try { 1 } catch (e) { e[\"message\"] }; r", nil},
+		{"import \"lib/math.ys\"; from \"x.ys\" import a; math.square(a)", nil},
+		{"let h = {\"a\": 1}; h.a; h.b", nil},
+		{"puts(args)", nil},
+
+		// compare
+		{`1 == "1"; 1.5 != true; [] < {}; 1 == 2.0; -1 < 0; !x == "no"`, []string{
+			"1:1: comparison of INTEGER and STRING is always false (compare)",
+			"1:11: comparison of FLOAT and BOOLEAN is always true (compare)",
+			"1:24: cannot compare ARRAY and HASH with < (compare)",
+			"1:51: comparison of BOOLEAN and STRING is always false (compare)",
+			"1:52: undefined: x (undefined)",
+		}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		var got []string
+		for _, d := range Check(program, Rules(), "args") {
+			got = append(got, d.String())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("input %q: wrong diagnostics.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	var names []string
+	for _, rule := range Rules() {
+		names = append(names, rule.Name)
+	}
+
+	if got := strings.Join(names, " "); got != "arity compare shadow undefined unreachable unused" {
+		t.Errorf("wrong rules. got=%q", got)
+	}
+
+	custom := &Rule{Name: "no_puts", Doc: "calls to puts", Run: func(pass *Pass) {
+		ast.Inspect(pass.Program, func(node ast.Node) bool {
+			if call, ok := node.(*ast.CallExpression); ok && call.Function.String() == "puts" {
+				pass.Reportf(call.Pos(), "puts called")
+			}
+			return true
+		})
+	}}
+
+	undefined, ok := Lookup("undefined")
+	if !ok {
+		t.Fatal("the undefined rule is not registered")
+	}
+
+	program := parse(t, "let f = fn() { let y = 1; puts(x) }; f()")
+
+	var got []string
+	for _, d := range Check(program, []*Rule{custom, undefined}) {
+		got = append(got, d.String())
+	}
+
+	expected := "1:27: puts called (no_puts)\n1:32: undefined: x (undefined)"
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("wrong diagnostics.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	p := parser.New(lexer.NewFile("a.ys", "\n  puts(x)"))
+	program := p.ParseProgram()
+
+	diagnostics := Check(program, Rules())
+
+	data, err := json.Marshal(diagnostics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"file":"a.ys","line":2,"column":8,"rule":"undefined","message":"undefined: x"}]`
+	if string(data) != expected {
+		t.Errorf("wrong JSON.\nwant=%s\ngot= %s", expected, data)
+	}
+}
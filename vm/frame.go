@@ -0,0 +1,32 @@
+// File: vm/frame.go
+
+package vm
+
+import (
+	"github.com/shafik23/ys/code"
+	"github.com/shafik23/ys/object"
+)
+
+// Frame is the activation record of a closure call.
+type Frame struct {
+	cl          *object.Closure
+	ip          int // instruction pointer within the closure's instructions
+	basePointer int // stack slot where the frame's locals start
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// handler is an exception handler: where to resume when an error is raised, and the
+// frame and stack height to unwind to first.
+type handler struct {
+	target      int  // the handler's offset in the instructions of its frame
+	catches     bool // whether it expects the error's value (a catch) or the error itself
+	framesIndex int
+	sp          int
+}
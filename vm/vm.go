@@ -0,0 +1,1028 @@
+// File: vm/vm.go
+
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/code"
+	"github.com/shafik23/ys/compiler"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+const (
+	StackSize   = 1 << 16
+	GlobalsSize = 1 << 16
+	MaxFrames   = 1 << 14
+)
+
+// infixOperators maps binary opcodes back to the operator the evaluator implements,
+// which the VM falls back to for anything but plain integer arithmetic.
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+}
+
+// VM executes bytecode produced by the compiler.
+type VM struct {
+	builtins []*object.Builtin
+	modules  *evaluator.Modules
+	budget   *evaluator.Budget
+	io       *object.IO
+
+	stack []object.Object
+	sp    int // always points to the next free slot; the top of the stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler // the exception handlers installed by OpTry, innermost last
+
+	// returnFrame is the frames index that a return to ends run: the frame of the
+	// builtin calling back the closure being run, or 0 if no builtin is.
+	returnFrame int
+
+	lastPopped object.Object
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobalsStore(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobalsStore returns a VM that reads and writes the given globals, so that
+// state survives across runs as it does in the REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	unit := &object.Unit{Constants: bytecode.Constants, Globals: globals, GlobalNames: bytecode.Globals}
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions}
+	mainClosure := &object.Closure{Fn: mainFn, Unit: unit}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	names := evaluator.BuiltinNames()
+	builtins := make([]*object.Builtin, len(names))
+	for i, name := range names {
+		builtins[i], _ = evaluator.LookupBuiltin(name)
+	}
+
+	return &VM{
+		builtins: builtins,
+		modules:  evaluator.NewModules(nil),
+		io:       object.StandardIO(),
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// SetModules makes the VM load imports with m, sharing its cache of loaded modules.
+func (vm *VM) SetModules(m *evaluator.Modules) {
+	vm.modules = m
+}
+
+// SetIO makes the builtins that programs call read from and write to streams.
+func (vm *VM) SetIO(streams *object.IO) {
+	vm.io = streams
+}
+
+// SetBudget makes the VM charge its work to b, and stop with a LimitError once b runs
+// out or its context is done. A nil b lifts the limits.
+func (vm *VM) SetBudget(b *evaluator.Budget) {
+	vm.budget = b
+}
+
+// LastPoppedStackElem returns the value of the last expression statement that ran,
+// which is the result of the program.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// Run executes the program. Runtime errors of the language are returned as *object.Error,
+// positioned at the instruction that raised them, unless a try in the program catches them.
+func (vm *VM) Run() error {
+	return vm.execute(0)
+}
+
+// execute runs the current frame until it ends, resuming at the exception handlers
+// installed since the first handlers ones when errors are raised.
+func (vm *VM) execute(handlers int) error {
+	for {
+		err := vm.run()
+
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			return err
+		}
+
+		if !errObj.Pos.IsValid() {
+			errObj.Pos = vm.currentPosition()
+		}
+		if errObj.Stack == nil {
+			errObj.Stack = vm.stackTrace(errObj.Pos)
+		}
+
+		if !vm.handle(errObj, handlers) {
+			return errObj
+		}
+	}
+}
+
+// handle unwinds the frames and the stack to the innermost exception handler, unless
+// it is one of the first handlers ones, and resumes execution there, reporting false if
+// there is none.
+func (vm *VM) handle(err *object.Error, handlers int) bool {
+	if len(vm.handlers) <= handlers {
+		return false
+	}
+
+	// Running out of budget stops the whole program, finally blocks included.
+	if err.Kind == object.LimitErrorKind {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.target - 1
+
+	// A catch gets the error's value; a finally block throws the error itself again.
+	var value object.Object = err
+	if h.catches {
+		value = evaluator.ErrorValue(err)
+	}
+
+	return vm.push(value) == nil
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		if vm.budget != nil {
+			if err := vm.budget.Step(); err != nil {
+				return err
+			}
+		}
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.currentFrame().cl.Unit.Constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual:
+			if err := vm.executeInfixOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(evaluator.TRUE); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(evaluator.FALSE); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.pushResult(evaluator.EvalPrefix("!", vm.pop())); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			operand := vm.pop()
+
+			if integer, ok := operand.(*object.Integer); ok && integer.Value != math.MinInt64 {
+				if err := vm.push(&object.Integer{Value: -integer.Value}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := vm.pushResult(evaluator.EvalPrefix("-", operand)); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			truthy := evaluator.IsTruthy(vm.stack[vm.sp-1])
+			if truthy == (op == code.OpJumpTruthyOrPop) {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.currentFrame().cl.Unit.Globals[globalIndex] = vm.pop()
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			globals := vm.currentFrame().cl.Unit.Globals
+			if globals[globalIndex] == nil {
+				return newError("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+
+			globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.currentFrame().cl.Unit.Globals[globalIndex]
+			if value == nil {
+				return newError("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+
+			// A local captured by a closure lives in a cell shared with the closure.
+			if c, ok := (*slot).(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			value := deref(vm.stack[frame.basePointer+int(localIndex)])
+			if value == nil {
+				return newError("identifier not found: %s", slotName(frame.cl.Fn.LocalNames, int(localIndex), "local"))
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(vm.builtins[builtinIndex]); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			value := deref(currentClosure.Free[freeIndex])
+			if value == nil {
+				return newError("identifier not found: %s", slotName(currentClosure.Fn.FreeNames, int(freeIndex), "free variable"))
+			}
+
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free
+			if c, ok := free[freeIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				free[freeIndex] = vm.pop()
+			}
+
+		case code.OpGetLocalIfSet, code.OpSetLocalIfSet, code.OpGetFreeIfSet, code.OpSetFreeIfSet:
+			index := int(code.ReadUint8(ins[ip+1:]))
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			var slot *object.Object
+			if op == code.OpGetLocalIfSet || op == code.OpSetLocalIfSet {
+				slot = &vm.stack[vm.currentFrame().basePointer+index]
+			} else {
+				slot = &vm.currentFrame().cl.Free[index]
+			}
+
+			value := deref(*slot)
+			if value == nil {
+				break
+			}
+
+			if op == code.OpGetLocalIfSet || op == code.OpGetFreeIfSet {
+				if err := vm.push(value); err != nil {
+					return err
+				}
+			} else if c, ok := (*slot).(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
+
+			c, ok := (*slot).(*cell)
+			if !ok {
+				c = &cell{value: *slot}
+				*slot = c
+			}
+
+			if err := vm.push(c); err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			if err := vm.push(vm.currentFrame().cl); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			if err := vm.pushAllocated(array); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.pushAllocated(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.pushResult(evaluator.EvalIndex(left, index)); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.pushResult(evaluator.EvalSetIndex(left, index, value, vm.budget)); err != nil {
+				return err
+			}
+
+		case code.OpDup:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+
+			start := vm.sp - n
+			for i := 0; i < n; i++ {
+				if err := vm.push(vm.stack[start+i]); err != nil {
+					return err
+				}
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// A return at the top level ends the program with that value.
+				vm.lastPopped = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+			if vm.framesIndex == vm.returnFrame {
+				return nil
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(evaluator.NULL); err != nil {
+				return err
+			}
+			if vm.framesIndex == vm.returnFrame {
+				return nil
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			iterable := vm.pop()
+
+			keys, values, ok := evaluator.IterationPairs(iterable)
+			if !ok {
+				return newError("cannot iterate over %s", iterable.Type())
+			}
+
+			_, isHash := iterable.(*object.Hash)
+			if err := vm.push(&iterator{keys: keys, values: values, isHash: isHash}); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numVars := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.iterNext(vm.pop().(*iterator), pos, int(numVars)); err != nil {
+				return err
+			}
+
+		case code.OpTry:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			catches := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			vm.handlers = append(vm.handlers, handler{
+				target:      target,
+				catches:     catches,
+				framesIndex: vm.framesIndex,
+				sp:          vm.sp,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			value := vm.pop()
+
+			// Rethrowing after a finally block keeps the error as it was first raised.
+			if err, ok := value.(*object.Error); ok {
+				return err
+			}
+			return evaluator.Throw(value)
+
+		case code.OpImport:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			path := vm.currentFrame().cl.Unit.Constants[constIndex].(*object.String).Value
+
+			module, err := vm.importModule(path)
+			if err != nil {
+				return err
+			}
+
+			if err := vm.push(module); err != nil {
+				return err
+			}
+
+		case code.OpMember:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			name := vm.currentFrame().cl.Unit.Constants[constIndex].(*object.String).Value
+
+			if err := vm.pushResult(evaluator.EvalMember(vm.pop(), name)); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("unhandled opcode %s", def.Name)
+		}
+	}
+
+	return nil
+}
+
+// importModule loads the module at path, imported from the file of the running code.
+// A module is compiled on its own and run by a VM of its own, whose globals become
+// the module's members; its closures keep using those globals wherever they are called.
+func (vm *VM) importModule(path string) (*object.Module, error) {
+	importSite := vm.currentPosition()
+
+	module, errObj := vm.modules.Load(path, importSite.File, func(module *object.Module, program *ast.Program) *object.Error {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		bytecode := comp.Bytecode()
+		machine := NewWithGlobalsStore(bytecode, make([]object.Object, len(bytecode.Globals)))
+		machine.modules = vm.modules
+		machine.budget = vm.budget
+		machine.io = vm.io
+
+		if err := machine.Run(); err != nil {
+			errObj, ok := err.(*object.Error)
+			if !ok {
+				errObj = &object.Error{Message: err.Error()}
+			}
+
+			// The module's top level shows up in stack traces as a call made by the import.
+			if n := len(errObj.Stack); n > 0 && errObj.Stack[n-1].Function == object.MainFunction {
+				errObj.Stack[n-1].Function = object.ModuleFunction(module.Name)
+				errObj.Stack = append(errObj.Stack, vm.stackTrace(importSite)...)
+			}
+			return errObj
+		}
+
+		module.Unit = machine.frames[0].cl.Unit
+		return nil
+	})
+	if errObj != nil {
+		return nil, errObj
+	}
+
+	return module, nil
+}
+
+func (vm *VM) executeInfixOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+
+	if leftOk && rightOk {
+		return vm.executeIntegerOperation(op, leftInt.Value, rightInt.Value)
+	}
+
+	if err := vm.budget.Operation(infixOperators[op], left, right); err != nil {
+		return err
+	}
+
+	return vm.pushAllocated(evaluator.EvalInfix(infixOperators[op], left, right))
+}
+
+// executeIntegerOperation is the fast path for integer operands; everything else, including
+// arithmetic that overflows into a big integer, goes through the evaluator so both engines
+// share one definition of the operators.
+func (vm *VM) executeIntegerOperation(op code.Opcode, left, right int64) error {
+	switch op {
+	case code.OpAdd:
+		if sum := left + right; (sum > left) == (right > 0) {
+			return vm.push(&object.Integer{Value: sum})
+		}
+	case code.OpSub:
+		if diff := left - right; (diff < left) == (right > 0) {
+			return vm.push(&object.Integer{Value: diff})
+		}
+	case code.OpMul:
+		product := left * right
+		if left == 0 || (product/left == right && !(left == -1 && right == math.MinInt64)) {
+			return vm.push(&object.Integer{Value: product})
+		}
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(left > right))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(left < right))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(left >= right))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(left <= right))
+	}
+
+	return vm.pushAllocated(evaluator.EvalInfix(infixOperators[op], &object.Integer{Value: left}, &object.Integer{Value: right}))
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	basePointer := vm.sp - numArgs
+	if vm.framesIndex >= MaxFrames || basePointer+cl.Fn.NumLocals >= StackSize {
+		return newError("stack overflow")
+	}
+	if err := vm.budget.Call(vm.framesIndex - 1); err != nil {
+		return err
+	}
+
+	// Clear the locals that are not parameters: a slot may still hold a cell left
+	// behind by an earlier call, which must not be shared with this one.
+	for i := basePointer + numArgs; i < basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+
+	frame := NewFrame(cl, basePointer)
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := builtin.Call(vm.io, builtinRuntime{vm}, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		result = evaluator.NULL
+	}
+
+	return vm.pushAllocated(result)
+}
+
+// builtinRuntime is the object.Runtime of the builtins the VM calls.
+type builtinRuntime struct {
+	vm *VM
+}
+
+func (rt builtinRuntime) Call(fn object.Object, args ...object.Object) object.Object {
+	return rt.vm.callFunction(fn, args...)
+}
+
+func (rt builtinRuntime) Reserve(n int64) *object.Error {
+	return rt.vm.budget.Reserve(n)
+}
+
+// callFunction calls fn on behalf of a builtin, running a closure to its end before
+// returning what it returns. Only the exception handlers installed by the closure can
+// catch its errors; the rest propagate from the builtin.
+func (vm *VM) callFunction(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		return vm.callBack(fn, args)
+	case *object.Builtin:
+		result := fn.Call(vm.io, builtinRuntime{vm}, args...)
+		if result == nil {
+			return evaluator.NULL
+		}
+		if err := vm.budget.Allocate(result); err != nil {
+			return err
+		}
+		return result
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
+
+// callBack runs cl with args until it returns, leaving the stack and the frames as they
+// were.
+func (vm *VM) callBack(cl *object.Closure, args []object.Object) object.Object {
+	sp, framesIndex, handlers, returnFrame := vm.sp, vm.framesIndex, len(vm.handlers), vm.returnFrame
+	defer func() {
+		vm.sp, vm.framesIndex, vm.handlers, vm.returnFrame = sp, framesIndex, vm.handlers[:handlers], returnFrame
+	}()
+
+	err := vm.push(cl)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.callClosure(cl, len(args))
+	}
+	if err == nil {
+		vm.returnFrame = framesIndex
+		err = vm.execute(handlers)
+	}
+
+	if err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+
+	return vm.stack[vm.sp-1]
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	unit := vm.currentFrame().cl.Unit
+
+	constant := unit.Constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free, Unit: unit})
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash, nil
+}
+
+// iterNext pushes the next element of a for-in loop, or jumps to pos once it is exhausted.
+// With two loop variables the key is pushed before the value; with one, hashes yield their keys.
+func (vm *VM) iterNext(it *iterator, pos int, numVars int) error {
+	if it.next >= len(it.values) {
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+
+	key, value := it.keys[it.next], it.values[it.next]
+	it.next++
+
+	if numVars == 2 {
+		if err := vm.push(key); err != nil {
+			return err
+		}
+	} else if it.isHash {
+		value = key
+	}
+
+	return vm.push(value)
+}
+
+// pushResult pushes the result of a shared evaluator operation, turning an error
+// value into a runtime error that stops the VM.
+func (vm *VM) pushResult(result object.Object) error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+
+	return vm.push(result)
+}
+
+// pushAllocated pushes the result of an operation that may have created an array, a hash
+// or a string, charging the budget for it.
+func (vm *VM) pushAllocated(result object.Object) error {
+	if err := vm.budget.Allocate(result); err != nil {
+		return err
+	}
+
+	return vm.pushResult(result)
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return newError("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// currentPosition returns the source position of the instruction the current frame is
+// executing: the closest recorded position at or before its instruction pointer.
+func (vm *VM) currentPosition() token.Pos {
+	return framePosition(vm.currentFrame())
+}
+
+// framePosition returns the source position of the instruction frame is executing.
+func framePosition(frame *Frame) token.Pos {
+	best := -1
+	var pos token.Pos
+
+	for offset, p := range frame.cl.Fn.Positions {
+		if offset <= frame.ip && offset > best {
+			best, pos = offset, p
+		}
+	}
+
+	return pos
+}
+
+// stackTrace describes the frames in progress, innermost first, given the position the
+// innermost one has reached.
+func (vm *VM) stackTrace(pos token.Pos) []object.StackFrame {
+	trace := make([]object.StackFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		if i < vm.framesIndex-1 {
+			pos = framePosition(frame)
+		}
+
+		name := frame.cl.Fn.Name
+		switch {
+		case i == 0:
+			name = object.MainFunction
+		case name == "":
+			name = object.AnonymousFunction
+		}
+
+		trace = append(trace, object.StackFrame{Function: name, Pos: pos})
+	}
+
+	return trace
+}
+
+func (vm *VM) globalName(index int) string {
+	return slotName(vm.currentFrame().cl.Unit.GlobalNames, index, "global")
+}
+
+// slotName returns the name of the variable in slot index, or describes the slot
+// when its name is not known.
+func slotName(names []string, index int, kind string) string {
+	if index < len(names) && names[index] != "" {
+		return names[index]
+	}
+	return fmt.Sprintf("%s %d", kind, index)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+
+	return evaluator.FALSE
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// cell boxes a variable that closures capture, so that assignments made through the
+// closure or the scope that defined the variable are seen by both.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string         { return "cell" }
+
+// deref returns the value of a variable, looking inside its cell if it has one.
+func deref(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
+
+// iterator is the hidden state of a for-in loop, kept in a variable between iterations.
+type iterator struct {
+	keys   []object.Object
+	values []object.Object
+	isHash bool
+	next   int
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
@@ -0,0 +1,148 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/compiler"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) { return 0; }
+				countDown(x - 1);
+			};
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		if (isEven(10)) { 1 } else { 0 }`, 1},
+	}
+
+	for _, tt := range tests {
+		result, err := run(tt.input)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != tt.expected {
+			t.Errorf("wrong result for %q. want=%d, got=%+v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestClosuresCaptureFreeVariables(t *testing.T) {
+	input := `
+	let newAdderOuter = fn(a, b) {
+		let c = a + b;
+		fn(d) {
+			let e = d + c;
+			fn(f) { e + f; };
+		};
+	};
+	let newAdderInner = newAdderOuter(1, 2);
+	let adder = newAdderInner(3);
+	adder(8);`
+
+	result, err := run(input)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 14 {
+		t.Errorf("wrong result. want=14, got=%+v", result)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"1(2)", "not a function: INTEGER"},
+		{"missing + 1", "identifier not found: missing"},
+		{"let f = fn() { f() }; f();", "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		_, err := run(tt.input)
+
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("expected runtime error for %q. got=%T (%+v)", tt.input, err, err)
+			continue
+		}
+
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestGlobalsSurviveAcrossRuns(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbolTable := compiler.New().SymbolTable()
+	constants := []object.Object{}
+
+	var result object.Object
+
+	for _, line := range []string{"let a = 40;", "let b = fn(x) { a + x };", "b(2)"} {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobalsStore(bytecode, globals)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result = machine.LastPoppedStackElem()
+	}
+
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 42 {
+		t.Errorf("wrong result. want=42, got=%+v", result)
+	}
+}
+
+func run(input string) (object.Object, error) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		return nil, err
+	}
+
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+
+	return machine.LastPoppedStackElem(), nil
+}
+
+func parse(input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	return p.ParseProgram()
+}
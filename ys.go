@@ -0,0 +1,196 @@
+// Package ys embeds the Ys language in Go programs.
+//
+// An Interpreter runs Ys code on the tree-walking evaluator, in a global environment that
+// lasts as long as the Interpreter, so that values and functions defined from Go, and by
+// earlier programs, are visible to later ones:
+//
+//	interp := ys.New()
+//	interp.RegisterFunc("shout", strings.ToUpper)
+//	interp.Eval(`let greet = fn(name) { shout("hello " + name) };`)
+//	result, err := interp.Call("greet", "gopher")
+//
+// Go values are converted to and from Ys values with ToObject and FromObject.
+package ys
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+)
+
+// Interpreter runs Ys programs for a Go program. It is not safe for concurrent use.
+type Interpreter struct {
+	env       *object.Environment
+	evaluator *evaluator.Evaluator
+	modules   *evaluator.Modules
+	limits    evaluator.Limits
+}
+
+// New returns an Interpreter with an empty global environment, which finds imports
+// relative to the importing file.
+func New() *Interpreter {
+	i := &Interpreter{env: object.NewEnvironment(), evaluator: evaluator.New()}
+	i.SetSearchPath(nil)
+	return i
+}
+
+// SetSearchPath makes the interpreter also look for imports in each of dirs, in order.
+// Modules imported before are loaded again the next time they are imported.
+func (i *Interpreter) SetSearchPath(dirs []string) {
+	i.modules = evaluator.NewModules(dirs)
+	i.evaluator.SetModules(i.modules)
+}
+
+// SetLimits bounds the resources each later call to Eval, RunFile or Call may use. A
+// program that exceeds them stops with an *object.Error of kind object.LimitErrorKind,
+// which it cannot catch.
+func (i *Interpreter) SetLimits(limits evaluator.Limits) {
+	i.limits = limits
+}
+
+// SetIO makes programs read from in, and write to out and, with `warn`, errOut, instead
+// of the process's standard streams.
+func (i *Interpreter) SetIO(in io.Reader, out, errOut io.Writer) {
+	i.evaluator.SetIO(object.NewIO(in, out, errOut))
+}
+
+// ParseError is returned for a program that does not parse. It lists each error with
+// its position.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
+// Eval runs the program src and returns the value of its last statement. A runtime
+// error in the program is returned as an *object.Error, with its position and stack
+// trace, and a program that does not parse as a *ParseError.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval, but stops the program with a LimitError once ctx is done.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	return i.run(ctx, "", src)
+}
+
+// RunFile runs the program in the file at path, like Eval. Errors are reported with the
+// file's name, and the file's imports are found relative to it.
+func (i *Interpreter) RunFile(path string) (object.Object, error) {
+	return i.RunFileContext(context.Background(), path)
+}
+
+// RunFileContext is like RunFile, but stops the program with a LimitError once ctx is done.
+func (i *Interpreter) RunFileContext(ctx context.Context, path string) (object.Object, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.run(ctx, path, string(src))
+}
+
+func (i *Interpreter) run(ctx context.Context, file, src string) (object.Object, error) {
+	p := parser.New(lexer.NewFile(file, src))
+
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	i.evaluator.SetBudget(evaluator.NewBudget(ctx, i.limits))
+	defer i.modules.Running(file)()
+
+	return i.result(i.evaluator.Eval(program, i.env))
+}
+
+// result separates a runtime error from an ordinary value.
+func (i *Interpreter) result(obj object.Object) (object.Object, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return nil, err
+	}
+
+	if obj == nil {
+		// A program ending in a statement, such as a let, has no value.
+		return evaluator.NULL, nil
+	}
+
+	return obj, nil
+}
+
+// Define binds name to value, converted with ToObject, in the global environment.
+func (i *Interpreter) Define(name string, value interface{}) error {
+	obj, err := i.converter().toObject(reflect.ValueOf(value))
+	if err != nil {
+		return fmt.Errorf("ys: defining %s: %w", name, err)
+	}
+
+	i.env.Set(name, obj)
+
+	return nil
+}
+
+// RegisterFunc binds name to a builtin that calls the Go function fn, converting its
+// arguments from Ys values and its results to them as described for ToObject. A
+// variadic fn takes any number of trailing arguments.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("ys: registering %s: %T is not a function", name, fn)
+	}
+
+	i.env.Set(name, i.converter().builtin(name, v))
+
+	return nil
+}
+
+// Get returns the value of the global name, and false if it is not defined.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// Call calls the global function or builtin name with args, converted with ToObject,
+// and returns its result. A runtime error is returned as an *object.Error.
+func (i *Interpreter) Call(name string, args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call, but stops the function with a LimitError once ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (object.Object, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		if builtin, isBuiltin := evaluator.LookupBuiltin(name); isBuiltin {
+			fn, ok = builtin, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("ys: calling %s: not defined", name)
+	}
+
+	objects := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := i.converter().toObject(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, fmt.Errorf("ys: calling %s: argument %d: %w", name, n+1, err)
+		}
+		objects[n] = obj
+	}
+
+	i.evaluator.SetBudget(evaluator.NewBudget(ctx, i.limits))
+
+	return i.result(i.evaluator.Call(fn, objects))
+}
+
+func (i *Interpreter) converter() converter {
+	return converter{evaluator: i.evaluator}
+}
@@ -0,0 +1,464 @@
+package ys_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shafik23/ys"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+func TestInterpreterEval(t *testing.T) {
+	interp := ys.New()
+
+	if _, err := interp.Eval("let double = fn(x) { x * 2 };"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	// Definitions persist from one program to the next.
+	result, err := interp.Eval("double(21)")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. want=42, got=%s", result.Inspect())
+	}
+
+	_, err = interp.Eval("let = 1")
+	var parseErr *ys.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Errors[0] != "1:5: expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong parse error. got=%v", err)
+	}
+
+	_, err = interp.Eval("double(true)")
+	var runtimeErr *object.Error
+	if !errors.As(err, &runtimeErr) || runtimeErr.Inspect() != "ERROR: 1:22: type mismatch: BOOLEAN * INTEGER" {
+		t.Errorf("wrong runtime error. got=%v", err)
+	}
+}
+
+func TestInterpreterIO(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	interp := ys.New()
+	interp.SetIO(strings.NewReader("gopher\n"), &stdout, &stderr)
+
+	if _, err := interp.Eval(`let name = input("name: "); puts("hello " + name); warn("done")`); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	if stdout.String() != "name: hello gopher\n" {
+		t.Errorf("wrong output. got=%q", stdout.String())
+	}
+	if stderr.String() != "done\n" {
+		t.Errorf("wrong error output. got=%q", stderr.String())
+	}
+}
+
+func TestInterpreterRunFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.ys":     "import \"lib/util.ys\"\nutil.twice(base)",
+		"lib/util.ys": "let twice = fn(x) { x * 2 };",
+		"lib/solo.ys": "let one = 1;",
+		"ca.ys":       "import \"cb.ys\"",
+		"cb.ys":       "import \"ca.ys\"",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	interp := ys.New()
+	if err := interp.Define("base", 21); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := interp.RunFile(filepath.Join(dir, "main.ys"))
+	if err != nil {
+		t.Fatalf("RunFile failed: %s", err)
+	}
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. want=42, got=%s", result.Inspect())
+	}
+
+	_, err = interp.RunFile(filepath.Join(dir, "ca.ys"))
+	if err == nil || !strings.HasSuffix(err.Error(), "import cycle: ca.ys -> cb.ys -> ca.ys") {
+		t.Errorf("wrong error for an import cycle. got=%v", err)
+	}
+
+	// Once a file has finished running, importing it is no longer a cycle.
+	solo := filepath.Join(dir, "lib/solo.ys")
+	if _, err := interp.RunFile(solo); err != nil {
+		t.Fatalf("RunFile failed: %s", err)
+	}
+	result, err = interp.Eval(`import "` + filepath.ToSlash(solo) + `"; solo.one`)
+	if err != nil || result.Inspect() != "1" {
+		t.Errorf("import after RunFile failed. got=%v (%v)", result, err)
+	}
+
+	if _, err := interp.RunFile(filepath.Join(dir, "missing.ys")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file error. got=%v", err)
+	}
+}
+
+// link is a Go value that can refer back to itself.
+type link struct {
+	Next *link
+}
+
+type point struct {
+	X, Y   int
+	Label  string `ys:"label"`
+	hidden int
+	Skip   bool `ys:"-"`
+}
+
+func TestDefine(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		src      string
+		expected string
+	}{
+		{nil, "value", "null"},
+		{true, "value", "true"},
+		{int8(-3), "value", "-3"},
+		{uint64(1 << 63), "value", "9223372036854775808"},
+		{2.5, "value", "2.5"},
+		{"hi", "value", "hi"},
+		{[]int{1, 2}, "value", "[1, 2]"},
+		{[2]string{"a", "b"}, "value", "[a, b]"},
+		{map[string]int{"c": 3, "a": 1, "b": 2}, "value", "{a: 1, b: 2, c: 3}"},
+		{point{X: 1, Y: 2, Label: "p", hidden: 3, Skip: true}, `[value["X"], value["Y"], value["label"], value["hidden"], value["Skip"]]`, "[1, 2, p, null, null]"},
+		{&point{X: 1}, `value["X"]`, "1"},
+		{(*point)(nil), "value", "null"},
+		{big.NewInt(7), "value", "7"},
+		{&object.String{Value: "as is"}, "value", "as is"},
+	}
+
+	for _, tt := range tests {
+		interp := ys.New()
+		if err := interp.Define("value", tt.value); err != nil {
+			t.Errorf("Define(%#v) failed: %s", tt.value, err)
+			continue
+		}
+
+		result, err := interp.Eval(tt.src)
+		if err != nil {
+			t.Errorf("Define(%#v): Eval failed: %s", tt.value, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("Define(%#v): wrong value. want=%s, got=%s", tt.value, tt.expected, result.Inspect())
+		}
+	}
+
+	if err := ys.New().Define("c", make(chan int)); err == nil || err.Error() != "ys: defining c: cannot convert chan int to a Ys value" {
+		t.Errorf("wrong error for an unconvertible value. got=%v", err)
+	}
+
+	loop := &link{}
+	loop.Next = loop
+	if _, err := ys.ToObject(loop); err == nil || err.Error() != "cannot convert *ys_test.link: it contains itself" {
+		t.Errorf("wrong error for a value that contains itself. got=%v", err)
+	}
+
+	// A value can still be reached more than once, as long as it is not inside itself.
+	shared := &point{X: 1}
+	if obj, err := ys.ToObject([]*point{shared, shared}); err != nil || obj.Inspect() != `[{X: 1, Y: 0, label: }, {X: 1, Y: 0, label: }]` {
+		t.Errorf("wrong result for a shared value. got=%v (%v)", obj, err)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	interp := ys.New()
+
+	funcs := map[string]interface{}{
+		"shout": strings.ToUpper,
+		"sum": func(xs ...int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"divide": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errors.New("cannot divide by zero")
+			}
+			return a / b, nil
+		},
+		"pair": func() (string, int) { return "a", 1 },
+		"mapInts": func(xs []int, f func(int) int) []int {
+			out := make([]int, len(xs))
+			for i, x := range xs {
+				out[i] = f(x)
+			}
+			return out
+		},
+		"tryEach": func(xs []int, f func(int) (int, error)) (int, error) {
+			for _, x := range xs {
+				if _, err := f(x); err != nil {
+					return x, err
+				}
+			}
+			return 0, nil
+		},
+		"fail": func(kind string) error {
+			return &object.Error{Message: "failed", Kind: kind}
+		},
+		"log":  func(v interface{}) {},
+		"ints": func(v [][]int) int { return len(v) },
+	}
+	for name, fn := range funcs {
+		if err := interp.RegisterFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`shout("hi")`, "HI"},
+		{`sum()`, "0"},
+		{`sum(1, 2, 3)`, "6"},
+		{`divide(1, 4)`, "0.25"},
+		{`divide(1, 0)`, "ERROR: 1:1: cannot divide by zero"},
+		{`try { divide(1, 0) } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`pair()`, "[a, 1]"},
+		{`mapInts([1, 2], fn(x) { x * 10 })`, "[10, 20]"},
+		{`mapInts([1, 2], fn(x) { x + true })`, "ERROR: 1:25: type mismatch: INTEGER + BOOLEAN"},
+		{`tryEach([1, 2, 3], fn(x) { if (x == 2) { throw "two" }; x })`, "ERROR: 1:42: two"},
+		{`try { fail("Custom") } catch (e) { e["kind"] }`, "Custom"},
+		{`shout(1)`, "ERROR: 1:1: argument 1 to `shout`: cannot convert INTEGER to string"},
+		{`shout()`, "ERROR: 1:1: wrong number of arguments. got=0, want=1"},
+		{`divide()`, "ERROR: 1:1: wrong number of arguments. got=0, want=2"},
+		{`sum(1, "2")`, "ERROR: 1:1: argument 2 to `sum`: cannot convert STRING to int"},
+		{`let a = [1]; a[0] = a; log(a)`, "ERROR: 1:24: argument 1 to `log`: cannot convert ARRAY: it contains itself"},
+		{`let h = {}; h["h"] = h; log([h])`, "ERROR: 1:25: argument 1 to `log`: value of h: cannot convert HASH to map[string]interface {}: it contains itself"},
+		{`let a = [1]; log([a, a])`, "null"},
+		{`let a = [1]; ints([a, a])`, "2"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Eval(tt.input)
+
+		var got string
+		if err != nil {
+			got = err.(*object.Error).Inspect()
+		} else {
+			got = result.Inspect()
+		}
+
+		if got != tt.expected {
+			t.Errorf("%s: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	if err := interp.RegisterFunc("nope", 1); err == nil {
+		t.Errorf("expected an error registering a non-function")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := ys.New()
+	if _, err := interp.Eval(`let add = fn(a, b) { a + b }; let names = fn(people) { people["gopher"] }`); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := interp.Call("add", 40, 2)
+	if err != nil || result.Inspect() != "42" {
+		t.Errorf("add(40, 2): want=42, got=%v (%v)", result, err)
+	}
+
+	result, err = interp.Call("names", map[string]point{"gopher": {X: 1}})
+	if err != nil || result.Type() != object.HASH_OBJ {
+		t.Errorf("names(...): want a hash, got=%v (%v)", result, err)
+	}
+
+	result, err = interp.Call("len", []string{"a", "b"})
+	if err != nil || result.Inspect() != "2" {
+		t.Errorf("len(...): want=2, got=%v (%v)", result, err)
+	}
+
+	if _, err := interp.Call("add", 1); err == nil || err.Error() != "wrong number of arguments: want=2, got=1" {
+		t.Errorf("wrong error for a call with too few arguments. got=%v", err)
+	}
+
+	_, err = interp.Call("add", 1, true)
+	var runtimeErr *object.Error
+	if !errors.As(err, &runtimeErr) || runtimeErr.StackTrace() != "    at add (1:22)\n    at <main>\n" {
+		t.Errorf("wrong runtime error. got=%v", err)
+	}
+
+	if _, err := interp.Call("missing"); err == nil || err.Error() != "ys: calling missing: not defined" {
+		t.Errorf("wrong error for an undefined function. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := ys.New()
+	interp.SetLimits(evaluator.Limits{MaxSteps: 10000})
+
+	if _, err := interp.Eval("let spin = fn() { while (true) { try { 1 } catch (e) { 2 } } };"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limits apply to each call afresh.
+	for n := 0; n < 2; n++ {
+		if result, err := interp.Eval("let i = 0; while (i < 1000) { i += 1 }; i"); err != nil || result.Inspect() != "1000" {
+			t.Fatalf("run %d: want=1000, got=%v (%v)", n, result, err)
+		}
+	}
+
+	_, err := interp.Call("spin")
+	var limitErr *object.Error
+	if !errors.As(err, &limitErr) || limitErr.Kind != object.LimitErrorKind || limitErr.Message != "step limit of 10000 exceeded" {
+		t.Errorf("wrong error for a runaway call. got=%v", err)
+	}
+
+	interp.SetLimits(evaluator.Limits{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = interp.EvalContext(ctx, "spin()")
+	if !errors.As(err, &limitErr) || limitErr.Message != "evaluation stopped: context deadline exceeded" {
+		t.Errorf("wrong error for a timed out program. got=%v", err)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	interp := ys.New()
+
+	eval := func(src string) object.Object {
+		t.Helper()
+		result, err := interp.Eval(src)
+		if err != nil {
+			t.Fatalf("Eval(%q) failed: %s", src, err)
+		}
+		return result
+	}
+
+	var p point
+	if err := ys.FromObject(eval(`{"X": 1, "Y": 2, "label": "p", "Skip": true}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{X: 1, Y: 2, Label: "p"}) {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	var m map[string][]float64
+	if err := ys.FromObject(eval(`{"a": [1, 2.5]}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string][]float64{"a": {1, 2.5}}) {
+		t.Errorf("wrong map. got=%v", m)
+	}
+
+	var v interface{}
+	if err := ys.FromObject(eval(`[1, "two", 3.0, true, first([]), {"k": [9223372036854775808]}, {1: 2}]`), &v); err != nil {
+		t.Fatal(err)
+	}
+	huge, _ := new(big.Int).SetString("9223372036854775808", 10)
+	expected := []interface{}{
+		int64(1), "two", 3.0, true, nil,
+		map[string]interface{}{"k": []interface{}{huge}},
+		map[interface{}]interface{}{int64(1): int64(2)},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("wrong value. want=%#v, got=%#v", expected, v)
+	}
+
+	var f func(string, int) (string, error)
+	if err := ys.FromObject(eval(`fn(s, n) { if (n < 0) { throw "negative" }; s + s }`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := f("ab", 2); s != "abab" || err != nil {
+		t.Errorf("f(ab, 2): want=abab, got=%q (%v)", s, err)
+	}
+	if _, err := f("ab", -1); err == nil || err.Error() != "negative" {
+		t.Errorf("f(ab, -1): want an error, got=%v", err)
+	}
+
+	// Without an error result, a failure panics with a CallbackError.
+	var g func(string) string
+	if err := ys.FromObject(eval(`fn(x) { x + 1 }`), &g); err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			failure, ok := recover().(ys.CallbackError)
+			if !ok || failure.Error() != "type mismatch: STRING + INTEGER" {
+				t.Errorf("g(x): want a CallbackError, got=%v", failure)
+			}
+		}()
+		g("x")
+	}()
+
+	var obj object.Object
+	if err := ys.FromObject(eval(`[1]`), &obj); err != nil || obj.Inspect() != "[1]" {
+		t.Errorf("wrong object. got=%v (%v)", obj, err)
+	}
+
+	tests := []struct {
+		src      string
+		target   interface{}
+		expected string
+	}{
+		{`"x"`, new(int), "cannot convert STRING to int"},
+		{`300`, new(int8), "300 overflows int8"},
+		{`-1`, new(uint), "-1 overflows uint"},
+		{`[1, "x"]`, new([]int), "element 1: cannot convert STRING to int"},
+		{`[1]`, new([2]int), "cannot convert an array of 1 elements to [2]int"},
+		{`{"X": "x"}`, new(point), "field X: cannot convert STRING to int"},
+		{`first([])`, new(int), "cannot convert NULL to int"},
+		{`let a = [1]; a[0] = a; a`, new([]interface{}), "element 0: cannot convert ARRAY: it contains itself"},
+		{`let a = [[1]]; a[0][0] = a; a`, new([][][]int), "element 0: element 0: cannot convert ARRAY to []int: it contains itself"},
+		{`let h = {}; h["h"] = h; h`, new(interface{}), "value of h: cannot convert HASH to map[string]interface {}: it contains itself"},
+	}
+
+	for _, tt := range tests {
+		err := ys.FromObject(eval(tt.src), tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s to %T: want error %q, got=%v", tt.src, tt.target, tt.expected, err)
+		}
+	}
+
+	if err := ys.FromObject(eval(`1`), p); err == nil {
+		t.Errorf("expected an error converting into a non-pointer")
+	}
+}
+
+func ExampleInterpreter() {
+	interp := ys.New()
+	interp.RegisterFunc("shout", strings.ToUpper)
+	interp.Eval(`let greet = fn(name) { shout("hello " + name) };`)
+
+	result, err := interp.Call("greet", "gopher")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var greeting string
+	ys.FromObject(result, &greeting)
+	fmt.Println(greeting)
+	// Output: HELLO GOPHER
+}
@@ -0,0 +1,302 @@
+// File: ystest/assert.go
+
+package ystest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/object"
+)
+
+// AssertionErrorKind is the kind of the errors failed assertions raise, which is what
+// tells a test that failed from one that went wrong in some other way.
+const AssertionErrorKind = "AssertionError"
+
+// BuiltinNames returns the names of the assertion builtins tests can use, sorted.
+func BuiltinNames() []string {
+	return []string{"assert", "assert_eq", "assert_error"}
+}
+
+// assertions returns the assertion builtins, which call functions with e.
+func assertions(e *evaluator.Evaluator) map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		// assert(condition, message?) fails unless condition is truthy.
+		"assert": {Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			if evaluator.IsTruthy(args[0]) {
+				return evaluator.NULL
+			}
+			return failure("assertion failed", args[1:], "")
+		}},
+
+		// assert_eq(got, want, message?) fails unless got and want are equal, comparing
+		// the elements of arrays and hashes rather than whether they are the same one.
+		"assert_eq": {Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			if equal(args[0], args[1]) {
+				return evaluator.NULL
+			}
+			return failure("assert_eq failed", args[2:], difference(args[0], args[1]))
+		}},
+
+		// assert_error(fn, want?) calls fn with no arguments, and fails unless it raises
+		// an error whose message contains want. It returns the error, as a catch clause
+		// would see it.
+		"assert_error": {Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			switch args[0].(type) {
+			case *object.Function, *object.Builtin:
+			default:
+				return newError("argument to `assert_error` must be FUNCTION, got %s", args[0].Type())
+			}
+
+			want := ""
+			if len(args) == 2 {
+				s, ok := args[1].(*object.String)
+				if !ok {
+					return newError("second argument to `assert_error` must be STRING, got %s", args[1].Type())
+				}
+				want = s.Value
+			}
+
+			err, ok := e.Call(args[0], nil).(*object.Error)
+			switch {
+			case !ok:
+				return failure("assert_error failed: the function returned without an error", nil, "")
+			case err.Kind == object.LimitErrorKind:
+				return err
+			case !strings.Contains(err.Message, want):
+				return failure("assert_error failed: the error's message does not contain "+strconv.Quote(want), nil,
+					"  message: "+strconv.Quote(err.Message))
+			}
+			return evaluator.ErrorValue(err)
+		}},
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// failure returns the error a failed assertion raises: what failed, the message the test
+// gave it, if any, and the details of how.
+func failure(what string, message []object.Object, details string) *object.Error {
+	if len(message) > 0 {
+		if s, ok := message[0].(*object.String); ok {
+			what += ": " + s.Value
+		} else {
+			what += ": " + message[0].Inspect()
+		}
+	}
+	if details != "" {
+		what += "\n" + details
+	}
+	return &object.Error{Message: what, Kind: AssertionErrorKind}
+}
+
+// equal reports whether a and b are equal: arrays if their elements are, hashes if they
+// have the same keys with equal values, and other values if == says they are.
+func equal(a, b object.Object) bool {
+	return equalSeen(a, b, map[[2]object.Object]bool{})
+}
+
+// equalSeen is equal, given the pairs of arrays and hashes already being compared. A pair
+// met again inside itself is taken to be equal, as its elements are compared where it
+// was first met, so values that contain themselves are compared without recursing for
+// ever.
+func equalSeen(a, b object.Object, seen map[[2]object.Object]bool) bool {
+	switch a.(type) {
+	case *object.Array, *object.Hash:
+		if a == b || seen[[2]object.Object{a, b}] {
+			return true
+		}
+		seen[[2]object.Object{a, b}] = true
+	}
+
+	switch a := a.(type) {
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !equalSeen(a.Elements[i], b.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+
+	case *object.Hash:
+		b, ok := b.(*object.Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !equalSeen(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+
+	result, ok := evaluator.EvalInfix("==", a, b).(*object.Boolean)
+	return ok && result.Value
+}
+
+// difference describes how got differs from want: side by side if both fit on a line,
+// and otherwise as a diff of their lines.
+func difference(got, want object.Object) string {
+	gotLines, wantLines := lines(got, "", nil), lines(want, "", nil)
+	if len(gotLines) == 1 && len(wantLines) == 1 {
+		return "  got:  " + gotLines[0] + "\n  want: " + wantLines[0]
+	}
+
+	var out strings.Builder
+	out.WriteString("  --- want\n  +++ got")
+	for _, line := range diff(wantLines, gotLines) {
+		out.WriteString("\n  " + line)
+	}
+	return out.String()
+}
+
+// lines renders obj for a diff: arrays and hashes with an element or pair on each line,
+// indented below their brackets, and strings that hold several lines as those lines.
+// Other values, and strings, are quoted as they would be written in a program. Like
+// Inspect, an array or hash inside itself, one of those enclosing it, is shown as [...]
+// or {...}.
+func lines(obj object.Object, indent string, enclosing []object.Object) []string {
+	switch obj.(type) {
+	case *object.Array, *object.Hash:
+		for _, e := range enclosing {
+			if e != obj {
+				continue
+			}
+			if obj.Type() == object.ARRAY_OBJ {
+				return []string{"[...]"}
+			}
+			return []string{"{...}"}
+		}
+		enclosing = append(enclosing, obj)
+	}
+
+	switch obj := obj.(type) {
+	case *object.String:
+		if strings.Contains(obj.Value, "\n") {
+			return strings.Split(obj.Value, "\n")
+		}
+		return []string{strconv.Quote(obj.Value)}
+
+	case *object.Array:
+		if len(obj.Elements) == 0 {
+			return []string{"[]"}
+		}
+		out := []string{"["}
+		for _, element := range obj.Elements {
+			out = append(out, member(indent+"  ", "", element, enclosing)...)
+		}
+		return append(out, indent+"]")
+
+	case *object.Hash:
+		if len(obj.Pairs) == 0 {
+			return []string{"{}"}
+		}
+		keys := make([]string, 0, len(obj.Pairs))
+		values := map[string]object.Object{}
+		for _, pair := range obj.Pairs {
+			key := inline(pair.Key)
+			keys = append(keys, key)
+			values[key] = pair.Value
+		}
+		sort.Strings(keys)
+
+		out := []string{"{"}
+		for _, key := range keys {
+			out = append(out, member(indent+"  ", key+": ", values[key], enclosing)...)
+		}
+		return append(out, indent+"}")
+	}
+
+	return []string{inline(obj)}
+}
+
+// member renders an element of an array, or with its key a value of a hash, followed by
+// a comma.
+func member(indent, key string, obj object.Object, enclosing []object.Object) []string {
+	var out []string
+	if s, ok := obj.(*object.String); ok {
+		// A string that holds several lines is quoted, to keep its lines apart from
+		// those of the array or hash around it.
+		out = []string{strconv.Quote(s.Value)}
+	} else {
+		out = lines(obj, indent, enclosing)
+	}
+	out[0] = indent + key + out[0]
+	out[len(out)-1] += ","
+	return out
+}
+
+// inline renders a value that is not an array or hash, or the key of a hash.
+func inline(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return strconv.Quote(obj.Value)
+	case *object.Function:
+		params := make([]string, len(obj.Parameters))
+		for i, param := range obj.Parameters {
+			params[i] = param.Value
+		}
+		return "fn(" + strings.Join(params, ", ") + ")"
+	case *object.Array, *object.Hash:
+		return strings.Join(lines(obj, "", nil), " ")
+	}
+	return obj.Inspect()
+}
+
+// diff returns the lines of a and b marked with what turns a into b: "-" for a line
+// removed, "+" for one added and " " for one kept. It finds a longest common
+// subsequence, which is quick enough for the sizes of values tests compare.
+func diff(a, b []string) []string {
+	// common[i][j] is the length of a longest common subsequence of a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	return out
+}
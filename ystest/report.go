@@ -0,0 +1,188 @@
+// File: ystest/report.go
+
+package ystest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shafik23/ys/object"
+)
+
+// Message says why a test did not pass: where and how it failed, followed by the calls
+// in progress if it failed inside a function the test called. It is "" for a test that
+// passed.
+func (r *Result) Message() string {
+	if r.Err == nil {
+		return ""
+	}
+
+	message := r.Err.Message
+	if r.Err.Pos.IsValid() {
+		message = r.Err.Pos.String() + ": " + message
+	}
+	if len(r.Err.Stack) > 2 {
+		// Below the test itself, which is always there, are the calls it made.
+		message += "\n" + strings.TrimSuffix(r.Err.StackTrace(), "\n")
+	}
+	return message
+}
+
+type jsonResult struct {
+	Name    string  `json:"name"`
+	Line    int     `json:"line"`
+	Status  Status  `json:"status"`
+	Time    float64 `json:"time"`
+	Message string  `json:"message,omitempty"`
+	Output  string  `json:"output,omitempty"`
+}
+
+type jsonSuite struct {
+	File  string       `json:"file"`
+	Time  float64      `json:"time"`
+	Error string       `json:"error,omitempty"`
+	Tests []jsonResult `json:"tests"`
+}
+
+// WriteJSON writes the outcomes of suites as a JSON array with an object for each file,
+// holding its name, the seconds its tests took, why they could not be run if they could
+// not, and the tests, each with its name, the line it is defined on, its status, the
+// seconds it took, why it did not pass and what it printed.
+func WriteJSON(w io.Writer, suites []*Suite) error {
+	out := []jsonSuite{}
+	for _, suite := range suites {
+		s := jsonSuite{File: suite.File, Time: suite.Duration.Seconds(), Tests: []jsonResult{}}
+		if suite.Err != nil {
+			s.Error = suite.Err.Error()
+		}
+		for _, r := range suite.Results {
+			s.Tests = append(s.Tests, jsonResult{
+				Name:    r.Name,
+				Line:    r.Pos.Line,
+				Status:  r.Status,
+				Time:    r.Duration.Seconds(),
+				Message: r.Message(),
+				Output:  r.Output,
+			})
+		}
+		out = append(out, s)
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// The elements of a JUnit XML report, as CI servers read them.
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut *junitText    `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",cdata"`
+}
+
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+// junitTime formats a duration in seconds, as JUnit reports give them.
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// WriteJUnit writes the outcomes of suites in the JUnit XML format, with a testsuite
+// element for each file. A file whose tests could not be run has a single test case,
+// named after the file, with an error saying why.
+func WriteJUnit(w io.Writer, suites []*Suite) error {
+	report := junitSuites{}
+	var total time.Duration
+
+	for _, suite := range suites {
+		s := junitSuite{Name: suite.File, Time: junitTime(suite.Duration)}
+
+		if suite.Err != nil {
+			s.Cases = append(s.Cases, junitCase{
+				Name:      suite.File,
+				ClassName: suite.File,
+				Time:      junitTime(0),
+				Error:     &junitProblem{Message: suite.Err.Error()},
+			})
+			s.Errors++
+		}
+
+		for _, r := range suite.Results {
+			c := junitCase{Name: r.Name, ClassName: suite.File, Time: junitTime(r.Duration)}
+			if r.Output != "" {
+				c.SystemOut = &junitText{Text: r.Output}
+			}
+
+			if r.Err != nil {
+				message, _, _ := strings.Cut(r.Err.Message, "\n")
+				problem := &junitProblem{Message: message, Type: r.Err.Kind, Text: r.Message()}
+				if problem.Type == "" {
+					problem.Type = object.RuntimeErrorKind
+				}
+
+				if r.Status == Fail {
+					c.Failure = problem
+					s.Failures++
+				} else {
+					c.Error = problem
+					s.Errors++
+				}
+			}
+
+			s.Cases = append(s.Cases, c)
+		}
+
+		s.Tests = len(s.Cases)
+		report.Tests += s.Tests
+		report.Failures += s.Failures
+		report.Errors += s.Errors
+		total += suite.Duration
+		report.Suites = append(report.Suites, s)
+	}
+
+	report.Time = junitTime(total)
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header+string(data)+"\n")
+	return err
+}
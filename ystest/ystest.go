@@ -0,0 +1,197 @@
+// File: ystest/ystest.go
+
+// Package ystest runs tests written in Ys. Tests live in files whose names end in
+// _test.ys, and are the top-level functions of those files whose names start with test_.
+// Each test runs on its own, in a fresh environment in which the file has been run
+// again, with the assertion builtins assert, assert_eq and assert_error defined.
+package ystest
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shafik23/ys/ast"
+	"github.com/shafik23/ys/evaluator"
+	"github.com/shafik23/ys/lexer"
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/parser"
+	"github.com/shafik23/ys/token"
+)
+
+// FileSuffix ends the names of the files that hold tests.
+const FileSuffix = "_test.ys"
+
+// TestPrefix starts the names of the functions that are tests.
+const TestPrefix = "test_"
+
+// IsTestFile reports whether the file named path holds tests.
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), FileSuffix)
+}
+
+// Find returns the test files named by paths: files named directly, whatever they are
+// called, and the test files in directories and their subdirectories, in lexical order.
+func Find(paths ...string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || (file != path && !IsTestFile(file)) {
+				return nil
+			}
+			files = append(files, file)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// Status is how a test ended.
+type Status string
+
+const (
+	Pass  Status = "pass"  // it returned without an error
+	Fail  Status = "fail"  // an assertion failed
+	Error Status = "error" // it, or the file it is in, raised any other error
+)
+
+// Result is the outcome of running one test.
+type Result struct {
+	Name     string
+	Pos      token.Pos // where the test is defined
+	Status   Status
+	Err      *object.Error // why the test did not pass, if it did not
+	Output   string        // what the test wrote to its output and error streams
+	Duration time.Duration
+}
+
+// Suite is the outcome of running the tests in one file.
+type Suite struct {
+	File     string
+	Err      error // why the file's tests could not be run, such as a syntax error
+	Results  []Result
+	Duration time.Duration
+}
+
+// Passed reports whether the file's tests could be run, and all of them passed.
+func (s *Suite) Passed() bool {
+	if s.Err != nil {
+		return false
+	}
+	for _, result := range s.Results {
+		if result.Status != Pass {
+			return false
+		}
+	}
+	return true
+}
+
+// Runner runs the tests in files.
+type Runner struct {
+	// SearchPath is where imports that are not relative to the importing file are
+	// looked for.
+	SearchPath []string
+
+	// Run, if set, selects the tests to run by name.
+	Run *regexp.Regexp
+}
+
+// RunFile runs the tests that src, the text of the file named file, defines, in the
+// order it defines them.
+func (r *Runner) RunFile(file, src string) *Suite {
+	suite := &Suite{File: file}
+	start := time.Now()
+	defer func() { suite.Duration = time.Since(start) }()
+
+	p := parser.New(lexer.NewFile(file, src))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		suite.Err = errs[0]
+		return suite
+	}
+
+	for _, test := range Tests(program) {
+		if r.Run != nil && !r.Run.MatchString(test.Name.Value) {
+			continue
+		}
+		suite.Results = append(suite.Results, r.runTest(program, test))
+	}
+
+	return suite
+}
+
+// Tests returns the let statements of program's top level that define tests.
+func Tests(program *ast.Program) []*ast.LetStatement {
+	var tests []*ast.LetStatement
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok && strings.HasPrefix(let.Name.Value, TestPrefix) {
+			if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+				tests = append(tests, let)
+			}
+		}
+	}
+	return tests
+}
+
+// runTest runs program in a fresh environment, then calls the test it defines.
+func (r *Runner) runTest(program *ast.Program, test *ast.LetStatement) Result {
+	result := Result{Name: test.Name.Value, Pos: test.Name.Pos()}
+
+	var output strings.Builder
+	e := evaluator.New()
+	modules := evaluator.NewModules(r.SearchPath)
+	modules.Running(program.Pos().File) // for as long as these modules are used
+	e.SetModules(modules)
+	e.SetIO(object.NewIO(strings.NewReader(""), &output, &output))
+
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{})
+	for name, builtin := range assertions(e) {
+		env.Set(name, builtin)
+	}
+
+	start := time.Now()
+	err := r.call(e, program, env, test)
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+
+	switch {
+	case err == nil:
+		result.Status = Pass
+	case err.Kind == AssertionErrorKind:
+		result.Status, result.Err = Fail, err
+	default:
+		result.Status, result.Err = Error, err
+	}
+
+	return result
+}
+
+// call runs program in env, then calls test with no arguments, and returns the error
+// either raised, if one did.
+func (r *Runner) call(e *evaluator.Evaluator, program *ast.Program, env *object.Environment, test *ast.LetStatement) *object.Error {
+	if err, ok := e.Eval(program, env).(*object.Error); ok {
+		return err
+	}
+
+	fn, _ := env.Get(test.Name.Value)
+	if fn, ok := fn.(*object.Function); !ok || len(fn.Parameters) > 0 {
+		return &object.Error{Message: fmt.Sprintf("%s must be a function with no parameters", test.Name.Value), Pos: test.Name.Pos()}
+	}
+
+	if err, ok := e.Call(fn, nil).(*object.Error); ok {
+		return err
+	}
+	return nil
+}
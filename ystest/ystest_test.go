@@ -0,0 +1,208 @@
+package ystest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/shafik23/ys/object"
+	"github.com/shafik23/ys/token"
+)
+
+func TestRunFile(t *testing.T) {
+	src := `let count = 0;
+let add = fn(a, b) { a + b };
+
+let test_add = fn() {
+  count += 1;
+  assert_eq(add(1, 2), 3);
+  assert(count == 1, "each test has its own environment");
+};
+
+let test_again = fn() {
+  count += 1;
+  assert(count == 1, "each test has its own environment");
+};
+
+let test_fails = fn() {
+  puts("checking");
+  assert_eq(add(1, 1), 3, "sums");
+};
+
+let test_errors = fn() { nope };
+
+let test_raises = fn() {
+  let err = assert_error(fn() { 1 + "a" }, "type mismatch");
+  assert_eq(err["kind"], "RuntimeError");
+};
+
+let test_not_raised = fn() { assert_error(fn() { 1 }) };
+
+let test_args = fn(x) { x };
+
+let helper = 1;
+`
+
+	tests := []struct {
+		run      string
+		expected []string // each test's name, status and message
+	}{
+		{"", []string{
+			"test_add pass ",
+			"test_again pass ",
+			"test_fails fail t_test.ys:17:3: assert_eq failed: sums\n  got:  2\n  want: 3",
+			"test_errors error t_test.ys:20:26: identifier not found: nope",
+			"test_raises pass ",
+			"test_not_raised fail t_test.ys:27:30: assert_error failed: the function returned without an error",
+			"test_args error t_test.ys:29:5: test_args must be a function with no parameters",
+		}},
+		{"add|again", []string{"test_add pass ", "test_again pass "}},
+		{"nothing", nil},
+	}
+
+	for _, tt := range tests {
+		r := &Runner{}
+		if tt.run != "" {
+			r.Run = regexp.MustCompile(tt.run)
+		}
+
+		suite := r.RunFile("t_test.ys", src)
+		if suite.Err != nil {
+			t.Fatalf("RunFile failed: %s", suite.Err)
+		}
+
+		var got []string
+		for _, result := range suite.Results {
+			got = append(got, result.Name+" "+string(result.Status)+" "+result.Message())
+		}
+
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("-run=%q: results wrong.\nwant=%q\ngot= %q", tt.run, tt.expected, got)
+		}
+		if suite.Passed() != (tt.run != "") {
+			t.Errorf("-run=%q: Passed() wrong. got=%t", tt.run, suite.Passed())
+		}
+	}
+
+	suite := (&Runner{Run: regexp.MustCompile("fails")}).RunFile("t_test.ys", src)
+	if output := suite.Results[0].Output; output != "checking\n" {
+		t.Errorf("output wrong. want=%q, got=%q", "checking\n", output)
+	}
+
+	suite = (&Runner{}).RunFile("bad_test.ys", "let = 1;")
+	if suite.Err == nil || suite.Passed() {
+		t.Errorf("a file that does not parse passed")
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the failure message, or "" if the assertion passes
+	}{
+		{`assert(true)`, ""},
+		{`assert(1 > 2)`, "assertion failed"},
+		{`assert(false, "oops")`, "assertion failed: oops"},
+		{`assert()`, "wrong number of arguments. got=0, want=1 or 2"},
+		{`assert_eq(1, 1.0)`, ""},
+		{`assert_eq([1, {"a": [2]}], [1, {"a": [2]}])`, ""},
+		{`assert_eq({"a": 1, "b": 2}, {"b": 2, "a": 1})`, ""},
+		{`assert_eq("a", "b")`, "assert_eq failed\n  got:  \"a\"\n  want: \"b\""},
+		{`assert_eq(1, "1")`, "assert_eq failed\n  got:  1\n  want: \"1\""},
+		{`assert_eq([1, 2, 3], [1, 3], "lists")`, "assert_eq failed: lists\n  --- want\n  +++ got\n   [\n     1,\n  +  2,\n     3,\n   ]"},
+		{`assert_eq({"a": [1], "b": 2}, {"a": [], "b": 2})`, "assert_eq failed\n  --- want\n  +++ got\n   {\n  -  \"a\": [],\n  +  \"a\": [\n  +    1,\n  +  ],\n     \"b\": 2,\n   }"},
+		{"assert_eq(\"one\ntwo\", \"one\n2\")", "assert_eq failed\n  --- want\n  +++ got\n   one\n  -2\n  +two"},
+		{`let a = [1]; a[0] = a; assert_eq(a, a)`, ""},
+		{`let a = [1]; a[0] = a; let b = [1]; b[0] = b; assert_eq(a, b)`, ""},
+		{`let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; assert_eq(a, b)`, "assert_eq failed\n  --- want\n  +++ got\n   [\n     [...],\n  -  3,\n  +  2,\n   ]"},
+		{`let h = {"n": 1}; h["h"] = h; assert_eq(h, {})`, "assert_eq failed\n  --- want\n  +++ got\n  -{}\n  +{\n  +  \"h\": {...},\n  +  \"n\": 1,\n  +}"},
+		{`assert_error(fn() { throw "bad" })`, ""},
+		{`assert_error(fn() { throw "bad" }, "good")`, "assert_error failed: the error's message does not contain \"good\"\n  message: \"bad\""},
+		{`assert_error(1)`, "argument to `assert_error` must be FUNCTION, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		src := "let test_it = fn() { " + tt.input + " };"
+		result := (&Runner{}).RunFile("t_test.ys", src).Results[0]
+
+		got := ""
+		if result.Err != nil {
+			got = result.Err.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong failure.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a_test.ys", "b.ys", "sub/c_test.ys", "sub/d_test.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := Find(dir, filepath.Join(dir, "b.ys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join(dir, "a_test.ys"), filepath.Join(dir, "sub/c_test.ys"), filepath.Join(dir, "b.ys")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Find wrong.\nwant=%q\ngot= %q", expected, files)
+	}
+}
+
+func TestReports(t *testing.T) {
+	suites := []*Suite{
+		{File: "a_test.ys", Results: []Result{
+			{Name: "test_ok", Pos: token.Pos{Line: 1}, Status: Pass, Output: "hi\n"},
+			{Name: "test_bad", Pos: token.Pos{Line: 2}, Status: Fail, Err: &object.Error{Message: "assertion failed\n  more", Kind: AssertionErrorKind}},
+			{Name: "test_err", Pos: token.Pos{Line: 3}, Status: Error, Err: &object.Error{Message: "boom"}},
+		}},
+		{File: "b_test.ys", Err: os.ErrNotExist},
+	}
+
+	var out bytes.Buffer
+	if err := WriteJSON(&out, suites); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"file": "a_test.ys"`,
+		`"name": "test_ok",`,
+		`"status": "fail",`,
+		`"message": "assertion failed\n  more"`,
+		`"output": "hi\n"`,
+		`"error": "file does not exist",`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("JSON report does not contain %s:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := WriteJUnit(&out, suites); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuites tests="4" failures="1" errors="2" time="0.000">`,
+		`<testsuite name="a_test.ys" tests="3" failures="1" errors="1" time="0.000">`,
+		`<system-out><![CDATA[hi` + "\n" + `]]></system-out>`,
+		`<failure message="assertion failed" type="AssertionError"><![CDATA[assertion failed` + "\n" + `  more]]></failure>`,
+		`<error message="boom" type="RuntimeError"><![CDATA[boom]]></error>`,
+		`<testcase name="b_test.ys" classname="b_test.ys" time="0.000">`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("JUnit report does not contain %s:\n%s", want, out.String())
+		}
+	}
+}